@@ -0,0 +1,350 @@
+// Command loadtest drives a running agent server with concurrent requests and reports
+// throughput and latency. It can also serve a mock OpenAI-compatible upstream with a
+// configurable latency, so the agent loop and middleware can be measured without real models:
+//
+//	go run ./cmd/loadtest -mock-addr :18099 -mock-latency 200ms -serve-mock
+//	BL_RUN_URL=http://localhost:18099 go run .
+//	go run ./cmd/loadtest -target http://localhost:1338 -concurrency 50 -duration 30s
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultPrompts is the prompt mix used when no prompts file is given
+var defaultPrompts = []weightedPrompt{
+	{weight: 5, text: "Say hello in one short sentence."},
+	{weight: 3, text: "Summarize the benefits of unit tests in three bullet points."},
+	{weight: 2, text: "Search the web for the latest Go release and tell me what changed."},
+}
+
+// weightedPrompt is a prompt drawn with a probability proportional to its weight
+type weightedPrompt struct {
+	weight int
+	text   string
+}
+
+// result is the outcome of a single request
+type result struct {
+	latency time.Duration
+	status  int
+	err     error
+}
+
+func main() {
+	target := flag.String("target", "http://localhost:1338", "base URL of the agent server")
+	endpoint := flag.String("endpoint", "/agent", "endpoint to call, /agent for JSON or / for streaming")
+	concurrency := flag.Int("concurrency", 10, "number of concurrent clients")
+	requests := flag.Int("requests", 200, "total number of requests, ignored when -duration is set")
+	duration := flag.Duration("duration", 0, "run for this long instead of a fixed number of requests")
+	timeout := flag.Duration("timeout", 2*time.Minute, "timeout of a single request")
+	promptsFile := flag.String("prompts", "", "file of prompts, one per line, optionally prefixed with `<weight>\\t`")
+	maxIterations := flag.Int("max-iterations", 0, "max_iterations sent with each request, 0 for the server default")
+	mockAddr := flag.String("mock-addr", "", "serve a mock OpenAI-compatible upstream on this address")
+	mockLatency := flag.Duration("mock-latency", 100*time.Millisecond, "latency of the mock upstream")
+	mockJitter := flag.Duration("mock-jitter", 0, "random extra latency of the mock upstream, up to this value")
+	serveMock := flag.Bool("serve-mock", false, "only serve the mock upstream until interrupted")
+	flag.Parse()
+
+	if *mockAddr != "" {
+		go serveMockUpstream(*mockAddr, *mockLatency, *mockJitter)
+		log.Printf("Mock upstream listening on %s, start the server with BL_RUN_URL pointing to it", *mockAddr)
+	}
+	if *serveMock {
+		if *mockAddr == "" {
+			log.Fatal("-serve-mock requires -mock-addr")
+		}
+		waitForInterrupt()
+		return
+	}
+
+	prompts := defaultPrompts
+	if *promptsFile != "" {
+		loaded, err := loadPrompts(*promptsFile)
+		if err != nil {
+			log.Fatalf("Failed to load prompts: %v", err)
+		}
+		prompts = loaded
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	url := strings.TrimRight(*target, "/") + *endpoint
+	log.Printf("Running load test against %s with %d clients", url, *concurrency)
+
+	client := &http.Client{
+		Timeout: *timeout,
+		Transport: &http.Transport{
+			MaxIdleConns:        *concurrency,
+			MaxIdleConnsPerHost: *concurrency,
+		},
+	}
+
+	jobs := make(chan string)
+	results := make(chan result, *concurrency)
+
+	var workers sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for prompt := range jobs {
+				results <- doRequest(client, url, prompt, *maxIterations)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		random := rand.New(rand.NewSource(time.Now().UnixNano()))
+		for sent := 0; *duration > 0 || sent < *requests; sent++ {
+			select {
+			case <-ctx.Done():
+				return
+			case jobs <- pickPrompt(random, prompts):
+			}
+		}
+	}()
+
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	start := time.Now()
+	var collected []result
+	for res := range results {
+		collected = append(collected, res)
+	}
+
+	report(os.Stdout, collected, time.Since(start))
+}
+
+// doRequest sends one agent request and measures it until the whole body has been read
+func doRequest(client *http.Client, url, prompt string, maxIterations int) result {
+	body, _ := json.Marshal(map[string]interface{}{
+		"inputs":         prompt,
+		"max_iterations": maxIterations,
+	})
+
+	start := time.Now()
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return result{latency: time.Since(start), err: err}
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return result{latency: time.Since(start), status: resp.StatusCode, err: err}
+	}
+	return result{latency: time.Since(start), status: resp.StatusCode}
+}
+
+// pickPrompt draws a prompt according to the weights of the mix
+func pickPrompt(random *rand.Rand, prompts []weightedPrompt) string {
+	total := 0
+	for _, prompt := range prompts {
+		total += prompt.weight
+	}
+	n := random.Intn(total)
+	for _, prompt := range prompts {
+		if n < prompt.weight {
+			return prompt.text
+		}
+		n -= prompt.weight
+	}
+	return prompts[len(prompts)-1].text
+}
+
+// loadPrompts reads a prompt mix from a file, one prompt per line with an optional `<weight>\t` prefix
+func loadPrompts(path string) ([]weightedPrompt, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var prompts []weightedPrompt
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		prompt := weightedPrompt{weight: 1, text: line}
+		if weight, text, found := strings.Cut(line, "\t"); found {
+			if parsed, err := strconv.Atoi(weight); err == nil && parsed > 0 {
+				prompt = weightedPrompt{weight: parsed, text: strings.TrimSpace(text)}
+			}
+		}
+		prompts = append(prompts, prompt)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(prompts) == 0 {
+		return nil, fmt.Errorf("no prompts in %s", path)
+	}
+	return prompts, nil
+}
+
+// report prints throughput, status counts, latency percentiles and a latency histogram
+func report(w io.Writer, results []result, elapsed time.Duration) {
+	if len(results) == 0 {
+		fmt.Fprintln(w, "No requests completed")
+		return
+	}
+
+	statuses := map[string]int{}
+	latencies := make([]time.Duration, 0, len(results))
+	succeeded := 0
+	for _, res := range results {
+		switch {
+		case res.err != nil:
+			statuses["error"]++
+		default:
+			statuses[strconv.Itoa(res.status)]++
+			if res.status < http.StatusBadRequest {
+				succeeded++
+			}
+		}
+		latencies = append(latencies, res.latency)
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	var sum time.Duration
+	for _, latency := range latencies {
+		sum += latency
+	}
+
+	fmt.Fprintf(w, "\nRequests:    %d in %s\n", len(results), elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Throughput:  %.2f req/s\n", float64(len(results))/elapsed.Seconds())
+	fmt.Fprintf(w, "Succeeded:   %d (%.1f%%)\n", succeeded, 100*float64(succeeded)/float64(len(results)))
+
+	keys := make([]string, 0, len(statuses))
+	for key := range statuses {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Fprintln(w, "\nStatus codes:")
+	for _, key := range keys {
+		fmt.Fprintf(w, "  %-6s %d\n", key, statuses[key])
+	}
+
+	fmt.Fprintln(w, "\nLatency:")
+	fmt.Fprintf(w, "  min   %s\n", latencies[0].Round(time.Microsecond))
+	fmt.Fprintf(w, "  mean  %s\n", (sum / time.Duration(len(latencies))).Round(time.Microsecond))
+	for _, p := range []float64{50, 90, 95, 99} {
+		fmt.Fprintf(w, "  p%-4g %s\n", p, percentile(latencies, p).Round(time.Microsecond))
+	}
+	fmt.Fprintf(w, "  max   %s\n", latencies[len(latencies)-1].Round(time.Microsecond))
+
+	fmt.Fprintln(w, "\nHistogram:")
+	printHistogram(w, latencies)
+}
+
+// percentile returns the p-th percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	index := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return sorted[index]
+}
+
+// printHistogram prints sorted latencies in exponential buckets
+func printHistogram(w io.Writer, sorted []time.Duration) {
+	const barWidth = 40
+
+	upper := time.Millisecond
+	for upper < sorted[0] {
+		upper *= 2
+	}
+
+	var buckets []time.Duration
+	var counts []int
+	index := 0
+	for index < len(sorted) {
+		count := 0
+		for index < len(sorted) && sorted[index] <= upper {
+			count++
+			index++
+		}
+		buckets = append(buckets, upper)
+		counts = append(counts, count)
+		upper *= 2
+	}
+
+	maxCount := 0
+	for _, count := range counts {
+		maxCount = max(maxCount, count)
+	}
+	for i, bucket := range buckets {
+		bar := strings.Repeat("#", counts[i]*barWidth/maxCount)
+		fmt.Fprintf(w, "  <= %-10s %6d %s\n", bucket, counts[i], bar)
+	}
+}
+
+// serveMockUpstream serves an OpenAI-compatible chat completions endpoint answering after a fixed latency
+func serveMockUpstream(addr string, latency, jitter time.Duration) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+
+		delay := latency
+		if jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(jitter)))
+		}
+		time.Sleep(delay)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":      fmt.Sprintf("mock-%d", time.Now().UnixNano()),
+			"object":  "chat.completion",
+			"created": time.Now().Unix(),
+			"model":   "mock",
+			"choices": []map[string]interface{}{
+				{
+					"index":         0,
+					"message":       map[string]string{"role": "assistant", "content": "This is a mock answer used for load testing."},
+					"finish_reason": "stop",
+				},
+			},
+			"usage": map[string]int{"prompt_tokens": 20, "completion_tokens": 10, "total_tokens": 30},
+		})
+	})
+
+	if err := http.ListenAndServe(addr, handler); err != nil {
+		log.Fatalf("Mock upstream failed: %v", err)
+	}
+}
+
+// waitForInterrupt blocks until the process receives an interrupt
+func waitForInterrupt() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	<-signals
+}
@@ -0,0 +1,6 @@
+//go:build example_extension
+
+package main
+
+// Link the example extension, see extensions/example
+import _ "template-custom-agent-go/extensions/example"
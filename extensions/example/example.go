@@ -0,0 +1,93 @@
+// Package example is an extension adding a tool, hooks and a channel, linked into the server by
+// building with -tags example_extension. Copy it as a starting point for your own extensions.
+package example
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"template-custom-agent-go/pkg/agent"
+	"template-custom-agent-go/pkg/agentext"
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/events"
+	"template-custom-agent-go/pkg/logger"
+	"template-custom-agent-go/pkg/tools"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	agentext.Register(agentext.Extension{
+		Name:       "example",
+		Tools:      []tools.Tool{wordCountTool},
+		Hooks:      map[string]events.Handler{events.RunFinished: logRun},
+		AgentHooks: []agent.HookFactory{newAuditHook},
+		Channels:   []agentext.Channel{echoChannel},
+	})
+}
+
+// wordCountTool counts the words of a text
+var wordCountTool = tools.Tool{
+	Name:        "word_count",
+	Description: "Count the words of a text.",
+	Parameters: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"text": map[string]interface{}{"type": "string", "description": "The text to count the words of"},
+		},
+		"required": []string{"text"},
+	},
+	Handler: func(ctx context.Context, arguments map[string]interface{}) (string, error) {
+		text, _ := arguments["text"].(string)
+		return fmt.Sprintf("%d words", len(strings.Fields(text))), nil
+	},
+}
+
+// logRun logs the outcome of every agent run
+func logRun(ctx context.Context, event events.Event) {
+	finish := event.Data.(events.RunFinish)
+	logger.InfofCtx(ctx, "Example extension: run %s of %s finished in %dms (%s%s)",
+		event.RunID, event.Agent, finish.DurationMs, finish.FinishReason, finish.Error)
+}
+
+// auditHook logs the tool calls of agents before they run
+type auditHook struct {
+	agent.NoopHook
+}
+
+// newAuditHook creates the audit hook of an agent
+func newAuditHook() agent.Hook {
+	return auditHook{}
+}
+
+// OnToolCall logs the tool call
+func (auditHook) OnToolCall(ctx context.Context, iteration int, call blaxel.ToolCall) error {
+	logger.InfofCtx(ctx, "Example extension: iteration %d calls %s with %s", iteration, call.Function.Name, call.Function.Arguments)
+	return nil
+}
+
+// echoChannel answers the messages posted to /channels/example with the agent
+func echoChannel(engine *gin.Engine, runner agentext.Runner) {
+	engine.POST("/channels/example", func(c *gin.Context) {
+		var message struct {
+			Text      string `json:"text" binding:"required"`
+			SessionID string `json:"session_id"`
+		}
+		if err := c.ShouldBindJSON(&message); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		result, err := runner.RunAgent(c.Request.Context(), agentext.RunRequest{
+			Channel:   "example",
+			Inputs:    message.Text,
+			SessionID: message.SessionID,
+		})
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"reply": result.Answer, "session_id": result.SessionID})
+	})
+}
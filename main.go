@@ -1,16 +1,43 @@
 package main
 
 import (
+	"context"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/config"
+	"template-custom-agent-go/pkg/dnscache"
+	"template-custom-agent-go/pkg/egress"
 	"template-custom-agent-go/pkg/logger"
 	"template-custom-agent-go/pkg/router"
+	"template-custom-agent-go/pkg/telemetry"
 
 	"github.com/gin-gonic/gin"
+	"github.com/pires/go-proxyproto"
 )
 
+// defaultShutdownTimeout bounds how long in-flight requests are drained on shutdown when
+// BL_SHUTDOWN_TIMEOUT is not set
+const defaultShutdownTimeout = 30 * time.Second
+
 func main() {
 	gin.SetMode(gin.ReleaseMode)
+	// Route and restrict outbound traffic before any HTTP client is created
+	egress.InstallProxy(egress.ProxyRulesFromEnv())
+	dnscache.Install(dnscache.ConfigFromEnv())
+	egress.Install(egress.PolicyFromEnv())
+
+	// Export spans over OTLP when configured with the OTEL_* variables
+	shutdownTracing, err := telemetry.Install(context.Background(), telemetry.ConfigFromEnv())
+	if err != nil {
+		logger.Fatalf("Failed to set up tracing: %v", err)
+	}
+
 	// Initialize Blaxel client
 	bl := blaxel.NewClient()
 
@@ -18,7 +45,10 @@ func main() {
 	r := router.NewRouter(bl)
 
 	// Setup all routes
-	engine := r.SetupRoutes()
+	engine, err := r.SetupRoutes()
+	if err != nil {
+		logger.Fatalf("Failed to set up routes: %v", err)
+	}
 
 	// Get host from environment variable or use default
 	host := os.Getenv("HOST")
@@ -31,9 +61,104 @@ func main() {
 		port = "80"
 	}
 
+	// Attribute requests to clients behind proxies, load balancers and CDNs
+	network, err := config.LoadNetworkConfig()
+	if err != nil {
+		logger.Fatalf("Invalid network configuration: %v", err)
+	}
+	engine.TrustedPlatform = network.TrustedPlatform
+	if network.TrustedProxies != nil {
+		if err := engine.SetTrustedProxies(network.TrustedProxies); err != nil {
+			logger.Fatalf("Invalid trusted proxies: %v", err)
+		}
+	}
+
+	listener, err := listen(host+":"+port, network)
+	if err != nil {
+		logger.Fatalf("Failed to listen on port %s: %v", port, err)
+	}
+
 	// Start server on the specified port
+	r.Announce(listener.Addr().String())
 	logger.Infof("Starting server on port %s", port)
-	if err := engine.Run(host + ":" + port); err != nil {
+	server := &http.Server{Handler: engine.Handler()}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	// Shut down on SIGTERM or SIGINT, a second signal killing the process
+	signals, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	select {
+	case err := <-served:
 		logger.Fatalf("Failed to start server: %v", err)
+	case <-signals.Done():
+		stop()
+	}
+	shutdown(server, r, bl, shutdownTracing)
+}
+
+// shutdown stops accepting connections and drains in-flight requests for up to BL_SHUTDOWN_TIMEOUT,
+// closing the connections of those still running afterwards, then closes the MCP connections and the
+// conversation store and flushes the pending spans
+func shutdown(server *http.Server, r *router.Router, bl *blaxel.Client, shutdownTracing func(context.Context) error) {
+	timeout := shutdownTimeout()
+	logger.Infof("Shutting down, draining in-flight requests for up to %s", timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Warningf("In-flight requests did not finish within %s, closing their connections", timeout)
+		server.Close()
+	}
+
+	// Close logs the servers whose connection failed to close
+	bl.McpManager.Close()
+	r.Close()
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := shutdownTracing(flushCtx); err != nil {
+		logger.Warningf("Failed to flush spans: %v", err)
+	}
+	logger.Infof("Server stopped")
+}
+
+// shutdownTimeout reads BL_SHUTDOWN_TIMEOUT, a duration such as 30s, zero closing in-flight
+// requests immediately
+func shutdownTimeout() time.Duration {
+	value := os.Getenv("BL_SHUTDOWN_TIMEOUT")
+	if value == "" {
+		return defaultShutdownTimeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		logger.Warningf("Invalid BL_SHUTDOWN_TIMEOUT %q, using %s", value, defaultShutdownTimeout)
+		return defaultShutdownTimeout
+	}
+	return timeout
+}
+
+// listen opens the server listener, reading PROXY protocol headers when enabled
+func listen(address string, network config.NetworkConfig) (net.Listener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if !network.ProxyProtocol {
+		return listener, nil
+	}
+
+	proxyListener := &proxyproto.Listener{Listener: listener, ReadHeaderTimeout: 10 * time.Second}
+	if len(network.ProxyProtocolSources) > 0 {
+		policy, err := proxyproto.ConnLaxWhiteListPolicy(network.ProxyProtocolSources)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		proxyListener.ConnPolicy = policy
 	}
+	logger.Infof("PROXY protocol enabled")
+	return proxyListener, nil
 }
@@ -0,0 +1,155 @@
+package a2a
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// defaultMaxTasks bounds the number of tasks kept in memory
+const defaultMaxTasks = 1000
+
+// TaskStore keeps A2A tasks in memory and tracks cancellation of running tasks
+type TaskStore struct {
+	mu       sync.RWMutex
+	tasks    map[string]*Task
+	cancels  map[string]context.CancelFunc
+	order    []string
+	maxTasks int
+}
+
+// NewTaskStore creates a new in-memory task store
+func NewTaskStore() *TaskStore {
+	return &TaskStore{
+		tasks:    make(map[string]*Task),
+		cancels:  make(map[string]context.CancelFunc),
+		maxTasks: defaultMaxTasks,
+	}
+}
+
+// Create registers a new submitted task for the given user message
+func (s *TaskStore) Create(message Message) Task {
+	contextID := message.ContextID
+	if contextID == "" {
+		contextID = uuid.NewString()
+	}
+	if message.Kind == "" {
+		message.Kind = "message"
+	}
+	if message.MessageID == "" {
+		message.MessageID = uuid.NewString()
+	}
+
+	task := &Task{
+		Kind:      "task",
+		ID:        uuid.NewString(),
+		ContextID: contextID,
+		Status: TaskStatus{
+			State:     TaskStateSubmitted,
+			Timestamp: time.Now(),
+		},
+	}
+	message.TaskID = task.ID
+	message.ContextID = contextID
+	task.History = []Message{message}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks[task.ID] = task
+	s.order = append(s.order, task.ID)
+	s.evictLocked()
+
+	return *task
+}
+
+// Get returns a snapshot of the task with the given ID
+func (s *TaskStore) Get(id string) (Task, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	task, exists := s.tasks[id]
+	if !exists {
+		return Task{}, false
+	}
+	return *task, true
+}
+
+// SetCancel records the function used to cancel a running task
+func (s *TaskStore) SetCancel(id string, cancel context.CancelFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cancels[id] = cancel
+}
+
+// UpdateStatus moves the task to a new state and returns the resulting snapshot
+func (s *TaskStore) UpdateStatus(id string, state TaskState, message *Message) (Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, exists := s.tasks[id]
+	if !exists {
+		return Task{}, fmt.Errorf("task %s not found", id)
+	}
+	if task.Status.State.IsTerminal() {
+		return *task, fmt.Errorf("task %s is already %s", id, task.Status.State)
+	}
+
+	task.Status = TaskStatus{
+		State:     state,
+		Message:   message,
+		Timestamp: time.Now(),
+	}
+	if message != nil {
+		task.History = append(task.History, *message)
+	}
+	if state.IsTerminal() {
+		delete(s.cancels, id)
+	}
+	return *task, nil
+}
+
+// AddArtifact attaches an artifact to the task
+func (s *TaskStore) AddArtifact(id string, artifact Artifact) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, exists := s.tasks[id]
+	if !exists {
+		return fmt.Errorf("task %s not found", id)
+	}
+	task.Artifacts = append(task.Artifacts, artifact)
+	return nil
+}
+
+// Cancel stops a running task and marks it as canceled
+func (s *TaskStore) Cancel(id string) (Task, error) {
+	s.mu.Lock()
+	cancel := s.cancels[id]
+	s.mu.Unlock()
+
+	task, err := s.UpdateStatus(id, TaskStateCanceled, nil)
+	if err != nil {
+		return task, err
+	}
+	if cancel != nil {
+		cancel()
+	}
+	return task, nil
+}
+
+// evictLocked drops the oldest finished tasks once the store exceeds its capacity
+func (s *TaskStore) evictLocked() {
+	for i := 0; len(s.tasks) > s.maxTasks && i < len(s.order); {
+		id := s.order[i]
+		task, exists := s.tasks[id]
+		if exists && !task.Status.State.IsTerminal() {
+			i++
+			continue
+		}
+		delete(s.tasks, id)
+		s.order = append(s.order[:i], s.order[i+1:]...)
+	}
+}
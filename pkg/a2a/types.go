@@ -0,0 +1,186 @@
+package a2a
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ProtocolVersion is the A2A protocol version implemented by this server
+const ProtocolVersion = "0.3.0"
+
+// TaskState represents the lifecycle state of an A2A task
+type TaskState string
+
+const (
+	TaskStateSubmitted TaskState = "submitted"
+	TaskStateWorking   TaskState = "working"
+	TaskStateCompleted TaskState = "completed"
+	TaskStateCanceled  TaskState = "canceled"
+	TaskStateFailed    TaskState = "failed"
+)
+
+// IsTerminal reports whether no further transitions are possible from the state
+func (s TaskState) IsTerminal() bool {
+	return s == TaskStateCompleted || s == TaskStateCanceled || s == TaskStateFailed
+}
+
+// Part represents a single piece of message or artifact content
+type Part struct {
+	Kind string          `json:"kind"` // "text", "data", "file"
+	Text string          `json:"text,omitempty"`
+	Data json.RawMessage `json:"data,omitempty"`
+	File *FileContent    `json:"file,omitempty"`
+}
+
+// FileContent references a file by URI, such as a result uploaded to object storage
+type FileContent struct {
+	Name     string `json:"name,omitempty"`
+	MimeType string `json:"mimeType,omitempty"`
+	URI      string `json:"uri"`
+}
+
+// Message represents a single turn exchanged between a client and the agent
+type Message struct {
+	Kind      string `json:"kind"`
+	MessageID string `json:"messageId"`
+	Role      string `json:"role"` // "user", "agent"
+	Parts     []Part `json:"parts"`
+	TaskID    string `json:"taskId,omitempty"`
+	ContextID string `json:"contextId,omitempty"`
+}
+
+// Text concatenates all text parts of the message
+func (m Message) Text() string {
+	text := ""
+	for _, part := range m.Parts {
+		if part.Kind == "text" {
+			text += part.Text
+		}
+	}
+	return text
+}
+
+// Artifact represents an output produced by a task
+type Artifact struct {
+	ArtifactID string `json:"artifactId"`
+	Name       string `json:"name,omitempty"`
+	Parts      []Part `json:"parts"`
+}
+
+// TaskStatus represents the current status of a task
+type TaskStatus struct {
+	State     TaskState `json:"state"`
+	Message   *Message  `json:"message,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Task represents a unit of work requested by an A2A client
+type Task struct {
+	Kind      string     `json:"kind"`
+	ID        string     `json:"id"`
+	ContextID string     `json:"contextId"`
+	Status    TaskStatus `json:"status"`
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+	History   []Message  `json:"history,omitempty"`
+}
+
+// TaskStatusUpdateEvent is streamed to clients when a task changes state
+type TaskStatusUpdateEvent struct {
+	Kind      string     `json:"kind"`
+	TaskID    string     `json:"taskId"`
+	ContextID string     `json:"contextId"`
+	Status    TaskStatus `json:"status"`
+	Final     bool       `json:"final"`
+}
+
+// TaskArtifactUpdateEvent is streamed to clients when a task produces an artifact
+type TaskArtifactUpdateEvent struct {
+	Kind      string   `json:"kind"`
+	TaskID    string   `json:"taskId"`
+	ContextID string   `json:"contextId"`
+	Artifact  Artifact `json:"artifact"`
+	LastChunk bool     `json:"lastChunk"`
+}
+
+// MessageSendParams represents the params of message/send and message/stream
+type MessageSendParams struct {
+	Message       Message `json:"message"`
+	Configuration *struct {
+		Blocking *bool `json:"blocking,omitempty"`
+	} `json:"configuration,omitempty"`
+}
+
+// TaskIDParams represents the params of tasks/get and tasks/cancel
+type TaskIDParams struct {
+	ID string `json:"id"`
+}
+
+// AgentCard describes the agent to A2A clients
+type AgentCard struct {
+	ProtocolVersion    string            `json:"protocolVersion"`
+	Name               string            `json:"name"`
+	Description        string            `json:"description"`
+	URL                string            `json:"url"`
+	Version            string            `json:"version"`
+	Capabilities       AgentCapabilities `json:"capabilities"`
+	DefaultInputModes  []string          `json:"defaultInputModes"`
+	DefaultOutputModes []string          `json:"defaultOutputModes"`
+	Skills             []AgentSkill      `json:"skills"`
+}
+
+// AgentCapabilities lists the optional protocol features supported by the agent
+type AgentCapabilities struct {
+	Streaming         bool `json:"streaming"`
+	PushNotifications bool `json:"pushNotifications"`
+}
+
+// AgentSkill describes a capability the agent exposes to A2A clients
+type AgentSkill struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+}
+
+// JSON-RPC error codes defined by JSON-RPC 2.0 and the A2A specification
+const (
+	ErrCodeParse             = -32700
+	ErrCodeInvalidRequest    = -32600
+	ErrCodeMethodNotFound    = -32601
+	ErrCodeInvalidParams     = -32602
+	ErrCodeInternal          = -32603
+	ErrCodeTaskNotFound      = -32001
+	ErrCodeTaskNotCancelable = -32002
+)
+
+// JSONRPCRequest represents an incoming JSON-RPC 2.0 request
+type JSONRPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      interface{}     `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+// JSONRPCError represents a JSON-RPC 2.0 error object
+type JSONRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// JSONRPCResponse represents an outgoing JSON-RPC 2.0 response
+type JSONRPCResponse struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      interface{}   `json:"id"`
+	Result  interface{}   `json:"result,omitempty"`
+	Error   *JSONRPCError `json:"error,omitempty"`
+}
+
+// NewResult creates a successful JSON-RPC response
+func NewResult(id interface{}, result interface{}) JSONRPCResponse {
+	return JSONRPCResponse{JSONRPC: "2.0", ID: id, Result: result}
+}
+
+// NewError creates a failed JSON-RPC response
+func NewError(id interface{}, code int, message string) JSONRPCResponse {
+	return JSONRPCResponse{JSONRPC: "2.0", ID: id, Error: &JSONRPCError{Code: code, Message: message}}
+}
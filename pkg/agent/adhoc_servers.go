@@ -0,0 +1,45 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"template-custom-agent-go/pkg/blaxel"
+)
+
+// RegisterAdHocServer exposes the tools of an MCP server connected for a single request, routing
+// their calls to it
+func (tm *ToolManager) RegisterAdHocServer(ctx context.Context, server *blaxel.AdHocServer) ([]blaxel.Tool, error) {
+	mcpTools, err := server.ListTools(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tools := make([]blaxel.Tool, 0, len(mcpTools))
+	for _, mcpTool := range mcpTools {
+		name := mcpTool.Name
+		tool := blaxel.Tool{
+			Type: "function",
+			Function: blaxel.Function{
+				Name:        name,
+				Description: mcpTool.Description,
+				Parameters:  convertParameters(mcpTool.InputSchema),
+			},
+		}
+
+		tools = append(tools, tm.RegisterLocalTool(tool, func(ctx context.Context, arguments map[string]interface{}) (string, error) {
+			result, err := server.CallTool(ctx, name, arguments)
+			if err != nil {
+				return "", err
+			}
+			content, err := json.Marshal(result.Content)
+			if err != nil {
+				return "", fmt.Errorf("failed to marshal tool result: %w", err)
+			}
+			return string(content), nil
+		}))
+	}
+
+	return tools, nil
+}
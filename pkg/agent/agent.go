@@ -4,29 +4,237 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"maps"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"sync"
 	"time"
 
 	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/events"
+	"template-custom-agent-go/pkg/glossary"
+	"template-custom-agent-go/pkg/i18n"
 	"template-custom-agent-go/pkg/logger"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Agent represents an AI agent with configurable model and tools
 type Agent struct {
 	name          string
 	model         string
+	synthesis     string
+	language      string
 	tools         []blaxel.Tool
 	blaxelClient  *blaxel.Client
 	systemPrompt  string
 	maxIterations int
+	maxTokens     int
+	contextTokens int
+	toolTimeout   time.Duration
 	toolManager   *ToolManager
+	guard         *toolResultGuard
+	handoff       *handoffConfig
+	handoffResult *Handoff
+	verify        bool
+	verification  *Verification
+	format        AnswerFormat
+	formatCheck   *FormatCheck
+	// jsonFormat asks for a JSON answer, checked and repaired when it does not follow it
+	jsonFormat    *responseFormat
+	jsonCheck     *StructuredOutput
+	rewrite       *rewriteConfig
+	rewriteResult *Rewrite
+	glossary      *glossary.Glossary
+	toolLimits    ToolCallLimits
+	toolPolicy    *toolPolicy
+	sampling      SamplingSchedule
+	budget        *toolBudget
+	intermediate  []IntermediateMessage
+	stats         RunStats
+	compactions   []Compaction
+	onDelta       func(Delta)
+	onTool        func(ToolEvent)
+	onIteration   func(IterationEvent)
+	onUsage       func(UsageEvent)
+	hooks         []Hook
+	bus           *events.Bus
+}
+
+// Delta is a fragment of assistant content streamed from the model while it is generated
+type Delta struct {
+	Iteration int    `json:"iteration"`
+	Content   string `json:"content"`
+}
+
+// IterationEvent reports a model turn of a run when it starts
+type IterationEvent struct {
+	Iteration int    `json:"iteration"`
+	Model     string `json:"model"`
+}
+
+// UsageEvent reports the token usage of a model response
+type UsageEvent struct {
+	Iteration int    `json:"iteration"`
+	Model     string `json:"model"`
+	blaxel.UsageInfo
+}
+
+// Statuses of tool events
+const (
+	ToolStarted   = "started"
+	ToolCompleted = "completed"
+	ToolSkipped   = "skipped"
+	ToolBlocked   = "blocked"
+)
+
+// ToolEvent reports a tool call of a run when it starts and once its result is known
+type ToolEvent struct {
+	Iteration  int    `json:"iteration"`
+	ToolCallID string `json:"tool_call_id"`
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	// Arguments are only set when the call starts
+	Arguments string `json:"arguments,omitempty"`
+	// DurationMs and ResultBytes describe the result of completed calls
+	DurationMs  int64 `json:"duration_ms,omitempty"`
+	ResultBytes int   `json:"result_bytes,omitempty"`
+	// Flagged is set when the tool result guard suspects a prompt injection in the result
+	Flagged bool `json:"flagged,omitempty"`
+}
+
+// IntermediateMessageType labels assistant turns that precede the final answer
+const IntermediateMessageType = "intermediate"
+
+// IntermediateMessage is an assistant turn of a run that requested tool calls before the final answer
+type IntermediateMessage struct {
+	Type      string            `json:"type"`
+	Iteration int               `json:"iteration"`
+	Content   string            `json:"content"`
+	ToolCalls []blaxel.ToolCall `json:"tool_calls,omitempty"`
 }
 
 // Config holds configuration for creating an agent
 type Config struct {
-	Name          string
-	Model         string
-	SystemPrompt  string
-	MaxIterations int
+	Name  string
+	Model string
+	// SystemPrompt is rendered as a Go text/template with PromptVariables, see RenderSystemPrompt
+	SystemPrompt    string
+	PromptVariables map[string]any
+	MaxIterations   int
+	// SynthesisModel answers the final turn without tools while Model drives the tool-calling
+	// iterations, BL_SYNTHESIS_MODEL being used when empty. No separate synthesis turn is run when
+	// it is not set or is the same as Model.
+	SynthesisModel string
+	// MaxToolCalls bounds the tool calls of a run, in total and per tool
+	MaxToolCalls ToolCallLimits
+	// AllowedTools restricts the tools of the run to these names when not nil, an empty list
+	// allowing none, and BlockedTools are never available. Calls to other tools are rejected.
+	AllowedTools []string
+	BlockedTools []string
+	// MaxTotalTokens stops a run once the tokens used across its model calls reach it,
+	// BL_MAX_TOTAL_TOKENS being used when zero. Runs are not limited when neither is set.
+	MaxTotalTokens int
+	// Language of the messages generated by the agent itself, BL_LANGUAGE being used when empty
+	Language string
+	// Sampling sets the temperature and top_p of each turn, BL_SAMPLING_SCHEDULE being used when empty
+	Sampling SamplingSchedule
+	// ToolTimeout bounds the duration of each tool call, BL_TOOL_TIMEOUT being used when zero and a
+	// negative value disabling the timeout
+	ToolTimeout time.Duration
+	// Verify recomputes the calculations of the final answer and runs its Python snippets in the
+	// sandbox, correcting the answer once when they do not hold. BL_VERIFY_ANSWERS=true enables it
+	// for every run.
+	Verify bool
+	// Glossary is added to the system prompt and enforced on the final answer when set
+	Glossary *glossary.Glossary
+	// AnswerFormat constrains the length and layout of the final answer, BL_ANSWER_FORMAT being used
+	// when empty
+	AnswerFormat AnswerFormat
+	// ResponseFormat asks for the final answer as a JSON object or following a JSON schema, the
+	// answer being repaired when it does not. The answer format and the rewrite are then not applied.
+	ResponseFormat *blaxel.ResponseFormat
+	// RewriteProfile names the profile of BL_REWRITE_PROFILES the final answer is rewritten in by a
+	// cheap model, BL_REWRITE_PROFILE being used when empty
+	RewriteProfile string
+}
+
+// messagePool reuses conversation slices across agent runs
+var messagePool = sync.Pool{
+	New: func() interface{} {
+		messages := make([]blaxel.ChatMessage, 0, 16)
+		return &messages
+	},
+}
+
+// maxPooledMessages bounds the capacity of slices returned to the pool so one long run does not pin memory
+const maxPooledMessages = 256
+
+// acquireMessages returns an empty conversation slice from the pool
+func acquireMessages() []blaxel.ChatMessage {
+	return (*messagePool.Get().(*[]blaxel.ChatMessage))[:0]
+}
+
+// releaseMessages clears a conversation slice and returns it to the pool
+func releaseMessages(messages *[]blaxel.ChatMessage) {
+	if cap(*messages) > maxPooledMessages {
+		return
+	}
+	clear(*messages)
+	*messages = (*messages)[:0]
+	messagePool.Put(messages)
+}
+
+// defaultContextTokens is the context window assumed when BL_MODEL_CONTEXT_TOKENS is not set
+const defaultContextTokens = 128000
+
+// contextWarningRatio is the share of the context window above which request payloads are logged as warnings
+const contextWarningRatio = 0.8
+
+// contextTokenLimit returns the context window of the model from BL_MODEL_CONTEXT_TOKENS
+func contextTokenLimit() int {
+	if value := os.Getenv("BL_MODEL_CONTEXT_TOKENS"); value != "" {
+		if limit, err := strconv.Atoi(value); err == nil && limit > 0 {
+			return limit
+		}
+		logger.Warningf("Invalid BL_MODEL_CONTEXT_TOKENS %q, using %d", value, defaultContextTokens)
+	}
+	return defaultContextTokens
+}
+
+// maxTotalTokensFromEnv returns the token budget of runs from BL_MAX_TOTAL_TOKENS, 0 when unlimited
+func maxTotalTokensFromEnv() int {
+	if value := os.Getenv("BL_MAX_TOTAL_TOKENS"); value != "" {
+		if limit, err := strconv.Atoi(value); err == nil && limit >= 0 {
+			return limit
+		}
+		logger.Warningf("Invalid BL_MAX_TOTAL_TOKENS %q, runs have no token budget", value)
+	}
+	return 0
+}
+
+// FinishReasonBudgetExceeded is the finish reason of runs stopped by their token budget
+const FinishReasonBudgetExceeded = "budget_exceeded"
+
+// defaultToolTimeout bounds each tool call when BL_TOOL_TIMEOUT is not set
+const defaultToolTimeout = 60 * time.Second
+
+// toolTimeoutFromEnv returns the tool call timeout from BL_TOOL_TIMEOUT, a duration such as 30s,
+// 0 disabling the timeout
+func toolTimeoutFromEnv() time.Duration {
+	if value := os.Getenv("BL_TOOL_TIMEOUT"); value != "" {
+		if timeout, err := time.ParseDuration(value); err == nil && timeout >= 0 {
+			if timeout == 0 {
+				return -1
+			}
+			return timeout
+		}
+		logger.Warningf("Invalid BL_TOOL_TIMEOUT %q, using %s", value, defaultToolTimeout)
+	}
+	return defaultToolTimeout
 }
 
 // NewAgent creates a new agent with the given configuration
@@ -40,24 +248,118 @@ func NewAgent(config Config, blaxelClient *blaxel.Client) *Agent {
 	if systemPrompt == "" {
 		systemPrompt = "You are a helpful AI assistant. Use the available tools when needed to help answer user questions."
 	}
+	if rendered, err := RenderSystemPrompt(systemPrompt, config.PromptVariables); err == nil {
+		systemPrompt = rendered
+	} else {
+		logger.Warningf("Agent %s: using the system prompt as written: %v", config.Name, err)
+	}
+
+	synthesisModel := config.SynthesisModel
+	if synthesisModel == "" {
+		synthesisModel = os.Getenv("BL_SYNTHESIS_MODEL")
+	}
+	if synthesisModel == config.Model {
+		synthesisModel = ""
+	}
+
+	language := config.Language
+	if language == "" {
+		language = i18n.DefaultLanguage()
+	}
+
+	toolTimeout := config.ToolTimeout
+	if toolTimeout == 0 {
+		toolTimeout = toolTimeoutFromEnv()
+	}
+
+	sampling := config.Sampling
+	if sampling.IsZero() {
+		sampling = defaultSamplingSchedule()
+	}
+
+	maxTokens := config.MaxTotalTokens
+	if maxTokens <= 0 {
+		maxTokens = maxTotalTokensFromEnv()
+	}
+
+	format := config.AnswerFormat
+	if format.IsZero() {
+		format = defaultAnswerFormat()
+	}
+
+	responseFormat, err := newResponseFormat(config.ResponseFormat)
+	if err != nil {
+		logger.Warningf("Agent %s: %v, answers are not checked against the response format", config.Name, err)
+	}
 
 	return &Agent{
 		name:          config.Name,
 		model:         config.Model,
+		synthesis:     synthesisModel,
+		language:      language,
 		blaxelClient:  blaxelClient,
 		systemPrompt:  systemPrompt,
 		maxIterations: maxIterations,
+		maxTokens:     maxTokens,
+		contextTokens: contextTokenLimit(),
+		toolTimeout:   toolTimeout,
 		tools:         []blaxel.Tool{},
 		toolManager:   NewToolManager(),
+		guard:         newToolResultGuard(),
+		handoff:       newHandoffConfig(),
+		toolLimits:    config.MaxToolCalls,
+		toolPolicy:    newToolPolicy(config.AllowedTools, config.BlockedTools),
+		sampling:      sampling,
+		verify:        config.Verify || verifyAnswersFromEnv(),
+		glossary:      config.Glossary,
+		format:        format,
+		jsonFormat:    responseFormat,
+		rewrite:       newRewriteConfig(config.RewriteProfile, config.Model),
+		hooks:         defaultHooks(),
+		bus:           events.Default,
 	}
 }
 
-// SetTools sets the tools available to the agent
+// SetTools sets the tools available to the agent, leaving out those its tool policy does not allow
 func (a *Agent) SetTools(tools []blaxel.Tool) *Agent {
-	a.tools = tools
+	a.tools = a.toolPolicy.filter(tools)
 	return a
 }
 
+// AddTool gives the agent a tool running the handler in process, unless its tool policy does not allow it.
+// Call it after SetToolManager, which would drop the handler.
+func (a *Agent) AddTool(tool blaxel.Tool, handler LocalToolHandler) *Agent {
+	tool = a.toolManager.RegisterLocalTool(tool, handler)
+	a.tools = append(a.tools, a.toolPolicy.filter([]blaxel.Tool{tool})...)
+	return a
+}
+
+// KeepTools restricts the tools offered to the model to the given names, returning the names the
+// agent has no tool for
+func (a *Agent) KeepTools(names []string) []string {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	kept := make([]blaxel.Tool, 0, len(names))
+	for _, tool := range a.tools {
+		if wanted[tool.Function.Name] {
+			kept = append(kept, tool)
+			delete(wanted, tool.Function.Name)
+		}
+	}
+	a.tools = kept
+
+	var unknown []string
+	for _, name := range names {
+		if wanted[name] {
+			unknown = append(unknown, name)
+			delete(wanted, name)
+		}
+	}
+	return unknown
+}
+
 // SetToolManager sets the tool manager for the agent
 func (a *Agent) SetToolManager(tm *ToolManager) *Agent {
 	a.toolManager = tm
@@ -70,6 +372,39 @@ func (a *Agent) SetSystemPrompt(prompt string) *Agent {
 	return a
 }
 
+// SetDeltaHandler streams the content of model turns to handler as it is generated. Turns drafted by
+// the iteration model are not streamed when a synthesis model writes the answer.
+func (a *Agent) SetDeltaHandler(handler func(Delta)) *Agent {
+	a.onDelta = handler
+	return a
+}
+
+// PostProcessesAnswer reports whether the final answer of a run can differ from the content streamed
+// to the delta handler, being corrected after verification, reformatted to the response or answer
+// format, rewritten, enforced by the glossary or changed by a hook implementing AnswerRewriter, so
+// callers streaming the answer should only send the final content
+func (a *Agent) PostProcessesAnswer() bool {
+	return a.verify || a.jsonFormat != nil || !a.format.IsZero() || a.rewrite != nil || a.glossary != nil || a.hooksRewriteAnswer()
+}
+
+// SetToolHandler reports the tool calls of runs to handler as they are executed
+func (a *Agent) SetToolHandler(handler func(ToolEvent)) *Agent {
+	a.onTool = handler
+	return a
+}
+
+// SetIterationHandler reports the model turns of runs to handler as they start
+func (a *Agent) SetIterationHandler(handler func(IterationEvent)) *Agent {
+	a.onIteration = handler
+	return a
+}
+
+// SetUsageHandler reports the token usage of each model response of runs to handler
+func (a *Agent) SetUsageHandler(handler func(UsageEvent)) *Agent {
+	a.onUsage = handler
+	return a
+}
+
 // SetMaxIterations sets the maximum number of iterations for the agent loop
 func (a *Agent) SetMaxIterations(max int) *Agent {
 	a.maxIterations = max
@@ -78,70 +413,523 @@ func (a *Agent) SetMaxIterations(max int) *Agent {
 
 // Run executes the agent loop with the given user input
 func (a *Agent) Run(ctx context.Context, userInput string) (*blaxel.ChatCompletionResponse, error) {
-	// Initialize conversation
-	messages := []blaxel.ChatMessage{
-		{
-			Role:    "system",
-			Content: a.systemPrompt,
-		},
-		{
-			Role:    "user",
-			Content: userInput,
-		},
+	return a.RunConversation(ctx, []blaxel.ChatMessage{{Role: "user", Content: userInput}})
+}
+
+// RunConversation executes the agent loop continuing a conversation, such as the messages of an
+// OpenAI chat completion request, after the system prompt of the agent
+func (a *Agent) RunConversation(ctx context.Context, conversation []blaxel.ChatMessage) (*blaxel.ChatCompletionResponse, error) {
+	// Identify the run in the provenance of its tool calls when the caller did not
+	if blaxel.RunIDFromContext(ctx) == "" {
+		ctx = blaxel.WithRunID(ctx, uuid.NewString())
+	}
+	ctx, span := tracer.Start(ctx, "invoke_agent "+a.name, trace.WithAttributes(
+		attribute.String("gen_ai.operation.name", "invoke_agent"),
+		attribute.String("gen_ai.agent.name", a.name),
+		attribute.String("gen_ai.request.model", a.model),
+	), trace.WithAttributes(executionAttributes()...))
+	start := time.Now()
+	a.publish(ctx, events.RunStarted, events.RunStart{Model: a.model, Messages: len(conversation)})
+	resp, err := a.runConversation(ctx, conversation)
+	if err == nil {
+		if a.rewrite != nil && a.jsonFormat == nil {
+			a.rewriteAnswer(ctx, resp)
+		}
+		// Report the tokens of the whole run rather than those of its last model call
+		resp.Usage = a.stats.TotalUsage()
+		if a.glossary != nil && a.jsonFormat == nil {
+			a.applyGlossary(ctx, resp)
+		}
+		if err = a.finishRun(ctx, resp); err != nil {
+			resp = nil
+		}
+	}
+	endRunSpan(span, a.stats, err)
+	a.publishRunFinished(ctx, resp, err, time.Since(start))
+	return resp, err
+}
+
+// applyGlossary enforces the glossary on the final answer
+func (a *Agent) applyGlossary(ctx context.Context, resp *blaxel.ChatCompletionResponse) {
+	for i := range resp.Choices {
+		content, replacements := a.glossary.Apply(resp.Choices[i].Message.Content)
+		if replacements.Total() == 0 {
+			continue
+		}
+		resp.Choices[i].Message.Content = content
+		logger.DebugfCtx(ctx, "Agent %s: glossary replaced %d preferred terms, %d banned terms and %d names",
+			a.name, replacements.Preferred, replacements.Banned, replacements.Names)
+	}
+}
+
+// runConversation runs the iterations of the agent loop until the model answers without tool calls
+func (a *Agent) runConversation(ctx context.Context, conversation []blaxel.ChatMessage) (*blaxel.ChatCompletionResponse, error) {
+	// Tell the model how untrusted tool results are delimited
+	systemPrompt := a.systemPrompt
+	if a.guard != nil {
+		systemPrompt += "\n\n" + a.guard.systemReminder()
+	}
+	if a.glossary != nil {
+		systemPrompt += "\n\n" + a.glossary.Prompt()
+	}
+	if a.jsonFormat != nil {
+		systemPrompt += "\n\n" + a.jsonFormat.prompt()
+	} else if !a.format.IsZero() {
+		systemPrompt += "\n\n" + a.format.prompt()
 	}
 
+	// Initialize conversation in a pooled slice, released once the loop is done with it
+	messages := acquireMessages()
+	defer releaseMessages(&messages)
+	messages = append(messages, blaxel.ChatMessage{
+		Role:    "system",
+		Content: systemPrompt,
+	})
+	messages = append(messages, conversation...)
+
+	a.intermediate = nil
+	a.handoffResult = nil
+	a.verification = nil
+	a.formatCheck = nil
+	a.jsonCheck = nil
+	a.rewriteResult = nil
+	a.budget = newToolBudget(a.toolLimits)
+	a.stats = newRunStats()
+	a.compactions = nil
+
+	// Encode the conversation incrementally across iterations
+	encoder := blaxel.NewRequestEncoder()
+
 	// Run agent loop
 	for iteration := 1; iteration <= a.maxIterations; iteration++ {
-		// Send request to AI model
-		req := blaxel.ChatCompletionRequest{
-			Messages: messages,
-			Tools:    a.tools,
+		// Stop once the caller is gone instead of paying for turns nobody reads
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("agent run cancelled before iteration %d: %w", iteration, err)
+		}
+		resp, done, err := a.runIteration(ctx, iteration, encoder, &messages)
+		if err != nil {
+			return nil, err
+		}
+		a.publishIteration(ctx, iteration, resp, done)
+		if done {
+			if a.handoffResult == nil {
+				resp = a.checkAnswer(ctx, encoder, &messages, resp)
+			}
+			return resp, nil
 		}
+		if a.budgetExceeded() {
+			logger.InfofCtx(ctx, "Agent %s stopped after iteration %d: %d tokens used, budget of %d tokens reached",
+				a.name, iteration, a.stats.TotalUsage().TotalTokens, a.maxTokens)
+			return a.createLimitResponse(i18n.BudgetExceeded, FinishReasonBudgetExceeded), nil
+		}
+	}
 
-		logger.Debugf("Iteration %d: Sending request with %d tools", iteration, len(a.tools))
-		if len(a.tools) > 0 {
-			logger.Debugf("Tools being sent: %v", a.tools[0].Function.Name)
+	// Max iterations reached, let the synthesis model answer with what was gathered
+	if a.synthesis != "" {
+		resp, err := a.synthesize(ctx, a.maxIterations+1, encoder, &messages)
+		if err == nil {
+			resp = a.checkAnswer(ctx, encoder, &messages, resp)
 		}
+		return resp, err
+	}
+	return a.createLimitResponse(i18n.MaxIterations, "length"), nil
+}
 
-		resp, err := a.blaxelClient.CreateChatCompletion(req)
+// budgetExceeded reports whether the run has used its token budget
+func (a *Agent) budgetExceeded() bool {
+	return a.maxTokens > 0 && a.stats.TotalUsage().TotalTokens >= a.maxTokens
+}
+
+// checkAnswer verifies the final answer when enabled, then checks it against the response format or
+// else the answer format. Runs out of token budget keep their answer without further turns.
+func (a *Agent) checkAnswer(ctx context.Context, encoder *blaxel.RequestEncoder, messages *[]blaxel.ChatMessage, resp *blaxel.ChatCompletionResponse) *blaxel.ChatCompletionResponse {
+	if a.verify && !a.budgetExceeded() {
+		resp = a.verifyAnswer(ctx, a.stats.Iterations, encoder, messages, resp)
+	}
+	if a.jsonFormat != nil && !a.budgetExceeded() {
+		resp = a.checkResponseFormat(ctx, encoder, messages, resp)
+	} else if !a.format.IsZero() && !a.budgetExceeded() {
+		resp = a.checkAnswerFormat(ctx, a.stats.Iterations, encoder, messages, resp)
+	}
+	return resp
+}
+
+// runIteration sends the conversation to the model and executes the requested tool calls.
+// It reports done when the model answered without tool calls. A panic is recovered and
+// returned as an error so it does not take down the whole request.
+func (a *Agent) runIteration(ctx context.Context, iteration int, encoder *blaxel.RequestEncoder, messages *[]blaxel.ChatMessage) (resp *blaxel.ChatCompletionResponse, done bool, err error) {
+	ctx, span := tracer.Start(ctx, "agent.iteration", trace.WithAttributes(
+		attribute.String("gen_ai.agent.name", a.name),
+		attribute.Int("agent.iteration", iteration),
+	))
+	defer func() {
 		if err != nil {
-			return nil, fmt.Errorf("failed to get AI response (iteration %d): %w", iteration, err)
+			recordSpanError(span, err)
 		}
+		span.End()
+	}()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			logger.ErrorfCtx(ctx, "Panic recovered in agent %s (iteration %d): %v\n%s", a.name, iteration, recovered, debug.Stack())
+			resp, done, err = nil, false, fmt.Errorf("panic in agent iteration %d: %v", iteration, recovered)
+		}
+	}()
+
+	a.stats.Iterations = iteration
+
+	// Send request to AI model with the tools still within the call budget, turns without tools
+	// being the final synthesis
+	tools := a.budget.available(a.tools)
+	synthesis := len(tools) == 0
+	sampling := a.sampling.forTurn(iteration, synthesis)
+
+	// Let the model hand the conversation over to a human, without touching the shared tool slice
+	if a.handoff != nil && !synthesis {
+		tools = append(tools[:len(tools):len(tools)], a.handoff.tool())
+	}
 
-		if len(resp.Choices) == 0 {
-			return nil, fmt.Errorf("no response choices returned (iteration %d)", iteration)
+	req := blaxel.ChatCompletionRequest{
+		Messages:    *messages,
+		Tools:       tools,
+		Temperature: sampling.Temperature,
+		TopP:        sampling.TopP,
+	}
+
+	logger.DebugfCtx(ctx, "Iteration %d: Sending request with %d tools", iteration, len(tools))
+	if len(tools) > 0 {
+		logger.DebugfCtx(ctx, "Tools being sent: %v", tools[0].Function.Name)
+	}
+
+	// Turns without tools are answered by the synthesis model when one is configured
+	if synthesis && a.synthesis != "" {
+		resp, err = a.synthesize(ctx, iteration, encoder, messages)
+		return resp, err == nil, err
+	}
+
+	a.emitIteration(iteration, a.model)
+	if err := a.startIteration(ctx, iteration, *messages); err != nil {
+		return nil, false, err
+	}
+	resp, err = a.complete(ctx, iteration, a.model, encoder, req, messages, a.synthesis == "")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get AI response (iteration %d): %w", iteration, err)
+	}
+	a.recordUsage(iteration, a.model, resp.Usage)
+
+	if len(resp.Choices) == 0 {
+		return nil, false, fmt.Errorf("no response choices returned (iteration %d)", iteration)
+	}
+
+	assistantMessage := resp.Choices[0].Message
+	logger.DebugfCtx(ctx, "Iteration %d: Assistant response has %d tool calls", iteration, len(assistantMessage.ToolCalls))
+
+	// No tool calls - this is the final response, written by the synthesis model when configured
+	if len(assistantMessage.ToolCalls) == 0 {
+		if a.synthesis != "" {
+			resp, err = a.synthesize(ctx, iteration, encoder, messages)
+			return resp, err == nil, err
 		}
+		*messages = append(*messages, assistantMessage)
+		return resp, true, nil
+	}
 
-		assistantMessage := resp.Choices[0].Message
-		logger.Debugf("Iteration %d: Assistant response has %d tool calls", iteration, len(assistantMessage.ToolCalls))
-		messages = append(messages, assistantMessage)
+	// The model asked for a human instead of calling tools
+	if a.handoff != nil {
+		if handoff := handoffRequest(assistantMessage.ToolCalls, iteration); handoff != nil {
+			return a.handOff(ctx, *handoff, *messages), true, nil
+		}
+	}
+	*messages = append(*messages, assistantMessage)
 
-		// Check if AI wants to use tools
-		if len(assistantMessage.ToolCalls) > 0 {
-			// Execute each tool call
-			for _, toolCall := range assistantMessage.ToolCalls {
-				toolResult, err := a.executeToolCall(ctx, toolCall)
-				if err != nil {
-					return nil, fmt.Errorf("failed to execute tool %s (iteration %d): %w",
-						toolCall.Function.Name, iteration, err)
-				}
+	// Keep the turn so callers can display it apart from the final answer
+	a.intermediate = append(a.intermediate, IntermediateMessage{
+		Type:      IntermediateMessageType,
+		Iteration: iteration,
+		Content:   assistantMessage.Content,
+		ToolCalls: assistantMessage.ToolCalls,
+	})
 
-				// Add tool result to conversation
-				messages = append(messages, blaxel.ChatMessage{
-					Role:       "tool",
-					Content:    string(toolResult),
-					ToolCallId: toolCall.Id,
-				})
+	// Execute each tool call
+	for _, toolCall := range assistantMessage.ToolCalls {
+		var toolResult []byte
+		event := ToolEvent{Iteration: iteration, ToolCallID: toolCall.Id, Name: toolCall.Function.Name}
+		if !a.toolPolicy.allows(toolCall.Function.Name) {
+			logger.InfofCtx(ctx, "Agent %s blocked tool %s (iteration %d): not allowed for this run", a.name, toolCall.Function.Name, iteration)
+			toolResult = toolErrorResult(fmt.Sprintf("tool call not executed, tool %s is not allowed for this run", toolCall.Function.Name))
+			event.Status = ToolBlocked
+			a.publish(ctx, events.GuardrailTriggered, events.Guardrail{
+				Iteration: iteration,
+				Guardrail: "tool_policy",
+				Tool:      toolCall.Function.Name,
+				Reason:    fmt.Sprintf("tool %s is not allowed for this run", toolCall.Function.Name),
+			})
+		} else if hookErr := a.allowToolCall(ctx, iteration, toolCall); hookErr != nil {
+			logger.InfofCtx(ctx, "Agent %s blocked tool %s (iteration %d): %v", a.name, toolCall.Function.Name, iteration, hookErr)
+			toolResult = toolErrorResult(fmt.Sprintf("tool call not executed: %v", hookErr))
+			event.Status = ToolBlocked
+		} else if allowed, limit := a.budget.allow(toolCall.Function.Name); allowed {
+			start := event
+			start.Status, start.Arguments = ToolStarted, toolCall.Function.Arguments
+			a.emitTool(start)
+			started := time.Now()
+			toolResult, err = a.safeExecuteToolCall(ctx, toolCall)
+			if err != nil {
+				return nil, false, fmt.Errorf("failed to execute tool %s (iteration %d): %w",
+					toolCall.Function.Name, iteration, err)
 			}
-			continue // Get next AI response with tool results
+			a.stats.ToolCalls[toolCall.Function.Name]++
+			toolResult = a.toolResult(ctx, iteration, toolCall, toolResult)
+			event.Status = ToolCompleted
+			event.DurationMs = time.Since(started).Milliseconds()
+			event.ResultBytes = len(toolResult)
+		} else {
+			logger.InfofCtx(ctx, "Agent %s skipped tool %s (iteration %d): %s reached", a.name, toolCall.Function.Name, iteration, limit)
+			toolResult = toolErrorResult(fmt.Sprintf("tool call not executed, %s reached", limit))
+			event.Status = ToolSkipped
+		}
+
+		// Add tool result to conversation, marked as untrusted data when guarded
+		content, flagged := string(toolResult), false
+		if a.guard != nil {
+			content, flagged = a.guard.wrap(toolCall.Function.Name, toolResult)
+		}
+		event.Flagged = flagged
+		a.emitTool(event)
+		a.publish(ctx, events.ToolCalled, events.ToolCall{
+			Iteration:   iteration,
+			ToolCallID:  event.ToolCallID,
+			Name:        event.Name,
+			Status:      event.Status,
+			DurationMs:  event.DurationMs,
+			ResultBytes: event.ResultBytes,
+		})
+		if flagged {
+			a.publish(ctx, events.GuardrailTriggered, events.Guardrail{
+				Iteration: iteration,
+				Guardrail: "prompt_injection",
+				Tool:      toolCall.Function.Name,
+				Reason:    fmt.Sprintf("possible prompt injection in the result of tool %s", toolCall.Function.Name),
+			})
 		}
+		*messages = append(*messages, blaxel.ChatMessage{
+			Role:       "tool",
+			Content:    content,
+			ToolCallId: toolCall.Id,
+		})
+
+		// Stop before the model reads a suspicious result when guardrails hand off to humans
+		if flagged && a.handoff != nil && a.handoff.onGuardrail {
+			return a.handOff(ctx, Handoff{
+				Trigger:   HandoffTriggerGuardrail,
+				Reason:    fmt.Sprintf("possible prompt injection in the result of tool %s", toolCall.Function.Name),
+				Iteration: iteration,
+			}, *messages), true, nil
+		}
+	}
+
+	// Ask the model to answer without the tools whose budget is spent
+	if instruction := a.budget.instruction(); instruction != "" {
+		logger.InfofCtx(ctx, "Agent %s iteration %d: %s", a.name, iteration, instruction)
+		*messages = append(*messages, blaxel.ChatMessage{
+			Role:    "system",
+			Content: instruction,
+		})
+	}
+
+	// Get next AI response with tool results
+	return resp, false, nil
+}
+
+// synthesize asks the synthesis model for the final answer, without tools, from the conversation so far
+func (a *Agent) synthesize(ctx context.Context, iteration int, encoder *blaxel.RequestEncoder, messages *[]blaxel.ChatMessage) (*blaxel.ChatCompletionResponse, error) {
+	sampling := a.sampling.forTurn(iteration, true)
+	req := blaxel.ChatCompletionRequest{
+		Messages:    *messages,
+		Temperature: sampling.Temperature,
+		TopP:        sampling.TopP,
+	}
+
+	logger.DebugfCtx(ctx, "Iteration %d: Sending synthesis request to %s", iteration, a.synthesis)
+	a.stats.Iterations = iteration
+	a.emitIteration(iteration, a.synthesis)
+	if err := a.startIteration(ctx, iteration, *messages); err != nil {
+		return nil, err
+	}
+	resp, err := a.complete(ctx, iteration, a.synthesis, encoder, req, messages, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get synthesis response from %s: %w", a.synthesis, err)
+	}
+	a.recordUsage(iteration, a.synthesis, resp.Usage)
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("no synthesis response choices returned from %s", a.synthesis)
+	}
+	return resp, nil
+}
+
+// correctAnswer runs one more turn without tools after the answer, asking the model to rewrite it
+// following the instruction. The turn is answered by the synthesis model when there is one.
+func (a *Agent) correctAnswer(ctx context.Context, iteration int, encoder *blaxel.RequestEncoder, messages *[]blaxel.ChatMessage, answer blaxel.ChatMessage, instruction string) (*blaxel.ChatCompletionResponse, error) {
+	last := len(*messages) - 1
+	if last < 0 || (*messages)[last].Role != "assistant" || (*messages)[last].Content != answer.Content {
+		*messages = append(*messages, answer)
+	}
+	*messages = append(*messages, blaxel.ChatMessage{Role: "system", Content: instruction})
+
+	model := a.model
+	if a.synthesis != "" {
+		model = a.synthesis
+	}
+	iteration++
+	sampling := a.sampling.forTurn(iteration, true)
+	req := blaxel.ChatCompletionRequest{
+		Messages:    *messages,
+		Temperature: sampling.Temperature,
+		TopP:        sampling.TopP,
+	}
+	a.emitIteration(iteration, model)
+	if err := a.startIteration(ctx, iteration, *messages); err != nil {
+		return nil, err
+	}
+	resp, err := a.complete(ctx, iteration, model, encoder, req, messages, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("no response choices returned (iteration %d)", iteration)
+	}
+	a.stats.Iterations = iteration
+	a.recordUsage(iteration, model, resp.Usage)
+	return resp, nil
+}
+
+// publish sends an event of the run to the event bus
+func (a *Agent) publish(ctx context.Context, topic string, data any) {
+	if !a.bus.HasSubscribers(topic) {
+		return
+	}
+	a.bus.Publish(ctx, events.Event{Topic: topic, RunID: blaxel.RunIDFromContext(ctx), Agent: a.name, Data: data})
+}
+
+// publishIteration sends the iteration.completed event of an iteration that did not fail
+func (a *Agent) publishIteration(ctx context.Context, iteration int, resp *blaxel.ChatCompletionResponse, final bool) {
+	if !a.bus.HasSubscribers(events.IterationCompleted) {
+		return
+	}
+	event := events.Iteration{Iteration: iteration, Model: a.model, TotalTokens: resp.Usage.TotalTokens, Final: final}
+	if len(resp.Choices) > 0 {
+		event.ToolCalls = len(resp.Choices[0].Message.ToolCalls)
+	}
+	a.publish(ctx, events.IterationCompleted, event)
+}
+
+// publishRunFinished sends the run.finished event with the outcome and statistics of the run
+func (a *Agent) publishRunFinished(ctx context.Context, resp *blaxel.ChatCompletionResponse, err error, duration time.Duration) {
+	if !a.bus.HasSubscribers(events.RunFinished) {
+		return
+	}
+	event := events.RunFinish{
+		Iterations:  a.stats.Iterations,
+		ToolCalls:   maps.Clone(a.stats.ToolCalls),
+		TotalTokens: a.stats.TotalUsage().TotalTokens,
+		DurationMs:  duration.Milliseconds(),
+	}
+	if err != nil {
+		event.Error = err.Error()
+	} else if len(resp.Choices) > 0 {
+		event.FinishReason = resp.Choices[0].FinishReason
+	}
+	a.publish(ctx, events.RunFinished, event)
+}
+
+// emitTool reports a tool event to the tool handler when one is set
+func (a *Agent) emitTool(event ToolEvent) {
+	if a.onTool != nil {
+		a.onTool(event)
+	}
+}
+
+// emitIteration reports the start of a model turn to the iteration handler when one is set
+func (a *Agent) emitIteration(iteration int, model string) {
+	if a.onIteration != nil {
+		a.onIteration(IterationEvent{Iteration: iteration, Model: model})
+	}
+}
+
+// recordUsage adds the token usage of a model response to the run statistics and reports it to
+// the usage handler when one is set
+func (a *Agent) recordUsage(iteration int, model string, usage blaxel.UsageInfo) {
+	a.stats.recordUsage(model, usage)
+	if a.onUsage != nil {
+		a.onUsage(UsageEvent{Iteration: iteration, Model: model, UsageInfo: usage})
+	}
+}
+
+// checkPayloadSize logs the request payload size of an iteration, warning when it approaches the context window
+func (a *Agent) checkPayloadSize(ctx context.Context, iteration, size int) {
+	tokens := blaxel.EstimateTokens(size)
+	if float64(tokens) >= contextWarningRatio*float64(a.contextTokens) {
+		logger.WarningfCtx(ctx, "Agent %s iteration %d: request payload of %d bytes (~%d tokens) is close to the context window of %d tokens",
+			a.name, iteration, size, tokens, a.contextTokens)
+		return
+	}
+	logger.DebugfCtx(ctx, "Agent %s iteration %d: request payload of %d bytes (~%d tokens)", a.name, iteration, size, tokens)
+}
+
+// toolOutcome is the result of a tool call run in its own goroutine
+type toolOutcome struct {
+	result []byte
+	err    error
+}
+
+// safeExecuteToolCall executes a tool call within the tool timeout, turning a panic or a timeout into
+// an error result for the model. The call runs in its own goroutine so a tool ignoring its context
+// cannot block the agent loop, and its error is returned when the context of the run is cancelled.
+func (a *Agent) safeExecuteToolCall(ctx context.Context, toolCall blaxel.ToolCall) ([]byte, error) {
+	callCtx, cancel := ctx, context.CancelFunc(func() {})
+	if a.toolTimeout > 0 {
+		callCtx, cancel = context.WithTimeout(ctx, a.toolTimeout)
+	}
+	defer cancel()
+
+	done := make(chan toolOutcome, 1)
+	go func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				logger.ErrorfCtx(callCtx, "Panic recovered in tool %s: %v\n%s", toolCall.Function.Name, recovered, debug.Stack())
+				done <- toolOutcome{result: toolErrorResult(fmt.Sprintf("tool %s failed unexpectedly: %v", toolCall.Function.Name, recovered))}
+			}
+		}()
+		result, err := a.executeToolCall(callCtx, toolCall)
+		done <- toolOutcome{result: result, err: err}
+	}()
+
+	var outcome toolOutcome
+	select {
+	case outcome = <-done:
+	case <-callCtx.Done():
+		outcome.err = callCtx.Err()
+	}
+	if outcome.err == nil || callCtx.Err() == nil {
+		return outcome.result, outcome.err
+	}
 
-		// No tool calls - this is the final response
-		return resp, nil
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("tool %s cancelled: %w", toolCall.Function.Name, err)
 	}
+	logger.WarningfCtx(ctx, "Agent %s: tool %s timed out after %s", a.name, toolCall.Function.Name, a.toolTimeout)
+	return toolErrorResult(fmt.Sprintf("tool %s did not respond within %s", toolCall.Function.Name, a.toolTimeout)), nil
+}
+
+// toolErrorResult formats an error message as a tool result the model can read
+func toolErrorResult(message string) []byte {
+	content, _ := json.Marshal(map[string]string{"error": message})
+	return content
+}
 
-	// Max iterations reached
-	return a.createMaxIterationsResponse(), nil
+// ToolError formats an error message as the result of an in-process tool, for failures the model
+// can recover from without failing the run
+func ToolError(message string) string {
+	return string(toolErrorResult(message))
 }
 
 // executeToolCall executes a single tool call and returns the result
@@ -154,10 +942,21 @@ func (a *Agent) executeToolCall(ctx context.Context, toolCall blaxel.ToolCall) (
 		}
 	}
 
+	// Run in-process tools directly
+	if handler, exists := a.toolManager.GetLocalHandler(toolCall.Function.Name); exists {
+		arguments, _ := params.(map[string]interface{})
+		result, err := handler(ctx, arguments)
+		if err != nil {
+			return nil, fmt.Errorf("failed to call tool %s: %w", toolCall.Function.Name, err)
+		}
+		return []byte(result), nil
+	}
+
 	// Get the server for this tool
 	serverName, exists := a.toolManager.GetServerForTool(toolCall.Function.Name)
 	if !exists {
-		return nil, fmt.Errorf("no server found for tool: %s", toolCall.Function.Name)
+		logger.InfofCtx(ctx, "Agent %s: no server found for tool %s", a.name, toolCall.Function.Name)
+		return toolErrorResult(fmt.Sprintf("tool %s is not available for this run", toolCall.Function.Name)), nil
 	}
 
 	// Call the tool through the appropriate MCP server
@@ -172,8 +971,9 @@ func (a *Agent) executeToolCall(ctx context.Context, toolCall blaxel.ToolCall) (
 	return content, nil
 }
 
-// createMaxIterationsResponse creates a response when max iterations are reached
-func (a *Agent) createMaxIterationsResponse() *blaxel.ChatCompletionResponse {
+// createLimitResponse creates the response of a run stopped by a limit, telling the user with the
+// message of key in their language. Its usage is set to that of the run by RunConversation.
+func (a *Agent) createLimitResponse(key, finishReason string) *blaxel.ChatCompletionResponse {
 	return &blaxel.ChatCompletionResponse{
 		ID:      fmt.Sprintf("agent-%s-%d", a.name, time.Now().Unix()),
 		Object:  "chat.completion",
@@ -184,19 +984,71 @@ func (a *Agent) createMaxIterationsResponse() *blaxel.ChatCompletionResponse {
 				Index: 0,
 				Message: blaxel.ChatMessage{
 					Role:    "assistant",
-					Content: "Maximum iterations reached. The agent may not have completed the task.",
+					Content: i18n.T(a.language, key),
 				},
-				FinishReason: "length",
+				FinishReason: finishReason,
 			},
 		},
-		Usage: blaxel.UsageInfo{
-			PromptTokens:     0,
-			CompletionTokens: 0,
-			TotalTokens:      0,
+	}
+}
+
+// createHandoffResponse creates the response telling the user a human will take over
+func (a *Agent) createHandoffResponse() *blaxel.ChatCompletionResponse {
+	return &blaxel.ChatCompletionResponse{
+		ID:      fmt.Sprintf("agent-%s-%d", a.name, time.Now().Unix()),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   a.model,
+		Choices: []blaxel.Choice{
+			{
+				Index: 0,
+				Message: blaxel.ChatMessage{
+					Role:    "assistant",
+					Content: i18n.T(a.language, i18n.Handoff),
+				},
+				FinishReason: "handoff",
+			},
 		},
 	}
 }
 
+// HandoffResult returns the handoff of the last run, nil when the agent answered itself
+func (a *Agent) HandoffResult() *Handoff {
+	return a.handoffResult
+}
+
+// Compactions returns what was removed from the conversation of the last run to fit the context window
+func (a *Agent) Compactions() []Compaction {
+	return a.compactions
+}
+
+// Verification returns the check of the final answer of the last run, nil when it was not verified
+func (a *Agent) Verification() *Verification {
+	return a.verification
+}
+
+// FormatCheck returns the check of the final answer of the last run against the answer format,
+// nil when the agent has none
+func (a *Agent) FormatCheck() *FormatCheck {
+	return a.formatCheck
+}
+
+// StructuredOutput returns the check of the final answer of the last run against the response
+// format, nil when the agent has none
+func (a *Agent) StructuredOutput() *StructuredOutput {
+	return a.jsonCheck
+}
+
+// RewriteResult returns the rewrite of the final answer of the last run, nil when it was not rewritten
+func (a *Agent) RewriteResult() *Rewrite {
+	return a.rewriteResult
+}
+
+// Stats returns what the last run did: its iterations, tool calls and token usage
+func (a *Agent) Stats() RunStats {
+	return a.stats
+}
+
 // GetName returns the agent's name
 func (a *Agent) GetName() string {
 	return a.name
@@ -207,6 +1059,19 @@ func (a *Agent) GetModel() string {
 	return a.model
 }
 
+// IntermediateMessages returns the assistant turns of the last run that requested tool calls, in order
+func (a *Agent) IntermediateMessages() []IntermediateMessage {
+	return a.intermediate
+}
+
+// LimitsHit returns the tool call limits reached during the last run
+func (a *Agent) LimitsHit() []string {
+	if a.budget == nil {
+		return nil
+	}
+	return a.budget.hit
+}
+
 // GetToolsCount returns the number of tools available to the agent
 func (a *Agent) GetToolsCount() int {
 	return len(a.tools)
@@ -0,0 +1,174 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/logger"
+)
+
+// AnswerFormat constrains the length and layout of final answers. The constraints are added to the
+// system prompt and checked on the answer, which is reformatted once when it does not follow them.
+type AnswerFormat struct {
+	// MaxWords bounds the number of words of the answer
+	MaxWords int `json:"max_words,omitempty" binding:"min=0"`
+	// BulletsOnly requires every line of the answer to be a list item, section headings aside
+	BulletsOnly bool `json:"bullets_only,omitempty"`
+	// IncludeSections are the headings the answer must have
+	IncludeSections []string `json:"include_sections,omitempty"`
+	// ExcludeSections are the headings the answer must not have
+	ExcludeSections []string `json:"exclude_sections,omitempty"`
+}
+
+// FormatCheck describes the check of the final answer against the answer format
+type FormatCheck struct {
+	// Violations lists how the answer did not follow the format
+	Violations []string `json:"violations,omitempty"`
+	// Reformatted reports whether a reformat turn replaced the answer
+	Reformatted bool `json:"reformatted"`
+	// Remaining lists the violations still found after the reformat turn
+	Remaining []string `json:"remaining,omitempty"`
+}
+
+// IsZero reports whether the format sets no constraint
+func (f AnswerFormat) IsZero() bool {
+	return f.MaxWords == 0 && !f.BulletsOnly && len(f.IncludeSections) == 0 && len(f.ExcludeSections) == 0
+}
+
+// defaultAnswerFormat is the format of agents that do not set one, read once from BL_ANSWER_FORMAT,
+// for example {"max_words": 150, "bullets_only": true}
+var defaultAnswerFormat = sync.OnceValue(func() AnswerFormat {
+	var format AnswerFormat
+	if value := os.Getenv("BL_ANSWER_FORMAT"); value != "" {
+		if err := json.Unmarshal([]byte(value), &format); err != nil {
+			logger.Warningf("Invalid BL_ANSWER_FORMAT, answers are not constrained: %v", err)
+			return AnswerFormat{}
+		}
+	}
+	return format
+})
+
+// prompt returns the instructions added to the system prompt of agents
+func (f AnswerFormat) prompt() string {
+	var prompt strings.Builder
+	prompt.WriteString("Format your final answer as follows.")
+	if f.MaxWords > 0 {
+		fmt.Fprintf(&prompt, "\n- Use at most %d words.", f.MaxWords)
+	}
+	if f.BulletsOnly {
+		prompt.WriteString("\n- Write only bullet points, one per line starting with \"- \", without paragraphs.")
+	}
+	if len(f.IncludeSections) > 0 {
+		fmt.Fprintf(&prompt, "\n- Include these sections, each under a Markdown heading: %s.", strings.Join(f.IncludeSections, ", "))
+	}
+	if len(f.ExcludeSections) > 0 {
+		fmt.Fprintf(&prompt, "\n- Do not include these sections: %s.", strings.Join(f.ExcludeSections, ", "))
+	}
+	return prompt.String()
+}
+
+// Patterns of the lines of answers
+var (
+	// headingPattern matches Markdown headings, bold lines and lines ending with a colon
+	headingPattern = regexp.MustCompile(`^(?:#{1,6}\s+(.+?)\s*#*|\*\*(.+?):?\*\*:?|([^-*+\s][^:]{0,60}):)$`)
+	// bulletPattern matches unordered and ordered list items
+	bulletPattern = regexp.MustCompile(`^(?:[-*+•]|\d+[.)])\s+\S`)
+)
+
+// violations returns how the answer does not follow the format
+func (f AnswerFormat) violations(answer string) []string {
+	var violations []string
+	if words := len(strings.Fields(answer)); f.MaxWords > 0 && words > f.MaxWords {
+		violations = append(violations, fmt.Sprintf("the answer has %d words, more than the maximum of %d", words, f.MaxWords))
+	}
+
+	headings := make(map[string]bool)
+	paragraphs := 0
+	inCode := false
+	for _, line := range strings.Split(answer, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "```") {
+			inCode = !inCode
+			continue
+		}
+		if line == "" || inCode {
+			continue
+		}
+		if heading, ok := sectionHeading(line); ok {
+			headings[heading] = true
+			continue
+		}
+		if !bulletPattern.MatchString(line) {
+			paragraphs++
+		}
+	}
+
+	if f.BulletsOnly && paragraphs > 0 {
+		violations = append(violations, fmt.Sprintf("the answer has %d lines that are not bullet points", paragraphs))
+	}
+	for _, section := range f.IncludeSections {
+		if !headings[normalizeHeading(section)] {
+			violations = append(violations, fmt.Sprintf("the answer has no %q section", section))
+		}
+	}
+	for _, section := range f.ExcludeSections {
+		if headings[normalizeHeading(section)] {
+			violations = append(violations, fmt.Sprintf("the answer has a %q section, which must be left out", section))
+		}
+	}
+	return violations
+}
+
+// sectionHeading returns the normalized title of a heading line
+func sectionHeading(line string) (string, bool) {
+	match := headingPattern.FindStringSubmatch(line)
+	if match == nil {
+		return "", false
+	}
+	for _, title := range match[1:] {
+		if title != "" {
+			return normalizeHeading(title), true
+		}
+	}
+	return "", false
+}
+
+// normalizeHeading lowercases a heading title without surrounding emphasis and punctuation
+func normalizeHeading(title string) string {
+	return strings.ToLower(strings.Trim(title, " *_:.#"))
+}
+
+// checkAnswerFormat checks the final answer against the answer format. When it does not follow it,
+// one reformat turn without tools replaces the answer, which is kept when the turn fails.
+func (a *Agent) checkAnswerFormat(ctx context.Context, iteration int, encoder *blaxel.RequestEncoder, messages *[]blaxel.ChatMessage, resp *blaxel.ChatCompletionResponse) *blaxel.ChatCompletionResponse {
+	if len(resp.Choices) == 0 {
+		return resp
+	}
+	answer := resp.Choices[0].Message
+	check := &FormatCheck{Violations: a.format.violations(answer.Content)}
+	a.formatCheck = check
+	if len(check.Violations) == 0 {
+		return resp
+	}
+	logger.InfofCtx(ctx, "Agent %s: answer does not follow the answer format, running a reformat turn: %s", a.name, strings.Join(check.Violations, "; "))
+
+	reformatted, err := a.correctAnswer(ctx, iteration, encoder, messages, answer,
+		"Your last answer does not follow the required format:\n- "+strings.Join(check.Violations, "\n- ")+
+			"\n"+a.format.prompt()+"\nReply with the full reformatted answer, without mentioning this request.")
+	if err != nil {
+		logger.WarningfCtx(ctx, "Agent %s: reformat turn failed, keeping the answer: %v", a.name, err)
+		return resp
+	}
+	check.Reformatted = true
+	check.Remaining = a.format.violations(reformatted.Choices[0].Message.Content)
+	if len(check.Remaining) > 0 {
+		logger.WarningfCtx(ctx, "Agent %s: reformatted answer still does not follow the answer format: %s", a.name, strings.Join(check.Remaining, "; "))
+	}
+	return reformatted
+}
@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"template-custom-agent-go/pkg/blaxel"
+)
+
+// ToolCallLimits bounds the number of tool calls of a run, zero meaning unlimited
+type ToolCallLimits struct {
+	Total   int            `json:"total,omitempty"`
+	PerTool map[string]int `json:"per_tool,omitempty"`
+}
+
+// UnmarshalJSON accepts either a number, used as the total limit, or an object with total and per_tool limits
+func (l *ToolCallLimits) UnmarshalJSON(data []byte) error {
+	var total int
+	if err := json.Unmarshal(data, &total); err == nil {
+		*l = ToolCallLimits{Total: total}
+		return nil
+	}
+
+	type limits ToolCallLimits
+	var parsed limits
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return fmt.Errorf("max_tool_calls must be a number or an object with total and per_tool: %w", err)
+	}
+	*l = ToolCallLimits(parsed)
+	return nil
+}
+
+// toolBudget counts the tool calls of one run against its limits and records the limits reached
+type toolBudget struct {
+	limits  ToolCallLimits
+	total   int
+	perTool map[string]int
+	hit     []string
+	pending []string
+}
+
+// newToolBudget creates the budget of a run
+func newToolBudget(limits ToolCallLimits) *toolBudget {
+	return &toolBudget{limits: limits, perTool: make(map[string]int)}
+}
+
+// allow consumes one call of the tool, reporting false with the reason when a limit is already reached
+func (b *toolBudget) allow(name string) (bool, string) {
+	if b.limits.Total > 0 && b.total >= b.limits.Total {
+		return false, b.totalLimit()
+	}
+	if limit := b.limits.PerTool[name]; limit > 0 && b.perTool[name] >= limit {
+		return false, b.toolLimit(name, limit)
+	}
+
+	b.total++
+	b.perTool[name]++
+	if b.limits.Total > 0 && b.total == b.limits.Total {
+		b.record(b.totalLimit())
+	}
+	if limit := b.limits.PerTool[name]; limit > 0 && b.perTool[name] == limit {
+		b.record(b.toolLimit(name, limit))
+	}
+	return true, ""
+}
+
+// available returns the tools that can still be called, none once the total limit is reached
+func (b *toolBudget) available(tools []blaxel.Tool) []blaxel.Tool {
+	if b.limits.Total > 0 && b.total >= b.limits.Total {
+		return nil
+	}
+	if len(b.limits.PerTool) == 0 {
+		return tools
+	}
+
+	available := make([]blaxel.Tool, 0, len(tools))
+	for _, tool := range tools {
+		if limit := b.limits.PerTool[tool.Function.Name]; limit > 0 && b.perTool[tool.Function.Name] >= limit {
+			continue
+		}
+		available = append(available, tool)
+	}
+	return available
+}
+
+// instruction returns the message telling the model about the limits reached since the last call, if any
+func (b *toolBudget) instruction() string {
+	if len(b.pending) == 0 {
+		return ""
+	}
+	reached := strings.Join(b.pending, ", ")
+	b.pending = nil
+
+	if b.limits.Total > 0 && b.total >= b.limits.Total {
+		return fmt.Sprintf("Tool call budget reached (%s). Do not call any more tools and answer with the information gathered so far.", reached)
+	}
+	return fmt.Sprintf("Tool call budget reached (%s). Do not call these tools again and answer with the information gathered so far if the remaining tools cannot help.", reached)
+}
+
+// record adds a limit to the ones reached by the run, once
+func (b *toolBudget) record(limit string) {
+	for _, hit := range b.hit {
+		if hit == limit {
+			return
+		}
+	}
+	b.hit = append(b.hit, limit)
+	b.pending = append(b.pending, limit)
+}
+
+// totalLimit describes the total limit
+func (b *toolBudget) totalLimit() string {
+	return fmt.Sprintf("total limit of %d tool calls", b.limits.Total)
+}
+
+// toolLimit describes the limit of one tool
+func (b *toolBudget) toolLimit(name string, limit int) string {
+	return fmt.Sprintf("limit of %d calls to %s", limit, name)
+}
@@ -0,0 +1,271 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/logger"
+)
+
+// defaultCatalogTTL is how long a converted tool catalog is reused before the MCP servers are queried again
+const defaultCatalogTTL = 30 * time.Second
+
+// CatalogSnapshot is an immutable set of converted MCP tools shared across requests.
+// Generation and ModifiedAt only change when the content of the catalog changes.
+type CatalogSnapshot struct {
+	Generation    uint64
+	FetchedAt     time.Time
+	ModifiedAt    time.Time
+	mcpTools      []blaxel.ToolWithServer
+	tools         []blaxel.Tool
+	toolServerMap map[string]string
+	digest        [sha256.Size]byte
+}
+
+// Digest identifies the content of the catalog, and is the same on every replica listing the same
+// tools, unlike Generation which counts the changes seen by the process
+func (s *CatalogSnapshot) Digest() string {
+	return hex.EncodeToString(s.digest[:16])
+}
+
+// Tools returns the converted tools. The slice is clipped so appending to it never writes to the snapshot.
+func (s *CatalogSnapshot) Tools() []blaxel.Tool {
+	return slices.Clip(s.tools)
+}
+
+// ToolsOf returns the converted tools of the given servers only
+func (s *CatalogSnapshot) ToolsOf(servers []string) []blaxel.Tool {
+	var tools []blaxel.Tool
+	for _, tool := range s.tools {
+		if slices.Contains(servers, s.toolServerMap[tool.Function.Name]) {
+			tools = append(tools, tool)
+		}
+	}
+	return tools
+}
+
+// MCPTools returns the tools as listed by the MCP servers. The slice must not be modified.
+func (s *CatalogSnapshot) MCPTools() []blaxel.ToolWithServer {
+	return slices.Clip(s.mcpTools)
+}
+
+// ToolCatalog caches the converted tools of all MCP servers so requests do not list and convert them each time
+type ToolCatalog struct {
+	mcpManager *blaxel.MCPManager
+	ttl        time.Duration
+	// cachePath is the file the catalog is persisted to, empty when it is not
+	cachePath string
+	// shared shares the catalog with the other replicas, nil when it is not
+	shared *sharedCatalog
+
+	mu          sync.Mutex
+	snapshot    *CatalogSnapshot
+	generation  uint64
+	subscribers map[chan *CatalogSnapshot]struct{}
+	// restored is set while the snapshot is the one loaded from the cache file, not validated yet
+	// against the MCP servers, and validating while that runs in the background
+	restored   bool
+	validating bool
+}
+
+// NewToolCatalog creates a tool catalog. The refresh interval is read from BL_TOOL_CATALOG_TTL,
+// a duration such as 30s; 0 disables caching. BL_TOOL_CATALOG_REDIS_URL shares the catalog with the
+// other replicas, so only one of them lists the MCP servers at each refresh.
+func NewToolCatalog(mcpManager *blaxel.MCPManager) *ToolCatalog {
+	ttl := defaultCatalogTTL
+	if value := os.Getenv("BL_TOOL_CATALOG_TTL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			logger.Warningf("Invalid BL_TOOL_CATALOG_TTL %q, using %s: %v", value, defaultCatalogTTL, err)
+		} else {
+			ttl = parsed
+		}
+	}
+
+	catalog := &ToolCatalog{
+		mcpManager: mcpManager,
+		ttl:        ttl,
+		cachePath:  os.Getenv("BL_TOOL_CATALOG_CACHE"),
+	}
+	if catalog.cachePath != "" {
+		catalog.restore()
+	}
+	if catalog.shared = newSharedCatalogFromEnv(); catalog.shared != nil {
+		if ttl > 0 {
+			logger.Infof("Sharing the tool catalog through Redis key %s", catalog.shared.key)
+			go catalog.listen()
+		} else {
+			logger.Warningf("BL_TOOL_CATALOG_REDIS_URL is ignored as BL_TOOL_CATALOG_TTL disables caching")
+			catalog.shared = nil
+		}
+	}
+	return catalog
+}
+
+// Snapshot returns the current catalog, refreshing it from the MCP servers when it has expired. The
+// catalog restored from the cache file is returned as is while it is validated in the background.
+func (c *ToolCatalog) Snapshot(ctx context.Context) (*CatalogSnapshot, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.restored {
+		if !c.validating {
+			c.validating = true
+			go c.validate()
+		}
+		return c.snapshot, nil
+	}
+	if c.snapshot != nil && c.ttl > 0 && !c.snapshot.FetchedAt.IsZero() && time.Since(c.snapshot.FetchedAt) < c.ttl {
+		return c.snapshot, nil
+	}
+	if c.shared != nil {
+		return c.sharedSnapshot(ctx)
+	}
+	return c.list(ctx)
+}
+
+// list replaces the catalog with the tools listed by the MCP servers. It is called with the lock held.
+func (c *ToolCatalog) list(ctx context.Context) (*CatalogSnapshot, error) {
+	mcpTools, err := c.mcpManager.ListAllTools(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tools: %w", err)
+	}
+	return c.install(mcpTools, time.Now()), nil
+}
+
+// install replaces the catalog with the listed tools, persisting them when they changed. It is
+// called with the lock held.
+func (c *ToolCatalog) install(mcpTools []blaxel.ToolWithServer, fetchedAt time.Time) *CatalogSnapshot {
+	// Keep a stable order, servers are listed in map order
+	slices.SortStableFunc(mcpTools, func(a, b blaxel.ToolWithServer) int {
+		if a.ServerName != b.ServerName {
+			return strings.Compare(a.ServerName, b.ServerName)
+		}
+		return strings.Compare(a.Tool.Name, b.Tool.Name)
+	})
+
+	toolManager := NewToolManager()
+	tools := toolManager.ConvertMCPToolsToOpenAI(mcpTools)
+
+	snapshot := &CatalogSnapshot{
+		Generation:    c.generation,
+		FetchedAt:     fetchedAt,
+		ModifiedAt:    fetchedAt,
+		mcpTools:      mcpTools,
+		tools:         tools,
+		toolServerMap: toolManager.toolServerMap,
+		digest:        catalogDigest(mcpTools),
+	}
+	if c.snapshot != nil && c.snapshot.digest == snapshot.digest {
+		snapshot.ModifiedAt = c.snapshot.ModifiedAt
+	} else {
+		c.generation++
+		snapshot.Generation = c.generation
+		logger.Debugf("Tool catalog changed (generation %d, %d tools)", c.generation, len(tools))
+		c.notify(snapshot)
+		if c.cachePath != "" {
+			c.persist(snapshot)
+		}
+	}
+	c.snapshot = snapshot
+	c.restored = false
+	return snapshot
+}
+
+// IsWarm reports whether the catalog can be served, fetched from the MCP servers or restored from
+// the cache file
+func (c *ToolCatalog) IsWarm() bool {
+	if c.mcpManager.IsWarm() {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.restored
+}
+
+// Subscribe returns a channel receiving the catalog each time its content changes, only the latest
+// change being kept for slow readers, and a function ending the subscription
+func (c *ToolCatalog) Subscribe() (<-chan *CatalogSnapshot, func()) {
+	changes := make(chan *CatalogSnapshot, 1)
+
+	c.mu.Lock()
+	if c.subscribers == nil {
+		c.subscribers = make(map[chan *CatalogSnapshot]struct{})
+	}
+	c.subscribers[changes] = struct{}{}
+	c.mu.Unlock()
+
+	return changes, func() {
+		c.mu.Lock()
+		delete(c.subscribers, changes)
+		c.mu.Unlock()
+	}
+}
+
+// notify sends a changed catalog to the subscribers, replacing a change they have not read yet.
+// It is called with the lock held, so it is the only sender.
+func (c *ToolCatalog) notify(snapshot *CatalogSnapshot) {
+	for changes := range c.subscribers {
+		select {
+		case <-changes:
+		default:
+		}
+		changes <- snapshot
+	}
+}
+
+// RefreshInterval returns how often the catalog is fetched again from the MCP servers, the default
+// TTL being used when caching is disabled
+func (c *ToolCatalog) RefreshInterval() time.Duration {
+	if c.ttl > 0 {
+		return c.ttl
+	}
+	return defaultCatalogTTL
+}
+
+// Invalidate expires the cached catalog so the next snapshot is fetched from the MCP servers, on
+// every replica when the catalog is shared
+func (c *ToolCatalog) Invalidate() {
+	c.expire()
+	if c.shared != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), sharedCatalogTimeout)
+		defer cancel()
+		if err := c.shared.invalidate(ctx); err != nil {
+			logger.Warningf("Failed to invalidate the shared tool catalog: %v", err)
+		}
+	}
+}
+
+// expire expires the cached catalog of the replica
+func (c *ToolCatalog) expire() {
+	c.mu.Lock()
+	if c.snapshot != nil {
+		expired := *c.snapshot
+		expired.FetchedAt = time.Time{}
+		c.snapshot = &expired
+	}
+	c.mu.Unlock()
+}
+
+// catalogDigest hashes the listed tools to detect whether the catalog changed between refreshes
+func catalogDigest(mcpTools []blaxel.ToolWithServer) [sha256.Size]byte {
+	hash := sha256.New()
+	encoder := json.NewEncoder(hash)
+	for _, toolWithServer := range mcpTools {
+		hash.Write([]byte(toolWithServer.ServerName))
+		encoder.Encode(toolWithServer.Tool)
+	}
+
+	var digest [sha256.Size]byte
+	hash.Sum(digest[:0])
+	return digest
+}
@@ -0,0 +1,144 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/logger"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// catalogCacheVersion is the format of the cache file, files of other versions being ignored
+const catalogCacheVersion = 1
+
+// catalogValidationTimeout bounds the background listing validating a restored catalog
+const catalogValidationTimeout = 30 * time.Second
+
+// catalogCacheFile is the tool catalog persisted to BL_TOOL_CATALOG_CACHE
+type catalogCacheFile struct {
+	Version   int                `json:"version"`
+	FetchedAt time.Time          `json:"fetched_at"`
+	Tools     []catalogCacheTool `json:"tools"`
+}
+
+// catalogCacheTool is a tool of the cache file with the server listing it
+type catalogCacheTool struct {
+	Server string    `json:"server"`
+	Tool   *mcp.Tool `json:"tool"`
+}
+
+// newCatalogCacheFile returns the cache file of the tools of a snapshot
+func newCatalogCacheFile(snapshot *CatalogSnapshot) catalogCacheFile {
+	file := catalogCacheFile{Version: catalogCacheVersion, FetchedAt: snapshot.FetchedAt}
+	for _, tool := range snapshot.mcpTools {
+		file.Tools = append(file.Tools, catalogCacheTool{Server: tool.ServerName, Tool: tool.Tool})
+	}
+	return file
+}
+
+// mcpTools returns the tools of the cache file with their server
+func (f catalogCacheFile) mcpTools() []blaxel.ToolWithServer {
+	mcpTools := make([]blaxel.ToolWithServer, 0, len(f.Tools))
+	for _, tool := range f.Tools {
+		if tool.Tool != nil {
+			mcpTools = append(mcpTools, blaxel.ToolWithServer{Tool: tool.Tool, ServerName: tool.Server})
+		}
+	}
+	return mcpTools
+}
+
+// restore loads the catalog persisted to the cache file, so the first requests after a restart do
+// not wait for the MCP servers to list their tools. A missing or unreadable file is skipped.
+func (c *ToolCatalog) restore() {
+	data, err := os.ReadFile(c.cachePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		logger.Warningf("Failed to read tool catalog cache %s: %v", c.cachePath, err)
+		return
+	}
+	var file catalogCacheFile
+	if err := json.Unmarshal(data, &file); err != nil || file.Version != catalogCacheVersion {
+		logger.Warningf("Ignoring tool catalog cache %s of unknown format", c.cachePath)
+		return
+	}
+
+	mcpTools := file.mcpTools()
+	toolManager := NewToolManager()
+	c.generation++
+	c.snapshot = &CatalogSnapshot{
+		Generation:    c.generation,
+		FetchedAt:     file.FetchedAt,
+		ModifiedAt:    file.FetchedAt,
+		mcpTools:      mcpTools,
+		tools:         toolManager.ConvertMCPToolsToOpenAI(mcpTools),
+		toolServerMap: toolManager.toolServerMap,
+		digest:        catalogDigest(mcpTools),
+	}
+	c.restored = true
+	logger.Infof("Restored %d tools from the tool catalog cache %s, fetched at %s",
+		len(mcpTools), c.cachePath, file.FetchedAt.Format(time.RFC3339))
+}
+
+// validate lists the tools of the MCP servers to replace the restored catalog. The restored catalog
+// is kept while no configured server is connected, and validated again with the next snapshot.
+func (c *ToolCatalog) validate() {
+	ctx, cancel := context.WithTimeout(context.Background(), catalogValidationTimeout)
+	defer cancel()
+	mcpTools, err := c.mcpManager.ListAllTools(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.validating = false
+	if !c.restored {
+		return
+	}
+	if err != nil || (c.mcpManager.GetServerCount() == 0 && len(c.mcpManager.ServerHealth()) > 0) {
+		logger.Warningf("Could not validate the restored tool catalog yet, no MCP server is connected")
+		return
+	}
+	snapshot := c.install(mcpTools, time.Now())
+	logger.Infof("Validated the restored tool catalog against the MCP servers (generation %d, %d tools)",
+		snapshot.Generation, len(snapshot.tools))
+}
+
+// persist writes the catalog to the cache file, through a temporary file renamed over it so a
+// restart never reads a half written file
+func (c *ToolCatalog) persist(snapshot *CatalogSnapshot) {
+	if err := writeCatalogCache(c.cachePath, snapshot); err != nil {
+		logger.Warningf("Failed to persist the tool catalog: %v", err)
+	}
+}
+
+// writeCatalogCache writes the tools of the snapshot to the cache file at path
+func writeCatalogCache(path string, snapshot *CatalogSnapshot) error {
+	data, err := json.Marshal(newCatalogCacheFile(snapshot))
+	if err != nil {
+		return fmt.Errorf("failed to encode tool catalog cache: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write tool catalog cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write tool catalog cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write tool catalog cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write tool catalog cache: %w", err)
+	}
+	return nil
+}
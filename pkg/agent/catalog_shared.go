@@ -0,0 +1,222 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"template-custom-agent-go/pkg/logger"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Timings of the tool catalog shared through Redis
+const (
+	// sharedCatalogLockTimeout bounds how long a replica holds the fetch lock, in case it stops
+	// before releasing it
+	sharedCatalogLockTimeout = 30 * time.Second
+	// sharedCatalogWait bounds how long a replica without a catalog waits for another one to
+	// publish it before listing the MCP servers itself
+	sharedCatalogWait = 10 * time.Second
+	// sharedCatalogPoll is the interval at which the published catalog is read while waiting
+	sharedCatalogPoll = 200 * time.Millisecond
+	// sharedCatalogTimeout bounds the Redis requests made outside of agent requests
+	sharedCatalogTimeout = 5 * time.Second
+)
+
+// Events published on the changes channel of the shared catalog
+const (
+	sharedCatalogUpdated     = "updated"
+	sharedCatalogInvalidated = "invalidated"
+)
+
+// releaseLockScript deletes the fetch lock only when it is still held by the replica
+var releaseLockScript = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+
+// sharedCatalog shares the tool catalog between the replicas of an agent through Redis. A single
+// replica at a time lists the MCP servers, holding the fetch lock, and publishes the catalog. The
+// others read it and are told of changes over pub/sub.
+type sharedCatalog struct {
+	client  *redis.Client
+	key     string
+	replica string
+}
+
+// redisLogger writes the logs of the Redis client with the logger
+type redisLogger struct{}
+
+// Printf logs a message of the Redis client as a warning, as it only logs connection failures
+func (redisLogger) Printf(ctx context.Context, format string, v ...interface{}) {
+	logger.WarningfCtx(ctx, format, v...)
+}
+
+// sharedCatalogMessage is published on the changes channel by the replica changing the catalog
+type sharedCatalogMessage struct {
+	Replica string `json:"replica"`
+	Event   string `json:"event"`
+}
+
+// newSharedCatalogFromEnv connects to the Redis server of BL_TOOL_CATALOG_REDIS_URL, such as
+// redis://host:6379/0, returning nil when it is not set. The catalog is stored under
+// BL_TOOL_CATALOG_REDIS_KEY, tool-catalog:<workspace>:<agent> by default.
+func newSharedCatalogFromEnv() *sharedCatalog {
+	url := os.Getenv("BL_TOOL_CATALOG_REDIS_URL")
+	if url == "" {
+		return nil
+	}
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		logger.Warningf("Invalid BL_TOOL_CATALOG_REDIS_URL, the tool catalog is not shared: %v", err)
+		return nil
+	}
+	key := os.Getenv("BL_TOOL_CATALOG_REDIS_KEY")
+	if key == "" {
+		key = "tool-catalog:" + os.Getenv("BL_WORKSPACE") + ":" + os.Getenv("BL_NAME")
+	}
+	redis.SetLogger(redisLogger{})
+	return &sharedCatalog{client: redis.NewClient(options), key: key, replica: uuid.NewString()}
+}
+
+// load returns the published catalog, nil when there is none
+func (s *sharedCatalog) load(ctx context.Context) (*catalogCacheFile, error) {
+	data, err := s.client.Get(ctx, s.key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file catalogCacheFile
+	if err := json.Unmarshal(data, &file); err != nil || file.Version != catalogCacheVersion {
+		logger.Warningf("Ignoring shared tool catalog %s of unknown format", s.key)
+		return nil, nil
+	}
+	return &file, nil
+}
+
+// lock takes the fetch lock, reporting false when another replica holds it
+func (s *sharedCatalog) lock(ctx context.Context) (bool, error) {
+	return s.client.SetNX(ctx, s.key+":lock", s.replica, sharedCatalogLockTimeout).Result()
+}
+
+// unlock releases the fetch lock if the replica still holds it
+func (s *sharedCatalog) unlock(ctx context.Context) {
+	if err := releaseLockScript.Run(ctx, s.client, []string{s.key + ":lock"}, s.replica).Err(); err != nil {
+		logger.Warningf("Failed to release the shared tool catalog lock: %v", err)
+	}
+}
+
+// publish stores the catalog of a snapshot and tells the other replicas it changed
+func (s *sharedCatalog) publish(ctx context.Context, snapshot *CatalogSnapshot) error {
+	data, err := json.Marshal(newCatalogCacheFile(snapshot))
+	if err != nil {
+		return fmt.Errorf("failed to encode tool catalog: %w", err)
+	}
+	if err := s.client.Set(ctx, s.key, data, 0).Err(); err != nil {
+		return err
+	}
+	return s.notify(ctx, sharedCatalogUpdated)
+}
+
+// invalidate deletes the published catalog and tells the other replicas to expire theirs
+func (s *sharedCatalog) invalidate(ctx context.Context) error {
+	if err := s.client.Del(ctx, s.key).Err(); err != nil {
+		return err
+	}
+	return s.notify(ctx, sharedCatalogInvalidated)
+}
+
+// notify publishes an event of the replica on the changes channel
+func (s *sharedCatalog) notify(ctx context.Context, event string) error {
+	message, _ := json.Marshal(sharedCatalogMessage{Replica: s.replica, Event: event})
+	return s.client.Publish(ctx, s.key+":changes", message).Err()
+}
+
+// listen applies the changes published by the other replicas to the catalog: a published catalog
+// replaces an older one, and an invalidation expires it. The subscription reconnects on its own.
+func (c *ToolCatalog) listen() {
+	subscription := c.shared.client.Subscribe(context.Background(), c.shared.key+":changes")
+	for message := range subscription.Channel() {
+		var change sharedCatalogMessage
+		if err := json.Unmarshal([]byte(message.Payload), &change); err != nil || change.Replica == c.shared.replica {
+			continue
+		}
+		switch change.Event {
+		case sharedCatalogUpdated:
+			c.reload()
+		case sharedCatalogInvalidated:
+			c.expire()
+		}
+	}
+}
+
+// reload installs the published catalog when it is newer than the current one
+func (c *ToolCatalog) reload() {
+	ctx, cancel := context.WithTimeout(context.Background(), sharedCatalogTimeout)
+	defer cancel()
+	file, err := c.shared.load(ctx)
+	if err != nil {
+		logger.Warningf("Failed to read the shared tool catalog: %v", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if file != nil && (c.snapshot == nil || file.FetchedAt.After(c.snapshot.FetchedAt)) {
+		snapshot := c.install(file.mcpTools(), file.FetchedAt)
+		logger.Debugf("Loaded the tool catalog published by another replica (generation %d, %d tools)",
+			snapshot.Generation, len(snapshot.tools))
+	}
+}
+
+// sharedSnapshot returns the catalog published by a replica while it is fresh. Otherwise the replica
+// taking the fetch lock lists the MCP servers and publishes the catalog, while the others keep their
+// expired catalog until it is published, or wait for it when they have none. Redis errors fall back
+// to listing the MCP servers. It is called with the lock held.
+func (c *ToolCatalog) sharedSnapshot(ctx context.Context) (*CatalogSnapshot, error) {
+	deadline := time.Now().Add(sharedCatalogWait)
+	for {
+		file, err := c.shared.load(ctx)
+		if err == nil && file != nil && time.Since(file.FetchedAt) < c.ttl {
+			return c.install(file.mcpTools(), file.FetchedAt), nil
+		}
+		locked := false
+		if err == nil {
+			locked, err = c.shared.lock(ctx)
+		}
+		if err != nil {
+			logger.WarningfCtx(ctx, "Failed to read the shared tool catalog, listing the MCP servers: %v", err)
+			return c.list(ctx)
+		}
+
+		if locked {
+			defer c.shared.unlock(context.WithoutCancel(ctx))
+			snapshot, err := c.list(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if err := c.shared.publish(ctx, snapshot); err != nil {
+				logger.WarningfCtx(ctx, "Failed to publish the shared tool catalog: %v", err)
+			}
+			return snapshot, nil
+		}
+		if c.snapshot != nil {
+			return c.snapshot, nil
+		}
+		if time.Now().After(deadline) {
+			return c.list(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(sharedCatalogPoll):
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/logger"
+)
+
+// Bounds applied when compacting a conversation that exceeds the model context window
+const (
+	// compactedToolResultBytes is the size tool results are truncated to
+	compactedToolResultBytes = 4000
+	// summaryMessageBytes is the size each message is cut to in the summarization prompt
+	summaryMessageBytes = 2000
+)
+
+// Compaction records what was removed from a conversation to fit the context window of the model
+type Compaction struct {
+	Iteration int `json:"iteration"`
+	// SummarizedMessages is the number of oldest messages replaced by a summary
+	SummarizedMessages int `json:"summarized_messages"`
+	// TruncatedToolResults is the number of tool results cut to fit
+	TruncatedToolResults int `json:"truncated_tool_results"`
+	// BytesRemoved is the size of the content removed from the conversation
+	BytesRemoved int `json:"bytes_removed"`
+}
+
+// complete sends a request to the model with the response format of the agent, streamed to the delta
+// handler when stream is set. When it exceeds the context window, the conversation is compacted and
+// the request retried once.
+func (a *Agent) complete(ctx context.Context, iteration int, model string, encoder *blaxel.RequestEncoder, req blaxel.ChatCompletionRequest, messages *[]blaxel.ChatMessage, stream bool) (*blaxel.ChatCompletionResponse, error) {
+	if a.jsonFormat != nil {
+		req.ResponseFormat = a.jsonFormat.format
+	}
+	resp, err := a.send(ctx, iteration, model, encoder, req, stream)
+	if !errors.Is(err, blaxel.ErrContextLengthExceeded) {
+		return resp, err
+	}
+
+	compaction, compactErr := a.compact(ctx, iteration, messages)
+	if compactErr != nil {
+		logger.WarningfCtx(ctx, "Agent %s could not compact its conversation (iteration %d): %v", a.name, iteration, compactErr)
+		return nil, err
+	}
+	a.compactions = append(a.compactions, compaction)
+	logger.InfofCtx(ctx, "Agent %s compacted its conversation to fit the context window (iteration %d): %d messages summarized, %d tool results truncated, %d bytes removed",
+		a.name, iteration, compaction.SummarizedMessages, compaction.TruncatedToolResults, compaction.BytesRemoved)
+
+	// The earlier messages changed, so they must be encoded again
+	encoder.Reset()
+	req.Messages = *messages
+	return a.send(ctx, iteration, model, encoder, req, stream)
+}
+
+// send sends one request to the model, streaming its content to the delta handler when stream is set
+func (a *Agent) send(ctx context.Context, iteration int, model string, encoder *blaxel.RequestEncoder, req blaxel.ChatCompletionRequest, stream bool) (*blaxel.ChatCompletionResponse, error) {
+	var resp *blaxel.ChatCompletionResponse
+	var err error
+	if stream && a.onDelta != nil {
+		resp, err = a.blaxelClient.CreateChatCompletionStream(ctx, model, encoder, req, func(content string) {
+			a.onDelta(Delta{Iteration: iteration, Content: content})
+		})
+	} else {
+		resp, err = a.blaxelClient.CreateModelChatCompletion(ctx, model, encoder, req)
+	}
+	a.checkPayloadSize(ctx, iteration, encoder.LastSize())
+	return resp, err
+}
+
+// compact shrinks the conversation by truncating large tool results and replacing the oldest turns
+// after the user input with a summary, keeping each assistant turn with its tool results
+func (a *Agent) compact(ctx context.Context, iteration int, messages *[]blaxel.ChatMessage) (Compaction, error) {
+	compaction := Compaction{Iteration: iteration}
+
+	for i, message := range *messages {
+		if message.Role != "tool" || len(message.Content) <= compactedToolResultBytes {
+			continue
+		}
+		removed := len(message.Content) - compactedToolResultBytes
+		(*messages)[i].Content = strings.ToValidUTF8(message.Content[:compactedToolResultBytes], "") +
+			fmt.Sprintf("\n[%d bytes truncated to fit the context window]", removed)
+		compaction.TruncatedToolResults++
+		compaction.BytesRemoved += removed
+	}
+
+	// Summarize the turns before the middle one, the system prompt and user input being kept
+	var turns []int
+	for i := 2; i < len(*messages); i++ {
+		if (*messages)[i].Role == "assistant" {
+			turns = append(turns, i)
+		}
+	}
+	if len(turns) >= 2 {
+		end := turns[len(turns)/2]
+		oldest := (*messages)[2:end]
+		summary, err := a.summarize(ctx, iteration, oldest)
+		if err != nil {
+			logger.WarningfCtx(ctx, "Agent %s failed to summarize %d messages, dropping them: %v", a.name, len(oldest), err)
+			summary = fmt.Sprintf("%d earlier messages were dropped to fit the context window.", len(oldest))
+		}
+		for _, message := range oldest {
+			compaction.BytesRemoved += len(message.Content)
+		}
+		compaction.SummarizedMessages = len(oldest)
+
+		compacted := make([]blaxel.ChatMessage, 0, len(*messages)-len(oldest)+1)
+		compacted = append(compacted, (*messages)[:2]...)
+		compacted = append(compacted, blaxel.ChatMessage{
+			Role:    "system",
+			Content: "Summary of the earlier steps of this conversation: " + summary,
+		})
+		compacted = append(compacted, (*messages)[end:]...)
+		*messages = compacted
+	}
+
+	if compaction.TruncatedToolResults == 0 && compaction.SummarizedMessages == 0 {
+		return compaction, fmt.Errorf("no tool result or turn left to compact")
+	}
+	return compaction, nil
+}
+
+// summarize asks the model for a short summary of the messages
+func (a *Agent) summarize(ctx context.Context, iteration int, messages []blaxel.ChatMessage) (string, error) {
+	var transcript strings.Builder
+	for _, message := range messages {
+		content := message.Content
+		if len(content) > summaryMessageBytes {
+			content = strings.ToValidUTF8(content[:summaryMessageBytes], "") + " [...]"
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n", message.Role, content)
+		for _, toolCall := range message.ToolCalls {
+			fmt.Fprintf(&transcript, "%s called %s(%s)\n", message.Role, toolCall.Function.Name, toolCall.Function.Arguments)
+		}
+	}
+
+	req := blaxel.ChatCompletionRequest{
+		Messages: []blaxel.ChatMessage{
+			{
+				Role: "system",
+				Content: "Summarize these steps of an assistant's work concisely, keeping every fact, tool result " +
+					"and decision needed to finish the task.",
+			},
+			{Role: "user", Content: transcript.String()},
+		},
+	}
+	resp, err := a.blaxelClient.CreateModelChatCompletion(ctx, a.model, blaxel.NewRequestEncoder(), req)
+	if err != nil {
+		return "", err
+	}
+	a.recordUsage(iteration, a.model, resp.Usage)
+	if len(resp.Choices) == 0 || resp.Choices[0].Message.Content == "" {
+		return "", fmt.Errorf("empty summary")
+	}
+	return resp.Choices[0].Message.Content, nil
+}
@@ -0,0 +1,174 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/logger"
+)
+
+// HandoffToolName is the tool the model calls to hand the conversation over to a human
+const HandoffToolName = "handoff_to_human"
+
+// Triggers of a handoff
+const (
+	HandoffTriggerAgent     = "agent"
+	HandoffTriggerGuardrail = "guardrail"
+)
+
+// handoffPostTimeout bounds the time spent posting a handoff to the human queue
+const handoffPostTimeout = 10 * time.Second
+
+// Handoff records that a run was handed over to a human instead of being answered by the agent
+type Handoff struct {
+	// Trigger is "agent" when the model asked for a human, "guardrail" when a guardrail fired
+	Trigger   string `json:"trigger"`
+	Reason    string `json:"reason"`
+	Summary   string `json:"summary,omitempty"`
+	Iteration int    `json:"iteration"`
+	// Posted reports whether the conversation was delivered to the human queue
+	Posted bool   `json:"posted"`
+	Error  string `json:"error,omitempty"`
+}
+
+// handoffConfig enables handoffs and where they are posted
+type handoffConfig struct {
+	webhookURL  string
+	onGuardrail bool
+}
+
+// newHandoffConfig creates the handoff configuration when BL_HANDOFF is enabled. Handoffs are posted to
+// BL_HANDOFF_WEBHOOK_URL when set, and BL_HANDOFF_ON_GUARDRAIL=true also hands off runs whose tool
+// results trigger the prompt injection guard.
+func newHandoffConfig() *handoffConfig {
+	if os.Getenv("BL_HANDOFF") != "true" {
+		return nil
+	}
+	return &handoffConfig{
+		webhookURL:  os.Getenv("BL_HANDOFF_WEBHOOK_URL"),
+		onGuardrail: os.Getenv("BL_HANDOFF_ON_GUARDRAIL") == "true",
+	}
+}
+
+// tool returns the definition of the handoff tool offered to the model
+func (h *handoffConfig) tool() blaxel.Tool {
+	return blaxel.Tool{
+		Type: "function",
+		Function: blaxel.Function{
+			Name: HandoffToolName,
+			Description: "Hand the conversation over to a human agent. Use it only when you cannot help the user " +
+				"with the available tools and information, or when the user explicitly asks for a human.",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"reason":  map[string]interface{}{"type": "string", "description": "Why a human is needed"},
+					"summary": map[string]interface{}{"type": "string", "description": "Short summary of the request for the human agent"},
+				},
+				"required": []string{"reason"},
+			},
+		},
+	}
+}
+
+// handoffPayload is the JSON posted to the human queue webhook
+type handoffPayload struct {
+	Agent        string               `json:"agent"`
+	Handoff      Handoff              `json:"handoff"`
+	Conversation []blaxel.ChatMessage `json:"conversation"`
+}
+
+// post delivers the handoff and the conversation to the webhook, as a Slack message for Slack
+// incoming webhooks and as JSON otherwise
+func (h *handoffConfig) post(ctx context.Context, agentName string, handoff Handoff, conversation []blaxel.ChatMessage) error {
+	var payload interface{} = handoffPayload{Agent: agentName, Handoff: handoff, Conversation: conversation}
+	if parsed, err := url.Parse(h.webhookURL); err == nil && parsed.Hostname() == "hooks.slack.com" {
+		payload = map[string]string{"text": slackHandoffText(agentName, handoff, conversation)}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal handoff: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, handoffPostTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create handoff request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post handoff: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("handoff webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// slackHandoffText formats a handoff as a Slack message with the user and assistant turns
+func slackHandoffText(agentName string, handoff Handoff, conversation []blaxel.ChatMessage) string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "*Handoff from agent %s* (%s): %s\n", agentName, handoff.Trigger, handoff.Reason)
+	if handoff.Summary != "" {
+		fmt.Fprintf(&text, "> %s\n", handoff.Summary)
+	}
+	for _, message := range conversation {
+		if (message.Role == "user" || message.Role == "assistant") && message.Content != "" {
+			fmt.Fprintf(&text, "*%s*: %s\n", message.Role, message.Content)
+		}
+	}
+	return text.String()
+}
+
+// handOff ends the run with a handoff, posting the conversation to the human queue when configured
+func (a *Agent) handOff(ctx context.Context, handoff Handoff, conversation []blaxel.ChatMessage) *blaxel.ChatCompletionResponse {
+	logger.InfofCtx(ctx, "Agent %s handed off to a human (iteration %d, %s): %s", a.name, handoff.Iteration, handoff.Trigger, handoff.Reason)
+
+	if a.handoff.webhookURL != "" {
+		if err := a.handoff.post(ctx, a.name, handoff, conversation); err != nil {
+			logger.ErrorfCtx(ctx, "Agent %s failed to post handoff: %v", a.name, err)
+			handoff.Error = err.Error()
+		} else {
+			handoff.Posted = true
+		}
+	}
+
+	a.handoffResult = &handoff
+	return a.createHandoffResponse()
+}
+
+// handoffRequest returns the handoff requested by the model among its tool calls, if any
+func handoffRequest(toolCalls []blaxel.ToolCall, iteration int) *Handoff {
+	for _, toolCall := range toolCalls {
+		if toolCall.Function.Name != HandoffToolName {
+			continue
+		}
+		var arguments struct {
+			Reason  string `json:"reason"`
+			Summary string `json:"summary"`
+		}
+		json.Unmarshal([]byte(toolCall.Function.Arguments), &arguments)
+		if arguments.Reason == "" {
+			arguments.Reason = "the agent could not help"
+		}
+		return &Handoff{
+			Trigger:   HandoffTriggerAgent,
+			Reason:    arguments.Reason,
+			Summary:   arguments.Summary,
+			Iteration: iteration,
+		}
+	}
+	return nil
+}
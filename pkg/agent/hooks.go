@@ -0,0 +1,149 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/events"
+)
+
+// Hook intercepts the agent loop, to add logging, guardrails or custom telemetry without changing
+// Agent.Run. Embed NoopHook to implement only some of the methods. Hooks run in the goroutine of the
+// run, in the order they were added. Registered hooks are created for each agent by their factory,
+// so they need no locking for state kept per run, unless the factory returns a shared instance.
+type Hook interface {
+	// OnIterationStart is called before each model turn with the messages sent, which must not be
+	// modified. An error stops the run with that error.
+	OnIterationStart(ctx context.Context, iteration int, messages []blaxel.ChatMessage) error
+	// OnToolCall is called before a tool call allowed by the tool policy is executed. An error
+	// blocks the call, the model reading the error as its result.
+	OnToolCall(ctx context.Context, iteration int, call blaxel.ToolCall) error
+	// OnToolResult is called with the result of an executed tool call and returns the result the
+	// model reads, which can be redacted or annotated
+	OnToolResult(ctx context.Context, iteration int, call blaxel.ToolCall, result string) string
+	// OnFinalResponse is called with the final response of a run, after its checks and rewrites,
+	// and can change it when the hook implements AnswerRewriter. An error fails the run.
+	OnFinalResponse(ctx context.Context, resp *blaxel.ChatCompletionResponse) error
+}
+
+// AnswerRewriter is implemented by hooks whose OnFinalResponse changes the answer. Runs with such a
+// hook stream only their final answer, as deltas of the answer written by the model would differ
+// from it. Hooks that only observe the answer keep it streamed as it is written.
+type AnswerRewriter interface {
+	RewritesAnswer() bool
+}
+
+// NoopHook implements Hook without doing anything, to be embedded by hooks
+type NoopHook struct{}
+
+// OnIterationStart does nothing
+func (NoopHook) OnIterationStart(context.Context, int, []blaxel.ChatMessage) error { return nil }
+
+// OnToolCall does nothing
+func (NoopHook) OnToolCall(context.Context, int, blaxel.ToolCall) error { return nil }
+
+// OnToolResult returns the result unchanged
+func (NoopHook) OnToolResult(_ context.Context, _ int, _ blaxel.ToolCall, result string) string {
+	return result
+}
+
+// OnFinalResponse does nothing
+func (NoopHook) OnFinalResponse(context.Context, *blaxel.ChatCompletionResponse) error { return nil }
+
+// HookFactory creates the hook of one agent
+type HookFactory func() Hook
+
+// registeredHooks holds the factories of the hooks given to every agent
+var registeredHooks struct {
+	sync.Mutex
+	factories []HookFactory
+}
+
+// RegisterHook adds a hook created by factory to every agent created afterwards, typically from an
+// init function
+func RegisterHook(factory HookFactory) {
+	registeredHooks.Lock()
+	defer registeredHooks.Unlock()
+	registeredHooks.factories = append(registeredHooks.factories, factory)
+}
+
+// defaultHooks creates the registered hooks of a new agent
+func defaultHooks() []Hook {
+	registeredHooks.Lock()
+	factories := slices.Clone(registeredHooks.factories)
+	registeredHooks.Unlock()
+
+	hooks := make([]Hook, 0, len(factories))
+	for _, factory := range factories {
+		hooks = append(hooks, factory())
+	}
+	return hooks
+}
+
+// AddHook adds a hook to the agent, after the registered ones
+func (a *Agent) AddHook(hook Hook) *Agent {
+	a.hooks = append(a.hooks, hook)
+	return a
+}
+
+// hooksRewriteAnswer reports whether a hook of the agent changes the final answer
+func (a *Agent) hooksRewriteAnswer() bool {
+	for _, hook := range a.hooks {
+		if rewriter, ok := hook.(AnswerRewriter); ok && rewriter.RewritesAnswer() {
+			return true
+		}
+	}
+	return false
+}
+
+// startIteration runs the OnIterationStart hooks of a model turn
+func (a *Agent) startIteration(ctx context.Context, iteration int, messages []blaxel.ChatMessage) error {
+	for _, hook := range a.hooks {
+		if err := hook.OnIterationStart(ctx, iteration, messages); err != nil {
+			return fmt.Errorf("iteration %d stopped by a hook: %w", iteration, err)
+		}
+	}
+	return nil
+}
+
+// allowToolCall runs the OnToolCall hooks of a tool call, returning the error of the hook blocking it
+func (a *Agent) allowToolCall(ctx context.Context, iteration int, call blaxel.ToolCall) error {
+	for _, hook := range a.hooks {
+		if err := hook.OnToolCall(ctx, iteration, call); err != nil {
+			a.publish(ctx, events.GuardrailTriggered, events.Guardrail{
+				Iteration: iteration,
+				Guardrail: "hook",
+				Tool:      call.Function.Name,
+				Reason:    err.Error(),
+			})
+			return err
+		}
+	}
+	return nil
+}
+
+// toolResult runs the OnToolResult hooks of an executed tool call, each receiving the result of the
+// previous one
+func (a *Agent) toolResult(ctx context.Context, iteration int, call blaxel.ToolCall, result []byte) []byte {
+	if len(a.hooks) == 0 {
+		return result
+	}
+	content := string(result)
+	for _, hook := range a.hooks {
+		content = hook.OnToolResult(ctx, iteration, call, content)
+	}
+	return []byte(content)
+}
+
+// finishRun runs the OnFinalResponse hooks of the final response
+func (a *Agent) finishRun(ctx context.Context, resp *blaxel.ChatCompletionResponse) error {
+	for _, hook := range a.hooks {
+		if err := hook.OnFinalResponse(ctx, resp); err != nil {
+			return fmt.Errorf("final response rejected by a hook: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"testing"
+)
+
+// observerHook only reads the runs of its agent
+type observerHook struct{ NoopHook }
+
+// redactingHook changes the final answer of its agent
+type redactingHook struct{ NoopHook }
+
+// RewritesAnswer reports the hook changes the answer
+func (redactingHook) RewritesAnswer() bool { return true }
+
+// TestPostProcessesAnswerWithHooks checks only hooks declaring they rewrite the answer stop it from
+// being streamed
+func TestPostProcessesAnswerWithHooks(t *testing.T) {
+	tests := []struct {
+		name  string
+		hooks []Hook
+		want  bool
+	}{
+		{"no hook", nil, false},
+		{"observer", []Hook{observerHook{}}, false},
+		{"rewriter", []Hook{observerHook{}, redactingHook{}}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runAgent := NewAgent(Config{Name: "test", Model: "test-model", SystemPrompt: "You help."}, nil)
+			runAgent.hooks = nil
+			for _, hook := range test.hooks {
+				runAgent.AddHook(hook)
+			}
+			if got := runAgent.PostProcessesAnswer(); got != test.want {
+				t.Errorf("PostProcessesAnswer() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package agent
+
+import (
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/tools"
+)
+
+// RegisterNativeTools exposes the Go functions of the registry as tools executed in-process
+func (tm *ToolManager) RegisterNativeTools(registry *tools.Registry) []blaxel.Tool {
+	nativeTools := make([]blaxel.Tool, 0, registry.Len())
+	for _, tool := range registry.List() {
+		nativeTools = append(nativeTools, tm.RegisterLocalTool(blaxel.Tool{
+			Type: "function",
+			Function: blaxel.Function{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		}, LocalToolHandler(tool.Handler)))
+	}
+	return nativeTools
+}
@@ -0,0 +1,38 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// RenderSystemPrompt renders a system prompt written as a Go text/template with the variables, such as
+// {{.user_name}}. The built-in variables date, time and datetime hold the current UTC date and time,
+// and variables of the same name replace them. Prompts without {{ are returned as is, and a prompt
+// referencing an undefined variable is an error.
+func RenderSystemPrompt(prompt string, variables map[string]any) (string, error) {
+	if !strings.Contains(prompt, "{{") {
+		return prompt, nil
+	}
+	tmpl, err := template.New("system_prompt").Option("missingkey=error").Parse(prompt)
+	if err != nil {
+		return "", fmt.Errorf("invalid system prompt template: %w", err)
+	}
+
+	now := time.Now().UTC()
+	data := map[string]any{
+		"date":     now.Format(time.DateOnly),
+		"time":     now.Format("15:04"),
+		"datetime": now.Format(time.RFC3339),
+	}
+	for name, value := range variables {
+		data[name] = value
+	}
+
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", fmt.Errorf("failed to render the system prompt template: %w", err)
+	}
+	return rendered.String(), nil
+}
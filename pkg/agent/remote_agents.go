@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/logger"
+)
+
+// invalidToolNameChars matches characters not allowed in OpenAI function names
+var invalidToolNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// maxToolNameLength is the longest function name accepted by OpenAI
+const maxToolNameLength = 64
+
+// remoteAgentToolName returns the tool name of a remote agent. Names sanitized or truncated to the
+// name of another agent get a suffix hashed from the agent name to stay distinct.
+func remoteAgentToolName(name string, used map[string]bool) string {
+	toolName := "agent_" + invalidToolNameChars.ReplaceAllString(name, "_")
+	if len(toolName) > maxToolNameLength {
+		toolName = toolName[:maxToolNameLength]
+	}
+	if used[toolName] {
+		sum := sha256.Sum256([]byte(name))
+		suffix := "_" + hex.EncodeToString(sum[:4])
+		toolName = toolName[:min(len(toolName), maxToolNameLength-len(suffix))] + suffix
+	}
+	return toolName
+}
+
+// RegisterRemoteAgents exposes the other agents of the workspace as tools delegating to them
+func (tm *ToolManager) RegisterRemoteAgents(ctx context.Context, client *blaxel.Client) ([]blaxel.Tool, error) {
+	remoteAgents, err := client.ListRemoteAgents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var tools []blaxel.Tool
+	used := make(map[string]bool)
+	for _, remoteAgent := range remoteAgents {
+		name := remoteAgent.Name
+
+		description := fmt.Sprintf("Delegate a task to the %s agent deployed in this workspace and return its answer.", name)
+		if remoteAgent.Description != "" {
+			description += " " + remoteAgent.Description
+		}
+
+		toolName := remoteAgentToolName(name, used)
+		if used[toolName] {
+			logger.Warningf("Skipping remote agent %s, its tool name %s is already used", name, toolName)
+			continue
+		}
+		used[toolName] = true
+
+		tool := blaxel.Tool{
+			Type: "function",
+			Function: blaxel.Function{
+				Name:        toolName,
+				Description: description,
+				Parameters: map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"inputs": map[string]interface{}{
+							"type":        "string",
+							"description": "The task or question to send to the agent",
+						},
+					},
+					"required": []string{"inputs"},
+				},
+			},
+		}
+
+		tools = append(tools, tm.RegisterLocalTool(tool, func(ctx context.Context, arguments map[string]interface{}) (string, error) {
+			inputs, _ := arguments["inputs"].(string)
+			if inputs == "" {
+				return string(toolErrorResult("missing required argument: inputs")), nil
+			}
+			answer, err := client.RunRemoteAgent(ctx, name, inputs)
+			var agentErr *blaxel.RemoteAgentError
+			if errors.As(err, &agentErr) {
+				logger.WarningfCtx(ctx, "Remote agent %s failed: %v", name, err)
+				return string(toolErrorResult(err.Error())), nil
+			}
+			return answer, err
+		}))
+	}
+
+	return tools, nil
+}
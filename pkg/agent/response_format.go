@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/logger"
+
+	"github.com/google/jsonschema-go/jsonschema"
+)
+
+// defaultResponseFormatRepairs is the number of repair turns when BL_RESPONSE_FORMAT_REPAIRS is not set
+const defaultResponseFormatRepairs = 2
+
+// StructuredOutput describes the check of the final answer against the response format
+type StructuredOutput struct {
+	// Valid reports whether the returned answer follows the response format
+	Valid bool `json:"valid"`
+	// Errors lists why each rejected answer did not follow it, in order
+	Errors []string `json:"errors,omitempty"`
+	// Repairs counts the repair turns run
+	Repairs int `json:"repairs"`
+}
+
+// responseFormat is the response format of an agent with its resolved JSON schema, nil for json_object
+type responseFormat struct {
+	format  *blaxel.ResponseFormat
+	schema  *jsonschema.Resolved
+	repairs int
+}
+
+// ValidateResponseFormat checks that a response format is supported and that its JSON schema resolves
+func ValidateResponseFormat(format *blaxel.ResponseFormat) error {
+	_, err := newResponseFormat(format)
+	return err
+}
+
+// newResponseFormat resolves the JSON schema of the response format, returning nil for text answers
+func newResponseFormat(format *blaxel.ResponseFormat) (*responseFormat, error) {
+	if format == nil || format.Type == "" || format.Type == blaxel.ResponseFormatText {
+		return nil, nil
+	}
+	switch format.Type {
+	case blaxel.ResponseFormatJSONObject:
+		return &responseFormat{format: format, repairs: responseFormatRepairsFromEnv()}, nil
+	case blaxel.ResponseFormatJSONSchema:
+	default:
+		return nil, fmt.Errorf("unknown response format type %s", format.Type)
+	}
+	if format.JSONSchema == nil || format.JSONSchema.Schema == nil {
+		return nil, errors.New("json_schema response format without a schema")
+	}
+
+	data, err := json.Marshal(format.JSONSchema.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	var schema jsonschema.Schema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	// Schemas written for earlier drafts are validated with draft 2020-12, which only rejects others
+	schema.Schema = ""
+	resolved, err := schema.Resolve(nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	return &responseFormat{format: format, schema: resolved, repairs: responseFormatRepairsFromEnv()}, nil
+}
+
+// responseFormatRepairsFromEnv returns the number of repair turns of answers not following the
+// response format, from BL_RESPONSE_FORMAT_REPAIRS
+func responseFormatRepairsFromEnv() int {
+	if value := os.Getenv("BL_RESPONSE_FORMAT_REPAIRS"); value != "" {
+		if repairs, err := strconv.Atoi(value); err == nil && repairs >= 0 {
+			return repairs
+		}
+		logger.Warningf("Invalid BL_RESPONSE_FORMAT_REPAIRS %q, using %d", value, defaultResponseFormatRepairs)
+	}
+	return defaultResponseFormatRepairs
+}
+
+// prompt returns the instructions added to the system prompt, for models ignoring response_format
+func (f *responseFormat) prompt() string {
+	if f.schema == nil {
+		return "Give your final answer as a single JSON object, without any other text."
+	}
+	schema, _ := json.Marshal(f.format.JSONSchema.Schema)
+	return "Give your final answer as a single JSON value following this JSON schema, without any other text:\n" + string(schema)
+}
+
+// parse returns the JSON of the answer, without the code fence models often wrap it in, or why it
+// does not follow the response format
+func (f *responseFormat) parse(answer string) (string, error) {
+	answer = strings.TrimSpace(answer)
+	if fenced, found := strings.CutPrefix(answer, "```"); found && strings.HasSuffix(fenced, "```") {
+		fenced = strings.TrimPrefix(fenced, "json")
+		answer = strings.TrimSpace(strings.TrimSuffix(fenced, "```"))
+	}
+
+	var value any
+	if err := json.Unmarshal([]byte(answer), &value); err != nil {
+		return "", fmt.Errorf("the answer is not valid JSON: %v", err)
+	}
+	if f.schema == nil {
+		if _, ok := value.(map[string]any); !ok {
+			return "", errors.New("the answer is not a JSON object")
+		}
+		return answer, nil
+	}
+	if err := f.schema.Validate(value); err != nil {
+		return "", fmt.Errorf("the answer does not follow the JSON schema: %v", err)
+	}
+	return answer, nil
+}
+
+// checkResponseFormat checks the final answer against the response format, replacing it with its
+// JSON when it follows it. Otherwise repair turns without tools replace the answer until it follows
+// the format or the repairs run out, the last answer being kept.
+func (a *Agent) checkResponseFormat(ctx context.Context, encoder *blaxel.RequestEncoder, messages *[]blaxel.ChatMessage, resp *blaxel.ChatCompletionResponse) *blaxel.ChatCompletionResponse {
+	if len(resp.Choices) == 0 {
+		return resp
+	}
+	check := &StructuredOutput{}
+	a.jsonCheck = check
+	for {
+		answer := resp.Choices[0].Message
+		value, err := a.jsonFormat.parse(answer.Content)
+		if err == nil {
+			check.Valid = true
+			resp.Choices[0].Message.Content = value
+			return resp
+		}
+		check.Errors = append(check.Errors, err.Error())
+		if check.Repairs >= a.jsonFormat.repairs || a.budgetExceeded() {
+			logger.WarningfCtx(ctx, "Agent %s: answer does not follow the response format after %d repair turns: %v", a.name, check.Repairs, err)
+			return resp
+		}
+
+		logger.InfofCtx(ctx, "Agent %s: answer does not follow the response format, running a repair turn: %v", a.name, err)
+		check.Repairs++
+		repaired, repairErr := a.correctAnswer(ctx, a.stats.Iterations, encoder, messages, answer,
+			"Your last answer cannot be used: "+err.Error()+".\n"+a.jsonFormat.prompt()+
+				"\nReply with the corrected JSON only, without mentioning this request.")
+		if repairErr != nil {
+			logger.WarningfCtx(ctx, "Agent %s: repair turn failed, keeping the answer: %v", a.name, repairErr)
+			return resp
+		}
+		resp = repaired
+	}
+}
@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/logger"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RewriteProfile sets the tone, reading level and persona a final answer is rewritten in
+type RewriteProfile struct {
+	Tone         string `json:"tone,omitempty"`
+	ReadingLevel string `json:"reading_level,omitempty"`
+	Persona      string `json:"persona,omitempty"`
+	// Instructions are added as written, for style rules the other fields do not cover
+	Instructions string `json:"instructions,omitempty"`
+	// Model rewrites answers of this profile in place of BL_REWRITE_MODEL
+	Model string `json:"model,omitempty"`
+}
+
+// Rewrite describes the rewrite stage of a run, with the answer before and after it
+type Rewrite struct {
+	Profile  string `json:"profile"`
+	Model    string `json:"model"`
+	Original string `json:"original"`
+	// Rewritten is the answer returned to the client, empty when the rewrite failed
+	Rewritten string           `json:"rewritten,omitempty"`
+	Error     string           `json:"error,omitempty"`
+	Usage     blaxel.UsageInfo `json:"usage"`
+}
+
+// rewriteConfig is the profile final answers are rewritten in and the model rewriting them
+type rewriteConfig struct {
+	name    string
+	profile RewriteProfile
+	model   string
+}
+
+// rewriteProfiles are the profiles of BL_REWRITE_PROFILES, read once, for example
+// {"support": {"tone": "warm and reassuring", "reading_level": "grade 8"}}
+var rewriteProfiles = sync.OnceValue(func() map[string]RewriteProfile {
+	profiles := map[string]RewriteProfile{}
+	if value := os.Getenv("BL_REWRITE_PROFILES"); value != "" {
+		if err := json.Unmarshal([]byte(value), &profiles); err != nil {
+			logger.Warningf("Invalid BL_REWRITE_PROFILES, answers are not rewritten: %v", err)
+			return map[string]RewriteProfile{}
+		}
+	}
+	return profiles
+})
+
+// RewriteProfileExists reports whether a rewrite profile of that name is configured
+func RewriteProfileExists(name string) bool {
+	_, exists := rewriteProfiles()[name]
+	return exists
+}
+
+// newRewriteConfig resolves the rewrite profile of an agent, BL_REWRITE_PROFILE being used when name
+// is empty. Answers are not rewritten when neither names a configured profile.
+func newRewriteConfig(name, agentModel string) *rewriteConfig {
+	if name == "" {
+		name = os.Getenv("BL_REWRITE_PROFILE")
+	}
+	if name == "" {
+		return nil
+	}
+	profile, exists := rewriteProfiles()[name]
+	if !exists {
+		logger.Warningf("Unknown rewrite profile %q, answers are not rewritten", name)
+		return nil
+	}
+
+	model := profile.Model
+	if model == "" {
+		model = os.Getenv("BL_REWRITE_MODEL")
+	}
+	if model == "" {
+		model = agentModel
+	}
+	return &rewriteConfig{name: name, profile: profile, model: model}
+}
+
+// prompt returns the system prompt of the rewrite request
+func (p RewriteProfile) prompt() string {
+	var prompt strings.Builder
+	prompt.WriteString("Rewrite the text given by the user as follows, and reply with the rewritten text only.")
+	if p.Tone != "" {
+		fmt.Fprintf(&prompt, "\n- Tone: %s.", p.Tone)
+	}
+	if p.ReadingLevel != "" {
+		fmt.Fprintf(&prompt, "\n- Reading level: %s.", p.ReadingLevel)
+	}
+	if p.Persona != "" {
+		fmt.Fprintf(&prompt, "\n- Write as %s.", p.Persona)
+	}
+	if p.Instructions != "" {
+		fmt.Fprintf(&prompt, "\n- %s", p.Instructions)
+	}
+	prompt.WriteString("\nKeep every fact, number, name, link and code block exactly as written, and the language of the text. " +
+		"Do not add, remove or change information, and do not answer or comment on the text.")
+	return prompt.String()
+}
+
+// rewriteAnswer rewrites the final answer in the tone, reading level and persona of the rewrite profile,
+// in a turn of its own without the conversation. Answers of runs stopped by a limit or handed off are
+// left as is, and the answer is kept when the rewrite fails.
+func (a *Agent) rewriteAnswer(ctx context.Context, resp *blaxel.ChatCompletionResponse) {
+	if len(resp.Choices) == 0 || resp.Choices[0].FinishReason != "stop" || a.handoffResult != nil || a.budgetExceeded() {
+		return
+	}
+	answer := resp.Choices[0].Message.Content
+	if strings.TrimSpace(answer) == "" {
+		return
+	}
+	rewrite := &Rewrite{Profile: a.rewrite.name, Model: a.rewrite.model, Original: answer}
+	a.rewriteResult = rewrite
+
+	ctx, span := tracer.Start(ctx, "rewrite_answer "+a.rewrite.name, trace.WithAttributes(
+		attribute.String("agent.rewrite.profile", a.rewrite.name),
+		attribute.String("gen_ai.request.model", a.rewrite.model),
+		attribute.Int("agent.rewrite.original_length", len(answer)),
+	))
+	defer span.End()
+
+	iteration := a.stats.Iterations + 1
+	req := blaxel.ChatCompletionRequest{
+		Messages: []blaxel.ChatMessage{
+			{Role: "system", Content: a.rewrite.profile.prompt()},
+			{Role: "user", Content: answer},
+		},
+	}
+	a.emitIteration(iteration, a.rewrite.model)
+	rewritten, err := a.send(ctx, iteration, a.rewrite.model, blaxel.NewRequestEncoder(), req, true)
+	if err == nil && (len(rewritten.Choices) == 0 || strings.TrimSpace(rewritten.Choices[0].Message.Content) == "") {
+		err = fmt.Errorf("no rewritten answer returned from %s", a.rewrite.model)
+	}
+	if rewritten != nil {
+		rewrite.Usage = rewritten.Usage
+		a.recordUsage(iteration, a.rewrite.model, rewritten.Usage)
+	}
+	if err != nil {
+		logger.WarningfCtx(ctx, "Agent %s: rewrite with profile %s failed, keeping the answer: %v", a.name, a.rewrite.name, err)
+		rewrite.Error = err.Error()
+		recordSpanError(span, err)
+		return
+	}
+
+	a.stats.Iterations = iteration
+	rewrite.Rewritten = rewritten.Choices[0].Message.Content
+	resp.Choices[0].Message.Content = rewrite.Rewritten
+	span.SetAttributes(attribute.Int("agent.rewrite.rewritten_length", len(rewrite.Rewritten)))
+}
@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"template-custom-agent-go/pkg/logger"
+)
+
+// Sampling holds the sampling parameters of one model turn, nil values keep the model defaults
+type Sampling struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+}
+
+// SamplingSchedule sets the sampling parameters of each turn of a run, for example a higher
+// temperature while exploring with tools and a lower one for the final synthesis
+type SamplingSchedule struct {
+	// Iterations holds the sampling of tool-calling turns by iteration, starting with the first one.
+	// The last entry applies to every later iteration.
+	Iterations []Sampling `json:"iterations,omitempty"`
+	// Synthesis is the sampling of turns sent without tools, which must answer the user
+	Synthesis *Sampling `json:"synthesis,omitempty"`
+}
+
+// IsZero reports whether the schedule sets no sampling parameter
+func (s SamplingSchedule) IsZero() bool {
+	return len(s.Iterations) == 0 && s.Synthesis == nil
+}
+
+// forTurn returns the sampling of an iteration, synthesis turns falling back to the iteration schedule
+func (s SamplingSchedule) forTurn(iteration int, synthesis bool) Sampling {
+	if synthesis && s.Synthesis != nil {
+		return *s.Synthesis
+	}
+	if len(s.Iterations) == 0 {
+		return Sampling{}
+	}
+	return s.Iterations[min(iteration, len(s.Iterations))-1]
+}
+
+// defaultSamplingSchedule is the schedule of agents that do not set one, read once from BL_SAMPLING_SCHEDULE,
+// for example {"iterations": [{"temperature": 0.8}], "synthesis": {"temperature": 0.2}}
+var defaultSamplingSchedule = sync.OnceValue(func() SamplingSchedule {
+	var schedule SamplingSchedule
+	if value := os.Getenv("BL_SAMPLING_SCHEDULE"); value != "" {
+		if err := json.Unmarshal([]byte(value), &schedule); err != nil {
+			logger.Warningf("Invalid BL_SAMPLING_SCHEDULE, using the model defaults: %v", err)
+			return SamplingSchedule{}
+		}
+	}
+	return schedule
+})
@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/logger"
+
+	"github.com/google/uuid"
+)
+
+// sandboxInterpreters maps supported languages to the file extension and command used to run them
+var sandboxInterpreters = map[string]struct {
+	extension string
+	command   string
+}{
+	"python":     {extension: "py", command: "python3"},
+	"javascript": {extension: "js", command: "node"},
+	"bash":       {extension: "sh", command: "bash"},
+}
+
+// RegisterSandboxTools exposes code execution and file access in a Blaxel sandbox as tools
+func (tm *ToolManager) RegisterSandboxTools(sandbox *blaxel.SandboxManager) []blaxel.Tool {
+	// Missing arguments are reported to the model in the result, like rejected sandbox requests
+	stringArg := func(arguments map[string]interface{}, name string) (string, error) {
+		value, _ := arguments[name].(string)
+		if value == "" {
+			return "", &sandboxToolError{fmt.Sprintf("missing required argument: %s", name)}
+		}
+		return value, nil
+	}
+
+	execTool := tm.RegisterLocalTool(newSandboxTool(
+		"sandbox_exec",
+		fmt.Sprintf("Execute a shell command in the isolated sandbox %s and return its output and exit code.", sandbox.Name()),
+		map[string]interface{}{
+			"command":     map[string]interface{}{"type": "string", "description": "The shell command to run"},
+			"working_dir": map[string]interface{}{"type": "string", "description": "Optional working directory"},
+		},
+		[]string{"command"},
+	), func(ctx context.Context, arguments map[string]interface{}) (string, error) {
+		command, err := stringArg(arguments, "command")
+		if err != nil {
+			return sandboxResult("", err)
+		}
+		workingDir, _ := arguments["working_dir"].(string)
+		return sandboxResult(sandbox.Exec(ctx, command, workingDir))
+	})
+
+	runCodeTool := tm.RegisterLocalTool(newSandboxTool(
+		"sandbox_run_code",
+		"Run a code snippet in the isolated sandbox and return its output. Supported languages: python, javascript, bash.",
+		map[string]interface{}{
+			"language": map[string]interface{}{"type": "string", "enum": []string{"python", "javascript", "bash"}},
+			"code":     map[string]interface{}{"type": "string", "description": "The source code to run"},
+		},
+		[]string{"language", "code"},
+	), func(ctx context.Context, arguments map[string]interface{}) (string, error) {
+		language, err := stringArg(arguments, "language")
+		if err != nil {
+			return sandboxResult("", err)
+		}
+		code, err := stringArg(arguments, "code")
+		if err != nil {
+			return sandboxResult("", err)
+		}
+		interpreter, exists := sandboxInterpreters[language]
+		if !exists {
+			return sandboxResult("", &sandboxToolError{fmt.Sprintf("unsupported language: %s", language)})
+		}
+
+		// The sandbox is shared by concurrent runs, so each snippet gets its own file
+		path := fmt.Sprintf("/tmp/snippet-%s.%s", uuid.NewString(), interpreter.extension)
+		if _, err := sandbox.WriteFile(ctx, path, code); err != nil {
+			return sandboxResult("", err)
+		}
+		defer func() {
+			if err := sandbox.DeleteFile(context.WithoutCancel(ctx), path); err != nil {
+				logger.WarningfCtx(ctx, "Failed to remove snippet %s from the sandbox: %v", path, err)
+			}
+		}()
+		return sandboxResult(sandbox.Exec(ctx, fmt.Sprintf("%s %s", interpreter.command, path), ""))
+	})
+
+	readFileTool := tm.RegisterLocalTool(newSandboxTool(
+		"sandbox_read_file",
+		"Read a file from the isolated sandbox.",
+		map[string]interface{}{
+			"path": map[string]interface{}{"type": "string", "description": "Absolute path of the file"},
+		},
+		[]string{"path"},
+	), func(ctx context.Context, arguments map[string]interface{}) (string, error) {
+		path, err := stringArg(arguments, "path")
+		if err != nil {
+			return sandboxResult("", err)
+		}
+		return sandboxResult(sandbox.ReadFile(ctx, path))
+	})
+
+	writeFileTool := tm.RegisterLocalTool(newSandboxTool(
+		"sandbox_write_file",
+		"Create or overwrite a file in the isolated sandbox.",
+		map[string]interface{}{
+			"path":    map[string]interface{}{"type": "string", "description": "Absolute path of the file"},
+			"content": map[string]interface{}{"type": "string", "description": "The content to write"},
+		},
+		[]string{"path", "content"},
+	), func(ctx context.Context, arguments map[string]interface{}) (string, error) {
+		path, err := stringArg(arguments, "path")
+		if err != nil {
+			return sandboxResult("", err)
+		}
+		content, _ := arguments["content"].(string)
+		return sandboxResult(sandbox.WriteFile(ctx, path, content))
+	})
+
+	return []blaxel.Tool{execTool, runCodeTool, readFileTool, writeFileTool}
+}
+
+// sandboxToolError is a problem with the arguments of a sandbox tool call, which the model can fix
+type sandboxToolError struct {
+	message string
+}
+
+// Error returns the message of the error
+func (e *sandboxToolError) Error() string {
+	return e.message
+}
+
+// sandboxResult returns the result of a sandbox tool. Invalid arguments and requests rejected by the
+// sandbox are returned as an error result for the model to recover from, so only failures to reach
+// the sandbox fail the run.
+func sandboxResult(result string, err error) (string, error) {
+	var toolErr *sandboxToolError
+	var requestErr *blaxel.SandboxRequestError
+	if errors.As(err, &toolErr) || errors.As(err, &requestErr) {
+		return string(toolErrorResult(err.Error())), nil
+	}
+	return result, err
+}
+
+// newSandboxTool creates the OpenAI definition of a sandbox tool
+func newSandboxTool(name, description string, properties map[string]interface{}, required []string) blaxel.Tool {
+	return blaxel.Tool{
+		Type: "function",
+		Function: blaxel.Function{
+			Name:        name,
+			Description: description,
+			Parameters: map[string]interface{}{
+				"type":       "object",
+				"properties": properties,
+				"required":   required,
+			},
+		},
+	}
+}
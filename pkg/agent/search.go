@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"template-custom-agent-go/pkg/blaxel"
+)
+
+// Weights of the places a query term can match a tool
+const (
+	nameExactWeight   = 10.0
+	nameTermWeight    = 3.0
+	descriptionWeight = 1.0
+)
+
+// ToolMatch is a tool ranked by its relevance to a search query
+type ToolMatch struct {
+	ServerName  string  `json:"server"`
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Score       float64 `json:"score"`
+}
+
+// SearchTools ranks tools by how well their names and descriptions match the query. Terms match
+// tool name words exactly, as a prefix or within a small edit distance to tolerate typos, and
+// description words exactly or as a prefix. At most limit matches are returned, best first.
+func SearchTools(tools []blaxel.ToolWithServer, query string, limit int) []ToolMatch {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil
+	}
+	normalizedQuery := strings.Join(terms, "_")
+
+	var matches []ToolMatch
+	for _, toolWithServer := range tools {
+		tool := toolWithServer.Tool
+		nameWords := searchTerms(tool.Name)
+		descriptionWords := searchTerms(tool.Description)
+
+		score := 0.0
+		if strings.Join(nameWords, "_") == normalizedQuery {
+			score += nameExactWeight
+		}
+		for _, term := range terms {
+			score += nameTermWeight * bestWordMatch(term, nameWords, true)
+			score += descriptionWeight * bestWordMatch(term, descriptionWords, false)
+		}
+		if score == 0 {
+			continue
+		}
+
+		matches = append(matches, ToolMatch{
+			ServerName:  toolWithServer.ServerName,
+			Name:        tool.Name,
+			Description: tool.Description,
+			Score:       score / float64(len(terms)),
+		})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Name < matches[j].Name
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// bestWordMatch returns how well a term matches the best of the words, from 0 to 1
+func bestWordMatch(term string, words []string, fuzzy bool) float64 {
+	best := 0.0
+	for _, word := range words {
+		switch {
+		case word == term:
+			return 1
+		case strings.HasPrefix(word, term) && len(term) >= 3:
+			best = max(best, 0.75)
+		case fuzzy && len(term) >= 4 && editDistance(term, word) <= len(term)/4:
+			best = max(best, 0.5)
+		}
+	}
+	return best
+}
+
+// searchTerms lowercases text and splits it into words on anything but letters and digits,
+// so snake_case, kebab-case and sentences are handled alike
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
@@ -0,0 +1,41 @@
+package agent
+
+import "template-custom-agent-go/pkg/blaxel"
+
+// RunStats describes the work done by a run
+type RunStats struct {
+	// Iterations is the number of model turns, the synthesis turn included
+	Iterations int
+	// ToolCalls counts the executed calls of each tool
+	ToolCalls map[string]int
+	// Usage is the token usage of each model called during the run
+	Usage map[string]blaxel.UsageInfo
+}
+
+// newRunStats creates empty run statistics
+func newRunStats() RunStats {
+	return RunStats{
+		ToolCalls: make(map[string]int),
+		Usage:     make(map[string]blaxel.UsageInfo),
+	}
+}
+
+// recordUsage adds the token usage of a model response
+func (s *RunStats) recordUsage(model string, usage blaxel.UsageInfo) {
+	total := s.Usage[model]
+	total.PromptTokens += usage.PromptTokens
+	total.CompletionTokens += usage.CompletionTokens
+	total.TotalTokens += usage.TotalTokens
+	s.Usage[model] = total
+}
+
+// TotalUsage returns the token usage summed across models
+func (s RunStats) TotalUsage() blaxel.UsageInfo {
+	var total blaxel.UsageInfo
+	for _, usage := range s.Usage {
+		total.PromptTokens += usage.PromptTokens
+		total.CompletionTokens += usage.CompletionTokens
+		total.TotalTokens += usage.TotalTokens
+	}
+	return total
+}
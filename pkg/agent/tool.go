@@ -1,21 +1,39 @@
 package agent
 
 import (
+	"context"
 	"encoding/json"
+	"slices"
 
 	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/logger"
 )
 
+// LocalToolHandler executes a tool in-process instead of routing it to an MCP server
+type LocalToolHandler func(ctx context.Context, arguments map[string]interface{}) (string, error)
+
 // ToolManager handles conversion between MCP tools and OpenAI tools
 type ToolManager struct {
 	// Map to track which server each tool belongs to
 	toolServerMap map[string]string
+	// Map of tools executed in-process
+	localHandlers map[string]LocalToolHandler
 }
 
 // NewToolManager creates a new tool manager
 func NewToolManager() *ToolManager {
 	return &ToolManager{
 		toolServerMap: make(map[string]string),
+		localHandlers: make(map[string]LocalToolHandler),
+	}
+}
+
+// NewToolManagerFromSnapshot creates a tool manager routing the tools of a catalog snapshot.
+// The server mapping is shared with the snapshot and only read.
+func NewToolManagerFromSnapshot(snapshot *CatalogSnapshot) *ToolManager {
+	return &ToolManager{
+		toolServerMap: snapshot.toolServerMap,
+		localHandlers: make(map[string]LocalToolHandler),
 	}
 }
 
@@ -27,23 +45,9 @@ func (tm *ToolManager) ConvertMCPToolsToOpenAI(mcpToolsWithServer []blaxel.ToolW
 	tm.toolServerMap = make(map[string]string)
 
 	for _, toolWithServer := range mcpToolsWithServer {
-		mcpTool := toolWithServer.Tool
-		serverName := toolWithServer.ServerName
-
-		// Store server association
-		tm.toolServerMap[mcpTool.Name] = serverName
-
-		// Handle optional description
-		description := mcpTool.Description
-
-		// Convert to OpenAI format
-		openAITool := blaxel.Tool{
-			Type: "function",
-			Function: blaxel.Function{
-				Name:        mcpTool.Name,
-				Description: description,
-				Parameters:  convertParameters(mcpTool.InputSchema),
-			},
+		openAITool, ok := tm.convertMCPTool(toolWithServer)
+		if !ok {
+			continue
 		}
 
 		openAITools = append(openAITools, openAITool)
@@ -52,12 +56,68 @@ func (tm *ToolManager) ConvertMCPToolsToOpenAI(mcpToolsWithServer []blaxel.ToolW
 	return openAITools
 }
 
+// convertMCPTool converts a single MCP tool, skipping it if the conversion panics
+func (tm *ToolManager) convertMCPTool(toolWithServer blaxel.ToolWithServer) (openAITool blaxel.Tool, ok bool) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			logger.Errorf("Skipping tool from server %s, conversion panicked: %v", toolWithServer.ServerName, recovered)
+			ok = false
+		}
+	}()
+
+	mcpTool := toolWithServer.Tool
+	serverName := toolWithServer.ServerName
+
+	// Store server association
+	tm.toolServerMap[mcpTool.Name] = serverName
+
+	// Handle optional description
+	description := mcpTool.Description
+
+	// Convert to OpenAI format
+	openAITool = blaxel.Tool{
+		Type: "function",
+		Function: blaxel.Function{
+			Name:        mcpTool.Name,
+			Description: description,
+			Parameters:  convertParameters(mcpTool.InputSchema),
+		},
+	}
+
+	return openAITool, true
+}
+
 // GetServerForTool returns the server name for a given tool
 func (tm *ToolManager) GetServerForTool(toolName string) (string, bool) {
 	serverName, exists := tm.toolServerMap[toolName]
 	return serverName, exists
 }
 
+// KeepServers restricts the MCP tools routed by the manager to those of the given servers, so tools
+// of the other servers cannot be called even when the model names them. The server mapping shared
+// with a snapshot is copied rather than modified.
+func (tm *ToolManager) KeepServers(servers []string) {
+	kept := make(map[string]string, len(tm.toolServerMap))
+	for tool, server := range tm.toolServerMap {
+		if slices.Contains(servers, server) {
+			kept[tool] = server
+		}
+	}
+	tm.toolServerMap = kept
+}
+
+// RegisterLocalTool registers a tool executed in-process by the given handler
+func (tm *ToolManager) RegisterLocalTool(tool blaxel.Tool, handler LocalToolHandler) blaxel.Tool {
+	tm.localHandlers[tool.Function.Name] = handler
+	return tool
+}
+
+// GetLocalHandler returns the in-process handler for a given tool
+func (tm *ToolManager) GetLocalHandler(toolName string) (LocalToolHandler, bool) {
+	handler, exists := tm.localHandlers[toolName]
+	return handler, exists
+}
+
 // convertParameters converts MCP input schema to OpenAI parameters format
 func convertParameters(inputSchema interface{}) map[string]interface{} {
 	// Convert to JSON and back to get a clean map[string]interface{}
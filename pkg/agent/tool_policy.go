@@ -0,0 +1,52 @@
+package agent
+
+import "template-custom-agent-go/pkg/blaxel"
+
+// toolPolicy scopes the tools a run may use by name
+type toolPolicy struct {
+	// allowed lists the only tools offered to the model, nil allowing every tool
+	allowed map[string]bool
+	// blocked lists tools never offered to the model, even when allowed
+	blocked map[string]bool
+}
+
+// newToolPolicy creates the policy of the allow and deny lists, nil when neither restricts tools.
+// An empty, non-nil allow list allows no tool.
+func newToolPolicy(allowed, blocked []string) *toolPolicy {
+	if allowed == nil && len(blocked) == 0 {
+		return nil
+	}
+	policy := &toolPolicy{blocked: make(map[string]bool, len(blocked))}
+	if allowed != nil {
+		policy.allowed = make(map[string]bool, len(allowed))
+		for _, name := range allowed {
+			policy.allowed[name] = true
+		}
+	}
+	for _, name := range blocked {
+		policy.blocked[name] = true
+	}
+	return policy
+}
+
+// allows reports whether the run may call the tool
+func (p *toolPolicy) allows(name string) bool {
+	if p == nil {
+		return true
+	}
+	return !p.blocked[name] && (p.allowed == nil || p.allowed[name])
+}
+
+// filter returns the tools the run may call
+func (p *toolPolicy) filter(tools []blaxel.Tool) []blaxel.Tool {
+	if p == nil {
+		return tools
+	}
+	kept := make([]blaxel.Tool, 0, len(tools))
+	for _, tool := range tools {
+		if p.allows(tool.Function.Name) {
+			kept = append(kept, tool)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"template-custom-agent-go/pkg/blaxel"
+
+	"github.com/blaxel-ai/toolkit/sdk"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// testModel answers chat completions with its responses in turn, keeping the requests it received
+type testModel struct {
+	mu        sync.Mutex
+	responses []blaxel.ChatMessage
+	requests  []blaxel.ChatCompletionRequest
+}
+
+// ServeHTTP answers a chat completion request with the next response
+func (m *testModel) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var request blaxel.ChatCompletionRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests = append(m.requests, request)
+	if len(m.responses) == 0 {
+		http.Error(w, "no response left", http.StatusInternalServerError)
+		return
+	}
+	message := m.responses[0]
+	m.responses = m.responses[1:]
+	json.NewEncoder(w).Encode(blaxel.ChatCompletionResponse{Choices: []blaxel.Choice{{Message: message, FinishReason: "stop"}}})
+}
+
+// newTestClient creates a client of the workspace "test" sending its model requests to model
+func newTestClient(t *testing.T, model http.Handler) *blaxel.Client {
+	t.Helper()
+	server := httptest.NewServer(model)
+	t.Cleanup(server.Close)
+	client, err := sdk.NewClientWithResponses(server.URL, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &blaxel.Client{BlaxelClient: client, Workspace: "test", RunUrl: server.URL, Model: "test-model"}
+}
+
+// TestKeepServersRejectsOtherServers checks a tool of a server left out of the run is answered with a
+// tool error instead of being called, even when the model names it
+func TestKeepServersRejectsOtherServers(t *testing.T) {
+	catalog := &ToolCatalog{}
+	snapshot := catalog.install([]blaxel.ToolWithServer{
+		{ServerName: "docs", Tool: &mcp.Tool{Name: "search_docs", InputSchema: map[string]interface{}{"type": "object"}}},
+		{ServerName: "admin", Tool: &mcp.Tool{Name: "delete_user", InputSchema: map[string]interface{}{"type": "object"}}},
+	}, time.Now())
+
+	model := &testModel{responses: []blaxel.ChatMessage{
+		{Role: "assistant", ToolCalls: []blaxel.ToolCall{{
+			Id:       "call_1",
+			Type:     "function",
+			Function: blaxel.ToolCallFunction{Name: "delete_user", Arguments: `{"id":"42"}`},
+		}}},
+		{Role: "assistant", Content: "I cannot delete users."},
+	}}
+	toolManager := NewToolManagerFromSnapshot(snapshot)
+	toolManager.KeepServers([]string{"docs"})
+	runAgent := NewAgent(Config{Name: "test", Model: "test-model", SystemPrompt: "You help."}, newTestClient(t, model))
+	runAgent.SetTools(snapshot.ToolsOf([]string{"docs"}))
+	runAgent.SetToolManager(toolManager)
+
+	resp, err := runAgent.Run(context.Background(), "Delete user 42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content := resp.Choices[0].Message.Content; content != "I cannot delete users." {
+		t.Errorf("answer = %q", content)
+	}
+
+	if len(model.requests) != 2 {
+		t.Fatalf("model received %d requests, want 2", len(model.requests))
+	}
+	for _, tool := range model.requests[0].Tools {
+		if tool.Function.Name == "delete_user" {
+			t.Errorf("delete_user offered to the model")
+		}
+	}
+	messages := model.requests[1].Messages
+	result := messages[len(messages)-1]
+	if result.Role != "tool" || !strings.Contains(result.Content, "tool delete_user is not available for this run") {
+		t.Errorf("tool result = %+v, want an error", result)
+	}
+	if _, exists := snapshot.toolServerMap["delete_user"]; !exists {
+		t.Errorf("KeepServers modified the server mapping of the snapshot")
+	}
+}
@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"template-custom-agent-go/pkg/config"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of agent runs and their iterations
+var tracer = otel.Tracer("template-custom-agent-go/pkg/agent")
+
+// executionAttributes returns the Blaxel execution and task of the process as span attributes, to
+// correlate run traces with platform executions
+func executionAttributes() []attribute.KeyValue {
+	execution := config.ExecutionFromEnv()
+	var attributes []attribute.KeyValue
+	if execution.ID != "" {
+		attributes = append(attributes, attribute.String("blaxel.execution.id", execution.ID))
+	}
+	if execution.TaskIndex != "" {
+		attributes = append(attributes, attribute.String("blaxel.task.index", execution.TaskIndex))
+	}
+	return attributes
+}
+
+// endRunSpan records the iterations and token usage of a run, or its error, and ends its span
+func endRunSpan(span trace.Span, stats RunStats, err error) {
+	defer span.End()
+	var inputTokens, outputTokens int
+	for _, usage := range stats.Usage {
+		inputTokens += usage.PromptTokens
+		outputTokens += usage.CompletionTokens
+	}
+	var toolCalls int
+	for _, calls := range stats.ToolCalls {
+		toolCalls += calls
+	}
+	span.SetAttributes(
+		attribute.Int("agent.iterations", stats.Iterations),
+		attribute.Int("agent.tool_calls", toolCalls),
+		attribute.Int("gen_ai.usage.input_tokens", inputTokens),
+		attribute.Int("gen_ai.usage.output_tokens", outputTokens),
+	)
+	if err != nil {
+		recordSpanError(span, err)
+	}
+}
+
+// recordSpanError marks a span as failed with the error
+func recordSpanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
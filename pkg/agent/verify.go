@@ -0,0 +1,368 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/logger"
+
+	"github.com/google/uuid"
+)
+
+// Bounds of the verification of a final answer
+const (
+	// maxVerifiedSnippets is the number of code snippets of an answer run in the sandbox
+	maxVerifiedSnippets = 3
+	// maxReportedProblems is the number of problems reported to the model in the corrective turn
+	maxReportedProblems = 5
+	// snippetOutputBytes bounds the output of a failing snippet quoted to the model
+	snippetOutputBytes = 500
+)
+
+// Verification describes the check of the final answer of a run
+type Verification struct {
+	// Calculations is the number of calculations whose result was recomputed
+	Calculations int `json:"calculations"`
+	// Snippets is the number of Python snippets run in the sandbox
+	Snippets int `json:"snippets"`
+	// Problems lists the wrong results and failing snippets found in the answer
+	Problems []string `json:"problems,omitempty"`
+	// Corrected reports whether a corrective turn replaced the answer
+	Corrected bool `json:"corrected"`
+}
+
+// verifyAnswersFromEnv reports whether final answers are verified for every run, set with BL_VERIFY_ANSWERS=true
+func verifyAnswersFromEnv() bool {
+	return os.Getenv("BL_VERIFY_ANSWERS") == "true"
+}
+
+// verifyAnswer recomputes the calculations of the final answer and runs its Python snippets in the
+// sandbox when there is one. When a result is wrong or a snippet fails, one corrective turn without
+// tools replaces the answer. The answer is kept when the corrective turn fails.
+func (a *Agent) verifyAnswer(ctx context.Context, iteration int, encoder *blaxel.RequestEncoder, messages *[]blaxel.ChatMessage, resp *blaxel.ChatCompletionResponse) *blaxel.ChatCompletionResponse {
+	if len(resp.Choices) == 0 {
+		return resp
+	}
+	answer := resp.Choices[0].Message
+	verification := &Verification{}
+	a.verification = verification
+
+	claims := arithmeticClaims(answer.Content)
+	verification.Calculations = len(claims)
+	for _, claim := range claims {
+		if problem, wrong := claim.check(); wrong {
+			verification.Problems = append(verification.Problems, problem)
+		}
+	}
+
+	if a.blaxelClient.Sandbox != nil {
+		snippets := pythonSnippets(answer.Content)
+		if len(snippets) > maxVerifiedSnippets {
+			snippets = snippets[:maxVerifiedSnippets]
+		}
+		verification.Snippets = len(snippets)
+		for i, snippet := range snippets {
+			if problem, failed := a.runSnippet(ctx, i+1, snippet); failed {
+				verification.Problems = append(verification.Problems, problem)
+			}
+		}
+	}
+
+	if len(verification.Problems) == 0 {
+		return resp
+	}
+	logger.InfofCtx(ctx, "Agent %s: answer failed verification, running a corrective turn: %s", a.name, strings.Join(verification.Problems, "; "))
+
+	reported := verification.Problems
+	if len(reported) > maxReportedProblems {
+		reported = reported[:maxReportedProblems]
+	}
+	corrected, err := a.correctAnswer(ctx, iteration, encoder, messages, answer,
+		"Verification of your last answer found these problems:\n- "+strings.Join(reported, "\n- ")+
+			"\nCheck them and reply with the full corrected answer, without mentioning this verification.")
+	if err != nil {
+		logger.WarningfCtx(ctx, "Agent %s: corrective turn failed, keeping the unverified answer: %v", a.name, err)
+		return resp
+	}
+	verification.Corrected = true
+	return corrected
+}
+
+// sandboxProcess is the part of a sandbox process used to tell whether a snippet failed
+type sandboxProcess struct {
+	ExitCode *int   `json:"exitCode"`
+	Logs     string `json:"logs"`
+	Stderr   string `json:"stderr"`
+}
+
+// runSnippet runs a Python snippet of the answer in the sandbox, reporting it when it fails. Snippets
+// that cannot be run, for instance when the sandbox is unavailable, are not reported.
+func (a *Agent) runSnippet(ctx context.Context, number int, snippet string) (string, bool) {
+	// The sandbox is shared by concurrent runs, so each snippet gets its own file
+	path := fmt.Sprintf("/tmp/verify-%s.py", uuid.NewString())
+	if _, err := a.blaxelClient.Sandbox.WriteFile(ctx, path, snippet); err != nil {
+		logger.WarningfCtx(ctx, "Agent %s: could not verify Python snippet %d: %v", a.name, number, err)
+		return "", false
+	}
+	defer func() {
+		if err := a.blaxelClient.Sandbox.DeleteFile(context.WithoutCancel(ctx), path); err != nil {
+			logger.WarningfCtx(ctx, "Failed to remove snippet %s from the sandbox: %v", path, err)
+		}
+	}()
+	output, err := a.blaxelClient.Sandbox.Exec(ctx, "python3 "+path, "")
+	if err != nil {
+		logger.WarningfCtx(ctx, "Agent %s: could not verify Python snippet %d: %v", a.name, number, err)
+		return "", false
+	}
+
+	var process sandboxProcess
+	if err := json.Unmarshal([]byte(output), &process); err != nil || process.ExitCode == nil || *process.ExitCode == 0 {
+		return "", false
+	}
+	details := process.Stderr
+	if details == "" {
+		details = process.Logs
+	}
+	if len(details) > snippetOutputBytes {
+		details = details[len(details)-snippetOutputBytes:]
+	}
+	return fmt.Sprintf("Python snippet %d fails with exit code %d: %s", number, *process.ExitCode, strings.TrimSpace(details)), true
+}
+
+// pythonCodeBlock matches the fenced Python code blocks of an answer
+var pythonCodeBlock = regexp.MustCompile("(?s)```(?:python|py|python3)[ \t]*\n(.*?)```")
+
+// pythonSnippets returns the fenced Python code blocks of an answer
+func pythonSnippets(text string) []string {
+	var snippets []string
+	for _, match := range pythonCodeBlock.FindAllStringSubmatch(text, -1) {
+		if snippet := strings.TrimSpace(match[1]); snippet != "" {
+			snippets = append(snippets, snippet)
+		}
+	}
+	return snippets
+}
+
+// arithmeticClaim is a calculation stated in an answer, such as "12 × 34 = 408"
+type arithmeticClaim struct {
+	expression string
+	stated     string
+}
+
+// calculationCharacters are the characters of arithmetic expressions
+const calculationCharacters = "0123456789.,+-*/×÷^() \t"
+
+// expressionNumber matches the numbers of an arithmetic expression
+var expressionNumber = regexp.MustCompile(`\d+(?:\.\d+)?`)
+
+// statedResult matches "= <number>", the result of a calculation
+var statedResult = regexp.MustCompile(`=\s*(-?\d+(?:,\d{3})*(?:\.\d+)?)`)
+
+// arithmeticClaims finds the calculations of an answer: arithmetic expressions of at least two
+// numbers directly followed by "=" and a number
+func arithmeticClaims(text string) []arithmeticClaim {
+	var claims []arithmeticClaim
+	for _, match := range statedResult.FindAllStringSubmatchIndex(text, -1) {
+		// The expression is the run of arithmetic characters before "="
+		start := match[0]
+		for start > 0 {
+			r, size := utf8.DecodeLastRuneInString(text[:start])
+			if !strings.ContainsRune(calculationCharacters, r) {
+				break
+			}
+			start -= size
+		}
+		expression := strings.TrimLeft(strings.TrimSpace(text[start:match[0]]), ", ")
+		if !isCalculation(expression) {
+			continue
+		}
+		claims = append(claims, arithmeticClaim{expression: expression, stated: text[match[2]:match[3]]})
+	}
+	return claims
+}
+
+// isCalculation reports whether an expression combines at least two numbers with an operator
+func isCalculation(expression string) bool {
+	numbers := expressionNumber.FindAllStringIndex(expression, -1)
+	if len(numbers) < 2 {
+		return false
+	}
+	between := expression[numbers[0][1]:numbers[len(numbers)-1][0]]
+	return strings.ContainsAny(between, "+-*/×÷^")
+}
+
+// check recomputes the calculation, reporting it when the stated result is wrong. The stated result
+// may be rounded to its number of decimals. Expressions that cannot be evaluated are not reported.
+func (c arithmeticClaim) check() (string, bool) {
+	actual, err := evaluate(c.expression)
+	if err != nil || math.IsNaN(actual) || math.IsInf(actual, 0) {
+		return "", false
+	}
+	stated, err := strconv.ParseFloat(strings.ReplaceAll(c.stated, ",", ""), 64)
+	if err != nil {
+		return "", false
+	}
+
+	decimals := 0
+	if dot := strings.IndexByte(c.stated, '.'); dot >= 0 {
+		decimals = len(c.stated) - dot - 1
+	}
+	tolerance := math.Max(0.5*math.Pow10(-decimals), 1e-9*math.Abs(actual))
+	if math.Abs(actual-stated) <= tolerance {
+		return "", false
+	}
+	return fmt.Sprintf("%s = %s is wrong, it equals %s", c.expression, c.stated, strconv.FormatFloat(actual, 'f', -1, 64)), true
+}
+
+// evaluate computes an arithmetic expression of numbers, + - * / × ÷ ^ and parentheses
+func evaluate(expression string) (float64, error) {
+	p := &expressionParser{input: []rune(strings.ReplaceAll(expression, ",", ""))}
+	value, err := p.sum()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpaces()
+	if p.pos != len(p.input) {
+		return 0, fmt.Errorf("unexpected %q", string(p.input[p.pos:]))
+	}
+	return value, nil
+}
+
+// expressionParser is a recursive descent parser of arithmetic expressions
+type expressionParser struct {
+	input []rune
+	pos   int
+}
+
+// skipSpaces moves past spaces and tabs
+func (p *expressionParser) skipSpaces() {
+	for p.pos < len(p.input) && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// next returns the next operator or parenthesis without consuming it, 0 at the end
+func (p *expressionParser) next() rune {
+	p.skipSpaces()
+	if p.pos == len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+// sum parses terms separated by + and -
+func (p *expressionParser) sum() (float64, error) {
+	value, err := p.product()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		switch p.next() {
+		case '+':
+			p.pos++
+			term, err := p.product()
+			if err != nil {
+				return 0, err
+			}
+			value += term
+		case '-':
+			p.pos++
+			term, err := p.product()
+			if err != nil {
+				return 0, err
+			}
+			value -= term
+		default:
+			return value, nil
+		}
+	}
+}
+
+// product parses factors separated by *, /, × and ÷
+func (p *expressionParser) product() (float64, error) {
+	value, err := p.power()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		switch p.next() {
+		case '*', '×':
+			p.pos++
+			factor, err := p.power()
+			if err != nil {
+				return 0, err
+			}
+			value *= factor
+		case '/', '÷':
+			p.pos++
+			factor, err := p.power()
+			if err != nil {
+				return 0, err
+			}
+			if factor == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			value /= factor
+		default:
+			return value, nil
+		}
+	}
+}
+
+// power parses a unary expression optionally raised to a right-associative power
+func (p *expressionParser) power() (float64, error) {
+	base, err := p.unary()
+	if err != nil {
+		return 0, err
+	}
+	if p.next() != '^' {
+		return base, nil
+	}
+	p.pos++
+	exponent, err := p.power()
+	if err != nil {
+		return 0, err
+	}
+	return math.Pow(base, exponent), nil
+}
+
+// unary parses a signed number or parenthesized expression
+func (p *expressionParser) unary() (float64, error) {
+	switch p.next() {
+	case '-':
+		p.pos++
+		value, err := p.unary()
+		return -value, err
+	case '+':
+		p.pos++
+		return p.unary()
+	case '(':
+		p.pos++
+		value, err := p.sum()
+		if err != nil {
+			return 0, err
+		}
+		if p.next() != ')' {
+			return 0, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return value, nil
+	}
+
+	start := p.pos
+	for p.pos < len(p.input) && (p.input[p.pos] >= '0' && p.input[p.pos] <= '9' || p.input[p.pos] == '.') {
+		p.pos++
+	}
+	if start == p.pos {
+		return 0, fmt.Errorf("expected a number at %d", start)
+	}
+	return strconv.ParseFloat(string(p.input[start:p.pos]), 64)
+}
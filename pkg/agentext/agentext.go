@@ -0,0 +1,158 @@
+package agentext
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"template-custom-agent-go/pkg/agent"
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/events"
+	"template-custom-agent-go/pkg/store"
+	"template-custom-agent-go/pkg/tools"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Extension adds tools, hooks, channels or a conversation store to the server without modifying its
+// packages. Register it from the init function of a package linked into the binary, typically with a
+// blank import in a file of package main guarded by a build tag.
+type Extension struct {
+	// Name identifies the extension in logs and GET /admin/info
+	Name string
+	// Tools are given to every agent like the native tools of pkg/tools
+	Tools []tools.Tool
+	// Hooks receive the events of agent runs, keyed by topic or events.AllTopics
+	Hooks map[string]events.Handler
+	// AgentHooks create the hooks intercepting the loop of every agent, which can block tool calls or
+	// change results
+	AgentHooks []agent.HookFactory
+	// Channels add HTTP routes, such as the webhooks of messaging platforms, once the core routes are
+	// set up. They share the middleware of the server, authentication included.
+	Channels []Channel
+	// ConversationStore opens the store persisting conversations in place of the built-in SQLite and
+	// Postgres ones. Returning a nil store disables persistence.
+	ConversationStore func(ctx context.Context) (store.Store, error)
+}
+
+// Channel sets up the routes of a channel, which runs the agent through the runner
+type Channel func(engine *gin.Engine, runner Runner)
+
+// Runner runs the agent for channels
+type Runner interface {
+	RunAgent(ctx context.Context, request RunRequest) (*RunResult, error)
+}
+
+// RunRequest is an agent run requested by a channel
+type RunRequest struct {
+	// Channel names the channel in the run summaries, as the endpoint of the run
+	Channel string
+	Inputs  string
+	// SessionID groups the runs of a conversation when conversations are persisted
+	SessionID string
+	Metadata  map[string]string
+	Tags      []string
+}
+
+// RunResult is the outcome of an agent run requested by a channel
+type RunResult struct {
+	RunID        string
+	SessionID    string
+	Answer       string
+	FinishReason string
+	Usage        blaxel.UsageInfo
+}
+
+// registry holds the registered extensions
+var registry = struct {
+	sync.Mutex
+	extensions map[string]Extension
+}{extensions: make(map[string]Extension)}
+
+// Register adds an extension, registering its tools and hooks right away. It panics when the name is
+// empty or taken, a tool is invalid, or another extension already provides the conversation store,
+// as it is meant to run from init functions.
+func Register(extension Extension) {
+	if err := register(extension); err != nil {
+		panic(err)
+	}
+}
+
+// register adds an extension, failing when it is invalid
+func register(extension Extension) error {
+	registry.Lock()
+	defer registry.Unlock()
+	if extension.Name == "" {
+		return fmt.Errorf("extension has no name")
+	}
+	if _, exists := registry.extensions[extension.Name]; exists {
+		return fmt.Errorf("extension %s is already registered", extension.Name)
+	}
+	if extension.ConversationStore != nil {
+		for _, other := range registry.extensions {
+			if other.ConversationStore != nil {
+				return fmt.Errorf("extension %s provides a conversation store, already provided by extension %s", extension.Name, other.Name)
+			}
+		}
+	}
+
+	for _, tool := range extension.Tools {
+		if err := tools.Register(tool); err != nil {
+			return fmt.Errorf("extension %s: %w", extension.Name, err)
+		}
+	}
+	for topic, handler := range extension.Hooks {
+		events.Subscribe(topic, handler)
+	}
+	for _, hook := range extension.AgentHooks {
+		agent.RegisterHook(hook)
+	}
+	registry.extensions[extension.Name] = extension
+	return nil
+}
+
+// Names returns the names of the registered extensions, sorted
+func Names() []string {
+	registry.Lock()
+	defer registry.Unlock()
+	names := make([]string, 0, len(registry.extensions))
+	for name := range registry.extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NamedChannel is a channel with the name of the extension providing it
+type NamedChannel struct {
+	Extension string
+	Setup     Channel
+}
+
+// Channels returns the channels of the registered extensions, sorted by extension name
+func Channels() []NamedChannel {
+	var channels []NamedChannel
+	for _, name := range Names() {
+		registry.Lock()
+		extension := registry.extensions[name]
+		registry.Unlock()
+		for _, channel := range extension.Channels {
+			channels = append(channels, NamedChannel{Extension: name, Setup: channel})
+		}
+	}
+	return channels
+}
+
+// ConversationStore returns the function opening the conversation store of the extension providing
+// one, with its name, or nil when none does
+func ConversationStore() (func(ctx context.Context) (store.Store, error), string) {
+	registry.Lock()
+	defer registry.Unlock()
+	for name, extension := range registry.extensions {
+		if extension.ConversationStore != nil {
+			return extension.ConversationStore, name
+		}
+	}
+	return nil, ""
+}
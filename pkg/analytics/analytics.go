@@ -0,0 +1,228 @@
+package analytics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/logger"
+)
+
+// Defaults of the run summary exporter
+const (
+	DefaultBatchSize     = 50
+	DefaultFlushInterval = 10 * time.Second
+	defaultQueueSize     = 1000
+	maxAttempts          = 3
+	retryBackoff         = time.Second
+	postTimeout          = 10 * time.Second
+)
+
+// Run statuses reported in summaries
+const (
+	StatusCompleted      = "completed"
+	StatusFailed         = "failed"
+	StatusMaxIterations  = "max_iterations"
+	StatusHandoff        = "handoff"
+	StatusBudgetExceeded = "budget_exceeded"
+)
+
+// Summary is the compact description of a run sent to the analytics endpoint
+type Summary struct {
+	RunID      string `json:"run_id"`
+	RequestID  string `json:"request_id,omitempty"`
+	Endpoint   string `json:"endpoint"`
+	Agent      string `json:"agent"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	Model      string `json:"model"`
+	Iterations int    `json:"iterations"`
+	// ToolCalls counts the executed calls of each tool
+	ToolCalls map[string]int   `json:"tool_calls,omitempty"`
+	Usage     blaxel.UsageInfo `json:"usage"`
+	// CostUSD is the estimated cost of the run, omitted when a model has no configured price
+	CostUSD       *float64  `json:"cost_usd,omitempty"`
+	LatencyMs     int64     `json:"latency_ms"`
+	LatencyBucket string    `json:"latency_bucket"`
+	StartedAt     time.Time `json:"started_at"`
+	// Metadata and Tags are the labels set by the request, for segmentation
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+	// ExecutionID and TaskIndex identify the Blaxel execution and task that ran the agent
+	ExecutionID string `json:"execution_id,omitempty"`
+	TaskIndex   string `json:"task_index,omitempty"`
+}
+
+// latencyBuckets are the upper bounds of the latency buckets of run summaries
+var latencyBuckets = []struct {
+	limit time.Duration
+	label string
+}{
+	{time.Second, "lt_1s"},
+	{5 * time.Second, "1s_5s"},
+	{15 * time.Second, "5s_15s"},
+	{time.Minute, "15s_60s"},
+}
+
+// LatencyBucket returns the bucket label of a run latency
+func LatencyBucket(latency time.Duration) string {
+	for _, bucket := range latencyBuckets {
+		if latency < bucket.limit {
+			return bucket.label
+		}
+	}
+	return "gte_60s"
+}
+
+// Price is the cost of a model in USD per million tokens
+type Price struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// Exporter batches run summaries and posts them to an analytics endpoint in the background,
+// retrying failed batches. Recording never blocks: summaries are dropped when the queue is full.
+type Exporter struct {
+	url           string
+	batchSize     int
+	flushInterval time.Duration
+	prices        map[string]Price
+	queue         chan Summary
+	client        *http.Client
+}
+
+// NewExporterFromEnv creates an exporter posting to BL_RUN_SUMMARY_URL, returning nil when it is not set.
+// BL_RUN_SUMMARY_BATCH_SIZE and BL_RUN_SUMMARY_FLUSH_INTERVAL control batching, and BL_MODEL_PRICES
+// holds the prices used to estimate costs as JSON, such as {"gpt-4o": {"input": 2.5, "output": 10}}.
+func NewExporterFromEnv() *Exporter {
+	url := os.Getenv("BL_RUN_SUMMARY_URL")
+	if url == "" {
+		return nil
+	}
+
+	exporter := &Exporter{
+		url:           url,
+		batchSize:     DefaultBatchSize,
+		flushInterval: DefaultFlushInterval,
+		prices:        map[string]Price{},
+		queue:         make(chan Summary, defaultQueueSize),
+		client:        &http.Client{Timeout: postTimeout},
+	}
+	if value := os.Getenv("BL_RUN_SUMMARY_BATCH_SIZE"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			logger.Warningf("Invalid BL_RUN_SUMMARY_BATCH_SIZE %q, using %d", value, DefaultBatchSize)
+		} else {
+			exporter.batchSize = size
+		}
+	}
+	if value := os.Getenv("BL_RUN_SUMMARY_FLUSH_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			logger.Warningf("Invalid BL_RUN_SUMMARY_FLUSH_INTERVAL %q, using %s", value, DefaultFlushInterval)
+		} else {
+			exporter.flushInterval = interval
+		}
+	}
+	if value := os.Getenv("BL_MODEL_PRICES"); value != "" {
+		if err := json.Unmarshal([]byte(value), &exporter.prices); err != nil {
+			logger.Warningf("Invalid BL_MODEL_PRICES, costs are not estimated: %v", err)
+		}
+	}
+
+	go exporter.loop()
+	logger.Infof("Run summaries enabled: batch_size=%d flush_interval=%s", exporter.batchSize, exporter.flushInterval)
+	return exporter
+}
+
+// Cost estimates the cost of the token usage of each model, returning nil when a model has no price
+func (e *Exporter) Cost(usage map[string]blaxel.UsageInfo) *float64 {
+	var cost float64
+	for model, tokens := range usage {
+		price, exists := e.prices[model]
+		if !exists {
+			return nil
+		}
+		cost += (float64(tokens.PromptTokens)*price.Input + float64(tokens.CompletionTokens)*price.Output) / 1e6
+	}
+	return &cost
+}
+
+// Record queues a summary for export, dropping it when the queue is full
+func (e *Exporter) Record(summary Summary) {
+	select {
+	case e.queue <- summary:
+	default:
+		logger.Warningf("Run summary queue full, dropping summary of run %s", summary.RunID)
+	}
+}
+
+// loop batches queued summaries, posting a batch when it is full or the flush interval elapses
+func (e *Exporter) loop() {
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Summary, 0, e.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.post(batch); err != nil {
+			logger.Errorf("Failed to export %d run summaries: %v", len(batch), err)
+		}
+		batch = make([]Summary, 0, e.batchSize)
+	}
+
+	for {
+		select {
+		case summary := <-e.queue:
+			batch = append(batch, summary)
+			if len(batch) >= e.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// post sends a batch as {"summaries": [...]}, retrying with a growing backoff
+func (e *Exporter) post(batch []Summary) error {
+	body, err := json.Marshal(map[string][]Summary{"summaries": batch})
+	if err != nil {
+		return fmt.Errorf("failed to marshal run summaries: %w", err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = e.send(body)
+		if err == nil || attempt == maxAttempts {
+			return err
+		}
+		logger.Debugf("Run summary export attempt %d failed, retrying: %v", attempt, err)
+		time.Sleep(retryBackoff * time.Duration(attempt))
+	}
+}
+
+// send posts one batch body to the endpoint
+func (e *Exporter) send(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create run summary request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post run summaries: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("run summary endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package blaxel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// remoteAgentsCacheTTL controls how long the list of workspace agents is reused
+const remoteAgentsCacheTTL = time.Minute
+
+// RemoteAgent represents another agent deployed in the same Blaxel workspace
+type RemoteAgent struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// remoteAgentsCache holds the last discovered workspace agents
+type remoteAgentsCache struct {
+	mu        sync.Mutex
+	agents    []RemoteAgent
+	fetchedAt time.Time
+}
+
+// RemoteAgentsEnabled reports whether sibling agents should be exposed as tools.
+// BL_REMOTE_AGENTS accepts "true" or "*" for every agent, or a comma-separated list of agent names.
+func (c *Client) RemoteAgentsEnabled() bool {
+	value := strings.TrimSpace(os.Getenv("BL_REMOTE_AGENTS"))
+	return value != "" && value != "false"
+}
+
+// ListRemoteAgents discovers the agents deployed in the workspace, excluding this deployment
+func (c *Client) ListRemoteAgents(ctx context.Context) ([]RemoteAgent, error) {
+	c.remoteAgents.mu.Lock()
+	defer c.remoteAgents.mu.Unlock()
+
+	if c.remoteAgents.agents != nil && time.Since(c.remoteAgents.fetchedAt) < remoteAgentsCacheTTL {
+		return c.remoteAgents.agents, nil
+	}
+
+	resp, err := c.BlaxelClient.ListAgentsWithResponse(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list agents: %w", err)
+	}
+	if resp.JSON200 == nil {
+		return nil, fmt.Errorf("failed to list agents: status %d: %s", resp.StatusCode(), string(resp.Body))
+	}
+
+	allowed := map[string]bool{}
+	filter := strings.TrimSpace(os.Getenv("BL_REMOTE_AGENTS"))
+	if filter != "true" && filter != "*" {
+		for _, name := range strings.Split(filter, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				allowed[name] = true
+			}
+		}
+	}
+	self := os.Getenv("BL_NAME")
+
+	agents := []RemoteAgent{}
+	for _, a := range *resp.JSON200 {
+		if a.Metadata == nil || a.Metadata.Name == nil {
+			continue
+		}
+		name := *a.Metadata.Name
+		if name == self || (len(allowed) > 0 && !allowed[name]) {
+			continue
+		}
+
+		remoteAgent := RemoteAgent{Name: name}
+		if a.Spec != nil && a.Spec.Description != nil {
+			remoteAgent.Description = *a.Spec.Description
+		}
+		agents = append(agents, remoteAgent)
+	}
+
+	c.remoteAgents.agents = agents
+	c.remoteAgents.fetchedAt = time.Now()
+	return agents, nil
+}
+
+// RemoteAgentError is a response of another agent of the workspace with a status other than 200
+type RemoteAgentError struct {
+	Name       string
+	StatusCode int
+	Body       string
+}
+
+// Error describes the failed run with the response of the agent
+func (e *RemoteAgentError) Error() string {
+	return fmt.Sprintf("agent %s failed with status %d: %s", e.Name, e.StatusCode, e.Body)
+}
+
+// RunRemoteAgent sends the inputs to another agent of the workspace and returns its answer. Plain text
+// is requested, as agents built from this template stream events by default.
+func (c *Client) RunRemoteAgent(ctx context.Context, name, inputs string) (string, error) {
+	jsonData, err := json.Marshal(map[string]string{"inputs": inputs})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := c.BlaxelClient.Run(
+		ctx,
+		c.Workspace,
+		"agent",
+		name,
+		"POST",
+		"/",
+		map[string]string{"Accept": "text/plain"},
+		[]string{},
+		string(jsonData),
+		c.Debug,
+		false,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to run agent %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &RemoteAgentError{Name: name, StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	return string(body), nil
+}
@@ -1,14 +1,21 @@
 package blaxel
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"template-custom-agent-go/pkg/logger"
+	"template-custom-agent-go/pkg/metrics"
+	"template-custom-agent-go/pkg/models"
+	"template-custom-agent-go/pkg/signing"
 
 	"github.com/blaxel-ai/toolkit/sdk"
 )
@@ -23,6 +30,14 @@ type Client struct {
 	Debug        bool
 	AuthProvider sdk.AuthProvider
 	McpManager   *MCPManager
+	// MCPServers are the MCP servers configured at startup, whether they could be connected or not
+	MCPServers []MCPServerConfig
+	Sandbox    *SandboxManager
+	// EmbeddingModel is the model of the workspace creating embeddings, BL_EMBEDDING_MODEL or Model
+	EmbeddingModel string
+
+	remoteAgents    remoteAgentsCache
+	modelValidation modelValidation
 }
 
 // ChatCompletionRequest represents the request body for chat completions
@@ -35,6 +50,35 @@ type ChatCompletionRequest struct {
 	TopP        *float64      `json:"top_p,omitempty"`
 	Tools       []Tool        `json:"tools,omitempty"`
 	ToolChoice  interface{}   `json:"tool_choice,omitempty"`
+	// StreamOptions is only sent with streamed requests
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+	// Store and Metadata are forwarded to providers supporting stored completions, such as OpenAI
+	Store    *bool             `json:"store,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty" binding:"max=16,dive,keys,max=64,endkeys,max=512"`
+	// ResponseFormat asks the model for an answer in JSON
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+}
+
+// Types of response formats
+const (
+	ResponseFormatText       = "text"
+	ResponseFormatJSONObject = "json_object"
+	ResponseFormatJSONSchema = "json_schema"
+)
+
+// ResponseFormat is the OpenAI response_format, asking for any JSON object with json_object or for
+// one following a JSON schema with json_schema
+type ResponseFormat struct {
+	Type       string      `json:"type" binding:"required,oneof=text json_object json_schema"`
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+// JSONSchema is the schema of json_schema response formats
+type JSONSchema struct {
+	Name        string                 `json:"name" binding:"required,max=64"`
+	Description string                 `json:"description,omitempty"`
+	Schema      map[string]interface{} `json:"schema,omitempty"`
+	Strict      *bool                  `json:"strict,omitempty"`
 }
 
 // Tool represents a tool that can be called by the AI
@@ -104,6 +148,26 @@ type ErrorResponse struct {
 	} `json:"error"`
 }
 
+// ErrContextLengthExceeded is wrapped by errors of requests larger than the context window of the model
+var ErrContextLengthExceeded = errors.New("context length exceeded")
+
+// contextLengthFragments are found in the messages of context window errors of providers without an error code for it
+var contextLengthFragments = []string{"context length", "context window", "maximum context", "prompt is too long", "too many tokens"}
+
+// isContextLengthError reports whether an upstream error is about the request exceeding the context window
+func isContextLengthError(code, message string) bool {
+	if code == "context_length_exceeded" {
+		return true
+	}
+	message = strings.ToLower(message)
+	for _, fragment := range contextLengthFragments {
+		if strings.Contains(message, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
 // NewClient creates a new Blaxel client
 func NewClient() *Client {
 	workspace := os.Getenv("BL_WORKSPACE")
@@ -122,6 +186,10 @@ func NewClient() *Client {
 	if model == "" {
 		model = "sandbox-openai"
 	}
+	embeddingModel := os.Getenv("BL_EMBEDDING_MODEL")
+	if embeddingModel == "" {
+		embeddingModel = model
+	}
 	debug := os.Getenv("BL_DEBUG")
 	if debug == "" {
 		debug = "false"
@@ -138,85 +206,178 @@ func NewClient() *Client {
 		logger.Warningf("Invalid credentials for workspace %s", workspace)
 		logger.Warningf("Please run `bl login %s` to fix it credentials.", workspace)
 	}
+	authProvider := sdk.GetAuthProvider(credentials, workspace, apiUrl)
+
+	headers, err := authProvider.GetHeaders()
+	if err != nil {
+		logger.Fatalf("failed to get headers: %v", err)
+	}
+
+	// Identify this deployment on every request sent through the SDK and to MCP servers
+	userAgent := UserAgent(headers["User-Agent"])
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	headers["User-Agent"] = userAgent
+	logger.Infof("Upstream requests identified as %q", userAgent)
+
 	c, err := sdk.NewClientWithCredentials(
 		sdk.RunClientWithCredentials{
 			ApiURL:      apiUrl,
 			RunURL:      runUrl,
 			Credentials: credentials,
 			Workspace:   workspace,
+			Headers:     map[string]string{"User-Agent": userAgent},
 		},
 	)
 	if err != nil {
 		logger.Fatalf("Error creating Blaxel client: %v", err)
 	}
-	authProvider := sdk.GetAuthProvider(credentials, workspace, apiUrl)
-
-	headers, err := authProvider.GetHeaders()
-	if err != nil {
-		logger.Fatalf("failed to get headers: %v", err)
-	}
 
 	// Initialize MCP Manager
 	mcpManager := NewMCPManager(headers)
 
+	// Sign the tool calls so MCP servers can verify they come from an agent run of the workspace
+	if signer := signing.NewProvenanceSignerFromEnv(); signer != nil {
+		mcpManager.EnableProvenance(signer, workspace)
+	}
+
 	// Configure MCP servers connected to
-	serverNames := []string{"blaxel-search"}
-	mcpServers := getMCPServersConfig(runUrl, workspace, serverNames)
+	mcpServers, err := MCPServersFromEnv(runUrl, workspace)
+	if err != nil {
+		logger.Fatalf("Error loading MCP servers: %v", err)
+	}
 	for _, serverConfig := range mcpServers {
 		if err := mcpManager.AddServer(serverConfig); err != nil {
 			logger.Warningf("Failed to add MCP server %s: %v", serverConfig.Name, err)
 		}
 	}
 
-	return &Client{
-		BlaxelClient: c,
-		Workspace:    workspace,
-		Model:        model,
-		Debug:        debug == "true",
-		AuthProvider: authProvider,
-		RunUrl:       runUrl,
-		ApiUrl:       apiUrl,
-		McpManager:   mcpManager,
+	if mcpManager.GetServerCount() == 0 {
+		logger.Warningf("No MCP servers available, agents will answer without MCP tools")
+	}
+
+	// Check the servers in the background, reconnecting those that fail
+	if interval := HealthCheckInterval(); interval > 0 && len(mcpServers) > 0 {
+		mcpManager.StartHealthChecks(interval)
+	}
+
+	client := &Client{
+		BlaxelClient:   c,
+		Workspace:      workspace,
+		Model:          model,
+		EmbeddingModel: embeddingModel,
+		Debug:          debug == "true",
+		AuthProvider:   authProvider,
+		RunUrl:         runUrl,
+		ApiUrl:         apiUrl,
+		McpManager:     mcpManager,
+		MCPServers:     mcpServers,
+	}
+
+	// Fetch the tool catalog in the background so gated agent traffic can start as soon as it is ready
+	if WarmupGateEnabled() {
+		go mcpManager.WarmUp(context.Background())
+	}
+
+	// Verify that the configured model exists in the workspace
+	validateCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client.ValidateModel(validateCtx)
+
+	// Enable sandbox tools when configured
+	if SandboxToolsEnabled() {
+		client.Sandbox = NewSandboxManager(client)
+	}
+
+	return client
+}
+
+// ToolsRequired reports whether readiness fails without MCP servers, enabled with BL_TOOLS_REQUIRED=true.
+// Agents otherwise run purely conversationally when no tools are available.
+func ToolsRequired() bool {
+	return os.Getenv("BL_TOOLS_REQUIRED") == "true"
+}
+
+// WarmupGateEnabled reports whether agent traffic is rejected until the tool catalog has been fetched,
+// enabled with BL_WARMUP_GATE=true
+func WarmupGateEnabled() bool {
+	return os.Getenv("BL_WARMUP_GATE") == "true"
+}
+
+// bufferPool reuses the buffers holding chat completion request and response bodies
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// maxPooledBufferSize bounds the buffers returned to the pool so one large response does not pin memory
+const maxPooledBufferSize = 1 << 20
+
+// acquireBuffer returns an empty buffer from the pool
+func acquireBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// releaseBuffer returns a buffer to the pool
+func releaseBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
 	}
+	bufferPool.Put(buf)
 }
 
 // CreateChatCompletion sends a chat completion request
-func (c *Client) CreateChatCompletion(req ChatCompletionRequest) (*ChatCompletionResponse, error) {
+func (c *Client) CreateChatCompletion(ctx context.Context, req ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	return c.CreateEncodedChatCompletion(ctx, NewRequestEncoder(), req)
+}
 
-	jsonData, err := json.Marshal(req)
-	if err != nil {
+// CreateEncodedChatCompletion sends a chat completion request encoded by the encoder of its
+// conversation, so messages sent by earlier requests are not encoded again
+func (c *Client) CreateEncodedChatCompletion(ctx context.Context, encoder *RequestEncoder, req ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	return c.CreateModelChatCompletion(ctx, c.Model, encoder, req)
+}
+
+// CreateModelChatCompletion sends an encoded chat completion request to the given model of the
+// workspace, the configured model being used when it is empty
+func (c *Client) CreateModelChatCompletion(ctx context.Context, model string, encoder *RequestEncoder, req ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	if model == "" {
+		model = c.Model
+	}
+
+	buf := acquireBuffer()
+	defer releaseBuffer(buf)
+
+	if err := encoder.Encode(buf, req); err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	resp, err := c.BlaxelClient.Run(
-		context.Background(),
-		c.Workspace,
-		"model",
-		c.Model,
-		"POST",
-		"/v1/chat/completions",
-		map[string]string{},
-		[]string{},
-		string(jsonData),
-		c.Debug,
-		false,
-	)
+	ctx, span := startChatSpan(ctx, model, false)
+	start := time.Now()
+	chatResp, err := c.sendChatCompletion(ctx, model, buf)
+	metrics.ObserveUpstream(metrics.KindModel, model, time.Since(start), err)
+	endChatSpan(span, chatResp, err)
+	return chatResp, err
+}
+
+// sendChatCompletion posts an encoded chat completion request to the model, reusing buf for the response
+func (c *Client) sendChatCompletion(ctx context.Context, model string, buf *bytes.Buffer) (*ChatCompletionResponse, error) {
+	resp, err := c.postChatCompletion(ctx, model, buf)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create chat completion: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
+	// Reuse the request buffer for the response body, the request has been sent already
+	buf.Reset()
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
+	body := buf.Bytes()
 
 	if resp.StatusCode != http.StatusOK {
-		var errorResp ErrorResponse
-		if err := json.Unmarshal(body, &errorResp); err != nil {
-			return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
-		}
-		return nil, fmt.Errorf("API error: %s", errorResp.Error.Message)
+		return nil, upstreamError(resp.StatusCode, body)
 	}
 
 	var chatResp ChatCompletionResponse
@@ -227,8 +388,43 @@ func (c *Client) CreateChatCompletion(req ChatCompletionRequest) (*ChatCompletio
 	return &chatResp, nil
 }
 
+// postChatCompletion posts an encoded chat completion request to the model, leaving the response to the caller
+func (c *Client) postChatCompletion(ctx context.Context, model string, buf *bytes.Buffer) (*http.Response, error) {
+	resp, err := c.BlaxelClient.Run(
+		ctx,
+		c.Workspace,
+		"model",
+		model,
+		"POST",
+		"/v1/chat/completions",
+		map[string]string{},
+		[]string{},
+		buf.String(),
+		c.Debug,
+		false,
+	)
+	if err != nil {
+		return nil, models.NewUpstreamTransportError(fmt.Errorf("failed to create chat completion: %w", err))
+	}
+	return resp, nil
+}
+
+// upstreamError converts the body of a failed chat completion or embeddings response into an error
+func upstreamError(status int, body []byte) error {
+	var errorResp ErrorResponse
+	if err := json.Unmarshal(body, &errorResp); err != nil {
+		return models.NewUpstreamStatusError(status,
+			fmt.Errorf("API request failed with status %d: %s", status, string(body)))
+	}
+	if isContextLengthError(errorResp.Error.Code, errorResp.Error.Message) {
+		return models.NewUpstreamStatusError(status,
+			fmt.Errorf("API error: %s: %w", errorResp.Error.Message, ErrContextLengthExceeded))
+	}
+	return models.NewUpstreamStatusError(status, fmt.Errorf("API error: %s", errorResp.Error.Message))
+}
+
 // CreateSimpleCompletion is a helper function for simple text completions
-func (c *Client) CreateSimpleCompletion(prompt string) (string, error) {
+func (c *Client) CreateSimpleCompletion(ctx context.Context, prompt string) (string, error) {
 	req := ChatCompletionRequest{
 		Messages: []ChatMessage{
 			{
@@ -238,7 +434,7 @@ func (c *Client) CreateSimpleCompletion(prompt string) (string, error) {
 		},
 	}
 
-	resp, err := c.CreateChatCompletion(req)
+	resp, err := c.CreateChatCompletion(ctx, req)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,95 @@
+package blaxel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"template-custom-agent-go/pkg/metrics"
+	"template-custom-agent-go/pkg/models"
+)
+
+// EmbeddingRequest is the OpenAI embeddings request. Input is a string or an array of strings.
+type EmbeddingRequest struct {
+	Model string      `json:"model,omitempty"`
+	Input interface{} `json:"input" binding:"required"`
+	// EncodingFormat can only be float, as embeddings are decoded as numbers
+	EncodingFormat string `json:"encoding_format,omitempty" binding:"omitempty,oneof=float"`
+	Dimensions     *int   `json:"dimensions,omitempty" binding:"omitempty,min=1"`
+	User           string `json:"user,omitempty"`
+}
+
+// EmbeddingResponse is the OpenAI embeddings response, with one embedding per input in order
+type EmbeddingResponse struct {
+	Object string         `json:"object"`
+	Data   []Embedding    `json:"data"`
+	Model  string         `json:"model"`
+	Usage  EmbeddingUsage `json:"usage"`
+}
+
+// Embedding is the vector of one input
+type Embedding struct {
+	Object    string    `json:"object"`
+	Index     int       `json:"index"`
+	Embedding []float64 `json:"embedding"`
+}
+
+// EmbeddingUsage is the token usage of an embeddings request
+type EmbeddingUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// CreateEmbeddings creates the embeddings of the inputs with the embedding model of the workspace,
+// through its /v1/embeddings route
+func (c *Client) CreateEmbeddings(ctx context.Context, req EmbeddingRequest) (*EmbeddingResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	ctx, span := startEmbeddingsSpan(ctx, c.EmbeddingModel)
+	start := time.Now()
+	embeddingResp, err := c.sendEmbeddings(ctx, body)
+	metrics.ObserveUpstream(metrics.KindModel, c.EmbeddingModel, time.Since(start), err)
+	endEmbeddingsSpan(span, embeddingResp, err)
+	return embeddingResp, err
+}
+
+// sendEmbeddings posts an encoded embeddings request to the embedding model
+func (c *Client) sendEmbeddings(ctx context.Context, body []byte) (*EmbeddingResponse, error) {
+	resp, err := c.BlaxelClient.Run(
+		ctx,
+		c.Workspace,
+		"model",
+		c.EmbeddingModel,
+		"POST",
+		"/v1/embeddings",
+		map[string]string{},
+		[]string{},
+		string(body),
+		c.Debug,
+		false,
+	)
+	if err != nil {
+		return nil, models.NewUpstreamTransportError(fmt.Errorf("failed to create embeddings: %w", err))
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, upstreamError(resp.StatusCode, respBody)
+	}
+
+	var embeddingResp EmbeddingResponse
+	if err := json.Unmarshal(respBody, &embeddingResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return &embeddingResp, nil
+}
@@ -0,0 +1,116 @@
+package blaxel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// RequestEncoder encodes the chat completion requests of one conversation, reusing the encoded
+// bytes of messages and tools sent by earlier requests. Conversations only grow by appending
+// messages, so the messages already encoded are never encoded again. A tool is assumed to keep its
+// definition for a conversation, so tools are only encoded again when their names change.
+type RequestEncoder struct {
+	messages  [][]byte
+	tools     []byte
+	toolNames []string
+	lastSize  int
+}
+
+// NewRequestEncoder creates an encoder for a new conversation
+func NewRequestEncoder() *RequestEncoder {
+	return &RequestEncoder{}
+}
+
+// encodedRequest is a chat completion request whose messages and tools are already encoded.
+// Its fields shadow the ones of the embedded request.
+type encodedRequest struct {
+	ChatCompletionRequest
+	Messages json.RawMessage `json:"messages"`
+	Tools    json.RawMessage `json:"tools,omitempty"`
+}
+
+// Encode writes the request as JSON to buf, encoding only the messages appended since the last call
+func (e *RequestEncoder) Encode(buf *bytes.Buffer, req ChatCompletionRequest) error {
+	if len(req.Messages) < len(e.messages) {
+		// The conversation was rewritten, start over
+		e.messages = nil
+	}
+	for _, message := range req.Messages[len(e.messages):] {
+		encoded, err := json.Marshal(message)
+		if err != nil {
+			return fmt.Errorf("failed to marshal message: %w", err)
+		}
+		e.messages = append(e.messages, encoded)
+	}
+
+	if len(req.Tools) > 0 && (e.tools == nil || !e.sameTools(req.Tools)) {
+		encoded, err := json.Marshal(req.Tools)
+		if err != nil {
+			return fmt.Errorf("failed to marshal tools: %w", err)
+		}
+		e.tools = encoded
+		e.toolNames = e.toolNames[:0]
+		for _, tool := range req.Tools {
+			e.toolNames = append(e.toolNames, tool.Function.Name)
+		}
+	}
+
+	messages := make([]byte, 0, e.messagesSize())
+	messages = append(messages, '[')
+	for i, encoded := range e.messages {
+		if i > 0 {
+			messages = append(messages, ',')
+		}
+		messages = append(messages, encoded...)
+	}
+	messages = append(messages, ']')
+
+	request := encodedRequest{ChatCompletionRequest: req, Messages: messages}
+	if len(req.Tools) > 0 {
+		request.Tools = e.tools
+	}
+	start := buf.Len()
+	if err := json.NewEncoder(buf).Encode(request); err != nil {
+		return err
+	}
+	e.lastSize = buf.Len() - start
+	return nil
+}
+
+// Reset forgets the encoded messages, for conversations whose earlier messages were rewritten in place
+func (e *RequestEncoder) Reset() {
+	e.messages = nil
+}
+
+// LastSize returns the size in bytes of the last encoded request
+func (e *RequestEncoder) LastSize() int {
+	return e.lastSize
+}
+
+// EstimateTokens roughly estimates the number of tokens of an encoded payload, about four bytes per token
+func EstimateTokens(size int) int {
+	return size / 4
+}
+
+// sameTools reports whether the tools have the names of the encoded ones, in the same order
+func (e *RequestEncoder) sameTools(tools []Tool) bool {
+	if len(tools) != len(e.toolNames) {
+		return false
+	}
+	for i, tool := range tools {
+		if tool.Function.Name != e.toolNames[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// messagesSize returns the size of the encoded messages array
+func (e *RequestEncoder) messagesSize() int {
+	size := 2 + len(e.messages)
+	for _, encoded := range e.messages {
+		size += len(encoded)
+	}
+	return size
+}
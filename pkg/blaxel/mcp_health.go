@@ -0,0 +1,260 @@
+package blaxel
+
+import (
+	"context"
+	"os"
+	"sort"
+	"time"
+
+	"template-custom-agent-go/pkg/logger"
+	"template-custom-agent-go/pkg/metrics"
+
+	blaxelMCP "github.com/blaxel-ai/toolkit/sdk/mcp"
+)
+
+// Settings of the MCP server health checks
+const (
+	// defaultHealthCheckInterval is the pace of the checks of healthy servers when BL_MCP_HEALTH_INTERVAL is not set
+	defaultHealthCheckInterval = 30 * time.Second
+	// healthCheckTimeout bounds each check
+	healthCheckTimeout = 10 * time.Second
+	// healthCheckTick is how often the checks that are due are looked for
+	healthCheckTick = time.Second
+	// Reconnection attempts of unhealthy servers start after minReconnectBackoff, doubling up to maxReconnectBackoff
+	minReconnectBackoff = 5 * time.Second
+	maxReconnectBackoff = 5 * time.Minute
+)
+
+// Health statuses of MCP servers
+const (
+	MCPServerHealthy   = "healthy"
+	MCPServerUnhealthy = "unhealthy"
+)
+
+// serverHealth tracks the health of a configured MCP server
+type serverHealth struct {
+	config    MCPServerConfig
+	healthy   bool
+	failures  int
+	lastCheck time.Time
+	lastError string
+	// next is when the server is checked again, or reconnected when it is unhealthy
+	next     time.Time
+	checking bool
+}
+
+// recordSuccess marks the server healthy, checking it again after the interval
+func (h *serverHealth) recordSuccess(now time.Time, interval time.Duration) {
+	h.healthy = true
+	h.failures = 0
+	h.lastCheck = now
+	h.lastError = ""
+	h.next = now.Add(interval)
+}
+
+// recordFailure marks the server unhealthy, trying to reconnect it after an exponential backoff
+func (h *serverHealth) recordFailure(err error, now time.Time) {
+	h.healthy = false
+	h.failures++
+	h.lastCheck = now
+	h.lastError = err.Error()
+	backoff := minReconnectBackoff
+	for i := 1; i < h.failures && backoff < maxReconnectBackoff; i++ {
+		backoff *= 2
+	}
+	h.next = now.Add(min(backoff, maxReconnectBackoff))
+}
+
+// MCPServerHealth is the health of a configured MCP server, as reported by /tools/servers
+type MCPServerHealth struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Connected is false while the server is being reconnected
+	Connected bool       `json:"connected"`
+	LastCheck *time.Time `json:"last_check,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	// ConsecutiveFailures counts the failed checks and reconnections since the server was last healthy
+	ConsecutiveFailures int        `json:"consecutive_failures,omitempty"`
+	NextRetry           *time.Time `json:"next_retry,omitempty"`
+}
+
+// ServerHealth returns the health of the configured servers, sorted by name
+func (m *MCPManager) ServerHealth() []MCPServerHealth {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	statuses := make([]MCPServerHealth, 0, len(m.health))
+	for name, health := range m.health {
+		status := MCPServerHealth{
+			Name:                name,
+			Status:              MCPServerHealthy,
+			Connected:           m.servers[name] != nil,
+			LastError:           health.lastError,
+			ConsecutiveFailures: health.failures,
+		}
+		if !health.lastCheck.IsZero() {
+			lastCheck := health.lastCheck
+			status.LastCheck = &lastCheck
+		}
+		if !health.healthy {
+			status.Status = MCPServerUnhealthy
+			next := health.next
+			status.NextRetry = &next
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// HealthyServerCount returns the number of servers whose last check succeeded
+func (m *MCPManager) HealthyServerCount() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	count := 0
+	for _, health := range m.health {
+		if health.healthy {
+			count++
+		}
+	}
+	return count
+}
+
+// HealthCheckInterval returns the pace of the checks of healthy MCP servers from BL_MCP_HEALTH_INTERVAL,
+// a duration such as 1m, 0 disabling the health checks
+func HealthCheckInterval() time.Duration {
+	if value := os.Getenv("BL_MCP_HEALTH_INTERVAL"); value != "" {
+		if interval, err := time.ParseDuration(value); err == nil && interval >= 0 {
+			return interval
+		}
+		logger.Warningf("Invalid BL_MCP_HEALTH_INTERVAL %q, using %s", value, defaultHealthCheckInterval)
+	}
+	return defaultHealthCheckInterval
+}
+
+// StartHealthChecks checks the servers in the background until Close: connected servers list their
+// tools at each interval, and servers failing that are disconnected and reconnected with backoff
+func (m *MCPManager) StartHealthChecks(interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.mu.Lock()
+	m.stopHealthChecks = cancel
+	now := time.Now()
+	for _, health := range m.health {
+		if health.healthy {
+			health.next = now.Add(interval)
+		}
+	}
+	m.mu.Unlock()
+
+	logger.Infof("Checking the health of MCP servers every %s", interval)
+	go func() {
+		ticker := time.NewTicker(healthCheckTick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				for _, name := range m.dueChecks(now) {
+					go m.checkServer(ctx, name, interval)
+				}
+			}
+		}
+	}()
+}
+
+// dueChecks returns the servers to check or reconnect now, marking them as being checked
+func (m *MCPManager) dueChecks(now time.Time) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var due []string
+	for name, health := range m.health {
+		if !health.checking && !now.Before(health.next) {
+			health.checking = true
+			due = append(due, name)
+		}
+	}
+	return due
+}
+
+// checkServer lists the tools of a connected server, reconnecting it when that fails or when it is
+// not connected
+func (m *MCPManager) checkServer(ctx context.Context, name string, interval time.Duration) {
+	m.mu.RLock()
+	client := m.servers[name]
+	health := m.health[name]
+	config := health.config
+	wasHealthy := health.healthy
+	m.mu.RUnlock()
+
+	var err error
+	if client != nil {
+		if err = listTools(ctx, client); err == nil {
+			m.recordCheck(name, nil, nil, interval)
+			return
+		}
+		logger.Warningf("MCP server %s failed its health check, reconnecting: %v", name, err)
+		m.disconnect(name, client)
+	}
+
+	// Reconnect with a new client rather than reusing one whose session may be gone
+	client, err = m.connect(config)
+	if err == nil {
+		if err = listTools(ctx, client); err != nil {
+			client.Close()
+			client = nil
+		}
+	}
+	if ctx.Err() != nil {
+		if client != nil {
+			client.Close()
+		}
+		return
+	}
+	m.recordCheck(name, client, err, interval)
+	if err == nil {
+		logger.Infof("Reconnected MCP server %s", name)
+	} else if wasHealthy {
+		logger.Warningf("Failed to reconnect MCP server %s: %v", name, err)
+	} else {
+		logger.Debugf("Failed to reconnect MCP server %s: %v", name, err)
+	}
+}
+
+// listTools checks that a server answers within the health check timeout
+func listTools(ctx context.Context, client *blaxelMCP.MCPClient) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	_, err := client.ListTools(ctx)
+	return err
+}
+
+// disconnect removes the client of a server that failed its health check
+func (m *MCPManager) disconnect(name string, client *blaxelMCP.MCPClient) {
+	m.mu.Lock()
+	if m.servers[name] == client {
+		delete(m.servers, name)
+	}
+	m.mu.Unlock()
+	if err := client.Close(); err != nil {
+		logger.Debugf("Failed to close MCP server %s: %v", name, err)
+	}
+}
+
+// recordCheck records the outcome of a check, installing the client of a reconnected server
+func (m *MCPManager) recordCheck(name string, client *blaxelMCP.MCPClient, err error, interval time.Duration) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	health := m.health[name]
+	health.checking = false
+	if err != nil {
+		health.recordFailure(err, now)
+		metrics.SetMCPServerUp(name, false)
+		return
+	}
+	if client != nil {
+		m.servers[name] = client
+	}
+	health.recordSuccess(now, interval)
+	metrics.SetMCPServerUp(name, true)
+}
@@ -5,22 +5,54 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"template-custom-agent-go/pkg/egress"
 	"template-custom-agent-go/pkg/logger"
+	"template-custom-agent-go/pkg/metrics"
+	"template-custom-agent-go/pkg/signing"
 
 	blaxelMCP "github.com/blaxel-ai/toolkit/sdk/mcp"
 	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // MCPServerConfig represents configuration for a single MCP server
 type MCPServerConfig struct {
-	Name string `json:"name"`
-	URL  string `json:"url"`
+	Name string `json:"name" binding:"required,max=64"`
+	URL  string `json:"url" binding:"omitempty,url"`
+}
+
+// UnmarshalJSON accepts a server object or the name of a server as a string
+func (c *MCPServerConfig) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		*c = MCPServerConfig{}
+		return json.Unmarshal(data, &c.Name)
+	}
+	type config MCPServerConfig
+	return json.Unmarshal(data, (*config)(c))
 }
 
 // MCPManager manages multiple MCP servers
 type MCPManager struct {
+	// mu guards servers and health, which the health checks update while requests read them
+	mu sync.RWMutex
+	// servers holds the clients of the connected servers
 	servers map[string]*blaxelMCP.MCPClient
+	// health holds the configuration and health of every configured server, connected or not
+	health  map[string]*serverHealth
 	headers map[string]string
+	warm    atomic.Bool
+	// cache holds recent tool results, nil when tool results are not cached
+	cache *ToolCache
+	// stopHealthChecks stops the health checks, nil until they are started
+	stopHealthChecks context.CancelFunc
+	// provenance signs the tool calls for tenant, nil when they are not signed
+	provenance *signing.Signer
+	tenant     string
 }
 
 // ToolWithServer represents a tool with its associated server
@@ -33,32 +65,110 @@ type ToolWithServer struct {
 func NewMCPManager(headers map[string]string) *MCPManager {
 	return &MCPManager{
 		servers: make(map[string]*blaxelMCP.MCPClient),
+		health:  make(map[string]*serverHealth),
 		headers: headers,
+		cache:   NewToolCacheFromEnv(),
 	}
 }
 
-// AddServer adds a new MCP server to the manager
+// AddServer adds a new MCP server to the manager. A server that cannot be connected is still
+// configured, so the health checks keep trying to connect it.
 func (m *MCPManager) AddServer(config MCPServerConfig) error {
-	client, err := blaxelMCP.NewMCPClient(config.URL, m.headers)
+	client, err := m.connect(config)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	health := &serverHealth{config: config}
+	m.health[config.Name] = health
 	if err != nil {
-		return fmt.Errorf("failed to create MCP client for %s: %w", config.Name, err)
+		health.recordFailure(err, time.Now())
+		metrics.SetMCPServerUp(config.Name, false)
+		return err
 	}
 
 	m.servers[config.Name] = client
+	health.healthy = true
+	metrics.SetMCPServerUp(config.Name, true)
 	logger.Debugf("Added MCP server: %s at %s", config.Name, config.URL)
 	return nil
 }
 
+// connect opens a client to an MCP server with the headers of the manager
+func (m *MCPManager) connect(config MCPServerConfig) (*blaxelMCP.MCPClient, error) {
+	// WebSocket connections bypass the HTTP transport, so the egress policy is checked up front
+	if err := egress.Check(config.URL); err != nil {
+		return nil, fmt.Errorf("MCP server %s not allowed: %w", config.Name, err)
+	}
+
+	// The WebSocket transport ignores proxies and sends headers only once per connection, so proxied
+	// servers and servers receiving provenance tokens are reached over HTTP streaming
+	transport := blaxelMCP.TransportTypeAuto
+	if proxy, err := egress.ProxyFor(config.URL); (err == nil && proxy != nil) || m.provenance != nil {
+		transport = blaxelMCP.TransportTypeHTTPStream
+	}
+
+	client, err := blaxelMCP.NewMCPClientWithTransport(config.URL, m.headers, transport)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MCP client for %s: %w", config.Name, err)
+	}
+	return client, nil
+}
+
+// AdHocServer is an MCP server connected for a single request, outside the servers of the manager
+type AdHocServer struct {
+	Name   string
+	client *blaxelMCP.MCPClient
+}
+
+// ConnectAdHoc connects to an MCP server for a single request. The caller closes it once done.
+func (m *MCPManager) ConnectAdHoc(config MCPServerConfig) (*AdHocServer, error) {
+	client, err := m.connect(config)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("Connected ad-hoc MCP server: %s at %s", config.Name, config.URL)
+	return &AdHocServer{Name: config.Name, client: client}, nil
+}
+
+// ListTools lists the tools of the server
+func (s *AdHocServer) ListTools(ctx context.Context) ([]*mcp.Tool, error) {
+	tools, err := s.client.ListTools(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tools of MCP server %s: %w", s.Name, err)
+	}
+	return tools.Tools, nil
+}
+
+// CallTool calls a tool of the server
+func (s *AdHocServer) CallTool(ctx context.Context, toolName string, params interface{}) (*mcp.CallToolResult, error) {
+	start := time.Now()
+	result, err := s.client.CallTool(ctx, toolName, params)
+	metrics.ObserveUpstream(metrics.KindMCP, s.Name, time.Since(start), err)
+	return result, err
+}
+
+// Close closes the connection to the server
+func (s *AdHocServer) Close() error {
+	return s.client.Close()
+}
+
 // ListAllTools aggregates tools from all connected MCP servers
 func (m *MCPManager) ListAllTools(ctx context.Context) ([]ToolWithServer, error) {
 	var allTools []ToolWithServer
 
-	for serverName, client := range m.servers {
+	// Without servers the catalog is complete, and empty, right away
+	clients := m.clients()
+	if len(clients) == 0 {
+		m.warm.Store(true)
+	}
+
+	for serverName, client := range clients {
 		tools, err := client.ListTools(ctx)
 		if err != nil {
 			logger.Warningf("Failed to get tools from server %s: %v", serverName, err)
 			continue
 		}
+		m.warm.Store(true)
 
 		for _, tool := range tools.Tools {
 			allTools = append(allTools, ToolWithServer{
@@ -71,18 +181,80 @@ func (m *MCPManager) ListAllTools(ctx context.Context) ([]ToolWithServer, error)
 	return allTools, nil
 }
 
-// CallTool routes a tool call to the appropriate MCP server
+// IsWarm reports whether the tool catalog has been fetched successfully at least once
+func (m *MCPManager) IsWarm() bool {
+	return m.warm.Load()
+}
+
+// WarmUp fetches the tool catalog until it succeeds or the context is done
+func (m *MCPManager) WarmUp(ctx context.Context) {
+	backoff := time.Second
+	for {
+		m.ListAllTools(ctx)
+		if m.IsWarm() {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+	logger.Infof("MCP tool catalog warmed up")
+}
+
+// CallTool routes a tool call to the appropriate MCP server, answering from the tool cache when
+// the same call succeeded recently
 func (m *MCPManager) CallTool(ctx context.Context, serverName, toolName string, params interface{}) (*mcp.CallToolResult, error) {
+	m.mu.RLock()
 	client, exists := m.servers[serverName]
+	_, configured := m.health[serverName]
+	m.mu.RUnlock()
 	if !exists {
+		if configured {
+			return nil, fmt.Errorf("MCP server %s is unavailable, reconnecting", serverName)
+		}
 		return nil, fmt.Errorf("MCP server %s not found", serverName)
 	}
 
-	return client.CallTool(ctx, toolName, params)
+	ctx, span := startToolSpan(ctx, serverName, toolName)
+	if m.cache != nil {
+		result, cached := m.cache.Get(serverName, toolName, params)
+		span.SetAttributes(attribute.Bool("mcp.tool.cache_hit", cached))
+		if cached {
+			endToolSpan(span, result, nil)
+			return result, nil
+		}
+	}
+
+	start := time.Now()
+	result, err := client.CallTool(m.withProvenance(ctx, serverName, toolName, params), toolName, params)
+	metrics.ObserveUpstream(metrics.KindMCP, serverName, time.Since(start), err)
+	endToolSpan(span, result, err)
+	if err == nil && m.cache != nil {
+		m.cache.Put(serverName, toolName, params, result)
+	}
+	return result, err
+}
+
+// clients returns the clients of the connected servers, so they are used without holding the lock
+func (m *MCPManager) clients() map[string]*blaxelMCP.MCPClient {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	clients := make(map[string]*blaxelMCP.MCPClient, len(m.servers))
+	for name, client := range m.servers {
+		clients[name] = client
+	}
+	return clients
 }
 
 // GetServerNames returns a list of all connected server names
 func (m *MCPManager) GetServerNames() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	var names []string
 	for name := range m.servers {
 		names = append(names, name)
@@ -90,13 +262,37 @@ func (m *MCPManager) GetServerNames() []string {
 	return names
 }
 
+// HasServer reports whether a server of the given name is configured, connected or not
+func (m *MCPManager) HasServer(name string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, exists := m.health[name]
+	return exists
+}
+
+// IsConnected reports whether a server of the given name is connected
+func (m *MCPManager) IsConnected(name string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, exists := m.servers[name]
+	return exists
+}
+
 // GetServerCount returns the number of connected servers
 func (m *MCPManager) GetServerCount() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	return len(m.servers)
 }
 
-// Close closes all MCP server connections
+// Close stops the health checks and closes all MCP server connections
 func (m *MCPManager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopHealthChecks != nil {
+		m.stopHealthChecks()
+	}
+
 	var lastErr error
 	for name, client := range m.servers {
 		if err := client.Close(); err != nil {
@@ -107,20 +303,79 @@ func (m *MCPManager) Close() error {
 	return lastErr
 }
 
-// getMCPServersConfig returns MCP server configurations
-// Can be extended to read from config file or environment variables
-func getMCPServersConfig(runUrl, workspace string, serverNames []string) []MCPServerConfig {
-	// Default configuration - can be extended to read from config file
-	servers := []MCPServerConfig{}
+// defaultMCPServers are the workspace functions connected when no MCP server is configured
+var defaultMCPServers = []string{"blaxel-search"}
 
-	for _, serverName := range serverNames {
-		servers = append(servers, MCPServerConfig{
-			Name: serverName,
-			URL:  fmt.Sprintf("%s/%s/functions/%s", runUrl, workspace, serverName),
-		})
+// MCPServersFromEnv returns the MCP servers to connect to: those of the BL_MCP_CONFIG file, then those
+// of BL_MCP_SERVERS, given as comma-separated workspace function names or as a JSON array of names and
+// {"name", "url"} objects. Servers without a URL are functions of the workspace, entries without a name
+// are skipped and later entries replace earlier ones of the same name. blaxel-search is used when
+// neither variable is set, and setting BL_MCP_SERVERS to an empty value disables MCP servers.
+func MCPServersFromEnv(runUrl, workspace string) ([]MCPServerConfig, error) {
+	var configs []MCPServerConfig
+	configPath := os.Getenv("BL_MCP_CONFIG")
+	if configPath != "" {
+		fromFile, err := LoadMCPServersFromConfig(configPath)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, fromFile...)
 	}
 
-	return servers
+	value, set := os.LookupEnv("BL_MCP_SERVERS")
+	if set {
+		fromEnv, err := parseMCPServers(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BL_MCP_SERVERS: %w", err)
+		}
+		configs = append(configs, fromEnv...)
+	} else if configPath == "" {
+		for _, name := range defaultMCPServers {
+			configs = append(configs, MCPServerConfig{Name: name})
+		}
+	}
+
+	servers := make([]MCPServerConfig, 0, len(configs))
+	indexes := make(map[string]int, len(configs))
+	for _, config := range configs {
+		config.Name = strings.TrimSpace(config.Name)
+		config.URL = strings.TrimSpace(config.URL)
+		if config.Name == "" {
+			logger.Warningf("Skipping MCP server without a name (url %q)", config.URL)
+			continue
+		}
+		if config.URL == "" {
+			config.URL = fmt.Sprintf("%s/%s/functions/%s", runUrl, workspace, config.Name)
+		}
+		if index, exists := indexes[config.Name]; exists {
+			servers[index] = config
+			continue
+		}
+		indexes[config.Name] = len(servers)
+		servers = append(servers, config)
+	}
+	return servers, nil
+}
+
+// parseMCPServers parses comma-separated server names, empty ones being ignored, or a JSON array of
+// names and server objects
+func parseMCPServers(value string) ([]MCPServerConfig, error) {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "[") {
+		var servers []MCPServerConfig
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				servers = append(servers, MCPServerConfig{Name: name})
+			}
+		}
+		return servers, nil
+	}
+
+	var servers []MCPServerConfig
+	if err := json.Unmarshal([]byte(value), &servers); err != nil {
+		return nil, fmt.Errorf("entries must be names or {\"name\", \"url\"} objects: %w", err)
+	}
+	return servers, nil
 }
 
 // LoadMCPServersFromConfig loads MCP server configurations from a config file
@@ -0,0 +1,93 @@
+package blaxel
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"template-custom-agent-go/pkg/logger"
+)
+
+// ModelInfo summarizes a model deployed in the workspace
+type ModelInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name,omitempty"`
+	Status      string `json:"status,omitempty"`
+}
+
+// modelValidation holds the outcome of the startup model check
+type modelValidation struct {
+	mu  sync.RWMutex
+	err error
+}
+
+// ListModels returns the models deployed in the workspace
+func (c *Client) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	resp, err := c.BlaxelClient.ListModelsWithResponse(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list models: %w", err)
+	}
+	if resp.JSON200 == nil {
+		return nil, fmt.Errorf("failed to list models: status %d: %s", resp.StatusCode(), string(resp.Body))
+	}
+
+	models := []ModelInfo{}
+	for _, model := range *resp.JSON200 {
+		if model.Metadata == nil || model.Metadata.Name == nil {
+			continue
+		}
+		info := ModelInfo{Name: *model.Metadata.Name}
+		if model.Metadata.DisplayName != nil {
+			info.DisplayName = *model.Metadata.DisplayName
+		}
+		if model.Status != nil {
+			info.Status = *model.Status
+		}
+		models = append(models, info)
+	}
+
+	sort.Slice(models, func(i, j int) bool { return models[i].Name < models[j].Name })
+	return models, nil
+}
+
+// ValidateModel checks that the configured model exists in the workspace.
+// Failures to reach the API are logged but not recorded, so only a wrong model name fails readiness.
+func (c *Client) ValidateModel(ctx context.Context) error {
+	models, err := c.ListModels(ctx)
+	if err != nil {
+		logger.Warningf("Could not validate model %s: %v", c.Model, err)
+		return nil
+	}
+
+	var validationErr error
+	names := make([]string, 0, len(models))
+	found := false
+	for _, model := range models {
+		names = append(names, model.Name)
+		if model.Name == c.Model {
+			found = true
+		}
+	}
+	if !found {
+		validationErr = fmt.Errorf("model %q not found in workspace %s, available models: %s",
+			c.Model, c.Workspace, strings.Join(names, ", "))
+		logger.Errorf("Invalid BL_MODEL: %v", validationErr)
+	} else {
+		logger.Debugf("Model %s found in workspace %s", c.Model, c.Workspace)
+	}
+
+	c.modelValidation.mu.Lock()
+	c.modelValidation.err = validationErr
+	c.modelValidation.mu.Unlock()
+
+	return validationErr
+}
+
+// ModelValidationError returns the error recorded by the last model validation, if any
+func (c *Client) ModelValidationError() error {
+	c.modelValidation.mu.RLock()
+	defer c.modelValidation.mu.RUnlock()
+	return c.modelValidation.err
+}
@@ -0,0 +1,75 @@
+package blaxel
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"template-custom-agent-go/pkg/logger"
+	"template-custom-agent-go/pkg/signing"
+)
+
+// runIDKey is the context key of the ID of the agent run
+type runIDKey struct{}
+
+// provenanceKey is the context key of the provenance token of a tool call
+type provenanceKey struct{}
+
+// WithRunID returns a context carrying the ID of the agent run, used in the provenance of its tool calls
+func WithRunID(ctx context.Context, runID string) context.Context {
+	return context.WithValue(ctx, runIDKey{}, runID)
+}
+
+// RunIDFromContext returns the ID of the agent run of the context, empty outside runs
+func RunIDFromContext(ctx context.Context) string {
+	runID, _ := ctx.Value(runIDKey{}).(string)
+	return runID
+}
+
+// EnableProvenance signs the tool calls of the manager, the tenant being the workspace. The requests
+// of MCP clients created afterwards go through a transport adding the tokens, so it must be called
+// before servers are added.
+func (m *MCPManager) EnableProvenance(signer *signing.Signer, tenant string) {
+	m.provenance = signer
+	m.tenant = tenant
+	http.DefaultTransport = &provenanceTransport{base: http.DefaultTransport}
+	logger.Infof("Signing the provenance of MCP tool calls for tenant %s", tenant)
+}
+
+// withProvenance returns a context carrying the provenance token of a tool call, which is left
+// unsigned when provenance is disabled or the token cannot be created
+func (m *MCPManager) withProvenance(ctx context.Context, serverName, toolName string, params interface{}) context.Context {
+	if m.provenance == nil {
+		return ctx
+	}
+	hash, err := signing.HashArguments(params)
+	if err == nil {
+		var token string
+		token, err = m.provenance.SignProvenance(signing.Provenance{
+			RunID:         RunIDFromContext(ctx),
+			Tenant:        m.tenant,
+			Server:        serverName,
+			Tool:          toolName,
+			ArgumentsHash: hash,
+			IssuedAt:      time.Now().Unix(),
+		})
+		if err == nil {
+			return context.WithValue(ctx, provenanceKey{}, token)
+		}
+	}
+	logger.WarningfCtx(ctx, "Failed to sign the provenance of tool %s: %v", toolName, err)
+	return ctx
+}
+
+// provenanceTransport adds the provenance token of a tool call to the requests sent for it
+type provenanceTransport struct {
+	base http.RoundTripper
+}
+
+func (t *provenanceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if token, ok := req.Context().Value(provenanceKey{}).(string); ok {
+		req = req.Clone(req.Context())
+		req.Header.Set(signing.ProvenanceHeader, token)
+	}
+	return t.base.RoundTrip(req)
+}
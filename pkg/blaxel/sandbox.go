@@ -0,0 +1,265 @@
+package blaxel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"template-custom-agent-go/pkg/logger"
+
+	"github.com/blaxel-ai/toolkit/sdk"
+)
+
+// sandboxReadyTimeout bounds how long to wait for a new sandbox to be deployed
+const sandboxReadyTimeout = 2 * time.Minute
+
+// SandboxManager creates and drives the Blaxel sandbox used by the sandbox tools
+type SandboxManager struct {
+	client *Client
+	name   string
+	image  string
+	memory int
+
+	mu    sync.Mutex
+	ready bool
+	// setup is the creation of the sandbox in flight, shared by the callers waiting for it
+	setup *sandboxSetup
+}
+
+// sandboxSetup is a creation of the sandbox, done being closed once err is set
+type sandboxSetup struct {
+	done chan struct{}
+	err  error
+}
+
+// SandboxToolsEnabled reports whether sandbox tools are enabled through BL_SANDBOX_TOOLS
+func SandboxToolsEnabled() bool {
+	return os.Getenv("BL_SANDBOX_TOOLS") == "true"
+}
+
+// NewSandboxManager creates a sandbox manager configured from environment variables
+func NewSandboxManager(client *Client) *SandboxManager {
+	name := os.Getenv("BL_SANDBOX_NAME")
+	if name == "" {
+		name = "template-custom-agent-go-sandbox"
+		if self := os.Getenv("BL_NAME"); self != "" {
+			name = self + "-sandbox"
+		}
+	}
+	image := os.Getenv("BL_SANDBOX_IMAGE")
+	if image == "" {
+		image = "blaxel/prod-base:latest"
+	}
+	memory := 4096
+	if value, err := strconv.Atoi(os.Getenv("BL_SANDBOX_MEMORY")); err == nil && value > 0 {
+		memory = value
+	}
+
+	return &SandboxManager{
+		client: client,
+		name:   name,
+		image:  image,
+		memory: memory,
+	}
+}
+
+// Name returns the name of the managed sandbox
+func (s *SandboxManager) Name() string {
+	return s.name
+}
+
+// EnsureSandbox creates the sandbox if needed and waits until it is deployed. The creation runs once
+// for all callers, each waiting for it until its own context is done.
+func (s *SandboxManager) EnsureSandbox(ctx context.Context) error {
+	s.mu.Lock()
+	if s.ready {
+		s.mu.Unlock()
+		return nil
+	}
+	setup := s.setup
+	if setup == nil {
+		setup = &sandboxSetup{done: make(chan struct{})}
+		s.setup = setup
+		go s.runSetup(context.WithoutCancel(ctx), setup)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-setup.done:
+		return setup.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runSetup creates the sandbox and reports the outcome to the callers waiting for setup
+func (s *SandboxManager) runSetup(ctx context.Context, setup *sandboxSetup) {
+	ctx, cancel := context.WithTimeout(ctx, sandboxReadyTimeout)
+	defer cancel()
+	err := s.createSandbox(ctx)
+
+	s.mu.Lock()
+	s.ready = err == nil
+	s.setup = nil
+	s.mu.Unlock()
+	setup.err = err
+	close(setup.done)
+}
+
+// createSandbox creates the sandbox if it does not exist and waits until it is deployed
+func (s *SandboxManager) createSandbox(ctx context.Context) error {
+	resp, err := s.client.BlaxelClient.GetSandboxWithResponse(ctx, s.name, &sdk.GetSandboxParams{})
+	if err != nil {
+		return fmt.Errorf("failed to get sandbox %s: %w", s.name, err)
+	}
+
+	if resp.StatusCode() == http.StatusNotFound {
+		logger.Infof("Creating sandbox %s from image %s", s.name, s.image)
+		body := sdk.CreateSandboxJSONRequestBody{
+			Metadata: &sdk.Metadata{Name: &s.name},
+			Spec: &sdk.SandboxSpec{
+				Runtime: &sdk.Runtime{
+					Image:  &s.image,
+					Memory: &s.memory,
+				},
+			},
+		}
+		created, err := s.client.BlaxelClient.CreateSandboxWithResponse(ctx, body)
+		if err != nil {
+			return fmt.Errorf("failed to create sandbox %s: %w", s.name, err)
+		}
+		if created.JSON200 == nil {
+			return fmt.Errorf("failed to create sandbox %s: status %d: %s", s.name, created.StatusCode(), string(created.Body))
+		}
+	} else if resp.JSON200 == nil {
+		return fmt.Errorf("failed to get sandbox %s: status %d: %s", s.name, resp.StatusCode(), string(resp.Body))
+	}
+
+	// Wait for the sandbox to be deployed
+	for {
+		resp, err := s.client.BlaxelClient.GetSandboxWithResponse(ctx, s.name, &sdk.GetSandboxParams{})
+		if err != nil && ctx.Err() == nil {
+			return fmt.Errorf("failed to get sandbox %s: %w", s.name, err)
+		}
+		if err == nil && resp.JSON200 != nil && resp.JSON200.Status != nil {
+			switch *resp.JSON200.Status {
+			case "DEPLOYED":
+				return nil
+			case "FAILED":
+				return fmt.Errorf("sandbox %s failed to deploy", s.name)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("sandbox %s was not ready after %s", s.name, sandboxReadyTimeout)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// resetSandbox makes the next call check the sandbox again, as it may have been deleted since it
+// was deployed
+func (s *SandboxManager) resetSandbox() {
+	s.mu.Lock()
+	s.ready = false
+	s.mu.Unlock()
+}
+
+// Exec runs a shell command in the sandbox and waits for it to complete
+func (s *SandboxManager) Exec(ctx context.Context, command, workingDir string) (string, error) {
+	payload := map[string]interface{}{
+		"command":           command,
+		"waitForCompletion": true,
+	}
+	if workingDir != "" {
+		payload["workingDir"] = workingDir
+	}
+	return s.call(ctx, "POST", "/process", payload)
+}
+
+// ReadFile returns the content of a file in the sandbox
+func (s *SandboxManager) ReadFile(ctx context.Context, path string) (string, error) {
+	return s.call(ctx, "GET", "/filesystem/"+strings.TrimPrefix(path, "/"), nil)
+}
+
+// WriteFile creates or replaces a file in the sandbox
+func (s *SandboxManager) WriteFile(ctx context.Context, path, content string) (string, error) {
+	return s.call(ctx, "PUT", "/filesystem/"+strings.TrimPrefix(path, "/"), map[string]interface{}{
+		"content": content,
+	})
+}
+
+// DeleteFile removes a file from the sandbox
+func (s *SandboxManager) DeleteFile(ctx context.Context, path string) error {
+	_, err := s.call(ctx, "DELETE", "/filesystem/"+strings.TrimPrefix(path, "/"), nil)
+	return err
+}
+
+// SandboxRequestError is a request rejected by the sandbox API, such as reading a missing file
+type SandboxRequestError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Body       string
+}
+
+// Error describes the rejected request with the response of the sandbox
+func (e *SandboxRequestError) Error() string {
+	return fmt.Sprintf("sandbox request %s %s failed with status %d: %s", e.Method, e.Path, e.StatusCode, e.Body)
+}
+
+// call sends a request to the sandbox API, creating the sandbox first if needed
+func (s *SandboxManager) call(ctx context.Context, method, path string, payload interface{}) (string, error) {
+	if err := s.EnsureSandbox(ctx); err != nil {
+		return "", err
+	}
+
+	body := ""
+	if payload != nil {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = string(jsonData)
+	}
+
+	resp, err := s.client.BlaxelClient.Run(
+		ctx,
+		s.client.Workspace,
+		"sandbox",
+		s.name,
+		method,
+		path,
+		map[string]string{},
+		[]string{},
+		body,
+		s.client.Debug,
+		false,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to call sandbox %s: %w", s.name, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		s.resetSandbox()
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return "", &SandboxRequestError{Method: method, Path: path, StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+
+	return string(respBody), nil
+}
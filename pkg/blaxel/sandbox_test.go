@@ -0,0 +1,121 @@
+package blaxel
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blaxel-ai/toolkit/sdk"
+)
+
+// testSandboxAPI fakes the sandbox endpoints of the Blaxel API, the sandbox being deployed once
+// deployed is closed. Requests run in the sandbox answer 404, as for a deleted sandbox.
+type testSandboxAPI struct {
+	deployed chan struct{}
+	gets     atomic.Int32
+	creates  atomic.Int32
+}
+
+// ServeHTTP answers the sandbox requests
+func (api *testSandboxAPI) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, "/sandboxes/"):
+		api.gets.Add(1)
+		if api.creates.Load() == 0 {
+			http.Error(w, `{"error": "not found"}`, http.StatusNotFound)
+			return
+		}
+		select {
+		case <-api.deployed:
+			w.Write([]byte(`{"status": "DEPLOYED"}`))
+		default:
+			w.Write([]byte(`{"status": "DEPLOYING"}`))
+		}
+	case req.Method == http.MethodPost && req.URL.Path == "/sandboxes":
+		api.creates.Add(1)
+		w.Write([]byte(`{"status": "DEPLOYING"}`))
+	default:
+		http.Error(w, `{"error": "not found"}`, http.StatusNotFound)
+	}
+}
+
+// newTestSandboxManager creates a sandbox manager whose API and run requests go to api
+func newTestSandboxManager(t *testing.T, api http.Handler) *SandboxManager {
+	t.Helper()
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	client, err := sdk.NewClientWithResponses(server.URL, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &SandboxManager{client: &Client{BlaxelClient: client, Workspace: "test", RunUrl: server.URL}, name: "test-sandbox"}
+}
+
+// TestEnsureSandboxWaitsWithCallerContext checks a caller leaves as soon as its context is done while
+// the sandbox is created, without stopping the creation the other callers wait for
+func TestEnsureSandboxWaitsWithCallerContext(t *testing.T) {
+	api := &testSandboxAPI{deployed: make(chan struct{})}
+	sandbox := newTestSandboxManager(t, api)
+
+	waiting := make(chan error, 1)
+	go func() { waiting <- sandbox.EnsureSandbox(context.Background()) }()
+	for api.creates.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	leaving := make(chan error, 1)
+	go func() { leaving <- sandbox.EnsureSandbox(ctx) }()
+	select {
+	case err := <-leaving:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("EnsureSandbox() = %v, want the deadline of the caller", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("caller still waiting after its deadline")
+	}
+
+	close(api.deployed)
+	select {
+	case err := <-waiting:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sandbox not ready after it was deployed")
+	}
+	if creates := api.creates.Load(); creates != 1 {
+		t.Errorf("sandbox created %d times, want 1", creates)
+	}
+}
+
+// TestSandboxCheckedAgainAfterNotFound checks a request answered 404 makes the next call check the
+// sandbox again instead of trusting it still exists
+func TestSandboxCheckedAgainAfterNotFound(t *testing.T) {
+	api := &testSandboxAPI{deployed: make(chan struct{})}
+	close(api.deployed)
+	sandbox := newTestSandboxManager(t, api)
+
+	if err := sandbox.EnsureSandbox(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	gets := api.gets.Load()
+
+	var requestErr *SandboxRequestError
+	if _, err := sandbox.ReadFile(context.Background(), "/missing"); !errors.As(err, &requestErr) {
+		t.Fatalf("ReadFile() = %v, want a request error", err)
+	}
+	if err := sandbox.EnsureSandbox(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if api.gets.Load() == gets {
+		t.Errorf("sandbox not checked again after a 404")
+	}
+}
@@ -0,0 +1,208 @@
+package blaxel
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"template-custom-agent-go/pkg/metrics"
+)
+
+// maxStreamLineSize bounds the size of one server-sent event line of a streamed completion
+const maxStreamLineSize = 1 << 20
+
+// StreamOptions asks the provider for extra chunks in streamed completions
+type StreamOptions struct {
+	// IncludeUsage adds a last chunk carrying the token usage of the request
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// ChatCompletionChunk is one event of a streamed chat completion
+type ChatCompletionChunk struct {
+	ID      string        `json:"id"`
+	Object  string        `json:"object"`
+	Created int64         `json:"created"`
+	Model   string        `json:"model"`
+	Choices []ChunkChoice `json:"choices"`
+	Usage   *UsageInfo    `json:"usage,omitempty"`
+	// Error is set by providers reporting a failure after the stream has started
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// ChunkChoice is the part of a streamed choice carried by one chunk
+type ChunkChoice struct {
+	Index        int        `json:"index"`
+	Delta        ChunkDelta `json:"delta"`
+	FinishReason *string    `json:"finish_reason"`
+}
+
+// ChunkDelta is the content added to the message by one chunk
+type ChunkDelta struct {
+	Role      string          `json:"role,omitempty"`
+	Content   string          `json:"content,omitempty"`
+	ToolCalls []ToolCallDelta `json:"tool_calls,omitempty"`
+}
+
+// ToolCallDelta is a fragment of a tool call, the arguments arriving over several chunks
+type ToolCallDelta struct {
+	Index    int              `json:"index"`
+	Id       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"`
+	Function ToolCallFunction `json:"function"`
+}
+
+// ChunkHandler receives each chunk of a streamed completion with the data it was decoded from, which
+// is only valid during the call. An error stops reading the stream and is returned to the caller.
+type ChunkHandler func(chunk *ChatCompletionChunk, data []byte) error
+
+// CreateChatCompletionStream sends a chat completion request with stream=true to the given model of
+// the workspace, calling onDelta with each fragment of content as it arrives. It returns the
+// completion assembled from the chunks, tool calls included, once the stream ends.
+func (c *Client) CreateChatCompletionStream(ctx context.Context, model string, encoder *RequestEncoder, req ChatCompletionRequest, onDelta func(content string)) (*ChatCompletionResponse, error) {
+	return c.StreamChatCompletion(ctx, model, encoder, req, func(chunk *ChatCompletionChunk, data []byte) error {
+		for _, choice := range chunk.Choices {
+			if choice.Index == 0 && choice.Delta.Content != "" && onDelta != nil {
+				onDelta(choice.Delta.Content)
+			}
+		}
+		return nil
+	})
+}
+
+// StreamChatCompletion sends a chat completion request with stream=true to the given model of the
+// workspace, passing each chunk to onChunk as it arrives, and returns the completion of the first
+// choice assembled from the chunks. Usage is always requested from the provider.
+func (c *Client) StreamChatCompletion(ctx context.Context, model string, encoder *RequestEncoder, req ChatCompletionRequest, onChunk ChunkHandler) (*ChatCompletionResponse, error) {
+	if model == "" {
+		model = c.Model
+	}
+	req.Stream = true
+	req.StreamOptions = &StreamOptions{IncludeUsage: true}
+
+	buf := acquireBuffer()
+	defer releaseBuffer(buf)
+
+	if err := encoder.Encode(buf, req); err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	ctx, span := startChatSpan(ctx, model, true)
+	start := time.Now()
+	chatResp, err := c.streamChatCompletion(ctx, model, buf, onChunk)
+	metrics.ObserveUpstream(metrics.KindModel, model, time.Since(start), err)
+	endChatSpan(span, chatResp, err)
+	return chatResp, err
+}
+
+// streamChatCompletion posts an encoded streamed request and reads its chunks
+func (c *Client) streamChatCompletion(ctx context.Context, model string, buf *bytes.Buffer, onChunk ChunkHandler) (*ChatCompletionResponse, error) {
+	resp, err := c.postChatCompletion(ctx, model, buf)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response body: %w", err)
+		}
+		return nil, upstreamError(resp.StatusCode, body)
+	}
+
+	return readChatCompletionStream(resp.Body, onChunk)
+}
+
+// readChatCompletionStream reads the server-sent events of a streamed completion until [DONE] or
+// the end of the body, assembling the message of the first choice
+func readChatCompletionStream(body io.Reader, onChunk ChunkHandler) (*ChatCompletionResponse, error) {
+	var (
+		chatResp     = &ChatCompletionResponse{Object: "chat.completion"}
+		message      = ChatMessage{Role: "assistant"}
+		content      bytes.Buffer
+		toolCalls    = map[int]*ToolCall{}
+		finishReason string
+	)
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLineSize)
+	for scanner.Scan() {
+		data, found := bytes.CutPrefix(scanner.Bytes(), []byte("data:"))
+		if !found {
+			// Event names, comments and blank separator lines
+			continue
+		}
+		data = bytes.TrimSpace(data)
+		if string(data) == "[DONE]" {
+			break
+		}
+
+		var chunk ChatCompletionChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal stream chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return nil, fmt.Errorf("API error during stream: %s", chunk.Error.Message)
+		}
+		if chatResp.ID == "" {
+			chatResp.ID, chatResp.Created, chatResp.Model = chunk.ID, chunk.Created, chunk.Model
+		}
+		if chunk.Usage != nil {
+			chatResp.Usage = *chunk.Usage
+		}
+		if onChunk != nil {
+			if err := onChunk(&chunk, data); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Index != 0 {
+				continue
+			}
+			content.WriteString(choice.Delta.Content)
+			for _, delta := range choice.Delta.ToolCalls {
+				toolCall, exists := toolCalls[delta.Index]
+				if !exists {
+					toolCall = &ToolCall{Type: "function"}
+					toolCalls[delta.Index] = toolCall
+				}
+				if delta.Id != "" {
+					toolCall.Id = delta.Id
+				}
+				if delta.Type != "" {
+					toolCall.Type = delta.Type
+				}
+				toolCall.Function.Name += delta.Function.Name
+				toolCall.Function.Arguments += delta.Function.Arguments
+			}
+			if choice.FinishReason != nil {
+				finishReason = *choice.FinishReason
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	message.Content = content.String()
+	indexes := make([]int, 0, len(toolCalls))
+	for index := range toolCalls {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		message.ToolCalls = append(message.ToolCalls, *toolCalls[index])
+	}
+
+	chatResp.Choices = []Choice{{Message: message, FinishReason: finishReason}}
+	return chatResp, nil
+}
@@ -0,0 +1,158 @@
+package blaxel
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"template-custom-agent-go/pkg/logger"
+	"template-custom-agent-go/pkg/metrics"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// defaultToolCacheSize bounds the number of cached tool results when BL_TOOL_CACHE_SIZE is not set
+const defaultToolCacheSize = 1000
+
+// Results of tool cache lookups, as counted in metrics
+const (
+	ToolCacheHit  = "hit"
+	ToolCacheMiss = "miss"
+)
+
+// toolCacheEntry is a cached tool result and when it expires
+type toolCacheEntry struct {
+	result  *mcp.CallToolResult
+	expires time.Time
+}
+
+// ToolCache keeps the results of MCP tool calls for a while, keyed by server, tool and arguments,
+// so identical calls such as the same search query do not reach the MCP server again. Only
+// successful results are cached, and cached results must not be modified.
+type ToolCache struct {
+	ttl  time.Duration
+	size int
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]toolCacheEntry
+}
+
+// NewToolCacheFromEnv creates a tool cache keeping results for BL_TOOL_CACHE_TTL, a duration such
+// as 5m, up to BL_TOOL_CACHE_SIZE results. It returns nil, disabling the cache, when the TTL is not
+// set or zero, since tools with side effects must not be skipped.
+func NewToolCacheFromEnv() *ToolCache {
+	value := os.Getenv("BL_TOOL_CACHE_TTL")
+	if value == "" {
+		return nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		logger.Warningf("Invalid BL_TOOL_CACHE_TTL %q, tool results are not cached", value)
+		return nil
+	}
+	if ttl == 0 {
+		return nil
+	}
+
+	size := defaultToolCacheSize
+	if value := os.Getenv("BL_TOOL_CACHE_SIZE"); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 {
+			size = parsed
+		} else {
+			logger.Warningf("Invalid BL_TOOL_CACHE_SIZE %q, using %d", value, defaultToolCacheSize)
+		}
+	}
+
+	logger.Infof("Caching tool results for %s, up to %d results", ttl, size)
+	return NewToolCache(ttl, size)
+}
+
+// NewToolCache creates a tool cache keeping up to size results for ttl
+func NewToolCache(ttl time.Duration, size int) *ToolCache {
+	return &ToolCache{
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[[sha256.Size]byte]toolCacheEntry),
+	}
+}
+
+// toolCacheKey identifies a call by server, tool and arguments. Arguments are marshaled with
+// sorted object keys, so the same arguments in another order share the key.
+func toolCacheKey(serverName, toolName string, params interface{}) ([sha256.Size]byte, bool) {
+	arguments, err := json.Marshal(params)
+	if err != nil {
+		return [sha256.Size]byte{}, false
+	}
+	hash := sha256.New()
+	hash.Write([]byte(serverName))
+	hash.Write([]byte{0})
+	hash.Write([]byte(toolName))
+	hash.Write([]byte{0})
+	hash.Write(arguments)
+	var key [sha256.Size]byte
+	hash.Sum(key[:0])
+	return key, true
+}
+
+// Get returns the cached result of a call, counting the lookup as a hit or a miss
+func (c *ToolCache) Get(serverName, toolName string, params interface{}) (*mcp.CallToolResult, bool) {
+	key, ok := toolCacheKey(serverName, toolName, params)
+	if !ok {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	entry, found := c.entries[key]
+	if found && !time.Now().Before(entry.expires) {
+		delete(c.entries, key)
+		found = false
+	}
+	c.mu.Unlock()
+
+	if !found {
+		metrics.ObserveToolCacheLookup(serverName, ToolCacheMiss)
+		return nil, false
+	}
+	metrics.ObserveToolCacheLookup(serverName, ToolCacheHit)
+	return entry.result, true
+}
+
+// Put caches the result of a call unless the tool reported an error
+func (c *ToolCache) Put(serverName, toolName string, params interface{}, result *mcp.CallToolResult) {
+	if result == nil || result.IsError {
+		return
+	}
+	key, ok := toolCacheKey(serverName, toolName, params)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= c.size {
+		c.evictLocked(now)
+	}
+	c.entries[key] = toolCacheEntry{result: result, expires: now.Add(c.ttl)}
+}
+
+// evictLocked removes the expired results, or the result expiring first when none has expired
+func (c *ToolCache) evictLocked(now time.Time) {
+	var oldestKey [sha256.Size]byte
+	var oldest time.Time
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+			continue
+		}
+		if oldest.IsZero() || entry.expires.Before(oldest) {
+			oldestKey, oldest = key, entry.expires
+		}
+	}
+	if len(c.entries) >= c.size {
+		delete(c.entries, oldestKey)
+	}
+}
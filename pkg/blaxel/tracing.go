@@ -0,0 +1,95 @@
+package blaxel
+
+import (
+	"context"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of model and MCP requests
+var tracer = otel.Tracer("template-custom-agent-go/pkg/blaxel")
+
+// startChatSpan starts the span of a chat completion request to a model
+func startChatSpan(ctx context.Context, model string, stream bool) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "chat "+model,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("gen_ai.operation.name", "chat"),
+			attribute.String("gen_ai.request.model", model),
+			attribute.Bool("gen_ai.request.stream", stream),
+		))
+}
+
+// endChatSpan records the token usage or the error of a chat completion and ends its span
+func endChatSpan(span trace.Span, resp *ChatCompletionResponse, err error) {
+	defer span.End()
+	if err != nil {
+		recordSpanError(span, err)
+		return
+	}
+	finishReasons := make([]string, 0, len(resp.Choices))
+	for _, choice := range resp.Choices {
+		finishReasons = append(finishReasons, choice.FinishReason)
+	}
+	span.SetAttributes(
+		attribute.String("gen_ai.response.model", resp.Model),
+		attribute.StringSlice("gen_ai.response.finish_reasons", finishReasons),
+		attribute.Int("gen_ai.usage.input_tokens", resp.Usage.PromptTokens),
+		attribute.Int("gen_ai.usage.output_tokens", resp.Usage.CompletionTokens),
+	)
+}
+
+// startEmbeddingsSpan starts the span of an embeddings request to a model
+func startEmbeddingsSpan(ctx context.Context, model string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "embeddings "+model,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("gen_ai.operation.name", "embeddings"),
+			attribute.String("gen_ai.request.model", model),
+		))
+}
+
+// endEmbeddingsSpan records the token usage or the error of an embeddings request and ends its span
+func endEmbeddingsSpan(span trace.Span, resp *EmbeddingResponse, err error) {
+	defer span.End()
+	if err != nil {
+		recordSpanError(span, err)
+		return
+	}
+	span.SetAttributes(
+		attribute.String("gen_ai.response.model", resp.Model),
+		attribute.Int("gen_ai.usage.input_tokens", resp.Usage.PromptTokens),
+	)
+}
+
+// startToolSpan starts the span of a tool call to an MCP server
+func startToolSpan(ctx context.Context, serverName, toolName string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "tools/call "+toolName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("mcp.server.name", serverName),
+			attribute.String("gen_ai.tool.name", toolName),
+		))
+}
+
+// endToolSpan records the error of a tool call, including errors reported in its result, and ends its span
+func endToolSpan(span trace.Span, result *mcp.CallToolResult, err error) {
+	defer span.End()
+	if err != nil {
+		recordSpanError(span, err)
+		return
+	}
+	if result != nil && result.IsError {
+		span.SetStatus(codes.Error, "tool returned an error")
+	}
+}
+
+// recordSpanError marks a span as failed with the error
+func recordSpanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,33 @@
+package blaxel
+
+import (
+	"fmt"
+	"os"
+
+	"template-custom-agent-go/pkg/config"
+)
+
+// UserAgent returns the User-Agent sent with model, MCP, agent and sandbox requests, so upstream
+// logs and rate limits can attribute traffic to this deployment. BL_USER_AGENT replaces it entirely;
+// otherwise it is "<BL_SERVICE_NAME>/<BL_SERVICE_VERSION> (instance <BL_INSTANCE_ID>)" followed by
+// the User-Agent of the Blaxel SDK when given. The version defaults to the version of the build and
+// the instance to the hostname.
+func UserAgent(sdkUserAgent string) string {
+	if userAgent := os.Getenv("BL_USER_AGENT"); userAgent != "" {
+		return userAgent
+	}
+
+	instance := os.Getenv("BL_INSTANCE_ID")
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+
+	userAgent := config.ServiceName() + "/" + config.ServiceVersion()
+	if instance != "" {
+		userAgent += fmt.Sprintf(" (instance %s)", instance)
+	}
+	if sdkUserAgent != "" {
+		userAgent += " " + sdkUserAgent
+	}
+	return userAgent
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"os"
+	"runtime"
+	"runtime/debug"
+)
+
+// defaultServiceName identifies this service when BL_SERVICE_NAME is not set
+const defaultServiceName = "template-custom-agent-go"
+
+// ServiceName returns the name of the service, BL_SERVICE_NAME or template-custom-agent-go
+func ServiceName() string {
+	if name := os.Getenv("BL_SERVICE_NAME"); name != "" {
+		return name
+	}
+	return defaultServiceName
+}
+
+// ServiceVersion returns the version of the service, BL_SERVICE_VERSION or the version of the build
+func ServiceVersion() string {
+	if version := os.Getenv("BL_SERVICE_VERSION"); version != "" {
+		return version
+	}
+	return ReadBuildInfo().Version
+}
+
+// BuildInfo describes the binary, from the module and VCS information embedded by the Go toolchain
+type BuildInfo struct {
+	// Version is the module version, the short VCS revision or "dev" when neither is known
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// ReadBuildInfo returns the build information of the running binary
+func ReadBuildInfo() BuildInfo {
+	build := BuildInfo{Version: "dev", GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return build
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build.Revision = setting.Value
+		case "vcs.time":
+			build.Time = setting.Value
+		case "vcs.modified":
+			build.Modified = setting.Value == "true"
+		}
+	}
+	if version := info.Main.Version; version != "" && version != "(devel)" {
+		build.Version = version
+	} else if len(build.Revision) >= 12 {
+		build.Version = build.Revision[:12]
+	}
+	return build
+}
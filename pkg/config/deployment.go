@@ -0,0 +1,167 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Deployment modes selecting a preset of middleware defaults
+const (
+	ModeInternal = "internal"
+	ModePublic   = "public"
+	ModeDev      = "dev"
+)
+
+// Roles granted to API keys, each including the permissions of the roles below it
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
+	RoleCaller   = "caller"
+)
+
+// roleLevels orders the roles by privilege
+var roleLevels = map[string]int{
+	RoleCaller:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// APIKey is an accepted API key and the role it grants
+type APIKey struct {
+	Key  string
+	Role string
+}
+
+// RoleAllows reports whether a role grants the permissions of the required role
+func RoleAllows(role, required string) bool {
+	level, known := roleLevels[role]
+	return known && level >= roleLevels[required]
+}
+
+// DeploymentConfig holds the middleware settings of a deployment
+type DeploymentConfig struct {
+	Mode string
+	// RequireAuth rejects requests without one of APIKeys
+	RequireAuth bool
+	APIKeys     []APIKey
+	// CORSOrigins lists the allowed origins, "*" allows any; empty disables CORS headers
+	CORSOrigins []string
+	// RateLimitRPS is the sustained number of requests per second allowed per client, 0 disables rate limiting
+	RateLimitRPS   float64
+	RateLimitBurst int
+	// DebugEndpoints enables the /admin routes
+	DebugEndpoints bool
+}
+
+// presets are the defaults of each deployment mode
+var presets = map[string]DeploymentConfig{
+	// internal: behind the Blaxel platform or a private network, which handles authentication
+	ModeInternal: {
+		Mode: ModeInternal,
+	},
+	// public: exposed directly to clients
+	ModePublic: {
+		Mode:           ModePublic,
+		RequireAuth:    true,
+		RateLimitRPS:   5,
+		RateLimitBurst: 20,
+	},
+	// dev: local development with browser clients
+	ModeDev: {
+		Mode:           ModeDev,
+		CORSOrigins:    []string{"*"},
+		DebugEndpoints: true,
+	},
+}
+
+// LoadDeploymentConfig builds the deployment configuration from the BL_DEPLOYMENT_MODE preset
+// (internal by default) and the individual overrides BL_REQUIRE_AUTH, BL_API_KEYS,
+// BL_CORS_ORIGINS, BL_RATE_LIMIT_RPS, BL_RATE_LIMIT_BURST and BL_DEBUG_ENDPOINTS.
+// BL_API_KEYS entries are "<key>" or "<key>:<role>", keys without a role being callers.
+func LoadDeploymentConfig() (DeploymentConfig, error) {
+	mode := strings.ToLower(os.Getenv("BL_DEPLOYMENT_MODE"))
+	if mode == "" {
+		mode = ModeInternal
+	}
+	config, exists := presets[mode]
+	if !exists {
+		return DeploymentConfig{}, fmt.Errorf("unknown BL_DEPLOYMENT_MODE %q, expected internal, public or dev", mode)
+	}
+
+	var err error
+	if config.RequireAuth, err = envBool("BL_REQUIRE_AUTH", config.RequireAuth); err != nil {
+		return DeploymentConfig{}, err
+	}
+	if config.DebugEndpoints, err = envBool("BL_DEBUG_ENDPOINTS", config.DebugEndpoints); err != nil {
+		return DeploymentConfig{}, err
+	}
+	if value := os.Getenv("BL_API_KEYS"); value != "" {
+		if config.APIKeys, err = parseAPIKeys(splitList(value)); err != nil {
+			return DeploymentConfig{}, err
+		}
+	}
+	if value, set := os.LookupEnv("BL_CORS_ORIGINS"); set {
+		config.CORSOrigins = splitList(value)
+	}
+	if value := os.Getenv("BL_RATE_LIMIT_RPS"); value != "" {
+		if config.RateLimitRPS, err = strconv.ParseFloat(value, 64); err != nil || config.RateLimitRPS < 0 {
+			return DeploymentConfig{}, fmt.Errorf("invalid BL_RATE_LIMIT_RPS %q", value)
+		}
+	}
+	if value := os.Getenv("BL_RATE_LIMIT_BURST"); value != "" {
+		if config.RateLimitBurst, err = strconv.Atoi(value); err != nil || config.RateLimitBurst < 0 {
+			return DeploymentConfig{}, fmt.Errorf("invalid BL_RATE_LIMIT_BURST %q", value)
+		}
+	}
+	if config.RateLimitRPS > 0 && config.RateLimitBurst == 0 {
+		config.RateLimitBurst = max(1, int(config.RateLimitRPS))
+	}
+
+	if config.RequireAuth && len(config.APIKeys) == 0 {
+		return DeploymentConfig{}, fmt.Errorf("authentication is required in %s mode but BL_API_KEYS is empty", config.Mode)
+	}
+
+	return config, nil
+}
+
+// parseAPIKeys reads "<key>" or "<key>:<role>" entries
+func parseAPIKeys(entries []string) ([]APIKey, error) {
+	keys := make([]APIKey, 0, len(entries))
+	for _, entry := range entries {
+		key, role, hasRole := strings.Cut(entry, ":")
+		if !hasRole {
+			role = RoleCaller
+		}
+		if _, known := roleLevels[role]; !known || key == "" {
+			return nil, fmt.Errorf("invalid BL_API_KEYS entry with role %q, expected <key>:admin, <key>:operator or <key>:caller", role)
+		}
+		keys = append(keys, APIKey{Key: key, Role: role})
+	}
+	return keys, nil
+}
+
+// envBool reads a boolean environment variable, returning fallback when it is not set
+func envBool(name string, fallback bool) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return parsed, nil
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
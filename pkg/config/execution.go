@@ -0,0 +1,31 @@
+package config
+
+import "os"
+
+// Execution identifies the Blaxel execution and task this process runs, to correlate runs with them
+type Execution struct {
+	ID        string `json:"execution_id,omitempty"`
+	TaskIndex string `json:"task_index,omitempty"`
+}
+
+// ExecutionFromEnv returns the execution of the process, read like the logger does from the
+// variables named by BL_EXECUTION_KEY and BL_TASK_KEY, BL_EXECUTION_ID and TASK_INDEX by default
+func ExecutionFromEnv() Execution {
+	return Execution{
+		ID:        os.Getenv(envOrDefault("BL_EXECUTION_KEY", "BL_EXECUTION_ID")),
+		TaskIndex: os.Getenv(envOrDefault("BL_TASK_KEY", "TASK_INDEX")),
+	}
+}
+
+// IsZero reports whether the process runs outside of a Blaxel execution
+func (e Execution) IsZero() bool {
+	return e.ID == "" && e.TaskIndex == ""
+}
+
+// envOrDefault returns the value of the environment variable key, or defaultValue when it is empty
+func envOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gcpLoadBalancerRanges are the source ranges of Google Cloud load balancers, which append the
+// client IP to X-Forwarded-For
+var gcpLoadBalancerRanges = []string{"35.191.0.0/16", "130.211.0.0/22"}
+
+// NetworkConfig holds how client IPs are determined behind proxies and load balancers
+type NetworkConfig struct {
+	// TrustedPlatform is the header carrying the client IP set by the platform in front of the service
+	TrustedPlatform string
+	// TrustedProxies lists the proxies whose X-Forwarded-For and X-Real-IP headers are trusted,
+	// nil keeping the Gin default of trusting every proxy
+	TrustedProxies []string
+	// ProxyProtocol reads the client address from PROXY protocol headers on incoming connections
+	ProxyProtocol bool
+	// ProxyProtocolSources lists the upstreams allowed to send PROXY protocol headers, empty allowing any
+	ProxyProtocolSources []string
+}
+
+// LoadNetworkConfig reads the network configuration from BL_TRUSTED_PLATFORM (cloudflare, appengine,
+// flyio, gcp-lb or a header name), BL_TRUSTED_PROXIES, BL_PROXY_PROTOCOL and BL_PROXY_PROTOCOL_SOURCES
+func LoadNetworkConfig() (NetworkConfig, error) {
+	var config NetworkConfig
+
+	if value, set := os.LookupEnv("BL_TRUSTED_PROXIES"); set {
+		config.TrustedProxies = splitList(value)
+		if config.TrustedProxies == nil {
+			config.TrustedProxies = []string{}
+		}
+	}
+
+	switch platform := os.Getenv("BL_TRUSTED_PLATFORM"); strings.ToLower(platform) {
+	case "":
+	case "cloudflare":
+		config.TrustedPlatform = gin.PlatformCloudflare
+	case "appengine":
+		config.TrustedPlatform = gin.PlatformGoogleAppEngine
+	case "flyio":
+		config.TrustedPlatform = gin.PlatformFlyIO
+	case "gcp-lb":
+		config.TrustedProxies = append(config.TrustedProxies, gcpLoadBalancerRanges...)
+	default:
+		config.TrustedPlatform = platform
+	}
+
+	var err error
+	if config.ProxyProtocol, err = envBool("BL_PROXY_PROTOCOL", false); err != nil {
+		return NetworkConfig{}, err
+	}
+	config.ProxyProtocolSources = splitList(os.Getenv("BL_PROXY_PROTOCOL_SOURCES"))
+
+	if err := validateAddresses(config.TrustedProxies); err != nil {
+		return NetworkConfig{}, err
+	}
+	if err := validateAddresses(config.ProxyProtocolSources); err != nil {
+		return NetworkConfig{}, err
+	}
+
+	return config, nil
+}
+
+// validateAddresses checks that every entry is an IP address or CIDR range
+func validateAddresses(addresses []string) error {
+	for _, address := range addresses {
+		if net.ParseIP(address) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(address); err != nil {
+			return fmt.Errorf("invalid proxy address %q, expected an IP or CIDR range", address)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package dnscache
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"template-custom-agent-go/pkg/logger"
+	"template-custom-agent-go/pkg/metrics"
+)
+
+// Defaults of the cache, used when BL_DNS_CACHE_TTL and BL_DNS_STALE_TTL are not set
+const (
+	DefaultTTL      = 30 * time.Second
+	DefaultStaleTTL = 10 * time.Minute
+)
+
+// Results of lookups, as counted in metrics
+const (
+	ResultHit   = "hit"
+	ResultMiss  = "miss"
+	ResultStale = "stale"
+	ResultError = "error"
+)
+
+// Config holds the lifetimes of cached addresses
+type Config struct {
+	// TTL is how long resolved addresses are used before resolving the host again, zero disabling
+	// the cache. The Go resolver does not expose record TTLs, so it bounds them instead.
+	TTL time.Duration
+	// StaleTTL is how long after expiring addresses are still used when resolving the host fails
+	StaleTTL time.Duration
+}
+
+// ConfigFromEnv reads BL_DNS_CACHE_TTL and BL_DNS_STALE_TTL, durations such as 30s
+func ConfigFromEnv() Config {
+	return Config{
+		TTL:      durationFromEnv("BL_DNS_CACHE_TTL", DefaultTTL),
+		StaleTTL: durationFromEnv("BL_DNS_STALE_TTL", DefaultStaleTTL),
+	}
+}
+
+// durationFromEnv parses a non-negative duration, falling back to the default when invalid
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		logger.Warningf("Invalid %s %q, using %s", name, value, fallback)
+		return fallback
+	}
+	return duration
+}
+
+// entry holds the addresses of a host and when they were resolved
+type entry struct {
+	addrs    []string
+	resolved time.Time
+}
+
+// Resolver caches host name lookups and falls back to the last known addresses of a host while
+// resolving it fails, so a transient resolver failure does not fail whole agent runs
+type Resolver struct {
+	config   Config
+	resolver *net.Resolver
+
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+// NewResolver creates a caching resolver on top of the default resolver
+func NewResolver(config Config) *Resolver {
+	return &Resolver{
+		config:   config,
+		resolver: net.DefaultResolver,
+		entries:  make(map[string]entry),
+	}
+}
+
+// LookupHost returns the addresses of the host, from the cache while they are fresh
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	host = strings.ToLower(host)
+	r.mu.Lock()
+	cached, found := r.entries[host]
+	r.mu.Unlock()
+
+	age := time.Since(cached.resolved)
+	if found && age < r.config.TTL {
+		metrics.ObserveDNSLookup(ResultHit)
+		return cached.addrs, nil
+	}
+
+	addrs, err := r.resolver.LookupHost(ctx, host)
+	if err != nil {
+		if found && age < r.config.TTL+r.config.StaleTTL && ctx.Err() == nil {
+			metrics.ObserveDNSLookup(ResultStale)
+			logger.Warningf("Failed to resolve %s, using the addresses resolved %s ago: %v", host, age.Round(time.Second), err)
+			return cached.addrs, nil
+		}
+		metrics.ObserveDNSLookup(ResultError)
+		return nil, err
+	}
+
+	metrics.ObserveDNSLookup(ResultMiss)
+	r.mu.Lock()
+	r.entries[host] = entry{addrs: addrs, resolved: time.Now()}
+	r.mu.Unlock()
+	return addrs, nil
+}
+
+// DialContext wraps a dial function so host names are resolved through the cache, each address
+// being tried in turn until one connects
+func (r *Resolver) DialContext(dial func(ctx context.Context, network, address string) (net.Conn, error)) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, address)
+		}
+
+		addrs, err := r.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var lastErr error
+		for _, addr := range addrs {
+			conn, err := dial(ctx, network, net.JoinHostPort(addr, port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil, lastErr
+	}
+}
+
+// Install resolves the connections of http.DefaultTransport, which includes the Blaxel SDK, HTTP MCP
+// clients and webhooks, through a caching resolver. It must run before the egress policy wraps the
+// transport.
+func Install(config Config) {
+	if config.TTL <= 0 {
+		return
+	}
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		logger.Warningf("Default HTTP transport replaced, DNS cache not installed")
+		return
+	}
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	}
+	transport.DialContext = NewResolver(config).DialContext(dial)
+	logger.Infof("DNS cache enabled: ttl=%s stale_ttl=%s", config.TTL, config.StaleTTL)
+}
@@ -0,0 +1,263 @@
+package egress
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"template-custom-agent-go/pkg/logger"
+)
+
+// Policy restricts the hosts outbound requests may contact. Hosts matching a deny pattern are
+// always rejected; when allow patterns are set, only hosts matching one of them are reachable.
+// Patterns are host names or IP addresses where "*" matches any sequence of characters, such as
+// "*.blaxel.ai", or CIDR ranges such as "10.0.0.0/8" matching IP addresses, including the addresses
+// host names resolve to for requests sent through Transport.
+type Policy struct {
+	Allow []string
+	Deny  []string
+}
+
+// PolicyFromEnv reads the policy from the comma separated BL_EGRESS_ALLOW and BL_EGRESS_DENY patterns
+func PolicyFromEnv() Policy {
+	return Policy{
+		Allow: splitPatterns(os.Getenv("BL_EGRESS_ALLOW")),
+		Deny:  splitPatterns(os.Getenv("BL_EGRESS_DENY")),
+	}
+}
+
+// Enabled reports whether the policy restricts any host
+func (p Policy) Enabled() bool {
+	return len(p.Allow) > 0 || len(p.Deny) > 0
+}
+
+// CheckHost returns an error when the policy forbids contacting the host, CIDR ranges only matching
+// hosts given as IP addresses
+func (p Policy) CheckHost(host string) error {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range p.Deny {
+		if matchHost(pattern, host) {
+			return fmt.Errorf("egress to %s denied by pattern %q", host, pattern)
+		}
+	}
+	if len(p.Allow) == 0 {
+		return nil
+	}
+	for _, pattern := range p.Allow {
+		if matchHost(pattern, host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("egress to %s not in the allowlist", host)
+}
+
+// CheckURL returns an error when the policy forbids contacting the host of the URL
+func (p Policy) CheckURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %w", rawURL, err)
+	}
+	if parsed.Hostname() == "" {
+		return fmt.Errorf("URL %q has no host", rawURL)
+	}
+	return p.CheckHost(parsed.Hostname())
+}
+
+// Transport wraps an HTTP transport so requests to hosts forbidden by the policy fail before being
+// sent. Host names are checked against the CIDR ranges of the policy with the addresses they resolve
+// to, on a copy of base dialing through the policy, so base must be an *http.Transport for these
+// ranges to apply to host names.
+func (p Policy) Transport(base http.RoundTripper) http.RoundTripper {
+	transport := &policyTransport{policy: p, base: base}
+	if t, ok := base.(*http.Transport); ok && p.hasCIDR() {
+		t = t.Clone()
+		dial := t.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+		}
+		t.DialContext = p.dialContext(dial)
+		transport.base = t
+		transport.checksAddresses = true
+	}
+	return transport
+}
+
+// hasCIDR reports whether the policy has CIDR ranges
+func (p Policy) hasCIDR() bool {
+	for _, pattern := range slices.Concat(p.Allow, p.Deny) {
+		if isCIDR(pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkName checks a host against the policy before it is resolved. A host name that only an
+// allowed CIDR range could match is reported as not allowed yet, without error, to be decided with
+// the address it resolves to.
+func (p Policy) checkName(host string) (allowed bool, err error) {
+	err = p.CheckHost(host)
+	if err == nil || len(p.Allow) == 0 || net.ParseIP(host) != nil || !slices.ContainsFunc(p.Allow, isCIDR) {
+		return err == nil, err
+	}
+	for _, pattern := range p.Deny {
+		if matchHost(pattern, host) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// checkAddress checks the address a host resolved to against the CIDR ranges of the policy, allowed
+// telling whether the host was already allowed by name
+func (p Policy) checkAddress(host string, ip net.IP, allowed bool) error {
+	for _, pattern := range p.Deny {
+		if isCIDR(pattern) && matchHost(pattern, ip.String()) {
+			return fmt.Errorf("egress to %s (%s) denied by pattern %q", host, ip, pattern)
+		}
+	}
+	if allowed || len(p.Allow) == 0 {
+		return nil
+	}
+	for _, pattern := range p.Allow {
+		if isCIDR(pattern) && matchHost(pattern, ip.String()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("egress to %s (%s) not in the allowlist", host, ip)
+}
+
+// dialTarget is the host of a request checked by name, passed to the dialer with its context
+type dialTarget struct {
+	host    string
+	allowed bool
+}
+
+// dialTargetKey is the context key of the dialTarget of a request
+type dialTargetKey struct{}
+
+// dialContext wraps a dial function so the address connected to is checked against the CIDR ranges
+// of the policy, closing the connection before anything is sent when they forbid it. Connections to
+// a proxy are not checked, the proxy resolving the host, so a host name needing a CIDR range to be
+// allowed cannot be reached through a proxy.
+func (p Policy) dialContext(dial func(ctx context.Context, network, address string) (net.Conn, error)) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		target, _ := ctx.Value(dialTargetKey{}).(dialTarget)
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		if target.host != "" && target.host != strings.ToLower(strings.TrimSuffix(host, ".")) {
+			if !target.allowed {
+				err := fmt.Errorf("egress to %s through a proxy cannot be checked against CIDR ranges", target.host)
+				logger.WarningfCtx(ctx, "Blocked outbound connection to %s: %v", address, err)
+				return nil, err
+			}
+			return dial(ctx, network, address)
+		}
+
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		remote, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+		ip := net.ParseIP(remote)
+		if ip == nil {
+			conn.Close()
+			return nil, fmt.Errorf("egress to %s: unknown remote address %s", host, conn.RemoteAddr())
+		}
+		if err := p.checkAddress(host, ip, target.allowed); err != nil {
+			conn.Close()
+			logger.WarningfCtx(ctx, "Blocked outbound connection to %s: %v", address, err)
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// installed is the process-wide policy set by Install
+var installed Policy
+
+// Install enforces the policy on every HTTP client built on http.DefaultTransport, which includes
+// the Blaxel SDK and MCP clients. It must run before those clients are created.
+func Install(policy Policy) {
+	if !policy.Enabled() {
+		return
+	}
+	installed = policy
+	http.DefaultTransport = policy.Transport(http.DefaultTransport)
+	logger.Infof("Egress policy enabled: allow=%v deny=%v", policy.Allow, policy.Deny)
+}
+
+// Check returns an error when the installed policy forbids contacting the host of the URL, for
+// connections that do not go through http.DefaultTransport such as WebSockets
+func Check(rawURL string) error {
+	if !installed.Enabled() {
+		return nil
+	}
+	return installed.CheckURL(rawURL)
+}
+
+// policyTransport rejects requests to hosts forbidden by the policy, including redirects
+type policyTransport struct {
+	policy Policy
+	base   http.RoundTripper
+	// checksAddresses is set when base dials through the policy, checking the resolved addresses
+	checksAddresses bool
+}
+
+// RoundTrip checks the host of the request against the policy before sending it. When base checks
+// the addresses it connects to, host names are only checked against the name patterns here, and
+// against the CIDR ranges once resolved.
+func (t *policyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := strings.ToLower(strings.TrimSuffix(req.URL.Hostname(), "."))
+	if !t.checksAddresses {
+		if err := t.policy.CheckHost(host); err != nil {
+			logger.Warningf("Blocked outbound request %s %s: %v", req.Method, req.URL.Redacted(), err)
+			return nil, err
+		}
+		return t.base.RoundTrip(req)
+	}
+
+	allowed, err := t.policy.checkName(host)
+	if err != nil {
+		logger.Warningf("Blocked outbound request %s %s: %v", req.Method, req.URL.Redacted(), err)
+		return nil, err
+	}
+	ctx := context.WithValue(req.Context(), dialTargetKey{}, dialTarget{host: host, allowed: allowed})
+	return t.base.RoundTrip(req.WithContext(ctx))
+}
+
+// isCIDR reports whether a pattern is a CIDR range
+func isCIDR(pattern string) bool {
+	_, _, err := net.ParseCIDR(pattern)
+	return err == nil
+}
+
+// matchHost reports whether a host matches a pattern, a CIDR range matching the IP addresses it contains
+func matchHost(pattern, host string) bool {
+	if _, network, err := net.ParseCIDR(pattern); err == nil {
+		ip := net.ParseIP(host)
+		return ip != nil && network.Contains(ip)
+	}
+	matched, _ := path.Match(strings.ToLower(pattern), host)
+	return matched
+}
+
+// splitPatterns splits a comma separated list of patterns, dropping empty entries
+func splitPatterns(value string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
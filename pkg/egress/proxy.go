@@ -0,0 +1,102 @@
+package egress
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"template-custom-agent-go/pkg/logger"
+)
+
+// ProxyRule sends requests to hosts matching Pattern through the proxy at URL, or directly when URL
+// is nil. Patterns follow the syntax of the egress policy.
+type ProxyRule struct {
+	Pattern string
+	URL     *url.URL
+}
+
+// ProxyRulesFromEnv reads BL_PROXY_RULES, comma separated "<pattern>=<proxy URL>" entries where
+// "direct" as the proxy bypasses any proxy, such as
+// "*.blaxel.ai=http://proxy:3128,10.0.0.0/8=direct". Invalid entries are skipped with a warning.
+func ProxyRulesFromEnv() []ProxyRule {
+	var rules []ProxyRule
+	for _, entry := range splitPatterns(os.Getenv("BL_PROXY_RULES")) {
+		pattern, proxy, found := strings.Cut(entry, "=")
+		if !found || pattern == "" {
+			logger.Warningf("Invalid BL_PROXY_RULES entry %q, expected <pattern>=<proxy URL>", entry)
+			continue
+		}
+		rule := ProxyRule{Pattern: pattern}
+		if proxy != "direct" {
+			parsed, err := url.Parse(proxy)
+			if err != nil || parsed.Host == "" {
+				logger.Warningf("Invalid proxy URL in BL_PROXY_RULES entry %q, skipping it", entry)
+				continue
+			}
+			rule.URL = parsed
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// proxyRules are the process-wide rules set by InstallProxy
+var proxyRules []ProxyRule
+
+// ProxyFor returns the proxy requests to the URL go through: the first rule matching its host,
+// otherwise HTTPS_PROXY or HTTP_PROXY unless the host is excluded by NO_PROXY. It returns nil for
+// direct connections.
+func ProxyFor(rawURL string) (*url.URL, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q: %w", rawURL, err)
+	}
+	return proxy(&http.Request{URL: parsed})
+}
+
+// proxy selects the proxy of a request from the installed rules and the environment
+func proxy(req *http.Request) (*url.URL, error) {
+	host := strings.ToLower(strings.TrimSuffix(req.URL.Hostname(), "."))
+	for _, rule := range proxyRules {
+		if matchHost(rule.Pattern, host) {
+			return rule.URL, nil
+		}
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// InstallProxy routes requests sent through http.DefaultTransport, which includes the Blaxel SDK,
+// MCP clients and webhooks, through the proxies of the rules and of the environment. It must run
+// before Install wraps the transport.
+func InstallProxy(rules []ProxyRule) {
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		logger.Warningf("Default HTTP transport replaced, outbound proxy rules not applied")
+		return
+	}
+	proxyRules = rules
+	transport.Proxy = proxy
+
+	for _, rule := range rules {
+		target := "direct"
+		if rule.URL != nil {
+			target = rule.URL.Redacted()
+		}
+		logger.Infof("Outbound proxy rule: %s via %s", rule.Pattern, target)
+	}
+	for _, name := range []string{"HTTPS_PROXY", "HTTP_PROXY", "NO_PROXY"} {
+		value := os.Getenv(name)
+		if value == "" {
+			value = os.Getenv(strings.ToLower(name))
+		}
+		if value == "" {
+			continue
+		}
+		if parsed, err := url.Parse(value); err == nil && parsed.User != nil {
+			value = parsed.Redacted()
+		}
+		logger.Infof("Outbound proxy from environment: %s=%s", name, value)
+	}
+}
@@ -0,0 +1,166 @@
+package events
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"template-custom-agent-go/pkg/logger"
+)
+
+// Topics of the events published by agent runs
+const (
+	RunStarted         = "run.started"
+	IterationCompleted = "iteration.completed"
+	ToolCalled         = "tool.called"
+	RunFinished        = "run.finished"
+	GuardrailTriggered = "guardrail.triggered"
+)
+
+// AllTopics subscribes a handler to every topic
+const AllTopics = "*"
+
+// Event is a notification of an agent run. Data holds the payload of the topic: RunStart, Iteration,
+// ToolCall, RunFinish or Guardrail.
+type Event struct {
+	Topic string    `json:"topic"`
+	RunID string    `json:"run_id,omitempty"`
+	Agent string    `json:"agent"`
+	At    time.Time `json:"at"`
+	Data  any       `json:"data,omitempty"`
+}
+
+// RunStart is the payload of run.started events
+type RunStart struct {
+	Model    string `json:"model"`
+	Messages int    `json:"messages"`
+}
+
+// Iteration is the payload of iteration.completed events
+type Iteration struct {
+	Iteration int    `json:"iteration"`
+	Model     string `json:"model"`
+	// ToolCalls counts the tool calls requested by the turn
+	ToolCalls   int  `json:"tool_calls"`
+	TotalTokens int  `json:"total_tokens"`
+	Final       bool `json:"final"`
+}
+
+// ToolCall is the payload of tool.called events, published once the result of the call is known
+type ToolCall struct {
+	Iteration   int    `json:"iteration"`
+	ToolCallID  string `json:"tool_call_id"`
+	Name        string `json:"name"`
+	Status      string `json:"status"`
+	DurationMs  int64  `json:"duration_ms,omitempty"`
+	ResultBytes int    `json:"result_bytes,omitempty"`
+}
+
+// RunFinish is the payload of run.finished events
+type RunFinish struct {
+	// FinishReason is the finish reason of the answer, empty when the run failed
+	FinishReason string         `json:"finish_reason,omitempty"`
+	Error        string         `json:"error,omitempty"`
+	Iterations   int            `json:"iterations"`
+	ToolCalls    map[string]int `json:"tool_calls,omitempty"`
+	TotalTokens  int            `json:"total_tokens"`
+	DurationMs   int64          `json:"duration_ms"`
+}
+
+// Guardrail is the payload of guardrail.triggered events
+type Guardrail struct {
+	Iteration int    `json:"iteration"`
+	Guardrail string `json:"guardrail"`
+	Tool      string `json:"tool,omitempty"`
+	Reason    string `json:"reason"`
+}
+
+// Handler receives the events of a topic. Handlers run synchronously on the agent run, so those doing
+// slow work such as network calls should hand it off to a goroutine.
+type Handler func(ctx context.Context, event Event)
+
+// subscription is a handler registered on a topic
+type subscription struct {
+	id      uint64
+	handler Handler
+}
+
+// Bus delivers the events published by agent runs to the handlers subscribed to their topic
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]subscription
+	nextID   uint64
+}
+
+// NewBus creates a bus without subscribers
+func NewBus() *Bus {
+	return &Bus{handlers: make(map[string][]subscription)}
+}
+
+// Default is the bus agents publish to
+var Default = NewBus()
+
+// Subscribe registers a handler on the default bus
+func Subscribe(topic string, handler Handler) func() {
+	return Default.Subscribe(topic, handler)
+}
+
+// Subscribe registers a handler for a topic, or every topic with AllTopics, and returns the function
+// removing it
+func (b *Bus) Subscribe(topic string, handler Handler) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	id := b.nextID
+	b.handlers[topic] = append(b.handlers[topic], subscription{id: id, handler: handler})
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			subscriptions := b.handlers[topic]
+			for i, subscription := range subscriptions {
+				if subscription.id == id {
+					b.handlers[topic] = append(subscriptions[:i:i], subscriptions[i+1:]...)
+					break
+				}
+			}
+		})
+	}
+}
+
+// HasSubscribers reports whether any handler would receive events of the topic
+func (b *Bus) HasSubscribers(topic string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.handlers[topic]) > 0 || len(b.handlers[AllTopics]) > 0
+}
+
+// Publish delivers an event to the handlers of its topic, then to those of every topic, in
+// subscription order. A panicking handler is logged without affecting the run or other handlers.
+func (b *Bus) Publish(ctx context.Context, event Event) {
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+	b.mu.RLock()
+	handlers := make([]subscription, 0, len(b.handlers[event.Topic])+len(b.handlers[AllTopics]))
+	handlers = append(handlers, b.handlers[event.Topic]...)
+	handlers = append(handlers, b.handlers[AllTopics]...)
+	b.mu.RUnlock()
+
+	for _, subscription := range handlers {
+		deliver(ctx, subscription.handler, event)
+	}
+}
+
+// deliver calls a handler, recovering its panics
+func deliver(ctx context.Context, handler Handler, event Event) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			logger.ErrorfCtx(ctx, "Panic recovered in %s event handler: %v\n%s", event.Topic, recovered, debug.Stack())
+		}
+	}()
+	handler(ctx, event)
+}
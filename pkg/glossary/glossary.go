@@ -0,0 +1,197 @@
+package glossary
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"template-custom-agent-go/pkg/logger"
+)
+
+// File is the JSON glossary file read from BL_GLOSSARY_FILE
+type File struct {
+	// Preferred maps each preferred term to the variants it replaces, such as "sign in" for "log in"
+	Preferred map[string][]string `json:"preferred"`
+	// Banned maps each term that must not appear in answers to the term replacing it
+	Banned map[string]string `json:"banned"`
+	// Names are product and brand names written with their exact casing
+	Names []string `json:"names"`
+}
+
+// rule replaces the matches of a pattern with a term
+type rule struct {
+	pattern     *regexp.Regexp
+	replacement string
+	banned      bool
+}
+
+// Glossary tells agents the terms of the domain and enforces them on their answers
+type Glossary struct {
+	file  File
+	rules []rule
+}
+
+// Replacements counts the terms replaced in an answer
+type Replacements struct {
+	Preferred int
+	Banned    int
+	Names     int
+}
+
+// Total returns the number of replaced terms
+func (r Replacements) Total() int {
+	return r.Preferred + r.Banned + r.Names
+}
+
+// NewFromEnv loads the glossary of BL_GLOSSARY_FILE, returning nil when it is not set
+func NewFromEnv() (*Glossary, error) {
+	path := os.Getenv("BL_GLOSSARY_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read glossary file: %w", err)
+	}
+	var file File
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse glossary file %s: %w", path, err)
+	}
+	glossary, err := New(file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid glossary file %s: %w", path, err)
+	}
+
+	logger.Infof("Glossary loaded from %s: %d preferred terms, %d banned terms, %d names",
+		path, len(file.Preferred), len(file.Banned), len(file.Names))
+	return glossary, nil
+}
+
+// New creates a glossary. Terms are matched as whole words regardless of case.
+func New(file File) (*Glossary, error) {
+	glossary := &Glossary{file: file}
+
+	// Banned terms first, so a preferred term or a name never reintroduces one
+	for _, term := range sortedKeys(file.Banned) {
+		if strings.TrimSpace(term) == "" || strings.TrimSpace(file.Banned[term]) == "" {
+			return nil, fmt.Errorf("banned term %q needs a replacement", term)
+		}
+		glossary.rules = append(glossary.rules, rule{pattern: termPattern(term), replacement: file.Banned[term], banned: true})
+	}
+	for _, term := range sortedKeys(file.Preferred) {
+		variants := file.Preferred[term]
+		if strings.TrimSpace(term) == "" || len(variants) == 0 {
+			return nil, fmt.Errorf("preferred term %q needs the variants it replaces", term)
+		}
+		glossary.rules = append(glossary.rules, rule{pattern: termPattern(variants...), replacement: term})
+	}
+	for _, name := range file.Names {
+		if strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("empty name")
+		}
+		glossary.rules = append(glossary.rules, rule{pattern: termPattern(name), replacement: name})
+	}
+	return glossary, nil
+}
+
+// termPattern matches any of the terms as whole words regardless of case, the longest first
+func termPattern(terms ...string) *regexp.Regexp {
+	quoted := make([]string, 0, len(terms))
+	for _, term := range terms {
+		quoted = append(quoted, regexp.QuoteMeta(strings.TrimSpace(term)))
+	}
+	sort.Slice(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+	return regexp.MustCompile(`(?i)(^|[^\p{L}\p{N}_])(` + strings.Join(quoted, "|") + `)($|[^\p{L}\p{N}_])`)
+}
+
+// sortedKeys returns the keys of a map in order, so rules apply the same way on every run
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Prompt returns the instructions added to the system prompt of agents
+func (g *Glossary) Prompt() string {
+	var prompt strings.Builder
+	prompt.WriteString("Follow this glossary in your answers.")
+	for _, term := range sortedKeys(g.file.Preferred) {
+		fmt.Fprintf(&prompt, "\n- Write %q, not %s.", term, quoteAll(g.file.Preferred[term]))
+	}
+	for _, term := range sortedKeys(g.file.Banned) {
+		fmt.Fprintf(&prompt, "\n- Never write %q; write %q instead.", term, g.file.Banned[term])
+	}
+	if len(g.file.Names) > 0 {
+		fmt.Fprintf(&prompt, "\n- Write these names with this exact casing: %s.", strings.Join(g.file.Names, ", "))
+	}
+	return prompt.String()
+}
+
+// quoteAll quotes the terms and joins them with "or"
+func quoteAll(terms []string) string {
+	quoted := make([]string, 0, len(terms))
+	for _, term := range terms {
+		quoted = append(quoted, fmt.Sprintf("%q", term))
+	}
+	return strings.Join(quoted, " or ")
+}
+
+// Apply enforces the glossary on an answer, replacing banned terms and variants of preferred terms
+// and fixing the casing of names. Replacements keep the capital of a term starting a sentence.
+func (g *Glossary) Apply(text string) (string, Replacements) {
+	var replacements Replacements
+	for _, rule := range g.rules {
+		text = replaceAll(rule.pattern, text, func(match string) string {
+			replacement := rule.replacement
+			if match == replacement {
+				return match
+			}
+			switch {
+			case rule.banned:
+				replacements.Banned++
+			case strings.EqualFold(match, replacement):
+				replacements.Names++
+			default:
+				replacements.Preferred++
+			}
+			return matchCapital(match, replacement)
+		})
+	}
+	return text, replacements
+}
+
+// replaceAll replaces the term of each match, keeping the characters around it. Matches may share
+// the separator between two adjacent terms, so the text is scanned until no match is left.
+func replaceAll(pattern *regexp.Regexp, text string, replace func(string) string) string {
+	var result strings.Builder
+	for {
+		match := pattern.FindStringSubmatchIndex(text)
+		if match == nil {
+			result.WriteString(text)
+			return result.String()
+		}
+		// Keep the leading separator and continue after the term, leaving the trailing separator to the next match
+		result.WriteString(text[:match[4]])
+		result.WriteString(replace(text[match[4]:match[5]]))
+		text = text[match[5]:]
+	}
+}
+
+// matchCapital capitalizes the replacement when the term it replaces starts with a capital and the
+// replacement with a lowercase letter, as at the start of a sentence
+func matchCapital(match, replacement string) string {
+	first, _ := utf8.DecodeRuneInString(match)
+	replacementFirst, size := utf8.DecodeRuneInString(replacement)
+	if unicode.IsUpper(first) && unicode.IsLower(replacementFirst) {
+		return string(unicode.ToUpper(replacementFirst)) + replacement[size:]
+	}
+	return replacement
+}
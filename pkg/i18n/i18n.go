@@ -0,0 +1,161 @@
+package i18n
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"template-custom-agent-go/pkg/logger"
+)
+
+// English is the language used when no supported language is requested
+const English = "en"
+
+// Message keys of server-generated strings
+const (
+	MaxIterations  = "max_iterations"
+	BudgetExceeded = "budget_exceeded"
+	Handoff        = "handoff"
+	// ErrorPrefix prefixes the error codes of error response messages
+	ErrorPrefix = "error."
+)
+
+// catalogs holds the server-generated strings of each supported language
+var catalogs = map[string]map[string]string{
+	"en": {
+		Handoff:                             "I'm handing this conversation over to a human agent who will follow up with you.",
+		BudgetExceeded:                      "Token budget reached. The agent may not have completed the task.",
+		MaxIterations:                       "Maximum iterations reached. The agent may not have completed the task.",
+		ErrorPrefix + "invalid_request":     "The request is malformed.",
+		ErrorPrefix + "validation_error":    "The request contains invalid values.",
+		ErrorPrefix + "unauthorized":        "Authentication is required.",
+		ErrorPrefix + "forbidden":           "You are not allowed to perform this action.",
+		ErrorPrefix + "not_found":           "The requested resource was not found.",
+		ErrorPrefix + "not_acceptable":      "The requested response format is not available.",
+		ErrorPrefix + "conflict":            "The request conflicts with the current state of the resource.",
+		ErrorPrefix + "rate_limited":        "Too many requests, please retry later.",
+		ErrorPrefix + "upstream_error":      "The AI service failed to respond.",
+		ErrorPrefix + "upstream_timeout":    "The AI service took too long to respond.",
+		ErrorPrefix + "service_unavailable": "The service is temporarily unavailable, please retry later.",
+		ErrorPrefix + "internal_error":      "An internal error occurred.",
+	},
+	"fr": {
+		Handoff:                             "Je transmets cette conversation à un agent humain qui reviendra vers vous.",
+		BudgetExceeded:                      "Budget de tokens atteint. L'agent n'a peut-être pas terminé la tâche.",
+		MaxIterations:                       "Nombre maximal d'itérations atteint. L'agent n'a peut-être pas terminé la tâche.",
+		ErrorPrefix + "invalid_request":     "La requête est mal formée.",
+		ErrorPrefix + "validation_error":    "La requête contient des valeurs invalides.",
+		ErrorPrefix + "unauthorized":        "Une authentification est requise.",
+		ErrorPrefix + "forbidden":           "Vous n'êtes pas autorisé à effectuer cette action.",
+		ErrorPrefix + "not_found":           "La ressource demandée est introuvable.",
+		ErrorPrefix + "not_acceptable":      "Le format de réponse demandé n'est pas disponible.",
+		ErrorPrefix + "conflict":            "La requête est en conflit avec l'état actuel de la ressource.",
+		ErrorPrefix + "rate_limited":        "Trop de requêtes, veuillez réessayer plus tard.",
+		ErrorPrefix + "upstream_error":      "Le service d'IA n'a pas répondu correctement.",
+		ErrorPrefix + "upstream_timeout":    "Le service d'IA a mis trop de temps à répondre.",
+		ErrorPrefix + "service_unavailable": "Le service est temporairement indisponible, veuillez réessayer plus tard.",
+		ErrorPrefix + "internal_error":      "Une erreur interne s'est produite.",
+	},
+	"es": {
+		Handoff:                             "Transfiero esta conversación a un agente humano que se pondrá en contacto con usted.",
+		BudgetExceeded:                      "Se alcanzó el presupuesto de tokens. Es posible que el agente no haya completado la tarea.",
+		MaxIterations:                       "Se alcanzó el número máximo de iteraciones. Es posible que el agente no haya completado la tarea.",
+		ErrorPrefix + "invalid_request":     "La solicitud está mal formada.",
+		ErrorPrefix + "validation_error":    "La solicitud contiene valores no válidos.",
+		ErrorPrefix + "unauthorized":        "Se requiere autenticación.",
+		ErrorPrefix + "forbidden":           "No tiene permiso para realizar esta acción.",
+		ErrorPrefix + "not_found":           "No se encontró el recurso solicitado.",
+		ErrorPrefix + "not_acceptable":      "El formato de respuesta solicitado no está disponible.",
+		ErrorPrefix + "conflict":            "La solicitud entra en conflicto con el estado actual del recurso.",
+		ErrorPrefix + "rate_limited":        "Demasiadas solicitudes, vuelva a intentarlo más tarde.",
+		ErrorPrefix + "upstream_error":      "El servicio de IA no respondió correctamente.",
+		ErrorPrefix + "upstream_timeout":    "El servicio de IA tardó demasiado en responder.",
+		ErrorPrefix + "service_unavailable": "El servicio no está disponible temporalmente, vuelva a intentarlo más tarde.",
+		ErrorPrefix + "internal_error":      "Se produjo un error interno.",
+	},
+	"de": {
+		Handoff:                             "Ich übergebe dieses Gespräch an einen menschlichen Mitarbeiter, der sich bei Ihnen melden wird.",
+		BudgetExceeded:                      "Token-Budget erreicht. Der Agent hat die Aufgabe möglicherweise nicht abgeschlossen.",
+		MaxIterations:                       "Maximale Anzahl an Iterationen erreicht. Der Agent hat die Aufgabe möglicherweise nicht abgeschlossen.",
+		ErrorPrefix + "invalid_request":     "Die Anfrage ist fehlerhaft.",
+		ErrorPrefix + "validation_error":    "Die Anfrage enthält ungültige Werte.",
+		ErrorPrefix + "unauthorized":        "Eine Authentifizierung ist erforderlich.",
+		ErrorPrefix + "forbidden":           "Sie sind nicht berechtigt, diese Aktion auszuführen.",
+		ErrorPrefix + "not_found":           "Die angeforderte Ressource wurde nicht gefunden.",
+		ErrorPrefix + "not_acceptable":      "Das angeforderte Antwortformat ist nicht verfügbar.",
+		ErrorPrefix + "conflict":            "Die Anfrage steht im Konflikt mit dem aktuellen Zustand der Ressource.",
+		ErrorPrefix + "rate_limited":        "Zu viele Anfragen, bitte versuchen Sie es später erneut.",
+		ErrorPrefix + "upstream_error":      "Der KI-Dienst hat nicht korrekt geantwortet.",
+		ErrorPrefix + "upstream_timeout":    "Der KI-Dienst hat zu lange für eine Antwort gebraucht.",
+		ErrorPrefix + "service_unavailable": "Der Dienst ist vorübergehend nicht verfügbar, bitte versuchen Sie es später erneut.",
+		ErrorPrefix + "internal_error":      "Ein interner Fehler ist aufgetreten.",
+	},
+}
+
+// defaultLanguage is the language of requests without a supported Accept-Language, from BL_LANGUAGE
+var defaultLanguage = loadDefaultLanguage()
+
+// loadDefaultLanguage reads BL_LANGUAGE, falling back to English when it is not supported
+func loadDefaultLanguage() string {
+	value := strings.ToLower(os.Getenv("BL_LANGUAGE"))
+	if value == "" {
+		return English
+	}
+	if _, supported := catalogs[value]; !supported {
+		logger.Warningf("Unsupported BL_LANGUAGE %q, using %s", value, English)
+		return English
+	}
+	return value
+}
+
+// DefaultLanguage returns the configured language
+func DefaultLanguage() string {
+	return defaultLanguage
+}
+
+// Negotiate returns the supported language preferred by an Accept-Language header, or the
+// configured language when none of them is supported
+func Negotiate(acceptLanguage string) string {
+	type preference struct {
+		language string
+		quality  float64
+	}
+
+	var preferences []preference
+	for _, entry := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(entry), ";")
+		quality := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		// Match on the primary subtag, so fr-CA selects fr
+		language, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if _, supported := catalogs[language]; supported && quality > 0 {
+			preferences = append(preferences, preference{language: language, quality: quality})
+		}
+	}
+	if len(preferences) == 0 {
+		return defaultLanguage
+	}
+
+	sort.SliceStable(preferences, func(i, j int) bool { return preferences[i].quality > preferences[j].quality })
+	return preferences[0].language
+}
+
+// T returns the string of a key in the language, falling back to English
+func T(language, key string) string {
+	if message, exists := catalogs[language][key]; exists {
+		return message
+	}
+	return catalogs[English][key]
+}
+
+// ErrorMessage returns the user-facing message of an error code in the language
+func ErrorMessage(language, errorCode string) string {
+	return T(language, ErrorPrefix+errorCode)
+}
@@ -0,0 +1,79 @@
+package logger
+
+import (
+	"context"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// maxCapturedMessageBytes truncates the captured log lines, which are persisted with their run
+const maxCapturedMessageBytes = 4096
+
+// CapturedLine is a log line emitted with the context of a capture
+type CapturedLine struct {
+	Time    time.Time
+	Level   LogLevel
+	Message string
+}
+
+// Capture keeps the last log lines emitted with its context, so they can be attached to a run. It is
+// safe for concurrent use, as tool calls log from their own goroutines.
+type Capture struct {
+	mu    sync.Mutex
+	limit int
+	lines []CapturedLine
+}
+
+// captureKey is the context key of the capture of a run
+type captureKey struct{}
+
+// NewCapture creates a capture keeping the last limit lines
+func NewCapture(limit int) *Capture {
+	return &Capture{limit: limit}
+}
+
+// WithCapture returns a context whose log lines logged through the context-aware functions are also
+// kept by capture. A nil capture returns ctx unchanged.
+func WithCapture(ctx context.Context, capture *Capture) context.Context {
+	if capture == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, captureKey{}, capture)
+}
+
+// add keeps a line, dropping the oldest one when the capture is full
+func (c *Capture) add(level LogLevel, message string) {
+	if len(message) > maxCapturedMessageBytes {
+		end := maxCapturedMessageBytes
+		for end > 0 && !utf8.RuneStart(message[end]) {
+			end--
+		}
+		message = message[:end] + "..."
+	}
+	line := CapturedLine{Time: time.Now(), Level: level, Message: message}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.limit <= 0 {
+		return
+	}
+	if len(c.lines) == c.limit {
+		copy(c.lines, c.lines[1:])
+		c.lines = c.lines[:len(c.lines)-1]
+	}
+	c.lines = append(c.lines, line)
+}
+
+// Lines returns the kept lines in order
+func (c *Capture) Lines() []CapturedLine {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]CapturedLine(nil), c.lines...)
+}
+
+// captureFromContext returns the capture of ctx, nil outside captured runs
+func captureFromContext(ctx context.Context) *Capture {
+	capture, _ := ctx.Value(captureKey{}).(*Capture)
+	return capture
+}
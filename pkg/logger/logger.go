@@ -2,11 +2,14 @@ package logger
 
 import (
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"go.opentelemetry.io/otel/trace"
 )
@@ -45,49 +48,86 @@ func (l LogLevel) String() string {
 
 // JsonFormatter handles JSON log formatting with OpenTelemetry context
 type JsonFormatter struct {
+	MessageName  string
+	SeverityName string
+	// Severities maps the levels to the severities of the ingestion target, level names being used
+	// for levels it leaves out
+	Severities map[LogLevel]string
+	// TimeName is the field of the log time, left out when empty, written with TimeFormat, a Go time
+	// layout or unix_ms
+	TimeName        string
+	TimeFormat      string
 	TraceIdName     string
 	SpanIdName      string
 	LabelsName      string
 	TraceIdPrefix   string
 	SpanIdPrefix    string
+	TraceIdFormat   string
+	SampledName     string
 	TaskIndex       string
 	TaskPrefix      string
 	ExecutionKey    string
 	ExecutionPrefix string
+	// Fields are added to every entry
+	Fields map[string]interface{}
 }
 
-// NewJsonFormatter creates a new JSON formatter with environment variable configuration
+// NewJsonFormatter creates a new JSON formatter with the field names of the BL_LOGGER_PRESET preset,
+// blaxel by default, overridden by environment variables
 func NewJsonFormatter() *JsonFormatter {
+	preset, _ := jsonPreset(os.Getenv("BL_LOGGER_PRESET"))
+	if preset.MessageName == "" {
+		preset, _ = jsonPreset(PresetBlaxel)
+	}
 	return &JsonFormatter{
-		TraceIdName:     getEnvOrDefault("BL_LOGGER_TRACE_ID", "trace_id"),
-		SpanIdName:      getEnvOrDefault("BL_LOGGER_SPAN_ID", "span_id"),
-		LabelsName:      getEnvOrDefault("BL_LOGGER_LABELS", "labels"),
-		TraceIdPrefix:   getEnvOrDefault("BL_LOGGER_TRACE_ID_PREFIX", ""),
-		SpanIdPrefix:    getEnvOrDefault("BL_LOGGER_SPAN_ID_PREFIX", ""),
+		MessageName:     getEnvOrDefault("BL_LOGGER_MESSAGE", preset.MessageName),
+		SeverityName:    getEnvOrDefault("BL_LOGGER_SEVERITY", preset.SeverityName),
+		Severities:      preset.Severities,
+		TimeName:        getEnvOrDefault("BL_LOGGER_TIME", preset.TimeName),
+		TimeFormat:      getEnvOrDefault("BL_LOGGER_TIME_FORMAT", preset.TimeFormat),
+		TraceIdName:     getEnvOrDefault("BL_LOGGER_TRACE_ID", preset.TraceIdName),
+		SpanIdName:      getEnvOrDefault("BL_LOGGER_SPAN_ID", preset.SpanIdName),
+		LabelsName:      getEnvOrDefault("BL_LOGGER_LABELS", preset.LabelsName),
+		TraceIdPrefix:   getEnvOrDefault("BL_LOGGER_TRACE_ID_PREFIX", preset.TraceIdPrefix),
+		SpanIdPrefix:    getEnvOrDefault("BL_LOGGER_SPAN_ID_PREFIX", preset.SpanIdPrefix),
+		TraceIdFormat:   preset.TraceIdFormat,
+		SampledName:     preset.SampledName,
 		TaskIndex:       getEnvOrDefault("BL_TASK_KEY", "TASK_INDEX"),
 		TaskPrefix:      getEnvOrDefault("BL_TASK_PREFIX", ""),
 		ExecutionKey:    getEnvOrDefault("BL_EXECUTION_KEY", "BL_EXECUTION_ID"),
 		ExecutionPrefix: getEnvOrDefault("BL_EXECUTION_PREFIX", ""),
+		Fields:          preset.Fields,
 	}
 }
 
 // Format formats a log entry as JSON with trace context
 func (jf *JsonFormatter) Format(ctx context.Context, level LogLevel, message string) string {
-	logEntry := map[string]interface{}{
-		"message":     message,
-		"severity":    level.String(),
-		jf.LabelsName: map[string]string{},
+	logEntry := make(map[string]interface{}, len(jf.Fields)+6)
+	for name, value := range jf.Fields {
+		logEntry[name] = value
+	}
+	logEntry[jf.MessageName] = message
+	logEntry[jf.SeverityName] = jf.severity(level)
+	logEntry[jf.LabelsName] = map[string]string{}
+	if jf.TimeName != "" {
+		logEntry[jf.TimeName] = jf.time(time.Now())
 	}
 
 	// Get current active span from context
 	span := trace.SpanFromContext(ctx)
 	if span.SpanContext().IsValid() {
 		spanContext := span.SpanContext()
-		traceIdHex := spanContext.TraceID().String()
-		spanIdHex := spanContext.SpanID().String()
+		traceID, spanID := spanContext.TraceID(), spanContext.SpanID()
+		traceId, spanId := traceID.String(), spanID.String()
+		if jf.TraceIdFormat == TraceIdFormatDatadog {
+			traceId, spanId = datadogId(traceID[8:]), datadogId(spanID[:])
+		}
 
-		logEntry[jf.TraceIdName] = jf.TraceIdPrefix + traceIdHex
-		logEntry[jf.SpanIdName] = jf.SpanIdPrefix + spanIdHex
+		logEntry[jf.TraceIdName] = jf.TraceIdPrefix + traceId
+		logEntry[jf.SpanIdName] = jf.SpanIdPrefix + spanId
+		if jf.SampledName != "" {
+			logEntry[jf.SampledName] = spanContext.IsSampled()
+		}
 	}
 
 	// Add task ID if available
@@ -109,6 +149,31 @@ func (jf *JsonFormatter) Format(ctx context.Context, level LogLevel, message str
 	return string(jsonBytes)
 }
 
+// severity returns the severity of the level for the ingestion target
+func (jf *JsonFormatter) severity(level LogLevel) string {
+	if severity, ok := jf.Severities[level]; ok {
+		return severity
+	}
+	return level.String()
+}
+
+// time returns the log time in the time format
+func (jf *JsonFormatter) time(now time.Time) interface{} {
+	switch jf.TimeFormat {
+	case TimeFormatUnixMs:
+		return now.UnixMilli()
+	case "":
+		return now.UTC().Format(time.RFC3339Nano)
+	default:
+		return now.UTC().Format(jf.TimeFormat)
+	}
+}
+
+// datadogId returns the decimal value of the big-endian bytes of an ID, as Datadog writes trace IDs
+func datadogId(id []byte) string {
+	return strconv.FormatUint(binary.BigEndian.Uint64(id), 10)
+}
+
 // ColoredFormatter handles colored log formatting
 type ColoredFormatter struct {
 	Colors map[string]string
@@ -168,11 +233,17 @@ func New() *Logger {
 	level := getLogLevelFromEnv()
 	formatter := getFormatterFromEnv()
 
-	return &Logger{
+	l := &Logger{
 		level:     level,
 		formatter: formatter,
 		logger:    log.New(os.Stdout, "", 0), // No default formatting
 	}
+	if preset := os.Getenv("BL_LOGGER_PRESET"); preset != "" {
+		if _, known := jsonPreset(preset); !known {
+			l.logf(WARNING, "Unknown BL_LOGGER_PRESET %q, using %s", preset, PresetBlaxel)
+		}
+	}
+	return l
 }
 
 // getEnvOrDefault returns environment variable value or default
@@ -183,10 +254,11 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
-// getFormatterFromEnv returns the appropriate formatter based on BL_LOGGER env var
+// getFormatterFromEnv returns the appropriate formatter based on BL_LOGGER env var, BL_LOGGER_PRESET
+// selecting JSON logs when BL_LOGGER is not set
 func getFormatterFromEnv() Formatter {
 	loggerType := getEnvOrDefault("BL_LOGGER", "colored")
-	if loggerType == "json" {
+	if loggerType == "json" || (os.Getenv("BL_LOGGER") == "" && os.Getenv("BL_LOGGER_PRESET") != "") {
 		return NewJsonFormatter()
 	}
 	return NewColoredFormatter()
@@ -249,12 +321,19 @@ func (l *Logger) shouldLog(level LogLevel) bool {
 
 // logf formats and logs a message if the level is appropriate
 func (l *Logger) logf(level LogLevel, format string, args ...interface{}) {
+	l.logfCtx(context.Background(), level, format, args...)
+}
+
+// logfCtx formats and logs a message with the trace context of ctx if the level is appropriate
+func (l *Logger) logfCtx(ctx context.Context, level LogLevel, format string, args ...interface{}) {
 	if !l.shouldLog(level) {
 		return
 	}
 
 	message := fmt.Sprintf(format, args...)
-	ctx := context.Background() // You can pass context from calling functions for trace context
+	if capture := captureFromContext(ctx); capture != nil {
+		capture.add(level, message)
+	}
 	formattedMessage := l.formatter.Format(ctx, level, message)
 	l.logger.Print(formattedMessage)
 
@@ -313,6 +392,33 @@ func Fatalf(format string, args ...interface{}) {
 	globalLogger.logf(FATAL, format, args...)
 }
 
+// Context-aware logger functions, adding the trace and span IDs of the span in ctx to JSON logs
+
+// TracefCtx logs at TRACE level with the trace context of ctx
+func TracefCtx(ctx context.Context, format string, args ...interface{}) {
+	globalLogger.logfCtx(ctx, TRACE, format, args...)
+}
+
+// DebugfCtx logs at DEBUG level with the trace context of ctx
+func DebugfCtx(ctx context.Context, format string, args ...interface{}) {
+	globalLogger.logfCtx(ctx, DEBUG, format, args...)
+}
+
+// InfofCtx logs at INFO level with the trace context of ctx
+func InfofCtx(ctx context.Context, format string, args ...interface{}) {
+	globalLogger.logfCtx(ctx, INFO, format, args...)
+}
+
+// WarningfCtx logs at WARNING level with the trace context of ctx
+func WarningfCtx(ctx context.Context, format string, args ...interface{}) {
+	globalLogger.logfCtx(ctx, WARNING, format, args...)
+}
+
+// ErrorfCtx logs at ERROR level with the trace context of ctx
+func ErrorfCtx(ctx context.Context, format string, args ...interface{}) {
+	globalLogger.logfCtx(ctx, ERROR, format, args...)
+}
+
 // GetLevel returns the current log level
 func GetLevel() LogLevel {
 	return globalLogger.level
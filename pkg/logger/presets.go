@@ -0,0 +1,105 @@
+package logger
+
+import (
+	"os"
+	"time"
+)
+
+// Presets of the JSON formatter, selected with BL_LOGGER_PRESET
+const (
+	PresetBlaxel  = "blaxel"
+	PresetGCP     = "gcp"
+	PresetDatadog = "datadog"
+	PresetECS     = "ecs"
+)
+
+// Formats of the log time, besides Go time layouts
+const (
+	TimeFormatUnixMs = "unix_ms"
+)
+
+// Formats of trace and span IDs
+const (
+	TraceIdFormatHex = "hex"
+	// TraceIdFormatDatadog is the decimal value of the lower 64 bits, as Datadog correlates them
+	TraceIdFormatDatadog = "datadog"
+)
+
+// jsonPreset returns the field names and severities of a log ingestion target, and whether it is known
+func jsonPreset(name string) (JsonFormatter, bool) {
+	switch name {
+	case "", PresetBlaxel:
+		return JsonFormatter{
+			MessageName:  "message",
+			SeverityName: "severity",
+			TraceIdName:  "trace_id",
+			SpanIdName:   "span_id",
+			LabelsName:   "labels",
+		}, true
+	case PresetGCP:
+		// Fields recognized by Cloud Logging in structured logs, which links the trace when its ID
+		// is prefixed with the project
+		preset := JsonFormatter{
+			MessageName:  "message",
+			SeverityName: "severity",
+			Severities: map[LogLevel]string{
+				TRACE:   "DEBUG",
+				DEBUG:   "DEBUG",
+				INFO:    "INFO",
+				WARNING: "WARNING",
+				ERROR:   "ERROR",
+				FATAL:   "CRITICAL",
+			},
+			TimeName:    "time",
+			TimeFormat:  time.RFC3339Nano,
+			TraceIdName: "logging.googleapis.com/trace",
+			SpanIdName:  "logging.googleapis.com/spanId",
+			SampledName: "logging.googleapis.com/trace_sampled",
+			LabelsName:  "logging.googleapis.com/labels",
+		}
+		if project := os.Getenv("GOOGLE_CLOUD_PROJECT"); project != "" {
+			preset.TraceIdPrefix = "projects/" + project + "/traces/"
+		}
+		return preset, true
+	case PresetDatadog:
+		return JsonFormatter{
+			MessageName:  "message",
+			SeverityName: "status",
+			Severities: map[LogLevel]string{
+				TRACE:   "debug",
+				DEBUG:   "debug",
+				INFO:    "info",
+				WARNING: "warn",
+				ERROR:   "error",
+				FATAL:   "critical",
+			},
+			TimeName:      "timestamp",
+			TimeFormat:    TimeFormatUnixMs,
+			TraceIdName:   "dd.trace_id",
+			SpanIdName:    "dd.span_id",
+			TraceIdFormat: TraceIdFormatDatadog,
+			LabelsName:    "labels",
+		}, true
+	case PresetECS:
+		return JsonFormatter{
+			MessageName:  "message",
+			SeverityName: "log.level",
+			Severities: map[LogLevel]string{
+				TRACE:   "trace",
+				DEBUG:   "debug",
+				INFO:    "info",
+				WARNING: "warn",
+				ERROR:   "error",
+				FATAL:   "fatal",
+			},
+			TimeName:    "@timestamp",
+			TimeFormat:  "2006-01-02T15:04:05.000Z07:00",
+			TraceIdName: "trace.id",
+			SpanIdName:  "span.id",
+			LabelsName:  "labels",
+			Fields:      map[string]interface{}{"ecs.version": "8.11.0"},
+		}, true
+	default:
+		return JsonFormatter{}, false
+	}
+}
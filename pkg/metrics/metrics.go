@@ -0,0 +1,197 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"template-custom-agent-go/pkg/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// registerer registers the metrics of the agent with the Blaxel execution and task of the process as
+// constant labels, to correlate them with platform executions
+var registerer = prometheus.WrapRegistererWith(executionLabels(config.ExecutionFromEnv()), prometheus.DefaultRegisterer)
+
+// executionLabels returns the labels of the execution and task that are known
+func executionLabels(execution config.Execution) prometheus.Labels {
+	labels := prometheus.Labels{}
+	if execution.ID != "" {
+		labels["execution_id"] = execution.ID
+	}
+	if execution.TaskIndex != "" {
+		labels["task_index"] = execution.TaskIndex
+	}
+	return labels
+}
+
+// latencyBuckets cover interactive latencies from 10ms to about 80s
+var latencyBuckets = prometheus.ExponentialBuckets(0.01, 2, 14)
+
+var (
+	streamTimeToFirstToken = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "agent",
+		Name:      "stream_time_to_first_token_seconds",
+		Help:      "Time from receiving a streaming request to sending the first token to the client.",
+		Buckets:   latencyBuckets,
+	}, []string{"model", "endpoint"})
+
+	streamInterTokenLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "agent",
+		Name:      "stream_inter_token_latency_seconds",
+		Help:      "Mean time between tokens sent to the client after the first one.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"model", "endpoint"})
+
+	streamTokensPerSecond = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "agent",
+		Name:      "stream_tokens_per_second",
+		Help:      "Tokens sent per second from the first token to the end of the stream.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 14),
+	}, []string{"model", "endpoint"})
+
+	streamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "agent",
+		Name:      "stream_duration_seconds",
+		Help:      "Total duration of streaming responses, from the request to the end of the stream.",
+		Buckets:   latencyBuckets,
+	}, []string{"model", "endpoint"})
+
+	toolInjectionDetected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent",
+		Name:      "tool_injection_detected_total",
+		Help:      "Tool results flagged as containing instruction-like content.",
+	}, []string{"tool"})
+
+	streamEventsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent",
+		Name:      "stream_events_dropped_total",
+		Help:      "Stream events not delivered to a subscriber whose buffer was full, by slow client policy.",
+	}, []string{"policy"})
+
+	streamSubscribersDisconnected = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "agent",
+		Name:      "stream_subscribers_disconnected_total",
+		Help:      "Stream subscribers disconnected for falling behind.",
+	})
+
+	dnsLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent",
+		Name:      "dns_lookups_total",
+		Help:      "Host name lookups of outbound connections, by result: hit, miss, stale or error.",
+	}, []string{"result"})
+
+	toolCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent",
+		Name:      "tool_cache_lookups_total",
+		Help:      "Lookups of MCP tool results in the tool cache, by server and result: hit or miss.",
+	}, []string{"server", "result"})
+
+	mcpServerUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "agent",
+		Name:      "mcp_server_up",
+		Help:      "Whether the last health check of each configured MCP server succeeded (1) or failed (0).",
+	}, []string{"server"})
+)
+
+func init() {
+	registerer.MustRegister(streamTimeToFirstToken, streamInterTokenLatency, streamTokensPerSecond, streamDuration, toolInjectionDetected,
+		streamEventsDropped, streamSubscribersDisconnected, dnsLookups, toolCacheLookups, mcpServerUp)
+}
+
+// StreamTiming holds the timings of one streamed response
+type StreamTiming struct {
+	Model    string
+	Endpoint string
+	// Start is when the request was received
+	Start time.Time
+	// FirstToken and LastToken are when the first and last tokens were sent to the client
+	FirstToken time.Time
+	LastToken  time.Time
+	// End is when the stream was closed
+	End    time.Time
+	Tokens int
+}
+
+// TimeToFirstToken returns the time from the request to the first token
+func (t StreamTiming) TimeToFirstToken() time.Duration {
+	return t.FirstToken.Sub(t.Start)
+}
+
+// InterTokenLatency returns the mean time between tokens after the first one
+func (t StreamTiming) InterTokenLatency() time.Duration {
+	if t.Tokens < 2 {
+		return 0
+	}
+	return t.LastToken.Sub(t.FirstToken) / time.Duration(t.Tokens-1)
+}
+
+// TokensPerSecond returns the token rate from the first to the last token
+func (t StreamTiming) TokensPerSecond() float64 {
+	elapsed := t.LastToken.Sub(t.FirstToken).Seconds()
+	if t.Tokens < 2 || elapsed <= 0 {
+		return 0
+	}
+	return float64(t.Tokens-1) / elapsed
+}
+
+// Duration returns the total duration of the stream
+func (t StreamTiming) Duration() time.Duration {
+	return t.End.Sub(t.Start)
+}
+
+// ObserveStream records the timings of a streamed response. Streams that sent no token only record their duration.
+func ObserveStream(timing StreamTiming) {
+	streamDuration.WithLabelValues(timing.Model, timing.Endpoint).Observe(timing.Duration().Seconds())
+	if timing.Tokens == 0 {
+		return
+	}
+
+	streamTimeToFirstToken.WithLabelValues(timing.Model, timing.Endpoint).Observe(timing.TimeToFirstToken().Seconds())
+	if timing.Tokens > 1 {
+		streamInterTokenLatency.WithLabelValues(timing.Model, timing.Endpoint).Observe(timing.InterTokenLatency().Seconds())
+		if rate := timing.TokensPerSecond(); rate > 0 {
+			streamTokensPerSecond.WithLabelValues(timing.Model, timing.Endpoint).Observe(rate)
+		}
+	}
+}
+
+// ObserveInjectionDetected counts a tool result flagged as a possible prompt injection
+func ObserveInjectionDetected(tool string) {
+	toolInjectionDetected.WithLabelValues(tool).Inc()
+}
+
+// ObserveStreamEventDropped counts an event a slow stream subscriber did not receive
+func ObserveStreamEventDropped(policy string) {
+	streamEventsDropped.WithLabelValues(policy).Inc()
+}
+
+// ObserveSlowSubscriberDisconnected counts a stream subscriber disconnected for falling behind
+func ObserveSlowSubscriberDisconnected() {
+	streamSubscribersDisconnected.Inc()
+}
+
+// ObserveDNSLookup counts a host name lookup of an outbound connection by result
+func ObserveDNSLookup(result string) {
+	dnsLookups.WithLabelValues(result).Inc()
+}
+
+// ObserveToolCacheLookup counts a lookup of a tool result in the tool cache by result
+func ObserveToolCacheLookup(server, result string) {
+	toolCacheLookups.WithLabelValues(server, result).Inc()
+}
+
+// SetMCPServerUp records whether an MCP server is healthy
+func SetMCPServerUp(server string, up bool) {
+	value := 0.0
+	if up {
+		value = 1
+	}
+	mcpServerUp.WithLabelValues(server).Set(value)
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,243 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"template-custom-agent-go/pkg/logger"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Kinds of upstream dependencies tracked against SLO thresholds
+const (
+	KindModel = "model"
+	KindMCP   = "mcp"
+)
+
+// SLO evaluation window and sample bounds
+const (
+	sloWindow     = 5 * time.Minute
+	sloMinSamples = 10
+	sloMaxSamples = 1000
+)
+
+var (
+	upstreamRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "agent",
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Duration of requests to models and MCP servers.",
+		Buckets:   latencyBuckets,
+	}, []string{"kind", "name", "outcome"})
+
+	sloDegraded = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "agent",
+		Name:      "slo_degraded",
+		Help:      "1 when a model or MCP server breaches its latency or error rate threshold.",
+	}, []string{"kind", "name"})
+)
+
+func init() {
+	registerer.MustRegister(upstreamRequestDuration, sloDegraded)
+}
+
+// SLOThreshold is the latency and error rate a model or MCP server must stay under
+type SLOThreshold struct {
+	// LatencyP95 is the maximum 95th percentile latency, zero disables the check
+	LatencyP95 time.Duration
+	// ErrorRate is the maximum share of failed requests between 0 and 1, zero disables the check
+	ErrorRate float64
+}
+
+// UnmarshalJSON reads a threshold with the latency as a duration string such as "5s"
+func (t *SLOThreshold) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		LatencyP95 string  `json:"latency_p95"`
+		ErrorRate  float64 `json:"error_rate"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw.LatencyP95 != "" {
+		latency, err := time.ParseDuration(raw.LatencyP95)
+		if err != nil {
+			return fmt.Errorf("invalid latency_p95 %q: %w", raw.LatencyP95, err)
+		}
+		t.LatencyP95 = latency
+	}
+	t.ErrorRate = raw.ErrorRate
+	return nil
+}
+
+// SLOStatus is the current state of a model or MCP server against its threshold
+type SLOStatus struct {
+	Kind       string   `json:"kind"`
+	Name       string   `json:"name"`
+	Degraded   bool     `json:"degraded"`
+	Samples    int      `json:"samples"`
+	LatencyP95 string   `json:"latency_p95"`
+	ErrorRate  float64  `json:"error_rate"`
+	Reasons    []string `json:"reasons,omitempty"`
+}
+
+// sloSample is one observed upstream request
+type sloSample struct {
+	at      time.Time
+	latency time.Duration
+	failed  bool
+}
+
+// sloTracker keeps the recent requests of each upstream dependency with a configured threshold
+type sloTracker struct {
+	mu         sync.Mutex
+	thresholds map[string]SLOThreshold
+	samples    map[string][]sloSample
+}
+
+// slo is the process-wide SLO tracker, configured from BL_SLO_THRESHOLDS
+var slo = newSLOTracker(os.Getenv("BL_SLO_THRESHOLDS"))
+
+// newSLOTracker parses thresholds given as a JSON object keyed by "<kind>/<name>", where the name
+// may be "*" to apply to every model or MCP server without a threshold of its own, for example
+// {"model/*": {"latency_p95": "10s", "error_rate": 0.1}, "mcp/blaxel-search": {"error_rate": 0.2}}
+func newSLOTracker(config string) *sloTracker {
+	tracker := &sloTracker{
+		thresholds: make(map[string]SLOThreshold),
+		samples:    make(map[string][]sloSample),
+	}
+	if config == "" {
+		return tracker
+	}
+	if err := json.Unmarshal([]byte(config), &tracker.thresholds); err != nil {
+		logger.Errorf("Invalid BL_SLO_THRESHOLDS, SLO tracking disabled: %v", err)
+		tracker.thresholds = make(map[string]SLOThreshold)
+	}
+	return tracker
+}
+
+// threshold returns the threshold of a dependency, falling back to the wildcard of its kind
+func (t *sloTracker) threshold(kind, name string) (SLOThreshold, bool) {
+	if threshold, exists := t.thresholds[kind+"/"+name]; exists {
+		return threshold, true
+	}
+	threshold, exists := t.thresholds[kind+"/*"]
+	return threshold, exists
+}
+
+// observe records a request when the dependency has a threshold
+func (t *sloTracker) observe(kind, name string, latency time.Duration, failed bool, now time.Time) {
+	if _, exists := t.threshold(kind, name); !exists {
+		return
+	}
+
+	t.mu.Lock()
+	key := kind + "/" + name
+	samples := append(t.samples[key], sloSample{at: now, latency: latency, failed: failed})
+	samples = pruneSamples(samples, now)
+	t.samples[key] = samples
+	t.mu.Unlock()
+
+	t.status(kind, name, now)
+}
+
+// status evaluates a dependency against its threshold over the recent window and updates its degraded gauge
+func (t *sloTracker) status(kind, name string, now time.Time) SLOStatus {
+	threshold, _ := t.threshold(kind, name)
+
+	t.mu.Lock()
+	key := kind + "/" + name
+	samples := pruneSamples(t.samples[key], now)
+	t.samples[key] = samples
+	latencies := make([]time.Duration, 0, len(samples))
+	failures := 0
+	for _, sample := range samples {
+		latencies = append(latencies, sample.latency)
+		if sample.failed {
+			failures++
+		}
+	}
+	t.mu.Unlock()
+
+	status := SLOStatus{Kind: kind, Name: name, Samples: len(samples)}
+	if len(samples) == 0 {
+		sloDegraded.WithLabelValues(kind, name).Set(0)
+		return status
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	p95 := latencies[(len(latencies)*95+99)/100-1]
+	status.LatencyP95 = p95.String()
+	status.ErrorRate = float64(failures) / float64(len(samples))
+
+	if len(samples) < sloMinSamples {
+		sloDegraded.WithLabelValues(kind, name).Set(0)
+		return status
+	}
+	if threshold.LatencyP95 > 0 && p95 > threshold.LatencyP95 {
+		status.Reasons = append(status.Reasons, fmt.Sprintf("p95 latency %s above %s", p95, threshold.LatencyP95))
+	}
+	if threshold.ErrorRate > 0 && status.ErrorRate > threshold.ErrorRate {
+		status.Reasons = append(status.Reasons, fmt.Sprintf("error rate %.2f above %.2f", status.ErrorRate, threshold.ErrorRate))
+	}
+	status.Degraded = len(status.Reasons) > 0
+
+	degraded := 0.0
+	if status.Degraded {
+		degraded = 1
+	}
+	sloDegraded.WithLabelValues(kind, name).Set(degraded)
+	return status
+}
+
+// statuses evaluates every dependency that has been observed, sorted by kind and name
+func (t *sloTracker) statuses(now time.Time) []SLOStatus {
+	t.mu.Lock()
+	keys := make([]string, 0, len(t.samples))
+	for key := range t.samples {
+		keys = append(keys, key)
+	}
+	t.mu.Unlock()
+	sort.Strings(keys)
+
+	statuses := make([]SLOStatus, 0, len(keys))
+	for _, key := range keys {
+		kind, name, _ := strings.Cut(key, "/")
+		statuses = append(statuses, t.status(kind, name, now))
+	}
+	return statuses
+}
+
+// pruneSamples drops samples older than the window and bounds their number
+func pruneSamples(samples []sloSample, now time.Time) []sloSample {
+	start := 0
+	for start < len(samples) && now.Sub(samples[start].at) > sloWindow {
+		start++
+	}
+	if len(samples)-start > sloMaxSamples {
+		start = len(samples) - sloMaxSamples
+	}
+	if start == 0 {
+		return samples
+	}
+	return append(samples[:0], samples[start:]...)
+}
+
+// ObserveUpstream records a request to a model or MCP server for the latency metrics and SLO tracking
+func ObserveUpstream(kind, name string, latency time.Duration, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	upstreamRequestDuration.WithLabelValues(kind, name, outcome).Observe(latency.Seconds())
+	slo.observe(kind, name, latency, err != nil, time.Now())
+}
+
+// SLOStatuses returns the state of every model and MCP server tracked against a threshold
+func SLOStatuses() []SLOStatus {
+	return slo.statuses(time.Now())
+}
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"template-custom-agent-go/pkg/config"
+	"template-custom-agent-go/pkg/logger"
+	"template-custom-agent-go/pkg/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHeader is the alternative header carrying the API key
+const APIKeyHeader = "X-API-Key"
+
+// Gin context keys holding the API key the request authenticated with and its role
+const (
+	apiKeyKey = "api_key"
+	roleKey   = "api_key_role"
+)
+
+// AuthMiddleware rejects requests without one of the API keys, sent as a bearer token or in
+// the X-API-Key header. Health probes and A2A discovery stay public.
+func AuthMiddleware(apiKeys []config.APIKey) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isPublicPath(c.Request.URL.Path) || c.Request.Method == http.MethodOptions {
+			c.Next()
+			return
+		}
+
+		key := c.GetHeader(APIKeyHeader)
+		if key == "" {
+			key, _ = strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		}
+		if key == "" {
+			abortWithError(c, http.StatusUnauthorized, models.NewUnauthorizedError(fmt.Errorf("missing API key")))
+			return
+		}
+
+		for _, apiKey := range apiKeys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey.Key)) == 1 {
+				c.Set(apiKeyKey, apiKey.Key)
+				c.Set(roleKey, apiKey.Role)
+				c.Next()
+				return
+			}
+		}
+		abortWithError(c, http.StatusUnauthorized, models.NewUnauthorizedError(fmt.Errorf("invalid API key")))
+	}
+}
+
+// GetAPIKey returns the API key the request authenticated with, if any
+func GetAPIKey(c *gin.Context) string {
+	return c.GetString(apiKeyKey)
+}
+
+// RequireRole rejects authenticated requests whose API key lacks the role, logging every access
+// decision. Requests are let through when authentication is disabled.
+func RequireRole(required string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, authenticated := c.Get(roleKey)
+		if !authenticated {
+			c.Next()
+			return
+		}
+
+		keyID := apiKeyID(GetAPIKey(c))
+		if !config.RoleAllows(role.(string), required) {
+			logger.WarningfCtx(c.Request.Context(), "Access denied: key %s with role %s, %s %s requires %s",
+				keyID, role, c.Request.Method, c.Request.URL.Path, required)
+			abortWithError(c, http.StatusForbidden,
+				models.NewForbiddenError(fmt.Errorf("role %s required, API key has role %s", required, role)))
+			return
+		}
+
+		logger.InfofCtx(c.Request.Context(), "Access granted: key %s with role %s, %s %s requires %s",
+			keyID, role, c.Request.Method, c.Request.URL.Path, required)
+		c.Next()
+	}
+}
+
+// GetRole returns the role of the API key the request authenticated with, if any
+func GetRole(c *gin.Context) string {
+	return c.GetString(roleKey)
+}
+
+// apiKeyID identifies an API key in logs without revealing it
+func apiKeyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:4])
+}
+
+// isPublicPath reports whether a path is reachable without authentication
+func isPublicPath(path string) bool {
+	return path == "/health" || strings.HasPrefix(path, "/health/") || strings.HasPrefix(path, "/.well-known/")
+}
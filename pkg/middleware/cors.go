@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CORSMiddleware allows browser requests from the given origins, "*" allowing any, and answers preflight requests
+func CORSMiddleware(origins []string) gin.HandlerFunc {
+	allowAny := slices.Contains(origins, "*")
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		switch {
+		case allowAny:
+			c.Header("Access-Control-Allow-Origin", "*")
+		case slices.Contains(origins, origin):
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Vary", "Origin")
+		default:
+			c.Next()
+			return
+		}
+		c.Header("Access-Control-Expose-Headers", RequestIDHeader+", "+ExecutionIDHeader+", "+TaskIndexHeader+", Retry-After, ETag")
+
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			c.Header("Access-Control-Allow-Headers", "Authorization, Content-Type, Accept, "+APIKeyHeader+", "+RequestIDHeader+", X-Agent-Mode")
+			c.Header("Access-Control-Max-Age", "600")
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
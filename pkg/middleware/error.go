@@ -1,12 +1,17 @@
 package middleware
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
 	"net/http"
 	"template-custom-agent-go/pkg/logger"
 	"template-custom-agent-go/pkg/models"
-	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
 )
 
 // ErrorHandlerMiddleware provides consistent error handling across all endpoints
@@ -21,26 +26,42 @@ func ErrorHandlerMiddleware() gin.HandlerFunc {
 			err := c.Errors.Last()
 
 			// Log the error
-			logger.Errorf("Request error: %v, Path: %s, Method: %s", err.Error(), c.Request.URL.Path, c.Request.Method)
+			logger.ErrorfCtx(c.Request.Context(), "Request error: %v, Path: %s, Method: %s", err.Error(), c.Request.URL.Path, c.Request.Method)
 
-			// Determine status code if not already set
-			statusCode := c.Writer.Status()
-			if statusCode == http.StatusOK {
-				statusCode = http.StatusInternalServerError
-			}
-
-			// Create standardized error response
-			errorResp := models.ErrorResponse{
-				Error:     err.Error(),
-				Code:      statusCode,
-				Timestamp: time.Now(),
-				Path:      c.Request.URL.Path,
-			}
+			// Determine status code and error code from the error type
+			statusCode, errorCode := ClassifyError(err.Err, c.Writer.Status())
 
 			// Only send response if not already sent
 			if !c.Writer.Written() {
-				c.JSON(statusCode, errorResp)
+				writeError(c, statusCode, errorCode, err.Error())
 			}
 		}
 	})
 }
+
+// ClassifyError returns the HTTP status and stable error code for an error.
+// Typed errors take precedence over a status already set by the handler.
+func ClassifyError(err error, currentStatus int) (int, string) {
+	var apiErr *models.APIError
+	var validationErrs validator.ValidationErrors
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var netErr net.Error
+
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr.Status, apiErr.Code
+	case errors.As(err, &validationErrs):
+		return http.StatusUnprocessableEntity, models.CodeValidation
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return http.StatusBadRequest, models.CodeInvalidRequest
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return http.StatusGatewayTimeout, models.CodeUpstreamTimeout
+	}
+
+	// Fall back to the status set by the handler
+	if currentStatus != http.StatusOK {
+		return currentStatus, models.CodeForStatus(currentStatus)
+	}
+	return http.StatusInternalServerError, models.CodeInternal
+}
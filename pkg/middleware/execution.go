@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"template-custom-agent-go/pkg/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Headers carrying the Blaxel execution and task of the process
+const (
+	ExecutionIDHeader = "X-Blaxel-Execution-ID"
+	TaskIndexHeader   = "X-Blaxel-Task-Index"
+)
+
+// ExecutionMiddleware adds the execution and task identifiers to every response, so clients can
+// correlate it with the Blaxel execution that served it
+func ExecutionMiddleware(execution config.Execution) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if execution.ID != "" {
+			c.Header(ExecutionIDHeader, execution.ID)
+		}
+		if execution.TaskIndex != "" {
+			c.Header(TaskIndexHeader, execution.TaskIndex)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"template-custom-agent-go/pkg/i18n"
+
+	"github.com/gin-gonic/gin"
+)
+
+// languageKey is the Gin context key holding the language of server-generated strings
+const languageKey = "language"
+
+// LanguageMiddleware selects the language of server-generated strings from the Accept-Language
+// header, falling back to BL_LANGUAGE
+func LanguageMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(languageKey, i18n.Negotiate(c.GetHeader("Accept-Language")))
+		c.Next()
+	}
+}
+
+// GetLanguage returns the language of the current request
+func GetLanguage(c *gin.Context) string {
+	if language := c.GetString(languageKey); language != "" {
+		return language
+	}
+	return i18n.DefaultLanguage()
+}
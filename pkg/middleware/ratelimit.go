@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"template-custom-agent-go/pkg/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimiterIdleTTL is how long an idle client bucket is kept before being dropped
+const rateLimiterIdleTTL = 10 * time.Minute
+
+// tokenBucket holds the remaining requests of one client
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// rateLimiter is a token bucket rate limiter keyed by client
+type rateLimiter struct {
+	rate      float64
+	burst     float64
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// allow takes a token from the bucket of the client, returning how long to wait when there is none
+func (rl *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if now.Sub(rl.lastSweep) > rateLimiterIdleTTL {
+		for bucketKey, bucket := range rl.buckets {
+			if now.Sub(bucket.lastSeen) > rateLimiterIdleTTL {
+				delete(rl.buckets, bucketKey)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	bucket, exists := rl.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: rl.burst, lastSeen: now}
+		rl.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(rl.burst, bucket.tokens+now.Sub(bucket.lastSeen).Seconds()*rl.rate)
+	bucket.lastSeen = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / rl.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// RateLimitMiddleware limits each client, identified by its API key or IP address, to rps
+// requests per second with bursts of up to burst requests. Health probes are not limited.
+func RateLimitMiddleware(rps float64, burst int) gin.HandlerFunc {
+	limiter := &rateLimiter{
+		rate:      rps,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+
+	return func(c *gin.Context) {
+		if isPublicPath(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		key := "ip:" + c.ClientIP()
+		if apiKey := GetAPIKey(c); apiKey != "" {
+			key = "key:" + apiKey
+		}
+
+		allowed, wait := limiter.allow(key, time.Now())
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			abortWithError(c, http.StatusTooManyRequests, models.NewRateLimitError(fmt.Errorf("rate limit exceeded, retry in %s", wait.Round(time.Millisecond))))
+			return
+		}
+		c.Next()
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"runtime/debug"
 	"template-custom-agent-go/pkg/logger"
 	"template-custom-agent-go/pkg/models"
-	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -14,18 +13,10 @@ import (
 func CustomRecoveryMiddleware() gin.HandlerFunc {
 	return gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
 		// Log the panic with stack trace
-		logger.Errorf("PANIC RECOVERED: %v\n%s", recovered, debug.Stack())
-
-		// Create standardized error response
-		errorResp := models.ErrorResponse{
-			Error:     "Internal server error - panic recovered",
-			Code:      http.StatusInternalServerError,
-			Timestamp: time.Now(),
-			Path:      c.Request.URL.Path,
-		}
+		logger.ErrorfCtx(c.Request.Context(), "PANIC RECOVERED: %v\n%s", recovered, debug.Stack())
 
 		// Return error response and abort further processing
-		c.JSON(http.StatusInternalServerError, errorResp)
+		writeError(c, http.StatusInternalServerError, models.CodeInternal, "Internal server error - panic recovered")
 		c.Abort()
 	})
 }
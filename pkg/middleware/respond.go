@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"template-custom-agent-go/pkg/i18n"
+	"template-custom-agent-go/pkg/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// writeError sends an error response, as problem details when the client accepts them
+// or when BL_PROBLEM_JSON is enabled, and in the standard error format otherwise.
+// Both carry a user-facing message in the language of the request.
+func writeError(c *gin.Context, statusCode int, errorCode, message string) {
+	language := GetLanguage(c)
+
+	if wantsProblemJSON(c) {
+		problemType := "about:blank"
+		if base := os.Getenv("BL_PROBLEM_TYPE_BASE"); base != "" {
+			problemType = strings.TrimSuffix(base, "/") + "/" + errorCode
+		}
+
+		// Titles stay the standard status text in English and are localized otherwise
+		title := http.StatusText(statusCode)
+		if language != i18n.English {
+			title = i18n.ErrorMessage(language, errorCode)
+		}
+
+		body, _ := json.Marshal(models.ProblemDetails{
+			Type:      problemType,
+			Title:     title,
+			Status:    statusCode,
+			Detail:    message,
+			Instance:  c.Request.URL.Path,
+			RequestID: GetRequestID(c),
+			ErrorCode: errorCode,
+		})
+		c.Data(statusCode, models.ProblemContentType, body)
+		return
+	}
+
+	c.JSON(statusCode, models.ErrorResponse{
+		Error:     message,
+		ErrorCode: errorCode,
+		Message:   i18n.ErrorMessage(language, errorCode),
+		Code:      statusCode,
+		Timestamp: time.Now(),
+		Path:      c.Request.URL.Path,
+		RequestID: GetRequestID(c),
+	})
+}
+
+// wantsProblemJSON reports whether errors should be sent as RFC 9457 problem details
+func wantsProblemJSON(c *gin.Context) bool {
+	if os.Getenv("BL_PROBLEM_JSON") == "true" {
+		return true
+	}
+	return strings.Contains(c.GetHeader("Accept"), models.ProblemContentType)
+}
+
+// abortWithError records the error for ErrorHandlerMiddleware and stops the handler chain
+func abortWithError(c *gin.Context, status int, err error) {
+	c.Error(err)
+	c.Status(status)
+	c.Abort()
+}
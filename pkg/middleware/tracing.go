@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"crypto/rand"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the spans created by this server
+const tracerName = "template-custom-agent-go"
+
+// traceContext reads and writes W3C traceparent and tracestate headers
+var traceContext = propagation.TraceContext{}
+
+// TracingMiddleware starts a server span for each request, continuing the trace of an incoming
+// traceparent header, and stores it in the request context so logs written with it carry the trace
+// and span IDs. Without a tracer provider the span is not recorded, but the request still gets its
+// own span ID, within the incoming trace or a new one, to correlate its logs.
+func TracingMiddleware() gin.HandlerFunc {
+	tracer := otel.Tracer(tracerName)
+	return func(c *gin.Context) {
+		ctx := traceContext.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		ctx, span := tracer.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("request.id", GetRequestID(c)),
+			))
+		defer span.End()
+
+		if !span.SpanContext().IsValid() || !span.IsRecording() {
+			ctx = trace.ContextWithSpanContext(ctx, localSpanContext(trace.SpanContextFromContext(ctx)))
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, c.Errors.String())
+		}
+	}
+}
+
+// localSpanContext creates the span context of an unrecorded request span, a child of the incoming
+// span when there is one
+func localSpanContext(parent trace.SpanContext) trace.SpanContext {
+	config := trace.SpanContextConfig{
+		TraceID:    parent.TraceID(),
+		TraceFlags: parent.TraceFlags(),
+		TraceState: parent.TraceState(),
+	}
+	if !config.TraceID.IsValid() {
+		rand.Read(config.TraceID[:])
+	}
+	rand.Read(config.SpanID[:])
+	return trace.NewSpanContext(config)
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// Stable error codes returned in error responses
+const (
+	CodeInvalidRequest  = "invalid_request"
+	CodeValidation      = "validation_error"
+	CodeUnauthorized    = "unauthorized"
+	CodeForbidden       = "forbidden"
+	CodeNotFound        = "not_found"
+	CodeNotAcceptable   = "not_acceptable"
+	CodeConflict        = "conflict"
+	CodeRateLimited     = "rate_limited"
+	CodeUpstreamError   = "upstream_error"
+	CodeUpstreamTimeout = "upstream_timeout"
+	CodeUnavailable     = "service_unavailable"
+	CodeInternal        = "internal_error"
+)
+
+// APIError is an error carrying the HTTP status and stable code it should be reported with
+type APIError struct {
+	Status int
+	Code   string
+	Err    error
+}
+
+// Error returns the message of the wrapped error
+func (e *APIError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// NewInvalidRequestError reports a malformed request body (400)
+func NewInvalidRequestError(err error) *APIError {
+	return &APIError{Status: http.StatusBadRequest, Code: CodeInvalidRequest, Err: err}
+}
+
+// NewValidationError reports a well-formed request failing validation (422)
+func NewValidationError(err error) *APIError {
+	return &APIError{Status: http.StatusUnprocessableEntity, Code: CodeValidation, Err: err}
+}
+
+// NewUnauthorizedError reports missing or invalid credentials (401)
+func NewUnauthorizedError(err error) *APIError {
+	return &APIError{Status: http.StatusUnauthorized, Code: CodeUnauthorized, Err: err}
+}
+
+// NewForbiddenError reports credentials lacking the required permissions (403)
+func NewForbiddenError(err error) *APIError {
+	return &APIError{Status: http.StatusForbidden, Code: CodeForbidden, Err: err}
+}
+
+// NewNotFoundError reports a missing resource (404)
+func NewNotFoundError(err error) *APIError {
+	return &APIError{Status: http.StatusNotFound, Code: CodeNotFound, Err: err}
+}
+
+// NewNotAcceptableError reports a response format the client accepts not being available (406)
+func NewNotAcceptableError(err error) *APIError {
+	return &APIError{Status: http.StatusNotAcceptable, Code: CodeNotAcceptable, Err: err}
+}
+
+// NewConflictError reports a request conflicting with the current state of a resource (409)
+func NewConflictError(err error) *APIError {
+	return &APIError{Status: http.StatusConflict, Code: CodeConflict, Err: err}
+}
+
+// NewRateLimitError reports a rate limit being hit (429)
+func NewRateLimitError(err error) *APIError {
+	return &APIError{Status: http.StatusTooManyRequests, Code: CodeRateLimited, Err: err}
+}
+
+// NewUpstreamTimeoutError reports an upstream model or tool call timing out (504)
+func NewUpstreamTimeoutError(err error) *APIError {
+	return &APIError{Status: http.StatusGatewayTimeout, Code: CodeUpstreamTimeout, Err: err}
+}
+
+// NewUpstreamError reports an upstream model or tool call failing (502)
+func NewUpstreamError(err error) *APIError {
+	return &APIError{Status: http.StatusBadGateway, Code: CodeUpstreamError, Err: err}
+}
+
+// NewUnavailableError reports the service not being able to handle the request yet (503)
+func NewUnavailableError(err error) *APIError {
+	return &APIError{Status: http.StatusServiceUnavailable, Code: CodeUnavailable, Err: err}
+}
+
+// NewUpstreamStatusError maps an error status returned by an upstream service to an API error
+func NewUpstreamStatusError(status int, err error) *APIError {
+	switch status {
+	case http.StatusTooManyRequests:
+		return NewRateLimitError(err)
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return NewUpstreamTimeoutError(err)
+	default:
+		return NewUpstreamError(err)
+	}
+}
+
+// NewUpstreamTransportError maps a failure to reach an upstream service to an API error
+func NewUpstreamTransportError(err error) *APIError {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return NewUpstreamTimeoutError(err)
+	}
+	return NewUpstreamError(err)
+}
+
+// CodeForStatus returns the stable error code matching an HTTP status
+func CodeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnprocessableEntity:
+		return CodeValidation
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusNotAcceptable:
+		return CodeNotAcceptable
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusBadGateway:
+		return CodeUpstreamError
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	case http.StatusGatewayTimeout:
+		return CodeUpstreamTimeout
+	default:
+		return CodeInternal
+	}
+}
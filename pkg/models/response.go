@@ -5,7 +5,10 @@ import "time"
 // ErrorResponse represents a standard error response format
 type ErrorResponse struct {
 	Error     string    `json:"error"`
+	ErrorCode string    `json:"error_code"`
+	Message   string    `json:"message"` // User-facing description of the error code in the request language
 	Code      int       `json:"code"`
 	Timestamp time.Time `json:"timestamp"`
 	Path      string    `json:"path"`
+	RequestID string    `json:"request_id,omitempty"`
 }
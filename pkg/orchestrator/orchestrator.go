@@ -0,0 +1,243 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+
+	"template-custom-agent-go/pkg/agent"
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/logger"
+)
+
+// DelegateToolPrefix prefixes the names of the tools delegating to other agents
+const DelegateToolPrefix = "delegate_"
+
+// agentNamePattern leaves room for the delegate tool prefix within the 64 characters of tool names
+var agentNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,55}$`)
+
+// AgentSpec declares a named agent with its own model, prompt and tools
+type AgentSpec struct {
+	Name string `json:"name"`
+	// Description tells supervisors what the agent is good at
+	Description   string `json:"description,omitempty" binding:"max=1024"`
+	Model         string `json:"model,omitempty" binding:"max=128"`
+	SystemPrompt  string `json:"system_prompt,omitempty"`
+	MaxIterations int    `json:"max_iterations,omitempty" binding:"min=0,max=100"`
+	// Tools restricts the agent to these tools when not null, an empty list allowing only its delegation
+	// tools, and MCPServers restricts it to the tools of these servers
+	Tools      []string `json:"tools" binding:"omitempty,max=100,dive,min=1,max=128"`
+	MCPServers []string `json:"mcp_servers,omitempty" binding:"omitempty,max=10,dive,min=1,max=128"`
+	// Delegates are the agents this agent supervises, each exposed to it as a delegate_<name> tool
+	Delegates []string `json:"delegates,omitempty" binding:"omitempty,max=20,dive,min=1,max=55"`
+}
+
+// AllowedTools returns the tools the agent may call, nil when not restricted: its tools and those
+// delegating to its delegates
+func (s AgentSpec) AllowedTools() []string {
+	if s.Tools == nil {
+		return nil
+	}
+	allowed := append([]string{}, s.Tools...)
+	for _, delegate := range s.Delegates {
+		allowed = append(allowed, DelegateToolPrefix+delegate)
+	}
+	return allowed
+}
+
+// Graph is the set of named agents and their delegations, which form no cycle
+type Graph struct {
+	agents map[string]AgentSpec
+	order  []string
+}
+
+// NewGraph validates the agents: names must be unique tool-safe identifiers, delegates must be
+// declared and delegations must not loop
+func NewGraph(specs []AgentSpec) (*Graph, error) {
+	graph := &Graph{agents: make(map[string]AgentSpec, len(specs))}
+	for _, spec := range specs {
+		if !agentNamePattern.MatchString(spec.Name) {
+			return nil, fmt.Errorf("invalid agent name %q: use up to 55 letters, digits, underscores or dashes", spec.Name)
+		}
+		if _, exists := graph.agents[spec.Name]; exists {
+			return nil, fmt.Errorf("agent %s is declared twice", spec.Name)
+		}
+		graph.agents[spec.Name] = spec
+		graph.order = append(graph.order, spec.Name)
+	}
+	for _, spec := range specs {
+		for _, delegate := range spec.Delegates {
+			if _, exists := graph.agents[delegate]; !exists {
+				return nil, fmt.Errorf("agent %s delegates to undeclared agent %s", spec.Name, delegate)
+			}
+		}
+	}
+	if cycle := graph.findCycle(); cycle != nil {
+		return nil, fmt.Errorf("agents delegate to each other in a loop: %v", cycle)
+	}
+	return graph, nil
+}
+
+// findCycle returns the agents of a delegation loop, nil when there is none
+func (g *Graph) findCycle() []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(g.agents))
+	var path []string
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		switch state[name] {
+		case visiting:
+			for i, step := range path {
+				if step == name {
+					return append(append([]string{}, path[i:]...), name)
+				}
+			}
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, delegate := range g.agents[name].Delegates {
+			if cycle := visit(delegate); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+	for _, name := range g.order {
+		if cycle := visit(name); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// graphFile is the JSON of agents config files
+type graphFile struct {
+	Agents []AgentSpec `json:"agents"`
+}
+
+// LoadGraph reads the agents of a config file, {"agents": [{"name": "...", ...}]}
+func LoadGraph(path string) (*Graph, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agents config file: %w", err)
+	}
+	var config graphFile
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse agents config file: %w", err)
+	}
+	graph, err := NewGraph(config.Agents)
+	if err != nil {
+		return nil, fmt.Errorf("invalid agents config file: %w", err)
+	}
+	return graph, nil
+}
+
+// Agent returns the spec of a named agent
+func (g *Graph) Agent(name string) (AgentSpec, bool) {
+	spec, exists := g.agents[name]
+	return spec, exists
+}
+
+// Agents returns the specs in declaration order
+func (g *Graph) Agents() []AgentSpec {
+	specs := make([]AgentSpec, 0, len(g.order))
+	for _, name := range g.order {
+		specs = append(specs, g.agents[name])
+	}
+	return specs
+}
+
+// Builder creates the agent of a spec with its model, prompt and tools, without delegation tools
+type Builder func(ctx context.Context, spec AgentSpec) (*agent.Agent, error)
+
+// Orchestrator runs the agents of a registry, supervisors handing sub-tasks to their delegates
+// through tools
+type Orchestrator struct {
+	registry *Registry
+	build    Builder
+}
+
+// New creates an orchestrator building the agents of the registry with the builder
+func New(registry *Registry, build Builder) *Orchestrator {
+	return &Orchestrator{registry: registry, build: build}
+}
+
+// Registry returns the agents of the orchestrator
+func (o *Orchestrator) Registry() *Registry {
+	return o.registry
+}
+
+// AddDelegates gives an agent built for the spec the tools delegating to its delegates, as they are
+// declared when it is built
+func (o *Orchestrator) AddDelegates(a *agent.Agent, spec AgentSpec) {
+	graph := o.registry.Graph()
+	for _, name := range spec.Delegates {
+		delegate, exists := graph.Agent(name)
+		if !exists {
+			continue
+		}
+		description := fmt.Sprintf("Delegate a sub-task to the %s agent and return its answer.", name)
+		if delegate.Description != "" {
+			description += " " + delegate.Description
+		}
+		a.AddTool(blaxel.Tool{
+			Type: "function",
+			Function: blaxel.Function{
+				Name:        DelegateToolPrefix + name,
+				Description: description,
+				Parameters: map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"task": map[string]interface{}{
+							"type":        "string",
+							"description": "The sub-task, with all the context the agent needs to complete it",
+						},
+					},
+					"required": []string{"task"},
+				},
+			},
+		}, func(ctx context.Context, arguments map[string]interface{}) (string, error) {
+			task, _ := arguments["task"].(string)
+			if task == "" {
+				return agent.ToolError("missing required argument: task"), nil
+			}
+			return o.delegate(ctx, spec.Name, delegate, task)
+		})
+	}
+}
+
+// delegate runs a sub-task with the delegate agent and returns its answer. Failures of the delegate
+// are returned as the result so the supervisor can retry or do without, only the cancellation of
+// the run failing it.
+func (o *Orchestrator) delegate(ctx context.Context, supervisor string, spec AgentSpec, task string) (string, error) {
+	logger.InfofCtx(ctx, "Agent %s delegated a sub-task to agent %s", supervisor, spec.Name)
+	delegate, err := o.build(ctx, spec)
+	if err != nil {
+		logger.WarningfCtx(ctx, "Agent %s could not delegate to agent %s: %v", supervisor, spec.Name, err)
+		return agent.ToolError(fmt.Sprintf("agent %s could not be started: %v", spec.Name, err)), nil
+	}
+	o.AddDelegates(delegate, spec)
+
+	response, err := delegate.Run(ctx, task)
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("agent %s cancelled: %w", spec.Name, ctx.Err())
+	}
+	if err != nil {
+		logger.WarningfCtx(ctx, "Agent %s failed the sub-task of agent %s: %v", spec.Name, supervisor, err)
+		return agent.ToolError(fmt.Sprintf("agent %s failed: %v", spec.Name, err)), nil
+	}
+	if len(response.Choices) == 0 || response.Choices[0].Message.Content == "" {
+		return agent.ToolError(fmt.Sprintf("agent %s gave no answer", spec.Name)), nil
+	}
+	return response.Choices[0].Message.Content, nil
+}
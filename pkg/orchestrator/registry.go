@@ -0,0 +1,174 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"template-custom-agent-go/pkg/logger"
+)
+
+// Errors of registry changes
+var (
+	ErrAgentNotFound = errors.New("agent not found")
+	ErrAgentExists   = errors.New("agent already exists")
+	// ErrAgentInUse is returned when deleting an agent other agents delegate to
+	ErrAgentInUse = errors.New("agent is delegated to")
+	// ErrInvalidAgent is returned when a change would leave an invalid agent graph
+	ErrInvalidAgent = errors.New("invalid agent")
+)
+
+// Registry holds the named agents, created, replaced and deleted while the server runs. Changes are
+// written to the config file of the registry when it has one, and kept in memory otherwise.
+type Registry struct {
+	mu    sync.RWMutex
+	graph *Graph
+	path  string
+	// validate checks what the graph cannot, such as the MCP servers of an agent existing
+	validate func(AgentSpec) error
+}
+
+// NewRegistry creates a registry of the agents of the graph, writing changes to the config file at
+// path unless it is empty. validate checks every agent added or replaced when not nil.
+func NewRegistry(graph *Graph, path string, validate func(AgentSpec) error) (*Registry, error) {
+	if validate != nil {
+		for _, spec := range graph.Agents() {
+			if err := validate(spec); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &Registry{graph: graph, path: path, validate: validate}, nil
+}
+
+// NewRegistryFromEnv creates a registry backed by the BL_AGENTS_CONFIG file, which is created with
+// the first change when it does not exist. Without it, agents only live in memory.
+func NewRegistryFromEnv(validate func(AgentSpec) error) (*Registry, error) {
+	path := os.Getenv("BL_AGENTS_CONFIG")
+	graph, _ := NewGraph(nil)
+	switch _, err := os.Stat(path); {
+	case path == "":
+		logger.Debugf("BL_AGENTS_CONFIG not set, named agents are kept in memory")
+	case errors.Is(err, os.ErrNotExist):
+		logger.Infof("Agents config file %s does not exist yet, it is created with the first agent", path)
+	default:
+		if graph, err = LoadGraph(path); err != nil {
+			return nil, err
+		}
+		logger.Infof("Loaded %d named agents from %s", len(graph.order), path)
+	}
+	return NewRegistry(graph, path, validate)
+}
+
+// Graph returns the agents as currently declared
+func (r *Registry) Graph() *Graph {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.graph
+}
+
+// Path returns the config file changes are written to, empty when agents are kept in memory
+func (r *Registry) Path() string {
+	return r.path
+}
+
+// Create adds an agent
+func (r *Registry) Create(spec AgentSpec) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.graph.Agent(spec.Name); exists {
+		return fmt.Errorf("%w: %s", ErrAgentExists, spec.Name)
+	}
+	return r.apply(append(r.graph.Agents(), spec), &spec)
+}
+
+// Update replaces an agent, keeping its place in the declaration order
+func (r *Registry) Update(spec AgentSpec) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.graph.Agent(spec.Name); !exists {
+		return fmt.Errorf("%w: %s", ErrAgentNotFound, spec.Name)
+	}
+	specs := r.graph.Agents()
+	for i := range specs {
+		if specs[i].Name == spec.Name {
+			specs[i] = spec
+		}
+	}
+	return r.apply(specs, &spec)
+}
+
+// Delete removes an agent no other agent delegates to
+func (r *Registry) Delete(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.graph.Agent(name); !exists {
+		return fmt.Errorf("%w: %s", ErrAgentNotFound, name)
+	}
+	var specs []AgentSpec
+	var supervisors []string
+	for _, spec := range r.graph.Agents() {
+		if spec.Name == name {
+			continue
+		}
+		for _, delegate := range spec.Delegates {
+			if delegate == name {
+				supervisors = append(supervisors, spec.Name)
+			}
+		}
+		specs = append(specs, spec)
+	}
+	if len(supervisors) > 0 {
+		return fmt.Errorf("%w: %s is a delegate of %s", ErrAgentInUse, name, strings.Join(supervisors, ", "))
+	}
+	return r.apply(specs, nil)
+}
+
+// apply validates the changed agent and the new graph, then saves it and replaces the current one
+func (r *Registry) apply(specs []AgentSpec, changed *AgentSpec) error {
+	if changed != nil && r.validate != nil {
+		if err := r.validate(*changed); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidAgent, err)
+		}
+	}
+	graph, err := NewGraph(specs)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidAgent, err)
+	}
+	if r.path != "" {
+		if err := saveGraph(r.path, graph); err != nil {
+			return err
+		}
+	}
+	r.graph = graph
+	return nil
+}
+
+// saveGraph writes the agents to the config file, through a temporary file renamed over it so the
+// file is never left half written
+func saveGraph(path string, graph *Graph) error {
+	data, err := json.MarshalIndent(graphFile{Agents: graph.Agents()}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode agents config file: %w", err)
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write agents config file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write agents config file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write agents config file: %w", err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("failed to write agents config file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,451 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"template-custom-agent-go/pkg/a2a"
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/logger"
+	"template-custom-agent-go/pkg/metrics"
+	"template-custom-agent-go/pkg/middleware"
+	"template-custom-agent-go/pkg/models"
+	"template-custom-agent-go/pkg/stream"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// setupA2ARoutes sets up the agent-to-agent (A2A) protocol routes
+func (r *Router) setupA2ARoutes(engine *gin.Engine) {
+	engine.GET("/.well-known/agent.json", r.agentCard)
+	engine.GET("/.well-known/agent-card.json", r.agentCard)
+	engine.POST("/a2a", r.a2aRPC)
+}
+
+// agentCard handles A2A agent card discovery requests
+func (r *Router) agentCard(c *gin.Context) {
+	name := os.Getenv("BL_NAME")
+	if name == "" {
+		name = "template-custom-agent-go"
+	}
+
+	url := os.Getenv("BL_A2A_URL")
+	if url == "" {
+		scheme := "http"
+		if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		url = fmt.Sprintf("%s://%s/a2a", scheme, c.Request.Host)
+	}
+
+	c.JSON(http.StatusOK, a2a.AgentCard{
+		ProtocolVersion: a2a.ProtocolVersion,
+		Name:            name,
+		Description:     "AI agent with multi-MCP server tool calling",
+		URL:             url,
+		Version:         "1.0.0",
+		Capabilities: a2a.AgentCapabilities{
+			Streaming: true,
+		},
+		DefaultInputModes:  []string{"text/plain"},
+		DefaultOutputModes: []string{"text/plain"},
+		Skills: []a2a.AgentSkill{
+			{
+				ID:          "agent",
+				Name:        "Agent",
+				Description: "Answers questions and performs tasks using the tools of all connected MCP servers",
+				Tags:        []string{"assistant", "tools", "mcp"},
+			},
+		},
+	})
+}
+
+// a2aRPC handles A2A JSON-RPC requests
+func (r *Router) a2aRPC(c *gin.Context) {
+	var request a2a.JSONRPCRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusOK, a2a.NewError(nil, a2a.ErrCodeParse, fmt.Sprintf("invalid JSON-RPC request: %v", err)))
+		return
+	}
+	if request.JSONRPC != "2.0" || request.Method == "" {
+		c.JSON(http.StatusOK, a2a.NewError(request.ID, a2a.ErrCodeInvalidRequest, "invalid JSON-RPC request"))
+		return
+	}
+
+	switch request.Method {
+	case "message/send":
+		r.a2aSendMessage(c, request)
+	case "message/stream":
+		r.a2aStreamMessage(c, request)
+	case "tasks/get":
+		r.a2aGetTask(c, request)
+	case "tasks/cancel":
+		r.a2aCancelTask(c, request)
+	case "tasks/resubscribe":
+		r.a2aResubscribe(c, request)
+	default:
+		c.JSON(http.StatusOK, a2a.NewError(request.ID, a2a.ErrCodeMethodNotFound, fmt.Sprintf("method %s not found", request.Method)))
+	}
+}
+
+// a2aSendMessage creates a task from the message and runs it, blocking unless told otherwise
+func (r *Router) a2aSendMessage(c *gin.Context, request a2a.JSONRPCRequest) {
+	params, ok := parseMessageParams(c, request)
+	if !ok {
+		return
+	}
+
+	task := r.a2aTasks.Create(params.Message)
+	blocking := params.Configuration == nil || params.Configuration.Blocking == nil || *params.Configuration.Blocking
+
+	if !blocking {
+		// Keep the values of the request, such as its trace, but not its cancellation
+		ctx, cancel := context.WithCancel(context.WithoutCancel(c.Request.Context()))
+		r.a2aTasks.SetCancel(task.ID, cancel)
+		go func() {
+			defer cancel()
+			r.executeA2ATask(ctx, task, params.Message.Text(), nil)
+		}()
+		c.JSON(http.StatusOK, a2a.NewResult(request.ID, task))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	r.a2aTasks.SetCancel(task.ID, cancel)
+
+	result, _ := r.executeA2ATask(ctx, task, params.Message.Text(), nil)
+	r.signedJSON(c, http.StatusOK, a2a.NewResult(request.ID, result))
+}
+
+// a2aStreamMessage creates a task from the message and streams its updates as server-sent events.
+// The updates go through the stream hub so other clients can follow the task with tasks/resubscribe.
+// The task outlives this client: it is only cancelled by tasks/cancel or once no subscriber is left.
+func (r *Router) a2aStreamMessage(c *gin.Context, request a2a.JSONRPCRequest) {
+	start := time.Now()
+	params, ok := parseMessageParams(c, request)
+	if !ok {
+		return
+	}
+
+	task := r.a2aTasks.Create(params.Message)
+
+	ctx, cancel := context.WithCancel(context.WithoutCancel(c.Request.Context()))
+	r.a2aTasks.SetCancel(task.ID, cancel)
+
+	r.a2aStreams.Open(task.ID)
+	subscription, _ := r.a2aStreams.Subscribe(task.ID)
+	defer r.unsubscribeA2A(task.ID, subscription)
+
+	// Run the task apart from the client so writing to it never holds up the agent
+	failed := make(chan error, 1)
+	go func() {
+		defer cancel()
+		defer r.a2aStreams.Close(task.ID)
+		_, err := r.executeA2ATask(ctx, task, params.Message.Text(), func(result interface{}) {
+			r.a2aStreams.Publish(task.ID, result)
+		})
+		failed <- err
+	}()
+
+	// Artifact updates carry the answer, they are the tokens of the stream
+	timing := metrics.StreamTiming{Endpoint: c.FullPath(), Start: start}
+	defer func() {
+		timing.End = time.Now()
+		r.observeStream(timing)
+	}()
+
+	startSSE(c)
+	if err := r.writeA2AEvent(c, request.ID, task); err != nil {
+		logger.Debugf("Stopped streaming A2A task %s: %v", task.ID, err)
+		return
+	}
+	if !r.forwardA2AEvents(c, request.ID, task.ID, subscription, func(result interface{}) {
+		if _, isArtifact := result.(a2a.TaskArtifactUpdateEvent); isArtifact {
+			timing.LastToken = time.Now()
+			if timing.Tokens == 0 {
+				timing.FirstToken = timing.LastToken
+			}
+			timing.Tokens++
+		}
+	}) {
+		return
+	}
+
+	// The events end before the task when this client was disconnected for falling behind
+	if subscription.Dropped() {
+		writeA2ADroppedError(c, task.ID)
+		return
+	}
+	select {
+	case err := <-failed:
+		if err != nil {
+			_, errorCode := middleware.ClassifyError(err, http.StatusInternalServerError)
+			stream.WriteSSEError(c.Writer, stream.ErrorEvent{
+				Error:     err.Error(),
+				ErrorCode: errorCode,
+				RequestID: middleware.GetRequestID(c),
+			})
+			c.Writer.Flush()
+		}
+	default:
+	}
+}
+
+// a2aResubscribe streams the updates of a task started by message/stream to another client, such
+// as a monitoring dashboard, starting with the current state of the task
+func (r *Router) a2aResubscribe(c *gin.Context, request a2a.JSONRPCRequest) {
+	var params a2a.TaskIDParams
+	if err := json.Unmarshal(request.Params, &params); err != nil || params.ID == "" {
+		c.JSON(http.StatusOK, a2a.NewError(request.ID, a2a.ErrCodeInvalidParams, "params.id is required"))
+		return
+	}
+
+	// Subscribe before reading the task so no update is missed in between
+	subscription, inFlight := r.a2aStreams.Subscribe(params.ID)
+	if inFlight {
+		defer r.unsubscribeA2A(params.ID, subscription)
+	}
+	task, exists := r.a2aTasks.Get(params.ID)
+	if !exists {
+		c.JSON(http.StatusOK, a2a.NewError(request.ID, a2a.ErrCodeTaskNotFound, "task not found"))
+		return
+	}
+
+	startSSE(c)
+	if err := r.writeA2AEvent(c, request.ID, task); err != nil || !inFlight {
+		return
+	}
+	if r.forwardA2AEvents(c, request.ID, task.ID, subscription, nil) && subscription.Dropped() {
+		writeA2ADroppedError(c, task.ID)
+	}
+}
+
+// forwardA2AEvents writes the updates of a task to a client, calling sent after each one, until the
+// events end. It reports false when the client went away first.
+func (r *Router) forwardA2AEvents(c *gin.Context, id interface{}, taskID string, subscription *stream.Subscription, sent func(interface{})) bool {
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			logger.Debugf("Client of A2A task %s went away", taskID)
+			return false
+		case result, open := <-subscription.Events():
+			if !open {
+				return true
+			}
+			if err := r.writeA2AEvent(c, id, result); err != nil {
+				logger.Debugf("Stopped streaming A2A task %s: %v", taskID, err)
+				return false
+			}
+			if sent != nil {
+				sent(result)
+			}
+		}
+	}
+}
+
+// unsubscribeA2A stops streaming a task to a client, cancelling the task when it was its last subscriber
+func (r *Router) unsubscribeA2A(taskID string, subscription *stream.Subscription) {
+	if !subscription.Unsubscribe() {
+		return
+	}
+	logger.Infof("Cancelling A2A task %s, its last subscriber left", taskID)
+	if _, err := r.a2aTasks.Cancel(taskID); err != nil {
+		logger.Debugf("A2A task %s not cancelled: %v", taskID, err)
+	}
+}
+
+// writeA2ADroppedError ends the stream of a client disconnected for falling behind the updates of a
+// task with an error event, so it can tell the stream from a finished one
+func writeA2ADroppedError(c *gin.Context, taskID string) {
+	stream.WriteSSEError(c.Writer, stream.ErrorEvent{
+		Error:     fmt.Sprintf("stream of task %s closed after falling behind its updates", taskID),
+		ErrorCode: stream.ErrorCodeSlowSubscriber,
+		RequestID: middleware.GetRequestID(c),
+	})
+	c.Writer.Flush()
+}
+
+// startSSE writes the headers of a server-sent events response
+func startSSE(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+}
+
+// writeA2AEvent writes a task or task update as a JSON-RPC result event and flushes it, failing
+// when the client does not accept it within the stream write timeout
+func (r *Router) writeA2AEvent(c *gin.Context, id interface{}, result interface{}) error {
+	data, err := json.Marshal(a2a.NewResult(id, result))
+	if err != nil {
+		logger.Errorf("Failed to marshal A2A event: %v", err)
+		return nil
+	}
+
+	if timeout := r.a2aStreams.WriteTimeout(); timeout > 0 {
+		controller := http.NewResponseController(c.Writer)
+		controller.SetWriteDeadline(time.Now().Add(timeout))
+		defer controller.SetWriteDeadline(time.Time{})
+	}
+	if _, err := fmt.Fprintf(c.Writer, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+// a2aGetTask returns the current state of a task
+func (r *Router) a2aGetTask(c *gin.Context, request a2a.JSONRPCRequest) {
+	var params a2a.TaskIDParams
+	if err := json.Unmarshal(request.Params, &params); err != nil || params.ID == "" {
+		c.JSON(http.StatusOK, a2a.NewError(request.ID, a2a.ErrCodeInvalidParams, "params.id is required"))
+		return
+	}
+
+	task, exists := r.a2aTasks.Get(params.ID)
+	if !exists {
+		c.JSON(http.StatusOK, a2a.NewError(request.ID, a2a.ErrCodeTaskNotFound, "task not found"))
+		return
+	}
+
+	r.signedJSON(c, http.StatusOK, a2a.NewResult(request.ID, task))
+}
+
+// a2aCancelTask cancels a running task
+func (r *Router) a2aCancelTask(c *gin.Context, request a2a.JSONRPCRequest) {
+	var params a2a.TaskIDParams
+	if err := json.Unmarshal(request.Params, &params); err != nil || params.ID == "" {
+		c.JSON(http.StatusOK, a2a.NewError(request.ID, a2a.ErrCodeInvalidParams, "params.id is required"))
+		return
+	}
+
+	if _, exists := r.a2aTasks.Get(params.ID); !exists {
+		c.JSON(http.StatusOK, a2a.NewError(request.ID, a2a.ErrCodeTaskNotFound, "task not found"))
+		return
+	}
+
+	task, err := r.a2aTasks.Cancel(params.ID)
+	if err != nil {
+		c.JSON(http.StatusOK, a2a.NewError(request.ID, a2a.ErrCodeTaskNotCancelable, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, a2a.NewResult(request.ID, task))
+}
+
+// executeA2ATask runs the agent for a task, recording progress in the task store and
+// forwarding status and artifact updates to emit when it is set. The returned error is
+// the reason the task failed, if it did.
+func (r *Router) executeA2ATask(ctx context.Context, task a2a.Task, input string, emit func(interface{})) (a2a.Task, error) {
+	updateStatus := func(state a2a.TaskState, message *a2a.Message) a2a.Task {
+		updated, err := r.a2aTasks.UpdateStatus(task.ID, state, message)
+		if err != nil {
+			logger.Debugf("A2A task %s status not updated to %s: %v", task.ID, state, err)
+			return updated
+		}
+		if emit != nil {
+			emit(a2a.TaskStatusUpdateEvent{
+				Kind:      "status-update",
+				TaskID:    task.ID,
+				ContextID: task.ContextID,
+				Status:    updated.Status,
+				Final:     state.IsTerminal(),
+			})
+		}
+		return updated
+	}
+	agentMessage := func(parts ...a2a.Part) *a2a.Message {
+		return &a2a.Message{
+			Kind:      "message",
+			MessageID: uuid.NewString(),
+			Role:      "agent",
+			Parts:     parts,
+			TaskID:    task.ID,
+			ContextID: task.ContextID,
+		}
+	}
+	errorMessage := func(err error) *a2a.Message {
+		return agentMessage(a2a.Part{Kind: "text", Text: err.Error()})
+	}
+
+	updateStatus(a2a.TaskStateWorking, nil)
+
+	a2aAgent, err := r.buildAgent(ctx, "a2a-agent", agentRequest{Inputs: input})
+	if err != nil {
+		return updateStatus(a2a.TaskStateFailed, errorMessage(err)), err
+	}
+
+	start := time.Now()
+	response, err := a2aAgent.Run(blaxel.WithRunID(ctx, task.ID), input)
+	r.recordRun(runRecord{ID: task.ID, Endpoint: "/a2a", Start: start}, a2aAgent, response, err)
+	if err != nil {
+		logger.ErrorfCtx(ctx, "A2A task %s failed: %v", task.ID, err)
+		return updateStatus(a2a.TaskStateFailed, errorMessage(err)), err
+	}
+	if len(response.Choices) == 0 {
+		err := models.NewUpstreamError(fmt.Errorf("no response generated"))
+		return updateStatus(a2a.TaskStateFailed, errorMessage(err)), err
+	}
+
+	parts := r.resultParts(ctx, task.ID, response.Choices[0].Message.Content)
+	artifact := a2a.Artifact{
+		ArtifactID: uuid.NewString(),
+		Name:       "response",
+		Parts:      parts,
+	}
+	if err := r.a2aTasks.AddArtifact(task.ID, artifact); err != nil {
+		logger.ErrorfCtx(ctx, "Failed to store artifact for A2A task %s: %v", task.ID, err)
+	}
+	if emit != nil {
+		emit(a2a.TaskArtifactUpdateEvent{
+			Kind:      "artifact-update",
+			TaskID:    task.ID,
+			ContextID: task.ContextID,
+			Artifact:  artifact,
+			LastChunk: true,
+		})
+	}
+
+	return updateStatus(a2a.TaskStateCompleted, agentMessage(parts...)), nil
+}
+
+// resultParts returns the parts carrying a task result: a file part with a presigned URL when the
+// result exceeds the result storage threshold, the text itself otherwise or when the upload fails
+func (r *Router) resultParts(ctx context.Context, taskID, content string) []a2a.Part {
+	if r.resultStore == nil || !r.resultStore.ShouldStore(len(content)) {
+		return []a2a.Part{{Kind: "text", Text: content}}
+	}
+
+	url, err := r.resultStore.Store(ctx, "a2a/"+taskID+"/response.txt", "text/plain; charset=utf-8",
+		strings.NewReader(content), int64(len(content)))
+	if err != nil {
+		logger.ErrorfCtx(ctx, "Failed to store result of A2A task %s, returning it inline: %v", taskID, err)
+		return []a2a.Part{{Kind: "text", Text: content}}
+	}
+	logger.InfofCtx(ctx, "Stored %d byte result of A2A task %s", len(content), taskID)
+	return []a2a.Part{{
+		Kind: "file",
+		File: &a2a.FileContent{Name: "response.txt", MimeType: "text/plain", URI: url},
+	}}
+}
+
+// parseMessageParams decodes message/send params, writing a JSON-RPC error when they are invalid
+func parseMessageParams(c *gin.Context, request a2a.JSONRPCRequest) (a2a.MessageSendParams, bool) {
+	var params a2a.MessageSendParams
+	if err := json.Unmarshal(request.Params, &params); err != nil {
+		c.JSON(http.StatusOK, a2a.NewError(request.ID, a2a.ErrCodeInvalidParams, fmt.Sprintf("invalid params: %v", err)))
+		return params, false
+	}
+	if params.Message.Text() == "" {
+		c.JSON(http.StatusOK, a2a.NewError(request.ID, a2a.ErrCodeInvalidParams, "message must contain at least one text part"))
+		return params, false
+	}
+	return params, true
+}
@@ -0,0 +1,83 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"template-custom-agent-go/pkg/config"
+	"template-custom-agent-go/pkg/logger"
+	"template-custom-agent-go/pkg/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupAdminRoutes sets up administrative routes, restricted to admin API keys
+func (r *Router) setupAdminRoutes(engine *gin.Engine) {
+	admin := engine.Group("/admin", middleware.RequireRole(config.RoleAdmin))
+	{
+		admin.GET("/routes", r.listRoutes)
+		admin.GET("/info", r.adminInfo)
+		admin.GET("/events", r.adminEvents)
+	}
+}
+
+// listRoutes returns the final route table
+func (r *Router) listRoutes(c *gin.Context) {
+	routes := r.routes.Routes()
+	c.JSON(http.StatusOK, gin.H{
+		"routes": routes,
+		"count":  len(routes),
+	})
+}
+
+// toolsChangedEvent is sent on admin event streams when the content of the tool catalog changes
+type toolsChangedEvent struct {
+	CatalogVersion uint64    `json:"catalog_version"`
+	ToolCount      int       `json:"tool_count"`
+	ModifiedAt     time.Time `json:"modified_at"`
+}
+
+// adminEvents streams server events as SSE, currently a tools_changed event each time the content of
+// the tool catalog changes. The catalog is fetched again at its refresh interval so changes are noticed
+// without agent traffic, and a comment is sent at the same pace to keep the connection open.
+func (r *Router) adminEvents(c *gin.Context) {
+	changes, unsubscribe := r.toolCatalog.Subscribe()
+	defer unsubscribe()
+
+	startSSE(c)
+	c.Writer.Flush()
+
+	ctx := c.Request.Context()
+	ticker := time.NewTicker(r.toolCatalog.RefreshInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.toolCatalog.Snapshot(ctx); err != nil {
+				logger.Warningf("Failed to refresh the tool catalog: %v", err)
+			}
+			if _, err := fmt.Fprint(c.Writer, ": keepalive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case catalog := <-changes:
+			data, err := json.Marshal(toolsChangedEvent{
+				CatalogVersion: catalog.Generation,
+				ToolCount:      len(catalog.MCPTools()),
+				ModifiedAt:     catalog.ModifiedAt,
+			})
+			if err != nil {
+				logger.Errorf("Failed to marshal tools_changed event: %v", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(c.Writer, "event: tools_changed\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
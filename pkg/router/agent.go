@@ -1,47 +1,154 @@
 package router
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"template-custom-agent-go/pkg/agent"
+	"template-custom-agent-go/pkg/analytics"
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/config"
+	"template-custom-agent-go/pkg/egress"
 	"template-custom-agent-go/pkg/logger"
+	"template-custom-agent-go/pkg/metrics"
+	"template-custom-agent-go/pkg/middleware"
+	"template-custom-agent-go/pkg/models"
+	"template-custom-agent-go/pkg/signing"
+	"template-custom-agent-go/pkg/stream"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
+// warmupRetryAfterSeconds is the Retry-After hint sent while the tool catalog is warming up
+const warmupRetryAfterSeconds = 5
+
+// Response formats of agent endpoints, negotiated from the Accept header
+const (
+	formatJSON   = "application/json"
+	formatSSE    = "text/event-stream"
+	formatNDJSON = "application/x-ndjson"
+	formatText   = "text/plain"
+)
+
+// rootFormats are the formats of POST /, the first being used when the client accepts any
+var rootFormats = []string{formatSSE, formatNDJSON, formatText, formatJSON}
+
+// Events of agent runs streamed as SSE or NDJSON
+const (
+	eventIteration    = "iteration"
+	eventDelta        = "message.delta"
+	eventToolCall     = "tool.call"
+	eventToolResult   = "tool.result"
+	eventUsage        = "usage"
+	eventIntermediate = "intermediate"
+	eventDone         = "done"
+	eventError        = "error"
+)
+
+// agentRequest represents the request body accepted by agent endpoints
+type agentRequest struct {
+	Inputs        string `json:"inputs" binding:"required"`
+	MaxIterations int    `json:"max_iterations,omitempty"`
+	Model         string `json:"model,omitempty"`
+	// SynthesisModel writes the final answer while Model drives the tool-calling iterations
+	SynthesisModel string `json:"synthesis_model,omitempty"`
+	SystemPrompt   string `json:"system_prompt,omitempty"`
+	// Variables fill the {{.name}} placeholders of the system prompt, a Go text/template
+	Variables map[string]any `json:"variables,omitempty" binding:"max=32"`
+	// IncludeIntermediate returns the assistant turns between tool calls along with the final answer
+	IncludeIntermediate bool `json:"include_intermediate,omitempty"`
+	// MaxToolCalls is either a total number of tool calls or an object with total and per_tool limits
+	MaxToolCalls agent.ToolCallLimits `json:"max_tool_calls,omitempty"`
+	// AllowedTools restricts the tools of the run to these names, an empty array allowing none, and
+	// BlockedTools are never available. Calls to other tools are rejected.
+	AllowedTools []string `json:"allowed_tools,omitempty" binding:"omitempty,max=100,dive,min=1,max=128"`
+	BlockedTools []string `json:"blocked_tools,omitempty" binding:"omitempty,max=100,dive,min=1,max=128"`
+	// MaxTotalTokens stops the run with the budget_exceeded finish reason once its model calls used that many tokens
+	MaxTotalTokens int `json:"max_total_tokens,omitempty" binding:"min=0"`
+	// Sampling sets the temperature and top_p by iteration and for the final synthesis turn
+	Sampling agent.SamplingSchedule `json:"sampling,omitempty"`
+	// Verify recomputes the calculations and runs the Python snippets of the answer, correcting it once when they do not hold
+	Verify bool `json:"verify,omitempty"`
+	// AnswerFormat constrains the length and layout of the answer, which is reformatted once when it does not follow it
+	AnswerFormat agent.AnswerFormat `json:"answer_format,omitempty"`
+	// ResponseFormat asks for the answer as a JSON object or following a JSON schema, the answer being
+	// repaired when it does not
+	ResponseFormat *blaxel.ResponseFormat `json:"response_format,omitempty"`
+	// RewriteProfile names the profile the final answer is rewritten in, adjusting its tone, reading
+	// level and persona without changing its facts
+	RewriteProfile string `json:"rewrite_profile,omitempty" binding:"max=64"`
+	// SessionID groups the runs of a conversation when conversations are persisted, a new session
+	// being started when it is empty
+	SessionID string `json:"session_id,omitempty" binding:"max=128"`
+	// Metadata and Tags label the run in run summaries, to segment experiments and customers
+	Metadata map[string]string `json:"metadata,omitempty" binding:"max=16,dive,keys,max=64,endkeys,max=512"`
+	Tags     []string          `json:"tags,omitempty" binding:"max=20,dive,min=1,max=64"`
+	// MCPServers restricts the MCP tools to the named servers, and adds servers given with a URL for
+	// this request only. All servers are used when it is not set.
+	MCPServers []blaxel.MCPServerConfig `json:"mcp_servers,omitempty" binding:"omitempty,max=10,dive"`
+	// Language of the messages generated by the agent itself, negotiated from Accept-Language
+	Language string `json:"-"`
+	// adHocServers are the servers of MCPServers given with a URL, connected for this request
+	adHocServers []*blaxel.AdHocServer
+}
+
+// agentResponse is the agent completion with the intermediate assistant turns when requested,
+// the tool call limits reached during the run, the handoff when a human takes over, what was
+// compacted to fit the context window, the verification, format check and structured output check
+// of the answer, and its rewrite
+type agentResponse struct {
+	*blaxel.ChatCompletionResponse
+	IntermediateMessages []agent.IntermediateMessage `json:"intermediate_messages,omitempty"`
+	LimitsHit            []string                    `json:"limits_hit,omitempty"`
+	Handoff              *agent.Handoff              `json:"handoff,omitempty"`
+	Compactions          []agent.Compaction          `json:"compactions,omitempty"`
+	Verification         *agent.Verification         `json:"verification,omitempty"`
+	FormatCheck          *agent.FormatCheck          `json:"format_check,omitempty"`
+	StructuredOutput     *agent.StructuredOutput     `json:"structured_output,omitempty"`
+	Rewrite              *agent.Rewrite              `json:"rewrite,omitempty"`
+	SessionID            string                      `json:"session_id,omitempty"`
+	// Execution identifies the Blaxel execution and task that ran the agent, when known
+	Execution *config.Execution `json:"execution,omitempty"`
+}
+
 // setupAgentRoutes sets up agent-related routes
 func (r *Router) setupAgentRoutes(engine *gin.Engine) {
-	agents := engine.Group("/agent")
+	agents := engine.Group("/agent", r.requireToolWarmup)
 	{
-		agents.POST("", r.runAgent)
-		agents.POST("/run", r.runAgent) // Alternative endpoint
+		runAgent := r.agentHandler("demo-agent", formatJSON, formatSSE, formatNDJSON, formatText)
+		agents.POST("", runAgent)
+		agents.POST("/run", runAgent) // Alternative endpoint
 	}
 
-	// Streaming agent endpoint at root
-	engine.POST("/", r.streamAgent)
+	// Streaming agent endpoint at root, answering with SSE events by default
+	engine.POST("/", r.requireToolWarmup, r.agentHandler("streaming-agent", rootFormats...))
 }
 
-// streamAgent handles streaming agent execution requests
-func (r *Router) streamAgent(c *gin.Context) {
-	var request struct {
-		Inputs        string `json:"inputs" binding:"required"`
-		MaxIterations int    `json:"max_iterations,omitempty"`
-		Model         string `json:"model,omitempty"`
-		SystemPrompt  string `json:"system_prompt,omitempty"`
-	}
-
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.Error(fmt.Errorf("invalid request: %w", err))
-		c.AbortWithStatus(http.StatusBadRequest)
+// requireToolWarmup rejects agent requests with a retry hint until the tool catalog has been
+// fetched once or restored from its cache file, when the warm-up gate is enabled
+func (r *Router) requireToolWarmup(c *gin.Context) {
+	if !blaxel.WarmupGateEnabled() || r.toolCatalog.IsWarm() {
+		c.Next()
 		return
 	}
 
+	c.Header("Retry-After", strconv.Itoa(warmupRetryAfterSeconds))
+	abortWithError(c, http.StatusServiceUnavailable,
+		models.NewUnavailableError(fmt.Errorf("agent is warming up, tool catalog not loaded yet")))
+}
+
+// buildAgent creates an agent from the request and wires it with the tools of all MCP servers
+func (r *Router) buildAgent(ctx context.Context, name string, request agentRequest) (*agent.Agent, error) {
 	// Set defaults
 	model := request.Model
 	if model == "" {
-		model = "sandbox-openai"
+		model = r.blaxelClient.Model
 	}
 
 	systemPrompt := request.SystemPrompt
@@ -51,24 +158,77 @@ func (r *Router) streamAgent(c *gin.Context) {
 
 	// Create agent with configuration
 	agentConfig := agent.Config{
-		Name:          "streaming-agent",
-		MaxIterations: request.MaxIterations,
-		Model:         model,
-		SystemPrompt:  systemPrompt,
+		Name:            name,
+		MaxIterations:   request.MaxIterations,
+		Model:           model,
+		SynthesisModel:  request.SynthesisModel,
+		SystemPrompt:    systemPrompt,
+		PromptVariables: request.Variables,
+		MaxToolCalls:    request.MaxToolCalls,
+		AllowedTools:    request.AllowedTools,
+		BlockedTools:    request.BlockedTools,
+		MaxTotalTokens:  request.MaxTotalTokens,
+		Sampling:        request.Sampling,
+		Language:        request.Language,
+		Verify:          request.Verify,
+		Glossary:        r.glossary,
+		AnswerFormat:    request.AnswerFormat,
+		ResponseFormat:  request.ResponseFormat,
+		RewriteProfile:  request.RewriteProfile,
 	}
 
-	demoAgent := agent.NewAgent(agentConfig, r.blaxelClient)
+	newAgent := agent.NewAgent(agentConfig, r.blaxelClient)
 
-	// Get and set available tools
-	mcpTools, err := r.blaxelClient.McpManager.ListAllTools(c)
+	// Get and set available tools from the shared catalog
+	catalog, err := r.toolCatalog.Snapshot(ctx)
 	if err != nil {
-		c.Error(fmt.Errorf("failed to get tools: %w", err))
-		c.AbortWithStatus(http.StatusInternalServerError)
-		return
+		return nil, err
+	}
+
+	toolManager := agent.NewToolManagerFromSnapshot(catalog)
+	tools := catalog.Tools()
+	if request.MCPServers != nil {
+		var names []string
+		for _, server := range request.MCPServers {
+			if server.URL == "" {
+				names = append(names, server.Name)
+			}
+		}
+		tools = catalog.ToolsOf(names)
+		toolManager.KeepServers(names)
 	}
 
-	toolManager := agent.NewToolManager()
-	tools := toolManager.ConvertMCPToolsToOpenAI(mcpTools)
+	// Expose the tools of the MCP servers connected for this request, replacing catalog tools of the same name
+	if len(request.adHocServers) > 0 {
+		var adHocTools []blaxel.Tool
+		for _, server := range request.adHocServers {
+			serverTools, err := toolManager.RegisterAdHocServer(ctx, server)
+			if err != nil {
+				return nil, models.NewUpstreamError(err)
+			}
+			adHocTools = append(adHocTools, serverTools...)
+		}
+		tools = replaceTools(tools, adHocTools)
+	}
+
+	// Expose the Go functions of the native tool registry, which run before MCP tools of the same name
+	if r.nativeTools.Len() > 0 {
+		tools = replaceTools(tools, toolManager.RegisterNativeTools(r.nativeTools))
+	}
+
+	// Expose sibling agents of the workspace as delegation tools
+	if r.blaxelClient.RemoteAgentsEnabled() {
+		agentTools, err := toolManager.RegisterRemoteAgents(ctx, r.blaxelClient)
+		if err != nil {
+			logger.WarningfCtx(ctx, "Failed to register remote agents as tools: %v", err)
+		}
+		tools = append(tools, agentTools...)
+	}
+
+	// Expose sandbox code execution and file access when enabled
+	if r.blaxelClient.Sandbox != nil {
+		tools = append(tools, toolManager.RegisterSandboxTools(r.blaxelClient.Sandbox)...)
+	}
 
 	toolNames := []string{}
 	for _, tool := range tools {
@@ -76,103 +236,533 @@ func (r *Router) streamAgent(c *gin.Context) {
 	}
 
 	// Set both tools and tool manager on the agent
-	demoAgent.SetTools(tools)
-	demoAgent.SetToolManager(toolManager)
-	logger.Debugf("Streaming agent configured with %s tools", strings.Join(toolNames, ", "))
-
-	// Set headers for streaming
-	c.Header("Content-Type", "text/plain; charset=utf-8")
-	c.Header("Cache-Control", "no-cache")
-	c.Header("Connection", "keep-alive")
-	c.Header("Access-Control-Allow-Origin", "*")
-
-	// Run the agent and stream the response
-	response, err := demoAgent.Run(c, request.Inputs)
-	if err != nil {
-		c.String(http.StatusInternalServerError, "Error: %v", err)
+	newAgent.SetTools(tools)
+	newAgent.SetToolManager(toolManager)
+	logger.DebugfCtx(ctx, "Agent %s configured with %s tools", name, strings.Join(toolNames, ", "))
+
+	return newAgent, nil
+}
+
+// replaceTools appends the replacements to the tools, dropping the tools of the same names
+func replaceTools(tools, replacements []blaxel.Tool) []blaxel.Tool {
+	replaced := make(map[string]bool, len(replacements))
+	for _, tool := range replacements {
+		replaced[tool.Function.Name] = true
+	}
+
+	kept := make([]blaxel.Tool, 0, len(tools)+len(replacements))
+	for _, tool := range tools {
+		if !replaced[tool.Function.Name] {
+			kept = append(kept, tool)
+		}
+	}
+	return append(kept, replacements...)
+}
+
+// agentBuilder creates the agent running a request
+type agentBuilder func(ctx context.Context, request agentRequest) (*agent.Agent, error)
+
+// agentResolver applies the defaults of the agent a request runs to the request, and returns how to build it
+type agentResolver func(c *gin.Context, request *agentRequest) (agentBuilder, error)
+
+// agentHandler runs the agent of the given name, configured by the request alone
+func (r *Router) agentHandler(name string, formats ...string) gin.HandlerFunc {
+	build := func(ctx context.Context, request agentRequest) (*agent.Agent, error) {
+		return r.buildAgent(ctx, name, request)
+	}
+	return r.agentRunHandler(func(*gin.Context, *agentRequest) (agentBuilder, error) { return build, nil }, formats...)
+}
+
+// agentRunHandler runs the agent resolved for the request and writes its answer in the format negotiated
+// from the Accept header: the JSON envelope, an SSE or NDJSON event stream, or the plain-text answer. The
+// first of formats is used when the client accepts any of them.
+func (r *Router) agentRunHandler(resolve agentResolver, formats ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		format := c.NegotiateFormat(formats...)
+		if format == "" {
+			abortWithError(c, http.StatusNotAcceptable, models.NewNotAcceptableError(
+				fmt.Errorf("cannot respond with %q, available formats are %s", c.GetHeader("Accept"), strings.Join(formats, ", "))))
+			return
+		}
+
+		var request agentRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			abortWithError(c, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+			return
+		}
+		request.Language = middleware.GetLanguage(c)
+		build, err := resolve(c, &request)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+		if _, err := agent.RenderSystemPrompt(request.SystemPrompt, request.Variables); err != nil {
+			abortWithError(c, http.StatusBadRequest, models.NewInvalidRequestError(err))
+			return
+		}
+		if request.RewriteProfile != "" && !agent.RewriteProfileExists(request.RewriteProfile) {
+			abortWithError(c, http.StatusBadRequest, models.NewInvalidRequestError(fmt.Errorf("unknown rewrite profile %s", request.RewriteProfile)))
+			return
+		}
+		if err := agent.ValidateResponseFormat(request.ResponseFormat); err != nil {
+			abortWithError(c, http.StatusBadRequest, models.NewInvalidRequestError(err))
+			return
+		}
+		if r.conversations == nil {
+			request.SessionID = ""
+		} else if request.SessionID == "" {
+			request.SessionID = uuid.NewString()
+		}
+
+		if err := r.connectMCPServers(c, &request); err != nil {
+			abortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+		defer request.closeMCPServers()
+
+		runAgent, err := build(c, request)
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		// Forward the content of the model as it is generated, unless the whole answer is needed
+		// first: JSON responses, plain text labelled with intermediate turns or signed, and answers
+		// changed after the model wrote them
+		out := &agentStream{router: r, c: c, format: format, model: runAgent.GetModel(), start: start}
+		defer out.close()
+		if format != formatJSON && !(format == formatText && (request.IncludeIntermediate || r.signer != nil)) &&
+			!runAgent.PostProcessesAnswer() {
+			runAgent.SetDeltaHandler(out.delta)
+		}
+		events := format == formatSSE || format == formatNDJSON
+		if events {
+			runAgent.SetIterationHandler(out.startIteration)
+			runAgent.SetUsageHandler(func(event agent.UsageEvent) { out.event(eventUsage, event) })
+		}
+		recorder := r.newConversationRecorder()
+		if events || recorder != nil {
+			runAgent.SetToolHandler(func(event agent.ToolEvent) {
+				if events {
+					out.tool(event)
+				}
+				recorder.tool(event)
+			})
+		}
+		if request.SessionID != "" {
+			c.Header(SessionIDHeader, request.SessionID)
+		}
+
+		// Failures before the first event keep their status code, later ones are reported in-band
+		record := newRunRecord(c, request, start)
+		response, err := runAgent.Run(recorder.capture(blaxel.WithRunID(c.Request.Context(), record.ID)), request.Inputs)
+		r.recordRun(record, runAgent, response, err)
+		r.saveConversation(c.Request.Context(), recorder, record, runAgent, request, response)
+		if err != nil {
+			out.fail(fmt.Errorf("agent execution failed: %w", err))
+			return
+		}
+		if len(response.Choices) == 0 {
+			out.fail(models.NewUpstreamError(fmt.Errorf("no response generated")))
+			return
+		}
+
+		switch format {
+		case formatText:
+			r.writeAgentText(out, runAgent, request, response)
+		case formatSSE, formatNDJSON:
+			writeAgentEvents(out, runAgent, request, response)
+		default:
+			r.signedJSON(c, http.StatusOK, r.newAgentResponse(runAgent, request, response))
+		}
+	}
+}
+
+// connectMCPServers checks the MCP servers selected by the request and connects those given with a URL,
+// which requires the admin role when authentication is enabled
+func (r *Router) connectMCPServers(c *gin.Context, request *agentRequest) error {
+	for _, server := range request.MCPServers {
+		if server.URL == "" {
+			if !r.blaxelClient.McpManager.HasServer(server.Name) {
+				return models.NewInvalidRequestError(fmt.Errorf("unknown MCP server %s", server.Name))
+			}
+			continue
+		}
+
+		if role := middleware.GetRole(c); role != "" && !config.RoleAllows(role, config.RoleAdmin) {
+			return models.NewForbiddenError(fmt.Errorf("role %s required for MCP servers given with a URL, API key has role %s", config.RoleAdmin, role))
+		}
+		if err := egress.Check(server.URL); err != nil {
+			return models.NewForbiddenError(fmt.Errorf("MCP server %s not allowed: %w", server.Name, err))
+		}
+		adHocServer, err := r.blaxelClient.McpManager.ConnectAdHoc(server)
+		if err != nil {
+			request.closeMCPServers()
+			return models.NewUpstreamError(err)
+		}
+		request.adHocServers = append(request.adHocServers, adHocServer)
+	}
+	return nil
+}
+
+// closeMCPServers closes the MCP servers connected for the request
+func (req *agentRequest) closeMCPServers() {
+	for _, server := range req.adHocServers {
+		if err := server.Close(); err != nil {
+			logger.Warningf("Failed to close MCP server %s: %v", server.Name, err)
+		}
+	}
+	req.adHocServers = nil
+}
+
+// newAgentResponse wraps the completion of a run with what the agent reports about it
+func (r *Router) newAgentResponse(runAgent *agent.Agent, request agentRequest, response *blaxel.ChatCompletionResponse) agentResponse {
+	result := agentResponse{
+		ChatCompletionResponse: response,
+		LimitsHit:              runAgent.LimitsHit(),
+		Handoff:                runAgent.HandoffResult(),
+		Compactions:            runAgent.Compactions(),
+		Verification:           runAgent.Verification(),
+		FormatCheck:            runAgent.FormatCheck(),
+		StructuredOutput:       runAgent.StructuredOutput(),
+		Rewrite:                runAgent.RewriteResult(),
+		SessionID:              request.SessionID,
+	}
+	if !r.execution.IsZero() {
+		result.Execution = &r.execution
+	}
+	if request.IncludeIntermediate {
+		result.IntermediateMessages = runAgent.IntermediateMessages()
+	}
+	return result
+}
+
+// writeAgentText writes the answer as plain text. Answers already streamed are only completed when the
+// agent wrote the final turn itself; otherwise the answer is sent word by word for a typing effect.
+func (r *Router) writeAgentText(out *agentStream, runAgent *agent.Agent, request agentRequest, response *blaxel.ChatCompletionResponse) {
+	content := response.Choices[0].Message.Content
+	if out.iteration > 0 {
+		if content != out.turn.String() {
+			out.writeText("\n" + content)
+		}
 		return
 	}
 
-	// Extract the final response content and stream it
-	if len(response.Choices) > 0 {
-		content := response.Choices[0].Message.Content
+	if request.IncludeIntermediate {
+		content = labelIntermediate(runAgent.IntermediateMessages(), content)
+	}
 
-		// Stream the content character by character for a typing effect
-		for _, char := range content {
-			c.Writer.WriteString(string(char))
-			c.Writer.Flush()
-			// Small delay for streaming effect (optional)
-			// time.Sleep(10 * time.Millisecond)
+	// The whole answer is known before streaming starts, so its signature fits in a header
+	if r.signer != nil {
+		out.c.Header(signing.SignatureHeader, r.signer.Sign([]byte(content), time.Now()))
+	}
+	for _, token := range strings.SplitAfter(content, " ") {
+		if !out.writeText(token) {
+			return
 		}
-	} else {
-		c.String(http.StatusInternalServerError, "No response generated")
 	}
 }
 
-// runAgent handles agent execution requests
-func (r *Router) runAgent(c *gin.Context) {
-	var request struct {
-		Inputs        string `json:"inputs" binding:"required"`
-		MaxIterations int    `json:"max_iterations,omitempty"`
-		Model         string `json:"model,omitempty"`
-		SystemPrompt  string `json:"system_prompt,omitempty"`
+// writeAgentEvents ends an event stream with an "intermediate" event per assistant turn that called
+// tools when requested, then a "done" event carrying the same envelope as JSON responses
+func writeAgentEvents(out *agentStream, runAgent *agent.Agent, request agentRequest, response *blaxel.ChatCompletionResponse) {
+	result := out.router.newAgentResponse(runAgent, request, response)
+	for i := range result.IntermediateMessages {
+		if !out.event(eventIntermediate, result.IntermediateMessages[i]) {
+			return
+		}
 	}
+	result.IntermediateMessages = nil
+	out.event(eventDone, result)
+}
+
+// agentStream writes a run in a streamed format. The response headers are only sent with the first
+// write, so the run can still fail with a regular error response until then.
+type agentStream struct {
+	router *Router
+	c      *gin.Context
+	format string
+	model  string
+	start  time.Time
 
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.Error(fmt.Errorf("invalid request: %w", err))
-		c.AbortWithStatus(http.StatusBadRequest)
+	started bool
+	failed  bool
+	text    *stream.Writer
+	// iteration is the turn of the last delta, and turn holds the content streamed during it
+	iteration int
+	turn      strings.Builder
+	// nextIteration is the "iteration" event of a turn that has not sent anything yet
+	nextIteration *agent.IterationEvent
+}
+
+// begin sends the headers of the stream once
+func (s *agentStream) begin() {
+	if s.started {
 		return
 	}
+	s.started = true
 
-	// Set defaults
-	model := request.Model
-	if model == "" {
-		model = "sandbox-openai"
+	switch s.format {
+	case formatSSE:
+		startSSE(s.c)
+	case formatNDJSON:
+		s.c.Header("Content-Type", formatNDJSON)
+		s.c.Header("Cache-Control", "no-cache")
+		s.c.Status(http.StatusOK)
+	default:
+		s.c.Header("Content-Type", "text/plain; charset=utf-8")
+		s.c.Header("Cache-Control", "no-cache")
+		s.c.Header("Connection", "keep-alive")
+		stream.DeclareErrorTrailers(s.c.Writer.Header())
+		s.text = stream.NewWriter(s.c.Writer, stream.ConfigFromEnv())
 	}
+}
 
-	systemPrompt := request.SystemPrompt
-	if systemPrompt == "" {
-		systemPrompt = "You are a helpful assistant that can answer questions and help with tasks."
+// delta forwards a fragment of content generated by the model, as a "message.delta" event or as plain text
+// where each turn starts on a new line
+func (s *agentStream) delta(delta agent.Delta) {
+	if delta.Iteration != s.iteration {
+		if s.iteration > 0 && s.format == formatText {
+			s.writeText("\n")
+		}
+		s.iteration = delta.Iteration
+		s.turn.Reset()
 	}
+	s.turn.WriteString(delta.Content)
 
-	// Create agent with configuration
-	agentConfig := agent.Config{
-		Name:          "demo-agent",
-		MaxIterations: request.MaxIterations,
-		Model:         model,
-		SystemPrompt:  systemPrompt,
+	if s.format == formatText {
+		s.writeText(delta.Content)
+		return
+	}
+	s.event(eventDelta, delta)
+}
+
+// startIteration holds the "iteration" event of a turn until its first event, so the stream does not
+// start before the model answers and failures of the first turn keep their status code
+func (s *agentStream) startIteration(event agent.IterationEvent) {
+	s.nextIteration = &event
+}
+
+// tool forwards a tool event, as a "tool.call" event when the call starts and a "tool.result" event
+// once it completed or was skipped
+func (s *agentStream) tool(event agent.ToolEvent) {
+	if event.Status == agent.ToolStarted {
+		s.event(eventToolCall, event)
+		return
 	}
+	s.event(eventToolResult, event)
+}
 
-	demoAgent := agent.NewAgent(agentConfig, r.blaxelClient)
+// writeText writes plain text through the buffered writer, reporting whether the client still reads
+func (s *agentStream) writeText(text string) bool {
+	if s.failed {
+		return false
+	}
+	s.begin()
+	if _, err := s.text.WriteString(text); err != nil {
+		logger.DebugfCtx(s.c.Request.Context(), "Stopped streaming response: %v", err)
+		s.failed = true
+	}
+	return !s.failed
+}
 
-	// Get and set available tools
-	mcpTools, err := r.blaxelClient.McpManager.ListAllTools(c)
+// event writes and flushes one event, as an SSE frame or as an NDJSON line of the form
+// {"event": ..., "data": ...}, reporting whether the client still reads
+func (s *agentStream) event(event string, data interface{}) bool {
+	if s.failed {
+		return false
+	}
+	if next := s.nextIteration; next != nil {
+		s.nextIteration = nil
+		if !s.event(eventIteration, next) {
+			return false
+		}
+	}
+	encoded, err := json.Marshal(data)
 	if err != nil {
-		c.Error(fmt.Errorf("failed to get tools: %w", err))
-		c.AbortWithStatus(http.StatusInternalServerError)
+		logger.ErrorfCtx(s.c.Request.Context(), "Failed to marshal %s event: %v", event, err)
+		return true
+	}
+
+	s.begin()
+	if s.format == formatSSE {
+		_, err = fmt.Fprintf(s.c.Writer, "event: %s\ndata: %s\n\n", event, encoded)
+	} else {
+		_, err = fmt.Fprintf(s.c.Writer, "{\"event\":%q,\"data\":%s}\n", event, encoded)
+	}
+	if err != nil {
+		logger.DebugfCtx(s.c.Request.Context(), "Stopped streaming events: %v", err)
+		s.failed = true
+		return false
+	}
+	s.c.Writer.Flush()
+	return true
+}
+
+// fail reports an error. Before the stream has started it is sent as a regular error response,
+// afterwards it is reported in the error trailers of plain text or as an "error" event.
+func (s *agentStream) fail(err error) {
+	if !s.started {
+		abortWithError(s.c, http.StatusInternalServerError, err)
 		return
 	}
 
-	toolManager := agent.NewToolManager()
-	tools := toolManager.ConvertMCPToolsToOpenAI(mcpTools)
+	_, errorCode := middleware.ClassifyError(err, http.StatusInternalServerError)
+	logger.ErrorfCtx(s.c.Request.Context(), "Stream failed after it started: %v, Path: %s", err, s.c.Request.URL.Path)
+	event := stream.ErrorEvent{
+		Error:     err.Error(),
+		ErrorCode: errorCode,
+		RequestID: middleware.GetRequestID(s.c),
+	}
+	if s.format == formatText {
+		s.text.Close()
+		stream.SetErrorTrailers(s.c.Writer.Header(), event)
+	} else {
+		s.event(eventError, event)
+	}
+	s.c.Abort()
+}
 
-	toolNames := []string{}
-	for _, tool := range tools {
-		toolNames = append(toolNames, tool.Function.Name)
+// close flushes plain text and records its latency metrics
+func (s *agentStream) close() {
+	if s.text == nil {
+		return
 	}
+	s.text.Close()
+	stats := s.text.Stats()
+	s.router.observeStream(metrics.StreamTiming{
+		Model:      s.model,
+		Endpoint:   s.c.FullPath(),
+		Start:      s.start,
+		FirstToken: stats.FirstToken,
+		LastToken:  stats.LastToken,
+		End:        time.Now(),
+		Tokens:     stats.Tokens,
+	})
+}
 
-	// Set both tools and tool manager on the agent
-	demoAgent.SetTools(tools)
-	demoAgent.SetToolManager(toolManager)
-	logger.Debugf("Agent configured with %s tools", strings.Join(toolNames, ", "))
+// runRecord identifies an agent run and its labels in the run summary
+type runRecord struct {
+	ID        string
+	RequestID string
+	Endpoint  string
+	Metadata  map[string]string
+	Tags      []string
+	Start     time.Time
+}
 
-	// Run the agent
-	response, err := demoAgent.Run(c, request.Inputs)
-	if err != nil {
-		c.Error(fmt.Errorf("agent execution failed: %w", err))
-		c.AbortWithStatus(http.StatusInternalServerError)
+// newRunRecord creates the record of a run started by an agent request
+func newRunRecord(c *gin.Context, request agentRequest, start time.Time) runRecord {
+	return runRecord{
+		ID:        uuid.NewString(),
+		RequestID: middleware.GetRequestID(c),
+		Endpoint:  c.FullPath(),
+		Metadata:  request.Metadata,
+		Tags:      request.Tags,
+		Start:     start,
+	}
+}
+
+// recordRun exports the summary of an agent run when run summaries are enabled
+func (r *Router) recordRun(record runRecord, runAgent *agent.Agent, response *blaxel.ChatCompletionResponse, runErr error) {
+	if r.runSummaries == nil {
+		return
+	}
+
+	stats := runAgent.Stats()
+	latency := time.Since(record.Start)
+	summary := analytics.Summary{
+		RunID:         record.ID,
+		RequestID:     record.RequestID,
+		Endpoint:      record.Endpoint,
+		Metadata:      record.Metadata,
+		Tags:          record.Tags,
+		Agent:         runAgent.GetName(),
+		Status:        runStatus(response, runErr),
+		Model:         runAgent.GetModel(),
+		Iterations:    stats.Iterations,
+		ToolCalls:     stats.ToolCalls,
+		Usage:         stats.TotalUsage(),
+		CostUSD:       r.runSummaries.Cost(stats.Usage),
+		LatencyMs:     latency.Milliseconds(),
+		LatencyBucket: analytics.LatencyBucket(latency),
+		StartedAt:     record.Start,
+		ExecutionID:   r.execution.ID,
+		TaskIndex:     r.execution.TaskIndex,
+	}
+	if runErr != nil {
+		summary.Error = runErr.Error()
+	}
+	r.runSummaries.Record(summary)
+}
+
+// runStatus returns the summary status of a run from its response or error
+func runStatus(response *blaxel.ChatCompletionResponse, runErr error) string {
+	if runErr != nil || response == nil || len(response.Choices) == 0 {
+		return analytics.StatusFailed
+	}
+	switch response.Choices[0].FinishReason {
+	case "length":
+		return analytics.StatusMaxIterations
+	case "handoff":
+		return analytics.StatusHandoff
+	case agent.FinishReasonBudgetExceeded:
+		return analytics.StatusBudgetExceeded
+	default:
+		return analytics.StatusCompleted
+	}
+}
+
+// labelIntermediate prefixes the streamed answer with the intermediate assistant turns, each on its own
+// "[intermediate]" line listing the tools it called, and labels the answer itself "[final]"
+func labelIntermediate(intermediate []agent.IntermediateMessage, content string) string {
+	var builder strings.Builder
+	for _, message := range intermediate {
+		builder.WriteString("[intermediate] ")
+		builder.WriteString(message.Content)
+		if len(message.ToolCalls) > 0 {
+			names := make([]string, len(message.ToolCalls))
+			for i, toolCall := range message.ToolCalls {
+				names[i] = toolCall.Function.Name
+			}
+			if message.Content != "" {
+				builder.WriteString(" ")
+			}
+			fmt.Fprintf(&builder, "(calling %s)", strings.Join(names, ", "))
+		}
+		builder.WriteString("\n")
+	}
+	builder.WriteString("[final] ")
+	builder.WriteString(content)
+	return builder.String()
+}
+
+// observeStream records the latency metrics of a streamed response and logs them
+func (r *Router) observeStream(timing metrics.StreamTiming) {
+	if timing.Model == "" {
+		timing.Model = r.blaxelClient.Model
+	}
+	metrics.ObserveStream(timing)
+	logger.Debugf("Stream %s on %s: ttft=%s inter_token=%s tokens_per_second=%.1f duration=%s tokens=%d",
+		timing.Endpoint, timing.Model, timing.TimeToFirstToken(), timing.InterTokenLatency(),
+		timing.TokensPerSecond(), timing.Duration(), timing.Tokens)
+}
+
+// signedJSON writes a JSON response, signed when response signing is enabled. The signature covers
+// the exact body bytes sent.
+func (r *Router) signedJSON(c *gin.Context, status int, value interface{}) {
+	if r.signer == nil {
+		c.JSON(status, value)
 		return
 	}
 
-	c.JSON(http.StatusOK, response)
+	body, err := json.Marshal(value)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, fmt.Errorf("failed to encode response: %w", err))
+		return
+	}
+	c.Header(signing.SignatureHeader, r.signer.Sign(body, time.Now()))
+	c.Data(status, "application/json; charset=utf-8", body)
 }
@@ -0,0 +1,236 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"template-custom-agent-go/pkg/agent"
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/config"
+	"template-custom-agent-go/pkg/logger"
+	"template-custom-agent-go/pkg/middleware"
+	"template-custom-agent-go/pkg/models"
+	"template-custom-agent-go/pkg/orchestrator"
+
+	"github.com/gin-gonic/gin"
+)
+
+// namedAgentInfo describes a named agent of the registry
+type namedAgentInfo struct {
+	Name          string   `json:"name"`
+	Description   string   `json:"description,omitempty"`
+	Model         string   `json:"model"`
+	SystemPrompt  string   `json:"system_prompt,omitempty"`
+	MaxIterations int      `json:"max_iterations,omitempty"`
+	Tools         []string `json:"tools"`
+	MCPServers    []string `json:"mcp_servers,omitempty"`
+	Delegates     []string `json:"delegates,omitempty"`
+}
+
+// newOrchestrator creates the orchestrator of the named agents of the BL_AGENTS_CONFIG registry
+func (r *Router) newOrchestrator() (*orchestrator.Orchestrator, error) {
+	registry, err := orchestrator.NewRegistryFromEnv(r.checkAgentServers)
+	if err != nil {
+		return nil, err
+	}
+	return orchestrator.New(registry, r.buildNamedAgent), nil
+}
+
+// checkAgentServers checks that the MCP servers of a named agent are configured
+func (r *Router) checkAgentServers(spec orchestrator.AgentSpec) error {
+	for _, server := range spec.MCPServers {
+		if !r.blaxelClient.McpManager.HasServer(server) {
+			return fmt.Errorf("agent %s uses unknown MCP server %s", spec.Name, server)
+		}
+	}
+	return nil
+}
+
+// buildNamedAgent creates the agent of a spec for a delegated sub-task
+func (r *Router) buildNamedAgent(ctx context.Context, spec orchestrator.AgentSpec) (*agent.Agent, error) {
+	var request agentRequest
+	applyAgentSpec(&request, spec)
+	return r.buildAgent(ctx, spec.Name, request)
+}
+
+// applyAgentSpec fills the settings the request leaves empty with those of the named agent. The tools
+// and MCP servers of the request can only narrow those of the agent, and its system prompt is always
+// that of the agent. The servers are enforced when the tools are called, buildAgent keeping only
+// those of request.MCPServers in the tool manager.
+func applyAgentSpec(request *agentRequest, spec orchestrator.AgentSpec) {
+	if request.Model == "" {
+		request.Model = spec.Model
+	}
+	request.SystemPrompt = spec.SystemPrompt
+	if request.MaxIterations == 0 {
+		request.MaxIterations = spec.MaxIterations
+	}
+	if allowed := spec.AllowedTools(); allowed != nil {
+		if request.AllowedTools == nil {
+			request.AllowedTools = allowed
+		} else {
+			request.AllowedTools = slices.DeleteFunc(slices.Clone(request.AllowedTools), func(tool string) bool {
+				return !slices.Contains(allowed, tool)
+			})
+		}
+	}
+	if spec.MCPServers == nil {
+		return
+	}
+	if request.MCPServers == nil {
+		request.MCPServers = make([]blaxel.MCPServerConfig, len(spec.MCPServers))
+		for i, server := range spec.MCPServers {
+			request.MCPServers[i] = blaxel.MCPServerConfig{Name: server}
+		}
+		return
+	}
+	// Servers given with a URL are not those of the agent, even with the same name
+	servers := []blaxel.MCPServerConfig{}
+	for _, server := range request.MCPServers {
+		if server.URL == "" && slices.Contains(spec.MCPServers, server.Name) {
+			servers = append(servers, server)
+		}
+	}
+	request.MCPServers = servers
+}
+
+// setupNamedAgentRoutes sets up the routes running and managing the named agents of the registry,
+// changes requiring the admin role
+func (r *Router) setupNamedAgentRoutes(engine *gin.Engine) {
+	agents := engine.Group("/agents")
+	{
+		runAgent := r.agentRunHandler(r.resolveNamedAgent, formatJSON, formatSSE, formatNDJSON, formatText)
+		agents.GET("", r.listNamedAgents)
+		agents.GET("/:name", r.getNamedAgent)
+		agents.POST("", middleware.RequireRole(config.RoleAdmin), r.createNamedAgent)
+		agents.PUT("/:name", middleware.RequireRole(config.RoleAdmin), r.updateNamedAgent)
+		agents.DELETE("/:name", middleware.RequireRole(config.RoleAdmin), r.deleteNamedAgent)
+		agents.POST("/:name", r.requireToolWarmup, runAgent)
+		agents.POST("/:name/run", r.requireToolWarmup, runAgent) // Alternative endpoint
+	}
+}
+
+// newNamedAgentInfo describes a named agent, with the model it runs
+func (r *Router) newNamedAgentInfo(spec orchestrator.AgentSpec) namedAgentInfo {
+	model := spec.Model
+	if model == "" {
+		model = r.blaxelClient.Model
+	}
+	return namedAgentInfo{
+		Name:          spec.Name,
+		Description:   spec.Description,
+		Model:         model,
+		SystemPrompt:  spec.SystemPrompt,
+		MaxIterations: spec.MaxIterations,
+		Tools:         spec.Tools,
+		MCPServers:    spec.MCPServers,
+		Delegates:     spec.Delegates,
+	}
+}
+
+// listNamedAgents lists the named agents in declaration order
+func (r *Router) listNamedAgents(c *gin.Context) {
+	specs := r.orchestrator.Registry().Graph().Agents()
+	agents := make([]namedAgentInfo, 0, len(specs))
+	for _, spec := range specs {
+		agents = append(agents, r.newNamedAgentInfo(spec))
+	}
+	c.JSON(http.StatusOK, gin.H{"agents": agents, "count": len(agents)})
+}
+
+// getNamedAgent describes the named agent of the path
+func (r *Router) getNamedAgent(c *gin.Context) {
+	spec, exists := r.orchestrator.Registry().Graph().Agent(c.Param("name"))
+	if !exists {
+		abortWithError(c, http.StatusNotFound, models.NewNotFoundError(fmt.Errorf("unknown agent %s", c.Param("name"))))
+		return
+	}
+	c.JSON(http.StatusOK, r.newNamedAgentInfo(spec))
+}
+
+// createNamedAgent adds a named agent to the registry
+func (r *Router) createNamedAgent(c *gin.Context) {
+	var spec orchestrator.AgentSpec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		abortWithError(c, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if err := r.orchestrator.Registry().Create(spec); err != nil {
+		abortWithError(c, http.StatusInternalServerError, registryError(err))
+		return
+	}
+	logger.InfofCtx(c.Request.Context(), "Named agent %s created", spec.Name)
+	c.JSON(http.StatusCreated, r.newNamedAgentInfo(spec))
+}
+
+// updateNamedAgent replaces the named agent of the path, the name of the body being optional
+func (r *Router) updateNamedAgent(c *gin.Context) {
+	var spec orchestrator.AgentSpec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		abortWithError(c, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if spec.Name == "" {
+		spec.Name = c.Param("name")
+	}
+	if spec.Name != c.Param("name") {
+		abortWithError(c, http.StatusBadRequest, models.NewInvalidRequestError(
+			fmt.Errorf("agent name %s does not match the path, agents cannot be renamed", spec.Name)))
+		return
+	}
+	if err := r.orchestrator.Registry().Update(spec); err != nil {
+		abortWithError(c, http.StatusInternalServerError, registryError(err))
+		return
+	}
+	logger.InfofCtx(c.Request.Context(), "Named agent %s updated", spec.Name)
+	c.JSON(http.StatusOK, r.newNamedAgentInfo(spec))
+}
+
+// deleteNamedAgent removes the named agent of the path from the registry
+func (r *Router) deleteNamedAgent(c *gin.Context) {
+	if err := r.orchestrator.Registry().Delete(c.Param("name")); err != nil {
+		abortWithError(c, http.StatusInternalServerError, registryError(err))
+		return
+	}
+	logger.InfofCtx(c.Request.Context(), "Named agent %s deleted", c.Param("name"))
+	c.Status(http.StatusNoContent)
+}
+
+// registryError gives the error of a registry change the status it is reported with
+func registryError(err error) error {
+	switch {
+	case errors.Is(err, orchestrator.ErrAgentNotFound):
+		return models.NewNotFoundError(err)
+	case errors.Is(err, orchestrator.ErrAgentExists), errors.Is(err, orchestrator.ErrAgentInUse):
+		return models.NewConflictError(err)
+	case errors.Is(err, orchestrator.ErrInvalidAgent):
+		return models.NewValidationError(err)
+	default:
+		return err
+	}
+}
+
+// resolveNamedAgent applies the settings of the agent named in the path to the request, the request
+// overriding them, and builds it with the tools delegating to the agents it supervises
+func (r *Router) resolveNamedAgent(c *gin.Context, request *agentRequest) (agentBuilder, error) {
+	spec, exists := r.orchestrator.Registry().Graph().Agent(c.Param("name"))
+	if !exists {
+		return nil, models.NewNotFoundError(fmt.Errorf("unknown agent %s", c.Param("name")))
+	}
+	if request.SystemPrompt != "" {
+		return nil, models.NewInvalidRequestError(fmt.Errorf("named agents use the system prompt of agent %s, system_prompt cannot be set", spec.Name))
+	}
+	applyAgentSpec(request, spec)
+
+	return func(ctx context.Context, request agentRequest) (*agent.Agent, error) {
+		namedAgent, err := r.buildAgent(ctx, spec.Name, request)
+		if err != nil {
+			return nil, err
+		}
+		r.orchestrator.AddDelegates(namedAgent, spec)
+		return namedAgent, nil
+	}, nil
+}
@@ -0,0 +1,111 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"template-custom-agent-go/pkg/agent"
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/orchestrator"
+	"template-custom-agent-go/pkg/tools"
+)
+
+// newToolCallModel serves chat completions calling the tool first, then answering answer. The messages
+// of the last request are kept in messages.
+func newToolCallModel(t *testing.T, tool, answer string, messages *[]blaxel.ChatMessage) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var request blaxel.ChatCompletionRequest
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		*messages = request.Messages
+		message := blaxel.ChatMessage{Role: "assistant", Content: answer}
+		if calls++; calls == 1 {
+			message = blaxel.ChatMessage{Role: "assistant", ToolCalls: []blaxel.ToolCall{{
+				Id:       "call_1",
+				Type:     "function",
+				Function: blaxel.ToolCallFunction{Name: tool, Arguments: "{}"},
+			}}}
+		}
+		json.NewEncoder(w).Encode(blaxel.ChatCompletionResponse{Choices: []blaxel.Choice{{Message: message, FinishReason: "stop"}}})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newCatalogRouter creates a router whose tool catalog is restored from a cache file listing the tools
+// search_docs of the server docs and delete_user of the server admin
+func newCatalogRouter(t *testing.T, client *blaxel.Client) *Router {
+	t.Helper()
+	cache, err := json.Marshal(map[string]interface{}{
+		"version":    1,
+		"fetched_at": time.Now(),
+		"tools": []map[string]interface{}{
+			{"server": "docs", "tool": map[string]interface{}{"name": "search_docs", "inputSchema": map[string]interface{}{"type": "object"}}},
+			{"server": "admin", "tool": map[string]interface{}{"name": "delete_user", "inputSchema": map[string]interface{}{"type": "object"}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "catalog.json")
+	if err := os.WriteFile(path, cache, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BL_TOOL_CATALOG_CACHE", path)
+
+	client.McpManager = blaxel.NewMCPManager(nil)
+	return &Router{blaxelClient: client, toolCatalog: agent.NewToolCatalog(client.McpManager), nativeTools: tools.NewRegistry()}
+}
+
+// TestNamedAgentRejectsToolsOfOtherServers checks a named agent cannot call the tools of MCP servers
+// outside its spec, whether it is run for a delegation or for a request naming more servers
+func TestNamedAgentRejectsToolsOfOtherServers(t *testing.T) {
+	spec := orchestrator.AgentSpec{Name: "docs-agent", SystemPrompt: "You search the docs.", MCPServers: []string{"docs"}}
+	tests := []struct {
+		name  string
+		build func(r *Router) (*agent.Agent, error)
+	}{
+		{"delegation", func(r *Router) (*agent.Agent, error) {
+			return r.buildNamedAgent(context.Background(), spec)
+		}},
+		{"request", func(r *Router) (*agent.Agent, error) {
+			request := agentRequest{MCPServers: []blaxel.MCPServerConfig{{Name: "docs"}, {Name: "admin"}}}
+			applyAgentSpec(&request, spec)
+			return r.buildAgent(context.Background(), spec.Name, request)
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var messages []blaxel.ChatMessage
+			model := newToolCallModel(t, "delete_user", "I cannot delete users.", &messages)
+			runAgent, err := test.build(newCatalogRouter(t, newTestClient(t, model.URL)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := runAgent.Run(context.Background(), "Delete user 42"); err != nil {
+				t.Fatal(err)
+			}
+			result := messages[len(messages)-1]
+			if result.Role != "tool" || !strings.Contains(result.Content, "tool delete_user is not available for this run") {
+				t.Errorf("tool result = %+v, want an error", result)
+			}
+		})
+	}
+}
@@ -1,12 +1,22 @@
 package router
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"template-custom-agent-go/pkg/agent"
 	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/logger"
+	"template-custom-agent-go/pkg/metrics"
+	"template-custom-agent-go/pkg/middleware"
+	"template-custom-agent-go/pkg/models"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 // setupChatRoutes sets up chat-related routes
@@ -15,31 +25,273 @@ func (r *Router) setupChatRoutes(engine *gin.Engine) {
 	v1 := engine.Group("/v1")
 	{
 		v1.POST("/chat/completions", r.chatCompletions)
+		v1.POST("/embeddings", r.embeddings)
 	}
 
 	// Simple chat endpoint
 	engine.POST("/chat", r.simpleChat)
 }
 
-// chatCompletions handles OpenAI-compatible chat completion requests
+// agentModeHeader runs the agent loop with the MCP tools of the server on /v1/chat/completions
+const agentModeHeader = "X-Agent-Mode"
+
+// chatCompletions handles OpenAI-compatible chat completion requests. The agent loop runs the tools
+// of the server when the X-Agent-Mode header is true, or when every tool of the request is one the
+// server can run; other requests are passed through to the model.
 func (r *Router) chatCompletions(c *gin.Context) {
 	var req blaxel.ChatCompletionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.Error(fmt.Errorf("invalid request format: %w", err))
-		c.AbortWithStatus(http.StatusBadRequest)
+		abortWithError(c, http.StatusBadRequest, fmt.Errorf("invalid request format: %w", err))
+		return
+	}
+
+	agentMode, _ := strconv.ParseBool(c.GetHeader(agentModeHeader))
+	if (agentMode || len(req.Tools) > 0) && r.agentChatCompletion(c, req, agentMode) {
+		return
+	}
+
+	if req.Stream {
+		r.streamChatCompletion(c, req)
+		return
+	}
+
+	resp, err := r.blaxelClient.CreateChatCompletion(c.Request.Context(), req)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, fmt.Errorf("failed to get AI response: %w", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// maxEmbeddingInputs bounds the inputs of an embeddings request, as OpenAI does
+const maxEmbeddingInputs = 2048
+
+// embeddings handles OpenAI-compatible embeddings requests with the embedding model of the workspace
+func (r *Router) embeddings(c *gin.Context) {
+	var req blaxel.EmbeddingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithError(c, http.StatusBadRequest, fmt.Errorf("invalid request format: %w", err))
+		return
+	}
+	switch input := req.Input.(type) {
+	case string:
+		if input == "" {
+			abortWithError(c, http.StatusBadRequest, models.NewInvalidRequestError(fmt.Errorf("input is empty")))
+			return
+		}
+	case []interface{}:
+		if len(input) == 0 || len(input) > maxEmbeddingInputs {
+			abortWithError(c, http.StatusBadRequest, models.NewInvalidRequestError(
+				fmt.Errorf("input must have between 1 and %d items", maxEmbeddingInputs)))
+			return
+		}
+	default:
+		abortWithError(c, http.StatusBadRequest, models.NewInvalidRequestError(fmt.Errorf("input must be a string or an array")))
 		return
 	}
 
-	resp, err := r.blaxelClient.CreateChatCompletion(req)
+	resp, err := r.blaxelClient.CreateEmbeddings(c.Request.Context(), req)
 	if err != nil {
-		c.Error(fmt.Errorf("failed to get AI response: %w", err))
-		c.AbortWithStatus(http.StatusInternalServerError)
+		abortWithError(c, http.StatusInternalServerError, fmt.Errorf("failed to create embeddings: %w", err))
 		return
 	}
 
 	c.JSON(http.StatusOK, resp)
 }
 
+// agentChatCompletion answers a chat completion with the agent loop, continuing the conversation of
+// the request with the tools it names or all tools of the server. It reports false, leaving the
+// request to the model, when a tool is unknown to the server and agent mode was not asked for, as
+// the client then runs its own tools.
+func (r *Router) agentChatCompletion(c *gin.Context, req blaxel.ChatCompletionRequest, agentMode bool) bool {
+	start := time.Now()
+	request := agentRequest{Language: middleware.GetLanguage(c), ResponseFormat: req.ResponseFormat}
+	runAgent, err := r.buildAgent(c, "chat-agent", request)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return true
+	}
+
+	if len(req.Tools) > 0 {
+		names := make([]string, len(req.Tools))
+		for i, tool := range req.Tools {
+			names[i] = tool.Function.Name
+		}
+		if unknown := runAgent.KeepTools(names); len(unknown) > 0 {
+			if !agentMode {
+				return false
+			}
+			abortWithError(c, http.StatusBadRequest, models.NewInvalidRequestError(
+				fmt.Errorf("unknown tools %s", strings.Join(unknown, ", "))))
+			return true
+		}
+	}
+
+	out := &chunkStream{c: c}
+	id, created := "chatcmpl-"+uuid.NewString(), time.Now().Unix()
+	chunk := func(delta blaxel.ChunkDelta, finishReason *string) *blaxel.ChatCompletionChunk {
+		return &blaxel.ChatCompletionChunk{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   runAgent.GetModel(),
+			Choices: []blaxel.ChunkChoice{{Delta: delta, FinishReason: finishReason}},
+		}
+	}
+	// Answers changed after the model wrote them are sent whole in the last chunk
+	if req.Stream && !runAgent.PostProcessesAnswer() {
+		// Each turn streamed by the model starts on a new line
+		iteration := 0
+		runAgent.SetDeltaHandler(func(delta agent.Delta) {
+			content := delta.Content
+			if delta.Iteration != iteration {
+				if iteration > 0 {
+					content = "\n" + content
+				}
+				iteration = delta.Iteration
+			}
+			role := ""
+			if !out.started {
+				role = "assistant"
+			}
+			out.writeChunk(chunk(blaxel.ChunkDelta{Role: role, Content: content}, nil))
+		})
+	}
+
+	response, err := runAgent.RunConversation(c.Request.Context(), req.Messages)
+	r.recordRun(runRecord{ID: uuid.NewString(), RequestID: middleware.GetRequestID(c), Endpoint: c.FullPath(), Start: start}, runAgent, response, err)
+	if err == nil && len(response.Choices) == 0 {
+		err = models.NewUpstreamError(fmt.Errorf("no response generated"))
+	}
+	if err != nil {
+		out.fail(fmt.Errorf("agent execution failed: %w", err))
+		return true
+	}
+
+	if !req.Stream {
+		c.JSON(http.StatusOK, response)
+		return true
+	}
+
+	finishReason := response.Choices[0].FinishReason
+	last := chunk(blaxel.ChunkDelta{}, &finishReason)
+	if !out.started {
+		// Answers that were not streamed, such as handoffs and post-processed answers, are sent in one chunk
+		last.Choices[0].Delta = blaxel.ChunkDelta{Role: "assistant", Content: response.Choices[0].Message.Content}
+	}
+	out.writeChunk(last)
+	if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+		usage := chunk(blaxel.ChunkDelta{}, nil)
+		usage.Choices, usage.Usage = []blaxel.ChunkChoice{}, &response.Usage
+		out.writeChunk(usage)
+	}
+	out.done()
+	return true
+}
+
+// streamChatCompletion forwards a streamed chat completion as OpenAI chat.completion.chunk events
+// ending with "data: [DONE]". Usage chunks are only forwarded when the client asked for them with
+// stream_options.include_usage.
+func (r *Router) streamChatCompletion(c *gin.Context, req blaxel.ChatCompletionRequest) {
+	includeUsage := req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+	timing := metrics.StreamTiming{Model: r.blaxelClient.Model, Endpoint: c.FullPath(), Start: time.Now()}
+	out := &chunkStream{c: c}
+
+	resp, err := r.blaxelClient.StreamChatCompletion(c.Request.Context(), "", blaxel.NewRequestEncoder(), req, func(chunk *blaxel.ChatCompletionChunk, data []byte) error {
+		if chunk.Usage != nil && !includeUsage {
+			if len(chunk.Choices) == 0 {
+				return nil
+			}
+			chunk.Usage = nil
+			encoded, err := json.Marshal(chunk)
+			if err != nil {
+				return fmt.Errorf("failed to marshal stream chunk: %w", err)
+			}
+			data = encoded
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" || len(choice.Delta.ToolCalls) > 0 {
+				if timing.FirstToken.IsZero() {
+					timing.FirstToken = time.Now()
+				}
+				timing.LastToken = time.Now()
+			}
+		}
+		return out.write(data)
+	})
+
+	if out.started {
+		timing.End = time.Now()
+		if resp != nil {
+			timing.Tokens = resp.Usage.CompletionTokens
+		}
+		r.observeStream(timing)
+	}
+	if err != nil {
+		out.fail(fmt.Errorf("failed to get AI response: %w", err))
+		return
+	}
+	out.done()
+}
+
+// chunkStream writes OpenAI chat.completion.chunk events. The headers are only sent with the first
+// event, so a failure before it is still a regular error response.
+type chunkStream struct {
+	c       *gin.Context
+	started bool
+	failed  bool
+}
+
+// write writes and flushes one event with the given data, failing once the client stopped reading
+func (s *chunkStream) write(data []byte) error {
+	if s.failed {
+		return fmt.Errorf("client stopped reading the stream")
+	}
+	if !s.started {
+		s.started = true
+		startSSE(s.c)
+	}
+	if _, err := fmt.Fprintf(s.c.Writer, "data: %s\n\n", data); err != nil {
+		s.failed = true
+		return fmt.Errorf("client stopped reading the stream: %w", err)
+	}
+	s.c.Writer.Flush()
+	return nil
+}
+
+// writeChunk writes one chunk
+func (s *chunkStream) writeChunk(chunk *blaxel.ChatCompletionChunk) {
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		logger.ErrorfCtx(s.c.Request.Context(), "Failed to marshal stream chunk: %v", err)
+		return
+	}
+	if err := s.write(data); err != nil {
+		logger.DebugfCtx(s.c.Request.Context(), "Stopped streaming chat completion: %v", err)
+	}
+}
+
+// done ends the stream with the [DONE] event
+func (s *chunkStream) done() {
+	_ = s.write([]byte("[DONE]"))
+}
+
+// fail reports an error, as a regular error response before the stream has started and afterwards
+// as a final {"error": ...} event, which OpenAI SDKs raise
+func (s *chunkStream) fail(err error) {
+	if !s.started {
+		abortWithError(s.c, http.StatusInternalServerError, err)
+		return
+	}
+
+	_, errorCode := middleware.ClassifyError(err, http.StatusInternalServerError)
+	logger.ErrorfCtx(s.c.Request.Context(), "Stream failed after it started: %v, Path: %s", err, s.c.Request.URL.Path)
+	event, _ := json.Marshal(gin.H{"error": gin.H{"message": err.Error(), "type": errorCode}})
+	_ = s.write(event)
+	s.c.Abort()
+}
+
 // simpleChat handles simple chat requests
 func (r *Router) simpleChat(c *gin.Context) {
 	var request struct {
@@ -48,15 +300,13 @@ func (r *Router) simpleChat(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.Error(fmt.Errorf("invalid request: %w", err))
-		c.AbortWithStatus(http.StatusBadRequest)
+		abortWithError(c, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
 		return
 	}
 
-	response, err := r.blaxelClient.CreateSimpleCompletion(request.Message)
+	response, err := r.blaxelClient.CreateSimpleCompletion(c.Request.Context(), request.Message)
 	if err != nil {
-		c.Error(fmt.Errorf("failed to get AI response: %w", err))
-		c.AbortWithStatus(http.StatusInternalServerError)
+		abortWithError(c, http.StatusInternalServerError, fmt.Errorf("failed to get AI response: %w", err))
 		return
 	}
 
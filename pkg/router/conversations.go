@@ -0,0 +1,235 @@
+package router
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"template-custom-agent-go/pkg/agent"
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/config"
+	"template-custom-agent-go/pkg/logger"
+	"template-custom-agent-go/pkg/middleware"
+	"template-custom-agent-go/pkg/models"
+	"template-custom-agent-go/pkg/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SessionIDHeader carries the session of agent runs when conversations are persisted
+const SessionIDHeader = "X-Session-ID"
+
+// Page sizes of the conversation listing
+const (
+	defaultConversationPageSize = 20
+	maxConversationPageSize     = 100
+)
+
+// saveConversationTimeout bounds the write of a run to the conversation store
+const saveConversationTimeout = 5 * time.Second
+
+// defaultRunLogLines is the number of log lines kept per run when BL_RUN_LOG_LINES is not set
+const defaultRunLogLines = 200
+
+// setupConversationRoutes sets up the routes inspecting persisted conversations, which hold the inputs
+// of every caller and so require the operator role
+func (r *Router) setupConversationRoutes(engine *gin.Engine) {
+	conversations := engine.Group("/conversations", middleware.RequireRole(config.RoleOperator))
+	{
+		conversations.GET("", r.listConversations)
+		conversations.GET("/:id", r.getConversation)
+	}
+	if _, ok := r.conversations.(store.RunReader); ok {
+		engine.GET("/runs/:id", middleware.RequireRole(config.RoleOperator), r.getRun)
+	}
+}
+
+// listConversations returns the conversation summaries, the most recently updated first, paginated
+// with ?limit= and the opaque ?cursor= returned as next_cursor
+func (r *Router) listConversations(c *gin.Context) {
+	limit := defaultConversationPageSize
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxConversationPageSize {
+			abortWithError(c, http.StatusBadRequest,
+				models.NewInvalidRequestError(fmt.Errorf("limit must be between 1 and %d", maxConversationPageSize)))
+			return
+		}
+		limit = parsed
+	}
+
+	offset := 0
+	if cursor := c.Query("cursor"); cursor != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err == nil {
+			offset, err = strconv.Atoi(string(decoded))
+		}
+		if err != nil || offset < 0 {
+			abortWithError(c, http.StatusBadRequest, models.NewInvalidRequestError(fmt.Errorf("invalid cursor")))
+			return
+		}
+	}
+
+	conversations, err := r.conversations.List(c, limit, offset)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	total, err := r.conversations.Count(c)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := gin.H{
+		"conversations": conversations,
+		"count":         len(conversations),
+		"total_count":   total,
+	}
+	if end := offset + len(conversations); end < total && len(conversations) == limit {
+		response["next_cursor"] = base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(end)))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// getConversation returns a conversation with its messages, tool calls and usage
+func (r *Router) getConversation(c *gin.Context) {
+	conversation, err := r.conversations.Get(c, c.Param("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, models.NewNotFoundError(fmt.Errorf("conversation %s not found", c.Param("id"))))
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, conversation)
+}
+
+// getRun returns a persisted run with its messages, tool calls, usage and log lines
+func (r *Router) getRun(c *gin.Context) {
+	run, err := r.conversations.(store.RunReader).GetRun(c, c.Param("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, models.NewNotFoundError(fmt.Errorf("run %s not found", c.Param("id"))))
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, run)
+}
+
+// runLogLinesFromEnv returns the number of log lines kept per persisted run, from BL_RUN_LOG_LINES
+func runLogLinesFromEnv() int {
+	if value := os.Getenv("BL_RUN_LOG_LINES"); value != "" {
+		if lines, err := strconv.Atoi(value); err == nil && lines >= 0 {
+			return lines
+		}
+		logger.Warningf("Invalid BL_RUN_LOG_LINES %q, using %d", value, defaultRunLogLines)
+	}
+	return defaultRunLogLines
+}
+
+// conversationRecorder collects the tool calls and log lines of a run for the conversation store
+type conversationRecorder struct {
+	arguments map[string]string
+	toolCalls []store.ToolCall
+	logs      *logger.Capture
+}
+
+// newConversationRecorder creates a recorder when conversations are persisted, nil otherwise
+func (r *Router) newConversationRecorder() *conversationRecorder {
+	if r.conversations == nil {
+		return nil
+	}
+	return &conversationRecorder{arguments: make(map[string]string), logs: logger.NewCapture(r.runLogLines)}
+}
+
+// capture returns the context of the run, whose log lines are kept by the recorder
+func (cr *conversationRecorder) capture(ctx context.Context) context.Context {
+	if cr == nil {
+		return ctx
+	}
+	return logger.WithCapture(ctx, cr.logs)
+}
+
+// tool records a tool call once its result is known, with the arguments of its start event
+func (cr *conversationRecorder) tool(event agent.ToolEvent) {
+	if cr == nil {
+		return
+	}
+	if event.Status == agent.ToolStarted {
+		cr.arguments[event.ToolCallID] = event.Arguments
+		return
+	}
+	cr.toolCalls = append(cr.toolCalls, store.ToolCall{
+		ToolCallID:  event.ToolCallID,
+		Iteration:   event.Iteration,
+		Name:        event.Name,
+		Arguments:   cr.arguments[event.ToolCallID],
+		Status:      event.Status,
+		DurationMs:  event.DurationMs,
+		ResultBytes: event.ResultBytes,
+		Flagged:     event.Flagged,
+	})
+}
+
+// saveConversation adds a run to the conversation of its session: the input, the assistant turns,
+// the tool calls and the token usage of each model. Failures are logged without failing the run.
+func (r *Router) saveConversation(ctx context.Context, recorder *conversationRecorder, record runRecord, runAgent *agent.Agent,
+	request agentRequest, response *blaxel.ChatCompletionResponse) {
+	if recorder == nil {
+		return
+	}
+
+	messages := []store.Message{{Role: "user", Content: request.Inputs}}
+	for _, turn := range runAgent.IntermediateMessages() {
+		messages = append(messages, store.Message{Role: "assistant", Content: turn.Content, Iteration: turn.Iteration})
+	}
+	stats := runAgent.Stats()
+	if response != nil && len(response.Choices) > 0 {
+		messages = append(messages, store.Message{
+			Role:      "assistant",
+			Content:   response.Choices[0].Message.Content,
+			Iteration: stats.Iterations,
+		})
+	}
+
+	usage := make([]store.RunUsage, 0, len(stats.Usage))
+	for model, modelUsage := range stats.Usage {
+		usage = append(usage, store.RunUsage{Model: model, Usage: store.Usage{
+			PromptTokens:     modelUsage.PromptTokens,
+			CompletionTokens: modelUsage.CompletionTokens,
+			TotalTokens:      modelUsage.TotalTokens,
+		}})
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Model < usage[j].Model })
+
+	var logs []store.LogLine
+	for _, line := range recorder.logs.Lines() {
+		logs = append(logs, store.LogLine{Level: line.Level.String(), Message: line.Message, CreatedAt: line.Time})
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), saveConversationTimeout)
+	defer cancel()
+	err := r.conversations.SaveRun(ctx, store.Run{
+		ConversationID: request.SessionID,
+		RunID:          record.ID,
+		Agent:          runAgent.GetName(),
+		Messages:       messages,
+		ToolCalls:      recorder.toolCalls,
+		Usage:          usage,
+		Logs:           logs,
+		At:             time.Now(),
+	})
+	if err != nil {
+		logger.WarningfCtx(ctx, "Failed to persist the conversation of session %s: %v", request.SessionID, err)
+	}
+}
@@ -0,0 +1,94 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"template-custom-agent-go/pkg/agentext"
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/logger"
+	"template-custom-agent-go/pkg/models"
+	"template-custom-agent-go/pkg/store"
+
+	"github.com/google/uuid"
+)
+
+// defaultChannelName is the endpoint recorded for channel runs that do not name their channel
+const defaultChannelName = "channel"
+
+// openConversationStore opens the conversation store of the extension providing one, or the
+// built-in store configured by the environment
+func openConversationStore(ctx context.Context) (store.Store, error) {
+	if open, name := agentext.ConversationStore(); open != nil {
+		conversations, err := open(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the conversation store of extension %s: %w", name, err)
+		}
+		if conversations != nil {
+			logger.Infof("Persisting conversations with extension %s", name)
+		}
+		return conversations, nil
+	}
+
+	repository, err := store.NewConversationRepositoryFromEnv(ctx)
+	if repository == nil {
+		return nil, err
+	}
+	return repository, nil
+}
+
+// RunAgent runs the agent for a channel of an extension. The run is recorded in run summaries and
+// conversations like those of /agent.
+func (r *Router) RunAgent(ctx context.Context, request agentext.RunRequest) (*agentext.RunResult, error) {
+	start := time.Now()
+	channel := request.Channel
+	if channel == "" {
+		channel = defaultChannelName
+	}
+	runRequest := agentRequest{
+		Inputs:    request.Inputs,
+		SessionID: request.SessionID,
+		Metadata:  request.Metadata,
+		Tags:      request.Tags,
+	}
+	if r.conversations == nil {
+		runRequest.SessionID = ""
+	} else if runRequest.SessionID == "" {
+		runRequest.SessionID = uuid.NewString()
+	}
+
+	runAgent, err := r.buildAgent(ctx, "channel-agent", runRequest)
+	if err != nil {
+		return nil, err
+	}
+	recorder := r.newConversationRecorder()
+	if recorder != nil {
+		runAgent.SetToolHandler(recorder.tool)
+	}
+
+	record := runRecord{
+		ID:       uuid.NewString(),
+		Endpoint: channel,
+		Metadata: request.Metadata,
+		Tags:     request.Tags,
+		Start:    start,
+	}
+	response, err := runAgent.Run(recorder.capture(blaxel.WithRunID(ctx, record.ID)), request.Inputs)
+	r.recordRun(record, runAgent, response, err)
+	r.saveConversation(ctx, recorder, record, runAgent, runRequest, response)
+	if err != nil {
+		return nil, fmt.Errorf("agent execution failed: %w", err)
+	}
+	if len(response.Choices) == 0 {
+		return nil, models.NewUpstreamError(fmt.Errorf("no response generated"))
+	}
+
+	return &agentext.RunResult{
+		RunID:        record.ID,
+		SessionID:    runRequest.SessionID,
+		Answer:       response.Choices[0].Message.Content,
+		FinishReason: response.Choices[0].FinishReason,
+		Usage:        response.Usage,
+	}, nil
+}
@@ -3,6 +3,9 @@ package router
 import (
 	"net/http"
 
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/metrics"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -27,10 +30,20 @@ func (r *Router) healthCheck(c *gin.Context) {
 
 // readinessCheck handles readiness probe requests
 func (r *Router) readinessCheck(c *gin.Context) {
-	// Check if MCP servers are available
-	serverCount := r.blaxelClient.McpManager.GetServerCount()
+	// Check that the configured model exists
+	if err := r.blaxelClient.ModelValidationError(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status": "not ready",
+			"reason": err.Error(),
+		})
+		return
+	}
 
-	if serverCount == 0 {
+	// Check if MCP servers pass their health checks, agents answering without tools unless they are required
+	serverCount := r.blaxelClient.McpManager.HealthyServerCount()
+	localTools := r.blaxelClient.Sandbox != nil || r.blaxelClient.RemoteAgentsEnabled()
+
+	if serverCount == 0 && blaxel.ToolsRequired() {
 		c.JSON(http.StatusServiceUnavailable, gin.H{
 			"status": "not ready",
 			"reason": "no MCP servers available",
@@ -38,10 +51,46 @@ func (r *Router) readinessCheck(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"status":      "ready",
+	// Report the servers failing their health checks, which are being reconnected
+	var unhealthy []string
+	for _, server := range r.blaxelClient.McpManager.ServerHealth() {
+		if server.Status != blaxel.MCPServerHealthy {
+			unhealthy = append(unhealthy, server.Name)
+		}
+	}
+
+	// Check that the tool catalog has been loaded when agent traffic is gated on it
+	if blaxel.WarmupGateEnabled() && !r.toolCatalog.IsWarm() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status": "not ready",
+			"reason": "tool catalog not loaded yet",
+		})
+		return
+	}
+
+	// Report models and MCP servers breaching their SLO thresholds without failing readiness
+	sloStatuses := metrics.SLOStatuses()
+	degraded := len(unhealthy) > 0
+	for _, status := range sloStatuses {
+		degraded = degraded || status.Degraded
+	}
+
+	status := "ready"
+	if serverCount == 0 && !localTools {
+		status = "ready (no tools)"
+	}
+	response := gin.H{
+		"status":      status,
 		"mcp_servers": serverCount,
-	})
+		"degraded":    degraded,
+	}
+	if len(unhealthy) > 0 {
+		response["unhealthy_mcp_servers"] = unhealthy
+	}
+	if len(sloStatuses) > 0 {
+		response["slo"] = sloStatuses
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // livenessCheck handles liveness probe requests
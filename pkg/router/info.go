@@ -0,0 +1,143 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"template-custom-agent-go/pkg/agentext"
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/config"
+	"template-custom-agent-go/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serverInfo describes how the server resolved its configuration, logged at startup and served by
+// GET /admin/info. Credentials are never included.
+type serverInfo struct {
+	UserAgent  string           `json:"user_agent"`
+	Build      config.BuildInfo `json:"build"`
+	StartedAt  time.Time        `json:"started_at"`
+	Address    string           `json:"address,omitempty"`
+	Workspace  string           `json:"workspace"`
+	Model      string           `json:"model"`
+	RunURL     string           `json:"run_url"`
+	APIURL     string           `json:"api_url"`
+	Execution  config.Execution `json:"execution"`
+	Deployment deploymentInfo   `json:"deployment"`
+	// Middleware lists the global middleware in the order they run
+	Middleware []string          `json:"middleware"`
+	MCPServers []mcpServerStatus `json:"mcp_servers"`
+	Features   map[string]bool   `json:"features"`
+	// Extensions lists the extensions linked into the binary
+	Extensions []string `json:"extensions,omitempty"`
+}
+
+// deploymentInfo is the deployment configuration without its API keys
+type deploymentInfo struct {
+	Mode           string   `json:"mode"`
+	RequireAuth    bool     `json:"require_auth"`
+	APIKeys        int      `json:"api_keys"`
+	CORSOrigins    []string `json:"cors_origins,omitempty"`
+	RateLimitRPS   float64  `json:"rate_limit_rps"`
+	RateLimitBurst int      `json:"rate_limit_burst"`
+	DebugEndpoints bool     `json:"debug_endpoints"`
+}
+
+// Statuses of configured MCP servers
+const (
+	mcpServerConnected   = "connected"
+	mcpServerUnavailable = "unavailable"
+)
+
+// mcpServerStatus is a configured MCP server and whether it is connected
+type mcpServerStatus struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Status string `json:"status"`
+}
+
+// newServerInfo describes the client configuration and the deployment, the middleware being added
+// as they are installed
+func (r *Router) newServerInfo(deployment config.DeploymentConfig) serverInfo {
+	return serverInfo{
+		UserAgent:  blaxel.UserAgent(""),
+		Build:      config.ReadBuildInfo(),
+		StartedAt:  time.Now(),
+		Workspace:  r.blaxelClient.Workspace,
+		Model:      r.blaxelClient.Model,
+		RunURL:     r.blaxelClient.RunUrl,
+		APIURL:     r.blaxelClient.ApiUrl,
+		Execution:  r.execution,
+		Extensions: agentext.Names(),
+		Deployment: deploymentInfo{
+			Mode:           deployment.Mode,
+			RequireAuth:    deployment.RequireAuth,
+			APIKeys:        len(deployment.APIKeys),
+			CORSOrigins:    deployment.CORSOrigins,
+			RateLimitRPS:   deployment.RateLimitRPS,
+			RateLimitBurst: deployment.RateLimitBurst,
+			DebugEndpoints: deployment.DebugEndpoints,
+		},
+		Features: map[string]bool{
+			"sandbox_tools":    r.blaxelClient.Sandbox != nil,
+			"remote_agents":    r.blaxelClient.RemoteAgentsEnabled(),
+			"warmup_gate":      blaxel.WarmupGateEnabled(),
+			"tools_required":   blaxel.ToolsRequired(),
+			"response_signing": r.signer != nil,
+			"result_storage":   r.resultStore != nil,
+			"run_summaries":    r.runSummaries != nil,
+			"native_tools":     r.nativeTools.Len() > 0,
+			"glossary":         r.glossary != nil,
+			"conversations":    r.conversations != nil,
+			"agents_config":    r.orchestrator.Registry().Path() != "",
+		},
+	}
+}
+
+// current returns the information with the present status of the MCP servers
+func (info serverInfo) current(mcpManager *blaxel.MCPManager, servers []blaxel.MCPServerConfig) serverInfo {
+	info.MCPServers = make([]mcpServerStatus, 0, len(servers))
+	for _, server := range servers {
+		status := mcpServerUnavailable
+		if mcpManager.IsConnected(server.Name) {
+			status = mcpServerConnected
+		}
+		info.MCPServers = append(info.MCPServers, mcpServerStatus{
+			Name:   server.Name,
+			URL:    redactURL(server.URL),
+			Status: status,
+		})
+	}
+	return info
+}
+
+// redactURL hides the password and query string of a URL, which may carry credentials
+func redactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	if parsed.RawQuery != "" {
+		parsed.RawQuery = "redacted"
+	}
+	return parsed.Redacted()
+}
+
+// Announce records the address the server listens on and logs the startup summary
+func (r *Router) Announce(address string) {
+	r.info.Address = address
+	summary, err := json.Marshal(r.info.current(r.blaxelClient.McpManager, r.blaxelClient.MCPServers))
+	if err != nil {
+		logger.Errorf("Failed to marshal startup summary: %v", err)
+		return
+	}
+	logger.Infof("Startup summary: %s", summary)
+}
+
+// adminInfo serves the startup summary with the present status of the MCP servers
+func (r *Router) adminInfo(c *gin.Context) {
+	c.JSON(http.StatusOK, r.info.current(r.blaxelClient.McpManager, r.blaxelClient.MCPServers))
+}
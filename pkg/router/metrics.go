@@ -0,0 +1,14 @@
+package router
+
+import (
+	"template-custom-agent-go/pkg/config"
+	"template-custom-agent-go/pkg/metrics"
+	"template-custom-agent-go/pkg/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupMetricsRoutes sets up the Prometheus metrics route, restricted to operator API keys
+func (r *Router) setupMetricsRoutes(engine *gin.Engine) {
+	engine.GET("/metrics", middleware.RequireRole(config.RoleOperator), gin.WrapH(metrics.Handler()))
+}
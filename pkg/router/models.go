@@ -0,0 +1,40 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupModelRoutes sets up model-related routes
+func (r *Router) setupModelRoutes(engine *gin.Engine) {
+	models := engine.Group("/models")
+	{
+		models.GET("/blaxel", r.listBlaxelModels)
+	}
+}
+
+// listBlaxelModels handles requests listing the models deployed in the workspace
+func (r *Router) listBlaxelModels(c *gin.Context) {
+	models, err := r.blaxelClient.ListModels(c)
+	if err != nil {
+		abortWithError(c, http.StatusBadGateway, fmt.Errorf("failed to list models: %w", err))
+		return
+	}
+
+	configuredValid := false
+	for _, model := range models {
+		if model.Name == r.blaxelClient.Model {
+			configuredValid = true
+			break
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"models":           models,
+		"count":            len(models),
+		"configured_model": r.blaxelClient.Model,
+		"configured_valid": configuredValid,
+	})
+}
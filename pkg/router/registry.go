@@ -0,0 +1,92 @@
+package router
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RouteInfo describes a registered route and the route group that registered it
+type RouteInfo struct {
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	Group   string `json:"group"`
+	Handler string `json:"handler"`
+}
+
+// routeRegistry records which route group registered each route, so conflicts are reported
+// with both owners instead of as a bare gin panic
+type routeRegistry struct {
+	routes []RouteInfo
+	owners map[string]string
+}
+
+// newRouteRegistry creates an empty route registry
+func newRouteRegistry() *routeRegistry {
+	return &routeRegistry{owners: make(map[string]string)}
+}
+
+// register runs the setup function of a route group and records the routes it added.
+// Gin panics on conflicting routes; the panic is turned into an error naming the groups involved.
+func (rr *routeRegistry) register(engine *gin.Engine, group string, setup func(*gin.Engine)) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = rr.conflictError(group, recovered)
+		}
+		rr.record(engine, group)
+	}()
+
+	setup(engine)
+	return nil
+}
+
+// record attributes the routes added since the last call to the group
+func (rr *routeRegistry) record(engine *gin.Engine, group string) {
+	for _, route := range engine.Routes() {
+		key := route.Method + " " + route.Path
+		if _, exists := rr.owners[key]; exists {
+			continue
+		}
+		rr.owners[key] = group
+		rr.routes = append(rr.routes, RouteInfo{
+			Method:  route.Method,
+			Path:    route.Path,
+			Group:   group,
+			Handler: route.Handler,
+		})
+	}
+}
+
+// conflictError describes a route registration panic, naming the groups owning routes on the same path
+func (rr *routeRegistry) conflictError(group string, recovered interface{}) error {
+	message := fmt.Sprint(recovered)
+
+	var existing []string
+	for key, owner := range rr.owners {
+		_, path, _ := strings.Cut(key, " ")
+		if strings.Contains(message, "'"+path+"'") {
+			existing = append(existing, fmt.Sprintf("%s (%s routes)", key, owner))
+		}
+	}
+	sort.Strings(existing)
+
+	if len(existing) == 0 {
+		return fmt.Errorf("invalid route in %s routes: %s", group, message)
+	}
+	return fmt.Errorf("route conflict in %s routes: %s, already registered: %s", group, message, strings.Join(existing, ", "))
+}
+
+// Routes returns the registered routes sorted by path and method
+func (rr *routeRegistry) Routes() []RouteInfo {
+	routes := make([]RouteInfo, len(rr.routes))
+	copy(routes, rr.routes)
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
+}
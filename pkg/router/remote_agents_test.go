@@ -0,0 +1,83 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"template-custom-agent-go/pkg/agent"
+	"template-custom-agent-go/pkg/blaxel"
+
+	"github.com/blaxel-ai/toolkit/sdk"
+	"github.com/gin-gonic/gin"
+)
+
+// newTestModel serves chat completions answering answer, in chunks when streaming is requested
+func newTestModel(t *testing.T, answer string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var request struct {
+			Stream bool `json:"stream"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !request.Stream {
+			json.NewEncoder(w).Encode(blaxel.ChatCompletionResponse{
+				Choices: []blaxel.Choice{{Message: blaxel.ChatMessage{Role: "assistant", Content: answer}, FinishReason: "stop"}},
+			})
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, word := range strings.SplitAfter(answer, " ") {
+			chunk, _ := json.Marshal(blaxel.ChatCompletionChunk{Choices: []blaxel.ChunkChoice{{Delta: blaxel.ChunkDelta{Content: word}}}})
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+		stop := "stop"
+		chunk, _ := json.Marshal(blaxel.ChatCompletionChunk{Choices: []blaxel.ChunkChoice{{FinishReason: &stop}}})
+		fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n\n", chunk)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTestClient creates a client of the workspace "test" whose run URL is runURL
+func newTestClient(t *testing.T, runURL string) *blaxel.Client {
+	t.Helper()
+	client, err := sdk.NewClientWithResponses(runURL, runURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &blaxel.Client{BlaxelClient: client, Workspace: "test", RunUrl: runURL, Model: "test-model"}
+}
+
+// TestRunRemoteAgentOnRoot runs a sibling agent through the root endpoint, which streams SSE events by
+// default, and checks the delegating agent reads the plain-text answer
+func TestRunRemoteAgentOnRoot(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	model := newTestModel(t, "The sibling answer")
+	sibling := &Router{blaxelClient: newTestClient(t, model.URL)}
+	build := func(ctx context.Context, request agentRequest) (*agent.Agent, error) {
+		return agent.NewAgent(agent.Config{Name: "sibling", Model: "test-model", SystemPrompt: "You answer."}, sibling.blaxelClient), nil
+	}
+	engine := gin.New()
+	engine.POST("/test/agents/sibling", sibling.agentRunHandler(func(*gin.Context, *agentRequest) (agentBuilder, error) {
+		return build, nil
+	}, rootFormats...))
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	answer, err := newTestClient(t, server.URL).RunRemoteAgent(context.Background(), "sibling", "Hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer != "The sibling answer" {
+		t.Errorf("answer = %q, want %q", answer, "The sibling answer")
+	}
+}
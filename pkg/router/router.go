@@ -1,44 +1,169 @@
 package router
 
 import (
+	"context"
 	"net/http"
 
+	"template-custom-agent-go/pkg/a2a"
+	"template-custom-agent-go/pkg/agent"
+	"template-custom-agent-go/pkg/agentext"
+	"template-custom-agent-go/pkg/analytics"
 	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/config"
+	"template-custom-agent-go/pkg/glossary"
+	"template-custom-agent-go/pkg/logger"
 	"template-custom-agent-go/pkg/middleware"
+	"template-custom-agent-go/pkg/orchestrator"
+	"template-custom-agent-go/pkg/signing"
+	"template-custom-agent-go/pkg/storage"
+	"template-custom-agent-go/pkg/store"
+	"template-custom-agent-go/pkg/stream"
+	"template-custom-agent-go/pkg/tools"
 
 	"github.com/gin-gonic/gin"
 )
 
 // Router holds the dependencies needed for all routes
 type Router struct {
-	blaxelClient *blaxel.Client
+	blaxelClient  *blaxel.Client
+	toolCatalog   *agent.ToolCatalog
+	nativeTools   *tools.Registry
+	a2aTasks      *a2a.TaskStore
+	a2aStreams    *stream.Hub
+	routes        *routeRegistry
+	signer        *signing.Signer
+	resultStore   *storage.ResultStore
+	runSummaries  *analytics.Exporter
+	glossary      *glossary.Glossary
+	conversations store.Store
+	orchestrator  *orchestrator.Orchestrator
+	execution     config.Execution
+	runLogLines   int
+	info          serverInfo
 }
 
 // NewRouter creates a new router with dependencies
 func NewRouter(blaxelClient *blaxel.Client) *Router {
 	return &Router{
 		blaxelClient: blaxelClient,
+		toolCatalog:  agent.NewToolCatalog(blaxelClient.McpManager),
+		nativeTools:  tools.Default,
+		a2aTasks:     a2a.NewTaskStore(),
+		a2aStreams:   stream.NewHub(stream.HubConfigFromEnv()),
+		routes:       newRouteRegistry(),
+		signer:       signing.NewSignerFromEnv(),
+		runSummaries: analytics.NewExporterFromEnv(),
+		execution:    config.ExecutionFromEnv(),
+		runLogLines:  runLogLinesFromEnv(),
 	}
 }
 
-// SetupRoutes configures all routes for the application
-func (r *Router) SetupRoutes() *gin.Engine {
+// SetupRoutes configures all routes for the application.
+// It returns an error when the deployment configuration is invalid or two route groups register conflicting routes.
+func (r *Router) SetupRoutes() (*gin.Engine, error) {
 	// Create a Gin router without default middleware
 	engine := gin.New()
 
+	deployment, err := config.LoadDeploymentConfig()
+	if err != nil {
+		return nil, err
+	}
+	if r.resultStore, err = storage.NewResultStoreFromEnv(); err != nil {
+		return nil, err
+	}
+	if r.glossary, err = glossary.NewFromEnv(); err != nil {
+		return nil, err
+	}
+	if r.conversations, err = openConversationStore(context.Background()); err != nil {
+		return nil, err
+	}
+	if r.orchestrator, err = r.newOrchestrator(); err != nil {
+		return nil, err
+	}
+	r.info = r.newServerInfo(deployment)
+	use := func(name string, handler gin.HandlerFunc) {
+		engine.Use(handler)
+		r.info.Middleware = append(r.info.Middleware, name)
+	}
+
 	// Add custom middleware stack
-	engine.Use(middleware.LoggingMiddleware())        // Custom logging
-	engine.Use(middleware.CustomRecoveryMiddleware()) // Custom panic recovery
-	engine.Use(middleware.ErrorHandlerMiddleware())   // Custom error handling
+	use("request_id", middleware.RequestIDMiddleware())       // Request ID assignment
+	use("tracing", middleware.TracingMiddleware())            // Request span for trace IDs in logs
+	use("language", middleware.LanguageMiddleware())          // Language of server-generated strings
+	use("logging", middleware.LoggingMiddleware())            // Custom logging
+	use("recovery", middleware.CustomRecoveryMiddleware())    // Custom panic recovery
+	use("error_handler", middleware.ErrorHandlerMiddleware()) // Custom error handling
+	if !r.execution.IsZero() {
+		use("execution", middleware.ExecutionMiddleware(r.execution)) // Blaxel execution and task headers
+	}
+
+	// Add the middleware enabled by the deployment mode
+	logger.Infof("Deployment mode %s: auth=%t cors=%v rate_limit=%g/s debug_endpoints=%t",
+		deployment.Mode, deployment.RequireAuth, deployment.CORSOrigins, deployment.RateLimitRPS, deployment.DebugEndpoints)
+	if len(deployment.CORSOrigins) > 0 {
+		use("cors", middleware.CORSMiddleware(deployment.CORSOrigins))
+	}
+	if deployment.RequireAuth {
+		use("auth", middleware.AuthMiddleware(deployment.APIKeys))
+	}
+	if deployment.RateLimitRPS > 0 {
+		use("rate_limit", middleware.RateLimitMiddleware(deployment.RateLimitRPS, deployment.RateLimitBurst))
+	}
+
+	// Setup all route groups, reporting conflicting routes as an error
+	groups := []struct {
+		name  string
+		setup func(*gin.Engine)
+	}{
+		{"health", r.setupHealthRoutes},
+		{"tools", r.setupToolRoutes},
+		{"agent", r.setupAgentRoutes},
+		{"agents", r.setupNamedAgentRoutes},
+		{"chat", r.setupChatRoutes},
+		{"a2a", r.setupA2ARoutes},
+		{"conversations", r.setupConversationRoutes},
+		{"models", r.setupModelRoutes},
+		{"metrics", r.setupMetricsRoutes},
+		{"admin", r.setupAdminRoutes},
+		{"root", r.setupRootRoutes},
+	}
+	for _, channel := range agentext.Channels() {
+		setup := channel.Setup
+		groups = append(groups, struct {
+			name  string
+			setup func(*gin.Engine)
+		}{"extension " + channel.Extension, func(engine *gin.Engine) { setup(engine, r) }})
+	}
+	for _, group := range groups {
+		if group.name == "admin" && !deployment.DebugEndpoints {
+			continue
+		}
+		if group.name == "conversations" && r.conversations == nil {
+			continue
+		}
+		if err := r.routes.register(engine, group.name, group.setup); err != nil {
+			return nil, err
+		}
+	}
 
-	// Setup all route groups
-	r.setupHealthRoutes(engine)
-	r.setupToolRoutes(engine)
-	r.setupAgentRoutes(engine)
-	r.setupChatRoutes(engine)
-	r.setupRootRoutes(engine)
+	return engine, nil
+}
+
+// Close releases the resources held by the routes once the server has stopped
+func (r *Router) Close() {
+	if r.conversations != nil {
+		if err := r.conversations.Close(); err != nil {
+			logger.Warningf("Failed to close the conversation store: %v", err)
+		}
+	}
+}
 
-	return engine
+// abortWithError records the error for the error handler middleware and stops the handler chain.
+// The status is used when the type of the error does not determine one.
+func abortWithError(c *gin.Context, status int, err error) {
+	c.Error(err)
+	c.Status(status)
+	c.Abort()
 }
 
 // setupRootRoutes sets up root and documentation routes
@@ -53,7 +178,7 @@ func (r *Router) rootEndpoint(c *gin.Context) {
 		"version": "1.0.0",
 		"endpoints": gin.H{
 			"/": []string{
-				"POST / - Stream agent execution",
+				"POST / - Stream agent events as SSE (iteration, message.delta, tool.call, tool.result, usage, done)",
 			},
 			"health": []string{
 				"GET /health - Basic health check",
@@ -61,7 +186,8 @@ func (r *Router) rootEndpoint(c *gin.Context) {
 				"GET /health/live - Liveness probe",
 			},
 			"tools": []string{
-				"GET /tools - List all tools from all MCP servers",
+				"GET /tools - List all tools from all MCP servers (?server=, ?q=, ?limit=, ?cursor=)",
+				"GET /tools/search?q= - Search tools by name and description",
 				"GET /tools/servers - List all MCP servers",
 				"GET /tools/servers/:server/tools - List tools from specific server",
 			},
@@ -69,16 +195,46 @@ func (r *Router) rootEndpoint(c *gin.Context) {
 				"POST /agent - Run agent with tool calling",
 				"POST /agent/run - Alternative agent endpoint",
 			},
+			"agents": []string{
+				"GET /agents - List the named agents",
+				"POST /agents - Create a named agent",
+				"GET /agents/:name - Describe a named agent",
+				"PUT /agents/:name - Replace a named agent",
+				"DELETE /agents/:name - Delete a named agent",
+				"POST /agents/:name/run - Run a named agent, delegating sub-tasks to the agents it supervises",
+				"POST /agents/:name - Alternative named agent endpoint",
+			},
 			"chat": []string{
 				"POST /v1/chat/completions - OpenAI-compatible chat completions",
+				"POST /v1/embeddings - OpenAI-compatible embeddings",
 				"POST /chat - Simple chat interface",
 			},
+			"models": []string{
+				"GET /models/blaxel - List models deployed in the workspace",
+			},
+			"metrics": []string{
+				"GET /metrics - Prometheus metrics",
+			},
+			"admin": []string{
+				"GET /admin/routes - List the registered routes",
+				"GET /admin/info - Resolved configuration and build information",
+			},
+			"conversations": []string{
+				"GET /conversations - List persisted conversations (?limit=, ?cursor=)",
+				"GET /conversations/:id - Messages, tool calls and usage of a conversation",
+				"GET /runs/:id - Messages, tool calls, usage and log lines of a run",
+			},
+			"a2a": []string{
+				"GET /.well-known/agent.json - A2A agent card",
+				"POST /a2a - A2A JSON-RPC endpoint (message/send, message/stream, tasks/get, tasks/cancel)",
+			},
 		},
 		"features": []string{
 			"Multi-MCP server support",
 			"OpenAI-compatible API",
 			"Tool calling and routing",
 			"Health monitoring",
+			"A2A protocol support",
 		},
 	})
 }
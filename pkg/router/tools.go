@@ -1,8 +1,17 @@
 package router
 
 import (
+	"encoding/base64"
 	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"template-custom-agent-go/pkg/agent"
+	"template-custom-agent-go/pkg/blaxel"
+	"template-custom-agent-go/pkg/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,22 +21,135 @@ func (r *Router) setupToolRoutes(engine *gin.Engine) {
 	tools := engine.Group("/tools")
 	{
 		tools.GET("", r.listTools)
+		tools.GET("/search", r.searchTools)
 		tools.GET("/servers", r.listMCPServers)
 		tools.GET("/servers/:server/tools", r.listServerTools)
 	}
 }
 
-// listTools handles tool listing requests from all servers
+// Page sizes of the tool listing
+const (
+	defaultToolPageSize = 100
+	maxToolPageSize     = 1000
+
+	defaultToolSearchLimit = 10
+)
+
+// listTools handles tool listing requests from all servers, filtered by ?server= and ?q= and
+// paginated with ?limit= and the opaque ?cursor= returned as next_cursor
 func (r *Router) listTools(c *gin.Context) {
-	tools, err := r.blaxelClient.McpManager.ListAllTools(c)
+	limit := defaultToolPageSize
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxToolPageSize {
+			abortWithError(c, http.StatusBadRequest,
+				models.NewInvalidRequestError(fmt.Errorf("limit must be between 1 and %d", maxToolPageSize)))
+			return
+		}
+		limit = parsed
+	}
+
+	after := ""
+	if cursor := c.Query("cursor"); cursor != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, models.NewInvalidRequestError(fmt.Errorf("invalid cursor")))
+			return
+		}
+		after = string(decoded)
+	}
+
+	catalog, err := r.toolCatalog.Snapshot(c)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, fmt.Errorf("failed to list tools: %w", err))
+		return
+	}
+	if notModified(c, catalog) {
+		return
+	}
+
+	// Filter the catalog, which is sorted by server and tool name
+	server := c.Query("server")
+	query := strings.ToLower(c.Query("q"))
+	var matches []blaxel.ToolWithServer
+	for _, toolWithServer := range catalog.MCPTools() {
+		if server != "" && toolWithServer.ServerName != server {
+			continue
+		}
+		if query != "" && !strings.Contains(strings.ToLower(toolWithServer.Tool.Name), query) &&
+			!strings.Contains(strings.ToLower(toolWithServer.Tool.Description), query) {
+			continue
+		}
+		matches = append(matches, toolWithServer)
+	}
+
+	// Resume after the tool the cursor points to
+	start := 0
+	if after != "" {
+		start = sort.Search(len(matches), func(i int) bool { return toolCursorKey(matches[i]) > after })
+	}
+	end := min(start+limit, len(matches))
+	page := matches[start:end]
+
+	response := gin.H{
+		"tools":           page,
+		"count":           len(page),
+		"total_count":     len(matches),
+		"catalog_version": catalog.Generation,
+	}
+	if end < len(matches) {
+		response["next_cursor"] = base64.RawURLEncoding.EncodeToString([]byte(toolCursorKey(matches[end-1])))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// toolCursorKey returns the sort key of a tool in the catalog, used as pagination cursor
+func toolCursorKey(toolWithServer blaxel.ToolWithServer) string {
+	return toolWithServer.ServerName + "\x00" + toolWithServer.Tool.Name
+}
+
+// searchTools handles ranked tool search requests over tool names and descriptions
+func (r *Router) searchTools(c *gin.Context) {
+	query := c.Query("q")
+	if strings.TrimSpace(query) == "" {
+		abortWithError(c, http.StatusBadRequest, models.NewInvalidRequestError(fmt.Errorf("query parameter q is required")))
+		return
+	}
+
+	limit := defaultToolSearchLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxToolPageSize {
+			abortWithError(c, http.StatusBadRequest,
+				models.NewInvalidRequestError(fmt.Errorf("limit must be between 1 and %d", maxToolPageSize)))
+			return
+		}
+		limit = parsed
+	}
+
+	catalog, err := r.toolCatalog.Snapshot(c)
 	if err != nil {
-		c.Error(fmt.Errorf("failed to list tools: %w", err))
+		abortWithError(c, http.StatusInternalServerError, fmt.Errorf("failed to list tools: %w", err))
 		return
 	}
 
+	tools := catalog.MCPTools()
+	if server := c.Query("server"); server != "" {
+		var serverTools []blaxel.ToolWithServer
+		for _, toolWithServer := range tools {
+			if toolWithServer.ServerName == server {
+				serverTools = append(serverTools, toolWithServer)
+			}
+		}
+		tools = serverTools
+	}
+
+	matches := agent.SearchTools(tools, query, limit)
 	c.JSON(http.StatusOK, gin.H{
-		"tools":       tools,
-		"total_count": len(tools),
+		"query":           query,
+		"matches":         matches,
+		"count":           len(matches),
+		"catalog_version": catalog.Generation,
 	})
 }
 
@@ -37,8 +159,10 @@ func (r *Router) listMCPServers(c *gin.Context) {
 	serverCount := r.blaxelClient.McpManager.GetServerCount()
 
 	c.JSON(http.StatusOK, gin.H{
-		"servers": serverNames,
-		"count":   serverCount,
+		"servers":       serverNames,
+		"count":         serverCount,
+		"health":        r.blaxelClient.McpManager.ServerHealth(),
+		"healthy_count": r.blaxelClient.McpManager.HealthyServerCount(),
 	})
 }
 
@@ -47,14 +171,14 @@ func (r *Router) listServerTools(c *gin.Context) {
 	serverName := c.Param("server")
 
 	// Get all tools and filter by server
-	allTools, err := r.blaxelClient.McpManager.ListAllTools(c)
+	catalog, err := r.toolCatalog.Snapshot(c)
 	if err != nil {
-		c.Error(fmt.Errorf("failed to list tools: %w", err))
+		abortWithError(c, http.StatusInternalServerError, fmt.Errorf("failed to list tools: %w", err))
 		return
 	}
 
 	var serverTools []interface{}
-	for _, toolWithServer := range allTools {
+	for _, toolWithServer := range catalog.MCPTools() {
 		if toolWithServer.ServerName == serverName {
 			serverTools = append(serverTools, toolWithServer.Tool)
 		}
@@ -67,10 +191,46 @@ func (r *Router) listServerTools(c *gin.Context) {
 		})
 		return
 	}
+	if notModified(c, catalog) {
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"server": serverName,
-		"tools":  serverTools,
-		"count":  len(serverTools),
+		"server":          serverName,
+		"tools":           serverTools,
+		"count":           len(serverTools),
+		"catalog_version": catalog.Generation,
 	})
 }
+
+// notModified sets the caching headers of a tool catalog response and answers 304 Not Modified
+// when the client already has the current content of the catalog. The ETag is derived from the
+// content rather than the generation, so it stays valid across restarts and replicas.
+func notModified(c *gin.Context, catalog *agent.CatalogSnapshot) bool {
+	etag := `W/"tools-` + catalog.Digest() + `"`
+	lastModified := catalog.ModifiedAt.UTC().Truncate(time.Second)
+
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", lastModified.Format(http.TimeFormat))
+	c.Header("Cache-Control", "no-cache")
+
+	// If-None-Match takes precedence over If-Modified-Since
+	if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" {
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				c.Status(http.StatusNotModified)
+				return true
+			}
+		}
+		return false
+	}
+
+	if ifModifiedSince := c.GetHeader("If-Modified-Since"); ifModifiedSince != "" {
+		if since, err := http.ParseTime(ifModifiedSince); err == nil && !lastModified.After(since) {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ProvenanceHeader is the request header carrying the provenance token of an MCP tool call
+const ProvenanceHeader = "X-Agent-Provenance"
+
+// Provenance describes the agent run an MCP tool call originates from
+type Provenance struct {
+	RunID  string `json:"run_id"`
+	Tenant string `json:"tenant"`
+	Server string `json:"server"`
+	Tool   string `json:"tool"`
+	// ArgumentsHash is the hex SHA-256 of the JSON arguments of the call, see HashArguments
+	ArgumentsHash string `json:"args_sha256"`
+	// IssuedAt is the time of the call in Unix seconds
+	IssuedAt int64  `json:"iat"`
+	KeyID    string `json:"kid,omitempty"`
+}
+
+// NewProvenanceSignerFromEnv creates the signer of tool call provenance tokens from
+// BL_PROVENANCE_SIGNING_KEY and BL_PROVENANCE_SIGNING_KEY_ID, returning nil when no key is configured
+func NewProvenanceSignerFromEnv() *Signer {
+	key := os.Getenv("BL_PROVENANCE_SIGNING_KEY")
+	if key == "" {
+		return nil
+	}
+	return NewSigner([]byte(key), os.Getenv("BL_PROVENANCE_SIGNING_KEY_ID"))
+}
+
+// HashArguments returns the hex SHA-256 of the JSON encoding of tool arguments, whose object keys
+// are sorted so the hash does not depend on their order
+func HashArguments(arguments any) (string, error) {
+	data, err := json.Marshal(arguments)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode tool arguments: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// SignProvenance returns the provenance token of a tool call, in the form
+// "<base64url JSON claims>.<base64url HMAC-SHA256 of the encoded claims>"
+func (s *Signer) SignProvenance(provenance Provenance) (string, error) {
+	provenance.KeyID = s.keyID
+	claims, err := json.Marshal(provenance)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode provenance: %w", err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(s.tokenMAC(payload)), nil
+}
+
+// VerifyProvenance checks a provenance token and returns its claims, rejecting tokens older than
+// maxAge when it is positive
+func (s *Signer) VerifyProvenance(token string, maxAge time.Duration) (Provenance, error) {
+	payload, signature, found := strings.Cut(token, ".")
+	if !found {
+		return Provenance{}, fmt.Errorf("malformed provenance token")
+	}
+	expected, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return Provenance{}, fmt.Errorf("invalid provenance signature encoding: %w", err)
+	}
+	if !hmac.Equal(expected, s.tokenMAC(payload)) {
+		return Provenance{}, fmt.Errorf("provenance signature mismatch")
+	}
+
+	claims, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return Provenance{}, fmt.Errorf("invalid provenance claims encoding: %w", err)
+	}
+	var provenance Provenance
+	if err := json.Unmarshal(claims, &provenance); err != nil {
+		return Provenance{}, fmt.Errorf("invalid provenance claims: %w", err)
+	}
+	if maxAge > 0 && time.Since(time.Unix(provenance.IssuedAt, 0)) > maxAge {
+		return Provenance{}, fmt.Errorf("provenance older than %s", maxAge)
+	}
+	return provenance, nil
+}
+
+// tokenMAC computes the HMAC of the encoded claims of a token
+func (s *Signer) tokenMAC(payload string) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
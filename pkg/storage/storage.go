@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"template-custom-agent-go/pkg/logger"
+)
+
+// Defaults of the result store settings
+const (
+	defaultRegion    = "us-east-1"
+	defaultThreshold = 1 << 20
+	defaultURLExpiry = time.Hour
+)
+
+// maxURLExpiry is the longest validity S3 accepts for presigned URLs
+const maxURLExpiry = 7 * 24 * time.Hour
+
+// ResultStore uploads large run results to an S3-compatible bucket so they are handed out as
+// presigned URLs instead of being kept in memory and returned inline
+type ResultStore struct {
+	endpoint        *url.URL
+	bucket          string
+	region          string
+	prefix          string
+	accessKeyID     string
+	secretAccessKey string
+	threshold       int
+	urlExpiry       time.Duration
+	client          *http.Client
+}
+
+// NewResultStoreFromEnv creates a result store from the BL_RESULT_STORAGE_* variables, returning nil when
+// BL_RESULT_STORAGE_BUCKET is not set. The endpoint defaults to AWS S3 in the region, and credentials
+// fall back to AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+func NewResultStoreFromEnv() (*ResultStore, error) {
+	bucket := os.Getenv("BL_RESULT_STORAGE_BUCKET")
+	if bucket == "" {
+		return nil, nil
+	}
+
+	region := envOr("BL_RESULT_STORAGE_REGION", defaultRegion)
+	endpoint, err := url.Parse(envOr("BL_RESULT_STORAGE_ENDPOINT", fmt.Sprintf("https://s3.%s.amazonaws.com", region)))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid BL_RESULT_STORAGE_ENDPOINT %q", os.Getenv("BL_RESULT_STORAGE_ENDPOINT"))
+	}
+
+	store := &ResultStore{
+		endpoint:        endpoint,
+		bucket:          bucket,
+		region:          region,
+		prefix:          strings.Trim(os.Getenv("BL_RESULT_STORAGE_PREFIX"), "/"),
+		accessKeyID:     envOr("BL_RESULT_STORAGE_ACCESS_KEY_ID", os.Getenv("AWS_ACCESS_KEY_ID")),
+		secretAccessKey: envOr("BL_RESULT_STORAGE_SECRET_ACCESS_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		threshold:       defaultThreshold,
+		urlExpiry:       defaultURLExpiry,
+		client:          http.DefaultClient,
+	}
+	if store.accessKeyID == "" || store.secretAccessKey == "" {
+		return nil, fmt.Errorf("BL_RESULT_STORAGE_BUCKET is set but no access key is configured")
+	}
+
+	if value := os.Getenv("BL_RESULT_STORAGE_THRESHOLD"); value != "" {
+		threshold, err := strconv.Atoi(value)
+		if err != nil || threshold < 0 {
+			return nil, fmt.Errorf("invalid BL_RESULT_STORAGE_THRESHOLD %q, expected a number of bytes", value)
+		}
+		store.threshold = threshold
+	}
+	if value := os.Getenv("BL_RESULT_STORAGE_URL_EXPIRY"); value != "" {
+		expiry, err := time.ParseDuration(value)
+		if err != nil || expiry <= 0 || expiry > maxURLExpiry {
+			return nil, fmt.Errorf("invalid BL_RESULT_STORAGE_URL_EXPIRY %q, expected a duration up to %s", value, maxURLExpiry)
+		}
+		store.urlExpiry = expiry
+	}
+
+	logger.Infof("Result storage enabled: bucket=%s endpoint=%s threshold=%d bytes", bucket, endpoint.Host, store.threshold)
+	return store, nil
+}
+
+// ShouldStore reports whether a result of the given size is uploaded rather than returned inline
+func (s *ResultStore) ShouldStore(size int) bool {
+	return size > s.threshold
+}
+
+// Store streams the body to the object key under the configured prefix and returns a presigned
+// URL to download it, valid for the configured expiry
+func (s *ResultStore) Store(ctx context.Context, key, contentType string, body io.Reader, size int64) (string, error) {
+	objectURL := s.objectURL(key)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, objectURL.String(), body)
+	if err != nil {
+		return "", fmt.Errorf("failed to create upload request: %w", err)
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	s.signRequest(req, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload result: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("result upload returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	return s.presign(http.MethodGet, objectURL, time.Now().UTC(), s.urlExpiry), nil
+}
+
+// objectURL returns the path-style URL of an object key
+func (s *ResultStore) objectURL(key string) *url.URL {
+	if s.prefix != "" {
+		key = s.prefix + "/" + key
+	}
+	objectURL := *s.endpoint
+	objectURL.Path = strings.TrimSuffix(objectURL.Path, "/") + "/" + s.bucket + "/" + key
+	objectURL.RawPath = canonicalURI(&objectURL)
+	objectURL.RawQuery = ""
+	return &objectURL
+}
+
+// unsignedPayload skips hashing the body so uploads can stream
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// signRequest adds the AWS Signature Version 4 authorization headers to a request
+func (s *ResultStore) signRequest(req *http.Request, at time.Time) {
+	timestamp := at.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", timestamp)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"content-type":         req.Header.Get("Content-Type"),
+		"x-amz-content-sha256": unsignedPayload,
+		"x-amz-date":           timestamp,
+	}
+	signedHeaders, canonicalHeaders := canonicalizeHeaders(headers)
+	canonicalRequest := strings.Join([]string{
+		req.Method, canonicalURI(req.URL), "", canonicalHeaders, signedHeaders, unsignedPayload,
+	}, "\n")
+
+	scope := s.scope(at)
+	signature := s.signature(at, timestamp, scope, canonicalRequest)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyID, scope, signedHeaders, signature))
+}
+
+// presign returns the URL with an AWS Signature Version 4 query string valid for the expiry
+func (s *ResultStore) presign(method string, target *url.URL, at time.Time, expiry time.Duration) string {
+	timestamp := at.Format("20060102T150405Z")
+	scope := s.scope(at)
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.accessKeyID+"/"+scope)
+	query.Set("X-Amz-Date", timestamp)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expiry.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	canonicalQuery := canonicalizeQuery(query)
+
+	canonicalRequest := strings.Join([]string{
+		method, canonicalURI(target), canonicalQuery, "host:" + target.Host + "\n", "host", unsignedPayload,
+	}, "\n")
+
+	presigned := *target
+	presigned.RawQuery = canonicalQuery + "&X-Amz-Signature=" + s.signature(at, timestamp, scope, canonicalRequest)
+	return presigned.String()
+}
+
+// scope returns the credential scope of requests signed at the given time
+func (s *ResultStore) scope(at time.Time) string {
+	return at.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+}
+
+// signature signs the canonical request with the key derived for its date, region and service
+func (s *ResultStore) signature(at time.Time, timestamp, scope, canonicalRequest string) string {
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", timestamp, scope, hex.EncodeToString(hashedRequest[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretAccessKey), at.Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// hmacSHA256 computes the HMAC-SHA256 of the data with the key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalURI encodes each segment of the URL path as required by Signature Version 4
+func canonicalURI(target *url.URL) string {
+	segments := strings.Split(target.Path, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalizeQuery encodes the query parameters sorted by name
+func canonicalizeQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, uriEncode(name)+"="+uriEncode(query.Get(name)))
+	}
+	return strings.Join(pairs, "&")
+}
+
+// canonicalizeHeaders returns the signed header names and the canonical headers block
+func canonicalizeHeaders(headers map[string]string) (string, string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonical strings.Builder
+	for _, name := range names {
+		canonical.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	return strings.Join(names, ";"), canonical.String()
+}
+
+// uriEncode percent-encodes every byte except the unreserved characters of RFC 3986
+func uriEncode(value string) string {
+	var encoded strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || strings.IndexByte("-_.~", c) >= 0 {
+			encoded.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&encoded, "%%%02X", c)
+	}
+	return encoded.String()
+}
+
+// envOr returns the value of an environment variable, or the fallback when it is not set
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,540 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"template-custom-agent-go/pkg/logger"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// Databases of the conversation store
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// defaultSQLitePath is the database file used when BL_CONVERSATION_SQLITE_PATH is not set
+const defaultSQLitePath = "conversations.db"
+
+// ErrNotFound is returned when a conversation or a run does not exist
+var ErrNotFound = errors.New("conversation not found")
+
+// Conversation is the history of a session: the messages, tool calls and token usage of its runs
+type Conversation struct {
+	ID        string    `json:"id"`
+	Agent     string    `json:"agent"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Runs and MessageCount count the runs and messages of the session
+	Runs         int `json:"runs"`
+	MessageCount int `json:"message_count"`
+	// Usage is the token usage summed across the runs
+	Usage Usage `json:"usage"`
+	// Messages, ToolCalls and UsageByRun are only set when a single conversation is read
+	Messages   []Message  `json:"messages,omitempty"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	UsageByRun []RunUsage `json:"usage_by_run,omitempty"`
+}
+
+// Message is a user input or an assistant turn of a run
+type Message struct {
+	RunID     string    `json:"run_id"`
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	Iteration int       `json:"iteration,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ToolCall is a tool call executed or skipped during a run
+type ToolCall struct {
+	RunID       string    `json:"run_id"`
+	ToolCallID  string    `json:"tool_call_id"`
+	Iteration   int       `json:"iteration"`
+	Name        string    `json:"name"`
+	Arguments   string    `json:"arguments,omitempty"`
+	Status      string    `json:"status"`
+	DurationMs  int64     `json:"duration_ms,omitempty"`
+	ResultBytes int       `json:"result_bytes,omitempty"`
+	Flagged     bool      `json:"flagged,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Usage is a token usage
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// RunUsage is the token usage of a model during a run
+type RunUsage struct {
+	RunID string `json:"run_id"`
+	Model string `json:"model"`
+	Usage
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LogLine is a log line emitted during a run
+type LogLine struct {
+	RunID     string    `json:"run_id"`
+	Level     string    `json:"level"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Run is what a run adds to the conversation of its session
+type Run struct {
+	ConversationID string
+	RunID          string
+	Agent          string
+	Messages       []Message
+	ToolCalls      []ToolCall
+	Usage          []RunUsage
+	Logs           []LogLine
+	At             time.Time
+}
+
+// RunDetail is a run of a conversation with its messages, tool calls, usage and log lines
+type RunDetail struct {
+	ID             string     `json:"id"`
+	ConversationID string     `json:"conversation_id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	Messages       []Message  `json:"messages"`
+	ToolCalls      []ToolCall `json:"tool_calls,omitempty"`
+	Usage          []RunUsage `json:"usage,omitempty"`
+	Logs           []LogLine  `json:"logs,omitempty"`
+}
+
+// dialect holds what differs between the SQL of the databases
+type dialect struct {
+	driver     string
+	serial     string
+	timestamp  string
+	positional bool
+}
+
+var dialects = map[string]dialect{
+	DriverSQLite:   {driver: "sqlite", serial: "INTEGER PRIMARY KEY AUTOINCREMENT", timestamp: "DATETIME"},
+	DriverPostgres: {driver: "pgx", serial: "BIGSERIAL PRIMARY KEY", timestamp: "TIMESTAMPTZ", positional: true},
+}
+
+// rebind replaces the ? placeholders of a query with $1, $2... for databases using positional parameters
+func (d dialect) rebind(query string) string {
+	if !d.positional {
+		return query
+	}
+	var rebound strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			rebound.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		rebound.WriteRune(r)
+	}
+	return rebound.String()
+}
+
+// Store persists the conversations of sessions. ConversationRepository implements it with SQLite and
+// Postgres, and extensions can provide other backends.
+type Store interface {
+	// SaveRun adds a run to its conversation, creating the conversation on its first run
+	SaveRun(ctx context.Context, run Run) error
+	// List returns the conversation summaries, the most recently updated first
+	List(ctx context.Context, limit, offset int) ([]Conversation, error)
+	// Count returns the number of conversations
+	Count(ctx context.Context) (int, error)
+	// Get returns a conversation with its messages, tool calls and usage, or ErrNotFound
+	Get(ctx context.Context, id string) (*Conversation, error)
+	Close() error
+}
+
+// RunReader is implemented by stores reading back a single run. Backends of extensions without it
+// only serve whole conversations.
+type RunReader interface {
+	// GetRun returns a run with its messages, tool calls, usage and log lines, or ErrNotFound
+	GetRun(ctx context.Context, runID string) (*RunDetail, error)
+}
+
+// ConversationRepository persists the conversations of sessions in SQLite or Postgres
+type ConversationRepository struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// NewConversationRepositoryFromEnv opens the conversation store, returning nil when persistence is
+// disabled. BL_CONVERSATION_DATABASE_URL selects a Postgres database by DSN. Otherwise
+// BL_CONVERSATION_STORE=true keeps conversations in the SQLite file BL_CONVERSATION_SQLITE_PATH,
+// conversations.db by default.
+func NewConversationRepositoryFromEnv(ctx context.Context) (*ConversationRepository, error) {
+	if dsn := os.Getenv("BL_CONVERSATION_DATABASE_URL"); dsn != "" {
+		repository, err := Open(ctx, DriverPostgres, dsn)
+		if err != nil {
+			return nil, err
+		}
+		logger.Infof("Persisting conversations in Postgres")
+		return repository, nil
+	}
+
+	switch value := os.Getenv("BL_CONVERSATION_STORE"); value {
+	case "", "false":
+		return nil, nil
+	case "true":
+	default:
+		return nil, fmt.Errorf("invalid BL_CONVERSATION_STORE %q, expected true or false", value)
+	}
+	path := os.Getenv("BL_CONVERSATION_SQLITE_PATH")
+	if path == "" {
+		path = defaultSQLitePath
+	}
+	repository, err := Open(ctx, DriverSQLite, "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("Persisting conversations in SQLite database %s", path)
+	return repository, nil
+}
+
+// Open connects to the database of the driver, DriverSQLite or DriverPostgres, and creates the
+// tables of the store when they do not exist
+func Open(ctx context.Context, driver, dsn string) (*ConversationRepository, error) {
+	dialect, ok := dialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported conversation store driver %q", driver)
+	}
+	db, err := sql.Open(dialect.driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s conversation store: %w", driver, err)
+	}
+	// SQLite allows a single writer, so queries wait for each other rather than failing as busy
+	if driver == DriverSQLite {
+		db.SetMaxOpenConns(1)
+	}
+
+	repository := &ConversationRepository{db: db, dialect: dialect}
+	if err := repository.migrate(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create %s conversation store: %w", driver, err)
+	}
+	return repository, nil
+}
+
+// migrate creates the tables and indexes of the store
+func (r *ConversationRepository) migrate(ctx context.Context) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS conversations (
+			id TEXT PRIMARY KEY,
+			agent TEXT NOT NULL,
+			created_at ` + r.dialect.timestamp + ` NOT NULL,
+			updated_at ` + r.dialect.timestamp + ` NOT NULL,
+			runs INTEGER NOT NULL DEFAULT 0,
+			message_count INTEGER NOT NULL DEFAULT 0,
+			prompt_tokens INTEGER NOT NULL DEFAULT 0,
+			completion_tokens INTEGER NOT NULL DEFAULT 0,
+			total_tokens INTEGER NOT NULL DEFAULT 0
+		)`,
+		`CREATE INDEX IF NOT EXISTS conversations_updated_at ON conversations (updated_at)`,
+		`CREATE TABLE IF NOT EXISTS conversation_messages (
+			id ` + r.dialect.serial + `,
+			conversation_id TEXT NOT NULL,
+			run_id TEXT NOT NULL,
+			role TEXT NOT NULL,
+			content TEXT NOT NULL,
+			iteration INTEGER NOT NULL,
+			created_at ` + r.dialect.timestamp + ` NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS conversation_messages_conversation ON conversation_messages (conversation_id)`,
+		`CREATE TABLE IF NOT EXISTS conversation_tool_calls (
+			id ` + r.dialect.serial + `,
+			conversation_id TEXT NOT NULL,
+			run_id TEXT NOT NULL,
+			tool_call_id TEXT NOT NULL,
+			iteration INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			arguments TEXT NOT NULL,
+			status TEXT NOT NULL,
+			duration_ms BIGINT NOT NULL,
+			result_bytes INTEGER NOT NULL,
+			flagged BOOLEAN NOT NULL,
+			created_at ` + r.dialect.timestamp + ` NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS conversation_tool_calls_conversation ON conversation_tool_calls (conversation_id)`,
+		`CREATE TABLE IF NOT EXISTS conversation_usage (
+			id ` + r.dialect.serial + `,
+			conversation_id TEXT NOT NULL,
+			run_id TEXT NOT NULL,
+			model TEXT NOT NULL,
+			prompt_tokens INTEGER NOT NULL,
+			completion_tokens INTEGER NOT NULL,
+			total_tokens INTEGER NOT NULL,
+			created_at ` + r.dialect.timestamp + ` NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS conversation_usage_conversation ON conversation_usage (conversation_id)`,
+		`CREATE TABLE IF NOT EXISTS conversation_run_logs (
+			id ` + r.dialect.serial + `,
+			conversation_id TEXT NOT NULL,
+			run_id TEXT NOT NULL,
+			level TEXT NOT NULL,
+			message TEXT NOT NULL,
+			created_at ` + r.dialect.timestamp + ` NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS conversation_run_logs_run ON conversation_run_logs (run_id)`,
+		`CREATE INDEX IF NOT EXISTS conversation_messages_run ON conversation_messages (run_id)`,
+		`CREATE INDEX IF NOT EXISTS conversation_tool_calls_run ON conversation_tool_calls (run_id)`,
+		`CREATE INDEX IF NOT EXISTS conversation_usage_run ON conversation_usage (run_id)`,
+	}
+	for _, statement := range statements {
+		if _, err := r.db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the database
+func (r *ConversationRepository) Close() error {
+	return r.db.Close()
+}
+
+// SaveRun adds the messages, tool calls, usage and log lines of a run to its conversation, creating
+// the conversation on its first run
+func (r *ConversationRepository) SaveRun(ctx context.Context, run Run) error {
+	at := run.At.UTC()
+	var total Usage
+	for _, usage := range run.Usage {
+		total.PromptTokens += usage.PromptTokens
+		total.CompletionTokens += usage.CompletionTokens
+		total.TotalTokens += usage.TotalTokens
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to save run %s: %w", run.RunID, err)
+	}
+	defer tx.Rollback()
+
+	exec := func(query string, args ...interface{}) error {
+		_, err := tx.ExecContext(ctx, r.dialect.rebind(query), args...)
+		return err
+	}
+	err = exec(`INSERT INTO conversations (id, agent, created_at, updated_at, runs, message_count, prompt_tokens, completion_tokens, total_tokens)
+		VALUES (?, ?, ?, ?, 1, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			agent = excluded.agent,
+			updated_at = excluded.updated_at,
+			runs = conversations.runs + 1,
+			message_count = conversations.message_count + excluded.message_count,
+			prompt_tokens = conversations.prompt_tokens + excluded.prompt_tokens,
+			completion_tokens = conversations.completion_tokens + excluded.completion_tokens,
+			total_tokens = conversations.total_tokens + excluded.total_tokens`,
+		run.ConversationID, run.Agent, at, at, len(run.Messages), total.PromptTokens, total.CompletionTokens, total.TotalTokens)
+	for _, message := range run.Messages {
+		if err != nil {
+			break
+		}
+		err = exec(`INSERT INTO conversation_messages (conversation_id, run_id, role, content, iteration, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			run.ConversationID, run.RunID, message.Role, message.Content, message.Iteration, at)
+	}
+	for _, call := range run.ToolCalls {
+		if err != nil {
+			break
+		}
+		err = exec(`INSERT INTO conversation_tool_calls (conversation_id, run_id, tool_call_id, iteration, name, arguments, status, duration_ms, result_bytes, flagged, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			run.ConversationID, run.RunID, call.ToolCallID, call.Iteration, call.Name, call.Arguments, call.Status, call.DurationMs, call.ResultBytes, call.Flagged, at)
+	}
+	for _, usage := range run.Usage {
+		if err != nil {
+			break
+		}
+		err = exec(`INSERT INTO conversation_usage (conversation_id, run_id, model, prompt_tokens, completion_tokens, total_tokens, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			run.ConversationID, run.RunID, usage.Model, usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens, at)
+	}
+	for _, line := range run.Logs {
+		if err != nil {
+			break
+		}
+		err = exec(`INSERT INTO conversation_run_logs (conversation_id, run_id, level, message, created_at) VALUES (?, ?, ?, ?, ?)`,
+			run.ConversationID, run.RunID, line.Level, line.Message, line.CreatedAt.UTC())
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save run %s: %w", run.RunID, err)
+	}
+	return nil
+}
+
+// conversationColumns are the columns of conversation summaries
+const conversationColumns = `id, agent, created_at, updated_at, runs, message_count, prompt_tokens, completion_tokens, total_tokens`
+
+// scanConversation reads a row of conversationColumns
+func scanConversation(row interface{ Scan(...interface{}) error }) (Conversation, error) {
+	var c Conversation
+	err := row.Scan(&c.ID, &c.Agent, &c.CreatedAt, &c.UpdatedAt, &c.Runs, &c.MessageCount,
+		&c.Usage.PromptTokens, &c.Usage.CompletionTokens, &c.Usage.TotalTokens)
+	return c, err
+}
+
+// List returns conversation summaries, the most recently updated first, skipping offset of them
+func (r *ConversationRepository) List(ctx context.Context, limit, offset int) ([]Conversation, error) {
+	rows, err := r.db.QueryContext(ctx, r.dialect.rebind(`SELECT `+conversationColumns+` FROM conversations
+		ORDER BY updated_at DESC, id LIMIT ? OFFSET ?`), limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %w", err)
+	}
+	defer rows.Close()
+
+	conversations := []Conversation{}
+	for rows.Next() {
+		conversation, err := scanConversation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list conversations: %w", err)
+		}
+		conversations = append(conversations, conversation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %w", err)
+	}
+	return conversations, nil
+}
+
+// Count returns the number of conversations
+func (r *ConversationRepository) Count(ctx context.Context) (int, error) {
+	var count int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM conversations`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count conversations: %w", err)
+	}
+	return count, nil
+}
+
+// Get returns a conversation with its messages, tool calls and usage in order, or ErrNotFound
+func (r *ConversationRepository) Get(ctx context.Context, id string) (*Conversation, error) {
+	conversation, err := scanConversation(r.db.QueryRowContext(ctx,
+		r.dialect.rebind(`SELECT `+conversationColumns+` FROM conversations WHERE id = ?`), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read conversation %s: %w", id, err)
+	}
+
+	err = r.query(ctx, `SELECT run_id, role, content, iteration, created_at FROM conversation_messages
+		WHERE conversation_id = ? ORDER BY id`, id, func(rows *sql.Rows) error {
+		var m Message
+		if err := rows.Scan(&m.RunID, &m.Role, &m.Content, &m.Iteration, &m.CreatedAt); err != nil {
+			return err
+		}
+		conversation.Messages = append(conversation.Messages, m)
+		return nil
+	})
+	if err == nil {
+		err = r.query(ctx, `SELECT run_id, tool_call_id, iteration, name, arguments, status, duration_ms, result_bytes, flagged, created_at
+			FROM conversation_tool_calls WHERE conversation_id = ? ORDER BY id`, id, func(rows *sql.Rows) error {
+			var t ToolCall
+			if err := rows.Scan(&t.RunID, &t.ToolCallID, &t.Iteration, &t.Name, &t.Arguments, &t.Status,
+				&t.DurationMs, &t.ResultBytes, &t.Flagged, &t.CreatedAt); err != nil {
+				return err
+			}
+			conversation.ToolCalls = append(conversation.ToolCalls, t)
+			return nil
+		})
+	}
+	if err == nil {
+		err = r.query(ctx, `SELECT run_id, model, prompt_tokens, completion_tokens, total_tokens, created_at
+			FROM conversation_usage WHERE conversation_id = ? ORDER BY id`, id, func(rows *sql.Rows) error {
+			var u RunUsage
+			if err := rows.Scan(&u.RunID, &u.Model, &u.PromptTokens, &u.CompletionTokens, &u.TotalTokens, &u.CreatedAt); err != nil {
+				return err
+			}
+			conversation.UsageByRun = append(conversation.UsageByRun, u)
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read conversation %s: %w", id, err)
+	}
+	return &conversation, nil
+}
+
+// GetRun returns a run with its messages, tool calls, usage and log lines in order, or ErrNotFound
+func (r *ConversationRepository) GetRun(ctx context.Context, runID string) (*RunDetail, error) {
+	run := RunDetail{ID: runID}
+	err := r.query(ctx, `SELECT conversation_id, run_id, role, content, iteration, created_at FROM conversation_messages
+		WHERE run_id = ? ORDER BY id`, runID, func(rows *sql.Rows) error {
+		var m Message
+		if err := rows.Scan(&run.ConversationID, &m.RunID, &m.Role, &m.Content, &m.Iteration, &m.CreatedAt); err != nil {
+			return err
+		}
+		run.CreatedAt = m.CreatedAt
+		run.Messages = append(run.Messages, m)
+		return nil
+	})
+	if err == nil && len(run.Messages) == 0 {
+		return nil, ErrNotFound
+	}
+	if err == nil {
+		err = r.query(ctx, `SELECT run_id, tool_call_id, iteration, name, arguments, status, duration_ms, result_bytes, flagged, created_at
+			FROM conversation_tool_calls WHERE run_id = ? ORDER BY id`, runID, func(rows *sql.Rows) error {
+			var t ToolCall
+			if err := rows.Scan(&t.RunID, &t.ToolCallID, &t.Iteration, &t.Name, &t.Arguments, &t.Status,
+				&t.DurationMs, &t.ResultBytes, &t.Flagged, &t.CreatedAt); err != nil {
+				return err
+			}
+			run.ToolCalls = append(run.ToolCalls, t)
+			return nil
+		})
+	}
+	if err == nil {
+		err = r.query(ctx, `SELECT run_id, model, prompt_tokens, completion_tokens, total_tokens, created_at
+			FROM conversation_usage WHERE run_id = ? ORDER BY id`, runID, func(rows *sql.Rows) error {
+			var u RunUsage
+			if err := rows.Scan(&u.RunID, &u.Model, &u.PromptTokens, &u.CompletionTokens, &u.TotalTokens, &u.CreatedAt); err != nil {
+				return err
+			}
+			run.Usage = append(run.Usage, u)
+			return nil
+		})
+	}
+	if err == nil {
+		err = r.query(ctx, `SELECT run_id, level, message, created_at FROM conversation_run_logs
+			WHERE run_id = ? ORDER BY id`, runID, func(rows *sql.Rows) error {
+			var l LogLine
+			if err := rows.Scan(&l.RunID, &l.Level, &l.Message, &l.CreatedAt); err != nil {
+				return err
+			}
+			run.Logs = append(run.Logs, l)
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run %s: %w", runID, err)
+	}
+	return &run, nil
+}
+
+// query runs a query with the id of a conversation or a run, calling scan for each row
+func (r *ConversationRepository) query(ctx context.Context, query, id string, scan func(*sql.Rows) error) error {
+	rows, err := r.db.QueryContext(ctx, r.dialect.rebind(query), id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
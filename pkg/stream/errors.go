@@ -0,0 +1,46 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Trailers used to report a failure on plain-text streams once the body has started
+const (
+	ErrorTrailer     = "X-Stream-Error"
+	ErrorCodeTrailer = "X-Stream-Error-Code"
+)
+
+// ErrorCodeSlowSubscriber reports a stream closed early because the client fell behind its events
+const ErrorCodeSlowSubscriber = "slow_subscriber"
+
+// ErrorEvent is the structured error emitted when a stream fails
+type ErrorEvent struct {
+	Error     string `json:"error"`
+	ErrorCode string `json:"error_code"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// WriteSSEError writes the error as an SSE `error` event
+func WriteSSEError(w io.Writer, event ErrorEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+	return err
+}
+
+// DeclareErrorTrailers announces the trailers used to report errors on a plain-text stream.
+// It must be called before the response headers are written.
+func DeclareErrorTrailers(header http.Header) {
+	header.Set("Trailer", ErrorTrailer+", "+ErrorCodeTrailer)
+}
+
+// SetErrorTrailers reports the error in the trailers declared by DeclareErrorTrailers
+func SetErrorTrailers(header http.Header, event ErrorEvent) {
+	header.Set(ErrorTrailer, event.Error)
+	header.Set(ErrorCodeTrailer, event.ErrorCode)
+}
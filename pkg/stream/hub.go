@@ -0,0 +1,208 @@
+package stream
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"template-custom-agent-go/pkg/logger"
+	"template-custom-agent-go/pkg/metrics"
+)
+
+// Defaults of the stream hub
+const (
+	DefaultSubscriberBuffer = 64
+	DefaultWriteTimeout     = 30 * time.Second
+)
+
+// Policies applied to a subscriber whose buffer is full
+const (
+	// SlowPolicyDisconnect ends the subscription, the client seeing its stream close
+	SlowPolicyDisconnect = "disconnect"
+	// SlowPolicyDrop discards the oldest buffered event to make room, keeping the client connected
+	SlowPolicyDrop = "drop"
+)
+
+// HubConfig controls how the hub deals with slow subscribers
+type HubConfig struct {
+	// Buffer is the number of events buffered for each subscriber
+	Buffer int
+	// SlowPolicy is applied when a subscriber's buffer is full
+	SlowPolicy string
+	// WriteTimeout bounds the time spent writing one event to a client, zero disabling it
+	WriteTimeout time.Duration
+}
+
+// HubConfigFromEnv reads the hub configuration from BL_STREAM_SUBSCRIBER_BUFFER (events),
+// BL_STREAM_SLOW_CLIENT_POLICY (disconnect or drop) and BL_STREAM_WRITE_TIMEOUT (a duration such as 30s)
+func HubConfigFromEnv() HubConfig {
+	config := HubConfig{
+		Buffer:       DefaultSubscriberBuffer,
+		SlowPolicy:   SlowPolicyDisconnect,
+		WriteTimeout: DefaultWriteTimeout,
+	}
+
+	if value := os.Getenv("BL_STREAM_SUBSCRIBER_BUFFER"); value != "" {
+		buffer, err := strconv.Atoi(value)
+		if err != nil || buffer <= 0 {
+			logger.Warningf("Invalid BL_STREAM_SUBSCRIBER_BUFFER %q, using %d", value, DefaultSubscriberBuffer)
+		} else {
+			config.Buffer = buffer
+		}
+	}
+	switch value := strings.ToLower(os.Getenv("BL_STREAM_SLOW_CLIENT_POLICY")); value {
+	case "", SlowPolicyDisconnect:
+	case SlowPolicyDrop:
+		config.SlowPolicy = SlowPolicyDrop
+	default:
+		logger.Warningf("Invalid BL_STREAM_SLOW_CLIENT_POLICY %q, using %s", value, SlowPolicyDisconnect)
+	}
+	if value := os.Getenv("BL_STREAM_WRITE_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			logger.Warningf("Invalid BL_STREAM_WRITE_TIMEOUT %q, using %s", value, DefaultWriteTimeout)
+		} else {
+			config.WriteTimeout = timeout
+		}
+	}
+
+	return config
+}
+
+// Hub broadcasts the events of in-flight runs, keyed by run ID, to every subscriber of the run.
+// Each subscriber has its own bounded buffer so a slow subscriber never blocks the run or the
+// others, nor grows memory: once its buffer is full, the slow client policy applies.
+type Hub struct {
+	mu     sync.Mutex
+	runs   map[string]map[*Subscription]struct{}
+	config HubConfig
+}
+
+// Subscription receives the events of one run
+type Subscription struct {
+	hub    *Hub
+	runID  string
+	events chan interface{}
+	closed bool
+	// dropped is set when the subscriber was disconnected for falling behind
+	dropped bool
+}
+
+// NewHub creates a hub with the configuration
+func NewHub(config HubConfig) *Hub {
+	if config.Buffer <= 0 {
+		config.Buffer = DefaultSubscriberBuffer
+	}
+	if config.SlowPolicy == "" {
+		config.SlowPolicy = SlowPolicyDisconnect
+	}
+	return &Hub{
+		runs:   make(map[string]map[*Subscription]struct{}),
+		config: config,
+	}
+}
+
+// WriteTimeout returns the time allowed to write one event to a client
+func (h *Hub) WriteTimeout() time.Duration {
+	return h.config.WriteTimeout
+}
+
+// Open registers an in-flight run so clients can subscribe to it
+func (h *Hub) Open(runID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, exists := h.runs[runID]; !exists {
+		h.runs[runID] = make(map[*Subscription]struct{})
+	}
+}
+
+// Subscribe adds a subscriber to the run, returning false when the run is not in flight
+func (h *Hub) Subscribe(runID string) (*Subscription, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	subscribers, exists := h.runs[runID]
+	if !exists {
+		return nil, false
+	}
+	subscription := &Subscription{hub: h, runID: runID, events: make(chan interface{}, h.config.Buffer)}
+	subscribers[subscription] = struct{}{}
+	return subscription, true
+}
+
+// Publish sends the event to every subscriber of the run without blocking, applying the slow
+// client policy to the subscribers whose buffer is full
+func (h *Hub) Publish(runID string, event interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for subscription := range h.runs[runID] {
+		select {
+		case subscription.events <- event:
+			continue
+		default:
+		}
+
+		metrics.ObserveStreamEventDropped(h.config.SlowPolicy)
+		if h.config.SlowPolicy == SlowPolicyDisconnect {
+			logger.Warningf("Disconnecting slow subscriber of run %s after %d buffered events", runID, h.config.Buffer)
+			metrics.ObserveSlowSubscriberDisconnected()
+			subscription.dropped = true
+			h.removeLocked(subscription)
+			continue
+		}
+
+		// Only Publish sends, under the mutex, so receiving one event always makes room
+		logger.Debugf("Dropping oldest buffered event of a slow subscriber of run %s", runID)
+		select {
+		case <-subscription.events:
+		default:
+		}
+		subscription.events <- event
+	}
+}
+
+// Close ends the run, closing the event channels of all its subscribers
+func (h *Hub) Close(runID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for subscription := range h.runs[runID] {
+		h.removeLocked(subscription)
+	}
+	delete(h.runs, runID)
+}
+
+// removeLocked detaches a subscription from its run and closes its channel, with the mutex held
+func (h *Hub) removeLocked(subscription *Subscription) {
+	if subscription.closed {
+		return
+	}
+	subscription.closed = true
+	delete(h.runs[subscription.runID], subscription)
+	close(subscription.events)
+}
+
+// Events returns the events of the run, closed when the run ends or the subscriber is disconnected
+func (s *Subscription) Events() <-chan interface{} {
+	return s.events
+}
+
+// Dropped reports whether the subscriber was disconnected for falling behind, rather than the run ending
+func (s *Subscription) Dropped() bool {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.dropped
+}
+
+// Unsubscribe stops receiving the events of the run, reporting whether the run is still in flight
+// without any subscriber left
+func (s *Subscription) Unsubscribe() (last bool) {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.removeLocked(s)
+	subscribers, inFlight := s.hub.runs[s.runID]
+	return inFlight && len(subscribers) == 0
+}
@@ -0,0 +1,197 @@
+package stream
+
+import (
+	"bufio"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"template-custom-agent-go/pkg/logger"
+)
+
+// Defaults for the buffered stream writer
+const (
+	DefaultFlushInterval = 50 * time.Millisecond
+	DefaultBufferSize    = 4096
+)
+
+// WriterConfig controls when a Writer flushes to the client
+type WriterConfig struct {
+	// FlushInterval is the longest time written data waits before being flushed. Data ending on a
+	// token boundary is flushed early once this much time has passed since the last flush.
+	// Zero flushes after every write.
+	FlushInterval time.Duration
+	// BufferSize is the amount of buffered data that forces a flush
+	BufferSize int
+}
+
+// ConfigFromEnv reads the writer configuration from BL_STREAM_FLUSH_INTERVAL (a duration such as 50ms)
+// and BL_STREAM_BUFFER_SIZE (bytes)
+func ConfigFromEnv() WriterConfig {
+	config := WriterConfig{
+		FlushInterval: DefaultFlushInterval,
+		BufferSize:    DefaultBufferSize,
+	}
+
+	if value := os.Getenv("BL_STREAM_FLUSH_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < 0 {
+			logger.Warningf("Invalid BL_STREAM_FLUSH_INTERVAL %q, using %s", value, DefaultFlushInterval)
+		} else {
+			config.FlushInterval = interval
+		}
+	}
+	if value := os.Getenv("BL_STREAM_BUFFER_SIZE"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			logger.Warningf("Invalid BL_STREAM_BUFFER_SIZE %q, using %d", value, DefaultBufferSize)
+		} else {
+			config.BufferSize = size
+		}
+	}
+
+	return config
+}
+
+// Writer buffers a streamed response and flushes it on token boundaries or when the flush interval
+// elapses, instead of flushing after every write. It is safe for use by one writer goroutine while
+// its timer flushes in the background.
+type Writer struct {
+	config    WriterConfig
+	flusher   http.Flusher
+	mu        sync.Mutex
+	buf       *bufio.Writer
+	timer     *time.Timer
+	lastFlush time.Time
+	err       error
+	closed    bool
+
+	tokens     int
+	firstToken time.Time
+	lastToken  time.Time
+}
+
+// Stats describes what a Writer sent to the client
+type Stats struct {
+	// Tokens is the number of writes, each write carrying one token of the response
+	Tokens int
+	// FirstToken and LastToken are when the first and last tokens were flushed to the client
+	FirstToken time.Time
+	LastToken  time.Time
+}
+
+// NewWriter creates a buffered writer over the response
+func NewWriter(w http.ResponseWriter, config WriterConfig) *Writer {
+	if config.BufferSize <= 0 {
+		config.BufferSize = DefaultBufferSize
+	}
+	flusher, _ := w.(http.Flusher)
+
+	return &Writer{
+		config:    config,
+		flusher:   flusher,
+		buf:       bufio.NewWriterSize(w, config.BufferSize),
+		lastFlush: time.Now(),
+	}
+}
+
+// Write buffers p, flushing when the buffer is full, when p ends on a token boundary and the
+// flush interval has elapsed, or when the interval timer fires
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	n, err := w.buf.Write(p)
+	if err != nil {
+		w.err = err
+		return n, err
+	}
+	w.tokens++
+
+	switch {
+	case w.config.FlushInterval == 0, w.buf.Buffered() >= w.config.BufferSize:
+		w.flushLocked()
+	case endsOnTokenBoundary(p) && time.Since(w.lastFlush) >= w.config.FlushInterval:
+		w.flushLocked()
+	case w.buf.Buffered() > 0 && w.timer == nil:
+		w.timer = time.AfterFunc(w.config.FlushInterval, w.timedFlush)
+	}
+
+	return n, w.err
+}
+
+// WriteString buffers s like Write
+func (w *Writer) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends all buffered data to the client
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flushLocked()
+	return w.err
+}
+
+// Close flushes the remaining data and stops the interval timer
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flushLocked()
+	w.closed = true
+	return w.err
+}
+
+// Stats returns what has been sent to the client so far
+func (w *Writer) Stats() Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return Stats{Tokens: w.tokens, FirstToken: w.firstToken, LastToken: w.lastToken}
+}
+
+// timedFlush flushes data that has waited for the whole flush interval
+func (w *Writer) timedFlush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.timer = nil
+	if !w.closed {
+		w.flushLocked()
+	}
+}
+
+// flushLocked writes the buffer to the response and flushes it, with the mutex held
+func (w *Writer) flushLocked() {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if w.err != nil || w.buf.Buffered() == 0 {
+		return
+	}
+	if err := w.buf.Flush(); err != nil {
+		w.err = err
+		return
+	}
+	if w.flusher != nil {
+		w.flusher.Flush()
+	}
+	w.lastFlush = time.Now()
+	if w.firstToken.IsZero() {
+		w.firstToken = w.lastFlush
+	}
+	w.lastToken = w.lastFlush
+}
+
+// endsOnTokenBoundary reports whether p ends with whitespace or punctuation
+func endsOnTokenBoundary(p []byte) bool {
+	r, _ := utf8.DecodeLastRune(p)
+	return r != utf8.RuneError && (unicode.IsSpace(r) || unicode.IsPunct(r))
+}
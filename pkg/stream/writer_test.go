@@ -0,0 +1,83 @@
+package stream
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// benchmarkContent is the answer streamed by the benchmarks
+var benchmarkContent = strings.Repeat("The agent streams its answer to the client as it is written. ", 64)
+
+// benchmarkStream measures serving benchmarkContent over HTTP with the stream function, the client
+// reading the whole response
+func benchmarkStream(b *testing.B, stream func(w http.ResponseWriter, content string)) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		stream(w, benchmarkContent)
+	}))
+	defer server.Close()
+	client := server.Client()
+
+	b.SetBytes(int64(len(benchmarkContent)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			b.Fatal(err)
+		}
+		n, err := io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if n != int64(len(benchmarkContent)) {
+			b.Fatalf("read %d bytes, want %d", n, len(benchmarkContent))
+		}
+	}
+}
+
+// BenchmarkFlushPerCharacter streams the content as the root endpoint did before Writer, writing and
+// flushing each character
+func BenchmarkFlushPerCharacter(b *testing.B) {
+	benchmarkStream(b, func(w http.ResponseWriter, content string) {
+		flusher := w.(http.Flusher)
+		for _, char := range content {
+			if _, err := io.WriteString(w, string(char)); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	})
+}
+
+// BenchmarkWriter streams the content as the root endpoint does, writing each word to a Writer with
+// the default configuration
+func BenchmarkWriter(b *testing.B) {
+	benchmarkStream(b, func(w http.ResponseWriter, content string) {
+		writer := NewWriter(w, WriterConfig{FlushInterval: DefaultFlushInterval, BufferSize: DefaultBufferSize})
+		defer writer.Close()
+		for _, token := range strings.SplitAfter(content, " ") {
+			if _, err := writer.WriteString(token); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkWriterPerCharacter writes each character to a Writer, isolating the cost of the flushes
+// from the size of the writes
+func BenchmarkWriterPerCharacter(b *testing.B) {
+	benchmarkStream(b, func(w http.ResponseWriter, content string) {
+		writer := NewWriter(w, WriterConfig{FlushInterval: DefaultFlushInterval, BufferSize: DefaultBufferSize})
+		defer writer.Close()
+		for _, char := range content {
+			if _, err := writer.WriteString(string(char)); err != nil {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"template-custom-agent-go/pkg/config"
+	"template-custom-agent-go/pkg/logger"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// OTLP protocols, as set in OTEL_EXPORTER_OTLP_PROTOCOL
+const (
+	ProtocolHTTP = "http/protobuf"
+	ProtocolGRPC = "grpc"
+)
+
+// Config selects how spans are exported
+type Config struct {
+	// Enabled exports spans over OTLP
+	Enabled bool
+	// Protocol is ProtocolHTTP or ProtocolGRPC
+	Protocol string
+	// Endpoint is the configured OTLP endpoint, for logging only since the exporter reads it itself
+	Endpoint string
+}
+
+// ConfigFromEnv reads the standard OpenTelemetry variables. Spans are exported when an OTLP endpoint
+// is set with OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or OTEL_EXPORTER_OTLP_ENDPOINT, or when
+// OTEL_TRACES_EXPORTER=otlp, unless OTEL_SDK_DISABLED=true or OTEL_TRACES_EXPORTER=none. The
+// exporter reads the other OTEL_EXPORTER_OTLP_* variables, such as headers and timeouts, itself.
+func ConfigFromEnv() Config {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	config := Config{Protocol: protocolFromEnv(), Endpoint: endpoint}
+
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return config
+	}
+	switch exporter := os.Getenv("OTEL_TRACES_EXPORTER"); exporter {
+	case "":
+		config.Enabled = endpoint != ""
+	case "otlp":
+		config.Enabled = true
+	case "none":
+	default:
+		logger.Warningf("Invalid OTEL_TRACES_EXPORTER %q, only otlp and none are supported", exporter)
+		config.Enabled = endpoint != ""
+	}
+	return config
+}
+
+// protocolFromEnv reads OTEL_EXPORTER_OTLP_TRACES_PROTOCOL or OTEL_EXPORTER_OTLP_PROTOCOL
+func protocolFromEnv() string {
+	name := "OTEL_EXPORTER_OTLP_TRACES_PROTOCOL"
+	protocol := os.Getenv(name)
+	if protocol == "" {
+		name = "OTEL_EXPORTER_OTLP_PROTOCOL"
+		protocol = os.Getenv(name)
+	}
+	switch protocol {
+	case "":
+		return ProtocolHTTP
+	case ProtocolHTTP, ProtocolGRPC:
+		return protocol
+	default:
+		logger.Warningf("Invalid %s %q, using %s", name, protocol, ProtocolHTTP)
+		return ProtocolHTTP
+	}
+}
+
+// Install sets the global tracer provider exporting spans over OTLP when enabled, and the W3C trace
+// context and baggage propagators. It returns a function flushing the pending spans on shutdown,
+// which does nothing when tracing is disabled.
+func Install(ctx context.Context, config Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !config.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := newExporter(ctx, config.Protocol)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+	res, err := newResource(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OpenTelemetry resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warningf("OpenTelemetry: %v", err)
+	}))
+
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = "the default endpoint"
+	}
+	logger.Infof("Tracing enabled: exporting spans over OTLP %s to %s", config.Protocol, endpoint)
+	return provider.Shutdown, nil
+}
+
+// newExporter creates the OTLP exporter of the protocol, configured by the OTEL_EXPORTER_OTLP_* variables
+func newExporter(ctx context.Context, protocol string) (sdktrace.SpanExporter, error) {
+	if protocol == ProtocolGRPC {
+		return otlptracegrpc.New(ctx)
+	}
+	return otlptracehttp.New(ctx)
+}
+
+// newResource describes this service, OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES taking
+// precedence over BL_SERVICE_NAME and the version of the service
+func newResource(ctx context.Context) (*resource.Resource, error) {
+	return resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithAttributes(
+			semconv.ServiceName(config.ServiceName()),
+			semconv.ServiceVersion(config.ServiceVersion()),
+		),
+		resource.WithFromEnv(),
+	)
+}
@@ -0,0 +1,95 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// Handler executes a native tool with the arguments given by the model, returning the result passed
+// back to it. An error fails the agent run, so problems the model can fix, such as a bad argument,
+// are better described in the result.
+type Handler func(ctx context.Context, arguments map[string]interface{}) (string, error)
+
+// Tool is a Go function exposed to agents as a tool
+type Tool struct {
+	Name        string
+	Description string
+	// Parameters is the JSON schema of the arguments, an object schema. Nil accepts no arguments.
+	Parameters map[string]interface{}
+	Handler    Handler
+}
+
+// toolNamePattern is the tool name format accepted by OpenAI-compatible models
+var toolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// Registry holds the native tools given to agents alongside the tools of MCP servers
+type Registry struct {
+	mu    sync.RWMutex
+	tools map[string]Tool
+	order []string
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{tools: make(map[string]Tool)}
+}
+
+// Default is the registry of the tools given to every agent
+var Default = NewRegistry()
+
+// Register adds a tool to the default registry
+func Register(tool Tool) error {
+	return Default.Register(tool)
+}
+
+// MustRegister adds a tool to the default registry, panicking when it is invalid, typically from init
+func MustRegister(tool Tool) {
+	if err := Default.Register(tool); err != nil {
+		panic(err)
+	}
+}
+
+// Register adds a tool. It fails when the name is invalid or already registered, or the handler is missing.
+func (r *Registry) Register(tool Tool) error {
+	if !toolNamePattern.MatchString(tool.Name) {
+		return fmt.Errorf("invalid tool name %q: use up to 64 letters, digits, underscores or dashes", tool.Name)
+	}
+	if tool.Handler == nil {
+		return fmt.Errorf("tool %s has no handler", tool.Name)
+	}
+	if tool.Parameters == nil {
+		tool.Parameters = map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{},
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.tools[tool.Name]; exists {
+		return fmt.Errorf("tool %s is already registered", tool.Name)
+	}
+	r.tools[tool.Name] = tool
+	r.order = append(r.order, tool.Name)
+	return nil
+}
+
+// List returns the registered tools in registration order
+func (r *Registry) List() []Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tools := make([]Tool, 0, len(r.order))
+	for _, name := range r.order {
+		tools = append(tools, r.tools[name])
+	}
+	return tools
+}
+
+// Len returns the number of registered tools
+func (r *Registry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.order)
+}